@@ -36,7 +36,7 @@ func (cli *Client) getBroadcastListParticipants(ctx context.Context, jid types.J
 			appendResult(nil, ErrNotLoggedIn)
 			return
 		}
-		list, err := cli.getStatusBroadcastRecipients()
+		list, err := cli.getStatusBroadcastRecipients(ctx)
 		if err != nil {
 			appendResult(nil, err)
 			return
@@ -69,8 +69,8 @@ func (cli *Client) getBroadcastListParticipants(ctx context.Context, jid types.J
 	}
 }
 
-func (cli *Client) getStatusBroadcastRecipients() ([]types.JID, error) {
-	statusPrivacyOptions, err := cli.GetStatusPrivacy()
+func (cli *Client) getStatusBroadcastRecipients(ctx context.Context) ([]types.JID, error) {
+	statusPrivacyOptions, err := cli.GetStatusPrivacyContext(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get status privacy: %w", err)
 	}
@@ -116,7 +116,13 @@ var DefaultStatusPrivacy = []types.StatusPrivacy{{
 //
 // There can be multiple different stored settings, the first one is always the default.
 func (cli *Client) GetStatusPrivacy() ([]types.StatusPrivacy, error) {
+	return cli.GetStatusPrivacyContext(context.Background())
+}
+
+// GetStatusPrivacyContext is like GetStatusPrivacy, but takes a context for cancellation and deadlines.
+func (cli *Client) GetStatusPrivacyContext(ctx context.Context) ([]types.StatusPrivacy, error) {
 	resp, err := cli.sendIQ(infoQuery{
+		Context:   ctx,
 		Namespace: "status",
 		Type:      iqGet,
 		To:        types.ServerJID,
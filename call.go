@@ -98,6 +98,11 @@ func (cli *Client) handleCallEvent(node *waBinary.Node) {
 
 // OfferCall offers a call to a user.
 func (cli *Client) OfferCall(callTo types.JID, video bool) error {
+	return cli.OfferCallContext(context.Background(), callTo, video)
+}
+
+// OfferCallContext is like OfferCall, but takes a context for cancellation and deadlines.
+func (cli *Client) OfferCallContext(ctx context.Context, callTo types.JID, video bool) error {
 	clientID := cli.getOwnJID()
 	if clientID.IsEmpty() {
 		return ErrNotLoggedIn
@@ -111,7 +116,7 @@ func (cli *Client) OfferCall(callTo types.JID, video bool) error {
 	if err != nil {
 		return fmt.Errorf("failed to marshal call: %w", err)
 	}
-	destinationNode, includeIdentity := cli.encryptMessageForDevices(context.TODO(), []types.JID{clientID, callTo}, clientID, callID, plaintext, dsmPlaintext, nil)
+	destinationNode, includeIdentity := cli.encryptMessageForDevices(ctx, []types.JID{clientID, callTo}, clientID, callID, plaintext, dsmPlaintext, nil)
 	if includeIdentity {
 		destinationNode = append(destinationNode, cli.makeDeviceIdentityNode())
 	}
@@ -144,6 +149,14 @@ func (cli *Client) OfferCall(callTo types.JID, video bool) error {
 
 // RejectCall rejects an incoming call.
 func (cli *Client) RejectCall(callFrom types.JID, callID string) error {
+	return cli.RejectCallContext(context.Background(), callFrom, callID)
+}
+
+// RejectCallContext is like RejectCall, but takes a context for cancellation and deadlines.
+func (cli *Client) RejectCallContext(ctx context.Context, callFrom types.JID, callID string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 	ownID := cli.getOwnJID()
 	if ownID.IsEmpty() {
 		return ErrNotLoggedIn
@@ -34,6 +34,7 @@ func (cli *Client) handleStreamError(node *waBinary.Node) {
 		}()
 	case code == "401" && conflictType == "device_removed":
 		cli.expectDisconnect()
+		cli.setConnectionState(types.ConnectionStateLoggedOut, nil)
 		cli.Log.Infof("Got device removed stream error, sending LoggedOut event and deleting session")
 		go cli.dispatchEvent(&events.LoggedOut{OnConnect: false, Reason: events.ConnectFailureLoggedOut})
 		err := cli.Store.Delete()
@@ -100,6 +101,7 @@ func (cli *Client) handleConnectFailure(node *waBinary.Node) {
 		)
 	}
 	if reason.IsLoggedOut() {
+		cli.setConnectionState(types.ConnectionStateLoggedOut, nil)
 		cli.Log.Infof("Got %s connect failure, sending LoggedOut event and deleting session", reason)
 		go cli.dispatchEvent(&events.LoggedOut{OnConnect: true, Reason: reason})
 		err := cli.Store.Delete()
@@ -107,11 +109,14 @@ func (cli *Client) handleConnectFailure(node *waBinary.Node) {
 			cli.Log.Warnf("Failed to delete store after %d failure: %v", int(reason), err)
 		}
 	} else if reason == events.ConnectFailureTempBanned {
+		cli.setConnectionState(types.ConnectionStateBanned, nil)
 		cli.Log.Warnf("Temporary ban connect failure: %s", node.XMLString())
+		expire := time.Duration(ag.Int("expire")) * time.Second
 		go cli.dispatchEvent(&events.TemporaryBan{
 			Code:   events.TempBanReason(ag.Int("code")),
-			Expire: time.Duration(ag.Int("expire")) * time.Second,
+			Expire: expire,
 		})
+		go cli.autoReconnect(ReconnectAttempt{Reason: ReconnectReasonTemporaryBan, BanExpire: expire})
 	} else if reason == events.ConnectFailureClientOutdated {
 		cli.Log.Errorf("Client outdated (405) connect failure (client version: %s)", store.GetWAVersion().String())
 		go cli.dispatchEvent(&events.ClientOutdated{})
@@ -145,6 +150,7 @@ func (cli *Client) handleConnectSuccess(node *waBinary.Node) {
 		if err != nil {
 			cli.Log.Warnf("Failed to send post-connect passive IQ: %v", err)
 		}
+		cli.setConnectionState(types.ConnectionStateConnected, nil)
 		cli.dispatchEvent(&events.Connected{})
 		cli.closeSocketWaitChan()
 	}()
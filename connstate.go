@@ -0,0 +1,73 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package whatsmeow
+
+import (
+	"github.com/go-whatsapp/whatsmeow/types"
+	"github.com/go-whatsapp/whatsmeow/types/events"
+)
+
+// State returns the current connection state of the client.
+//
+// Every change is also emitted as an events.ConnectionState event.
+func (cli *Client) State() types.ConnectionState {
+	cli.connStateLock.Lock()
+	defer cli.connStateLock.Unlock()
+	return cli.connState
+}
+
+func (cli *Client) setConnectionState(state types.ConnectionState, err error) {
+	cli.connStateLock.Lock()
+	defer cli.connStateLock.Unlock()
+	cli.unlockedSetConnectionState(state, err)
+}
+
+// setDisconnectedState moves the client to the given disconnected state (Disconnected or BackingOff),
+// unless the client is logged out or banned, in which case those states are kept.
+func (cli *Client) setDisconnectedState(state types.ConnectionState, err error) {
+	cli.connStateLock.Lock()
+	defer cli.connStateLock.Unlock()
+	if cli.connState == types.ConnectionStateLoggedOut || cli.connState == types.ConnectionStateBanned {
+		return
+	}
+	cli.unlockedSetConnectionState(state, err)
+}
+
+func (cli *Client) unlockedSetConnectionState(state types.ConnectionState, err error) {
+	if cli.connState == state {
+		return
+	}
+	cli.Log.Debugf("Connection state changed from %s to %s", cli.connState, state)
+	cli.connStateQueue = append(cli.connStateQueue, &events.ConnectionState{
+		State:    state,
+		Previous: cli.connState,
+		Error:    err,
+	})
+	cli.connState = state
+	if !cli.connStateDispatching {
+		cli.connStateDispatching = true
+		go cli.dispatchConnectionStates()
+	}
+}
+
+// dispatchConnectionStates dispatches queued state change events in order.
+// Only one instance runs at a time, so event handlers can safely change the state (e.g. call Connect).
+func (cli *Client) dispatchConnectionStates() {
+	for {
+		cli.connStateLock.Lock()
+		if len(cli.connStateQueue) == 0 {
+			cli.connStateDispatching = false
+			cli.connStateLock.Unlock()
+			return
+		}
+		evt := cli.connStateQueue[0]
+		cli.connStateQueue[0] = nil
+		cli.connStateQueue = cli.connStateQueue[1:]
+		cli.connStateLock.Unlock()
+		cli.dispatchEvent(evt)
+	}
+}
@@ -0,0 +1,69 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package whatsmeow
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/go-whatsapp/whatsmeow/types"
+	"github.com/go-whatsapp/whatsmeow/types/events"
+	waLog "github.com/go-whatsapp/whatsmeow/util/log"
+)
+
+func TestConnectionStateTransitions(t *testing.T) {
+	cli := NewClient(newTestDevice(t), waLog.Noop)
+	if state := cli.State(); state != types.ConnectionStateDisconnected {
+		t.Fatalf("new client is in state %s", state)
+	}
+	evts := make(chan *events.ConnectionState, 10)
+	cli.AddEventHandler(func(evt interface{}) {
+		if stateEvt, ok := evt.(*events.ConnectionState); ok {
+			// Changing the state from a handler must not deadlock, and must be queued after the current event
+			if stateEvt.State == types.ConnectionStateConnected {
+				cli.setConnectionState(types.ConnectionStateBackingOff, nil)
+			}
+			evts <- stateEvt
+		}
+	})
+
+	testErr := errors.New("test")
+	cli.setConnectionState(types.ConnectionStateConnecting, nil)
+	cli.setConnectionState(types.ConnectionStateConnecting, nil)
+	cli.setConnectionState(types.ConnectionStateConnected, nil)
+	cli.setConnectionState(types.ConnectionStateLoggedOut, testErr)
+	cli.setDisconnectedState(types.ConnectionStateDisconnected, nil)
+	if state := cli.State(); state != types.ConnectionStateLoggedOut {
+		t.Fatalf("logged out state was overridden with %s", state)
+	}
+
+	expected := []events.ConnectionState{
+		{State: types.ConnectionStateConnecting, Previous: types.ConnectionStateDisconnected},
+		{State: types.ConnectionStateConnected, Previous: types.ConnectionStateConnecting},
+		{State: types.ConnectionStateLoggedOut, Previous: types.ConnectionStateConnected, Error: testErr},
+		{State: types.ConnectionStateBackingOff, Previous: types.ConnectionStateLoggedOut},
+	}
+	for i, exp := range expected {
+		select {
+		case evt := <-evts:
+			if *evt != exp {
+				t.Fatalf("unexpected event #%d: %+v", i+1, evt)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for event #%d", i+1)
+		}
+	}
+	select {
+	case evt := <-evts:
+		t.Fatalf("unexpected extra event %+v", evt)
+	case <-time.After(50 * time.Millisecond):
+	}
+	if !types.ConnectionStateBanned.IsTerminal() || types.ConnectionStateBackingOff.IsTerminal() {
+		t.Fatal("unexpected IsTerminal result")
+	}
+}
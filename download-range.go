@@ -0,0 +1,164 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package whatsmeow
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"fmt"
+)
+
+// DownloadRange downloads and decrypts a part of a media file without downloading the whole file,
+// e.g. to seek in a video or to read the header of a large document.
+//
+// The offset and length are in bytes of the decrypted file. The range is clamped to the end of the file,
+// so the returned slice may be shorter than the requested length. The message must have the file length set.
+//
+// Media is encrypted with AES-CBC, so any block can be decrypted using the previous block of ciphertext as the IV.
+// However, the HMAC and hashes only cover the whole file, which means that the returned data is only verified if the
+// message has a streaming sidecar (which is usually the case for videos and audio). In that case, the 64 KiB chunks
+// overlapping the range are downloaded and checked against the sidecar. Otherwise, the data is returned unverified.
+func (cli *Client) DownloadRange(ctx context.Context, msg DownloadableMessage, offset, length int64, extra ...DownloadExtra) ([]byte, error) {
+	req, err := getDownloadExtra(extra)
+	if err != nil {
+		return nil, err
+	}
+	mediaType, url, err := cli.getDownloadInfo(msg)
+	if err != nil {
+		return nil, err
+	}
+	fileLength := int64(getSize(msg))
+	if fileLength <= 0 {
+		return nil, fmt.Errorf("%w: message doesn't contain the file length", ErrInvalidMediaRange)
+	} else if offset < 0 || length < 0 || offset > fileLength {
+		return nil, fmt.Errorf("%w: %d+%d is outside file of length %d", ErrInvalidMediaRange, offset, length, fileLength)
+	} else if offset+length > fileLength {
+		length = fileLength - offset
+	}
+	if length == 0 {
+		return []byte{}, nil
+	} else if data := cli.getCachedMedia(msg.GetFileSha256(), msg.GetFileEncSha256(), req.Progress); data != nil && int64(len(data)) == fileLength {
+		return data[offset : offset+length], nil
+	}
+	var sidecar []byte
+	if withSidecar, ok := msg.(downloadableMessageWithSidecar); ok {
+		sidecar = withSidecar.GetStreamingSidecar()
+		if len(sidecar) > 0 && !cli.sidecarMatchesFile(sidecar, int(fileLength)) {
+			sidecar = nil
+		}
+	}
+	r := &mediaRange{
+		mediaKey:   msg.GetMediaKey(),
+		newsletter: isPlainMedia(req.Newsletter, msg.GetMediaKey(), msg.GetFileSha256()),
+		mediaType:  mediaType,
+		fileLength: fileLength,
+		sidecar:    sidecar,
+		offset:     offset,
+		length:     length,
+		progress:   req.Progress,
+	}
+	if !r.newsletter && len(r.mediaKey) == 0 {
+		return nil, ErrNoMediaKey
+	} else if len(url) > 0 {
+		return cli.downloadMediaRange(ctx, url, r)
+	}
+	mediaConn, err := cli.refreshMediaConn(ctx, false)
+	if err != nil {
+		return nil, fmt.Errorf("failed to refresh media connections: %w", err)
+	}
+	for i, host := range mediaConn.Hosts {
+		mediaURL := getMediaURL(host.Hostname, msg.GetDirectPath(), msg.GetFileEncSha256(), mediaTypeToMMSType[mediaType], r.newsletter)
+		data, err := cli.downloadMediaRange(ctx, mediaURL, r)
+		if err == nil {
+			return data, nil
+		} else if i >= len(mediaConn.Hosts)-1 {
+			return nil, fmt.Errorf("failed to download media from last host: %w", err)
+		}
+		cli.Log.Warnf("Failed to download media range: %s, trying with next host...", err)
+	}
+	return nil, fmt.Errorf("failed to download media: no media hosts available")
+}
+
+// mediaRange contains the parameters of a DownloadRange call.
+type mediaRange struct {
+	mediaKey   []byte
+	newsletter bool
+	mediaType  MediaType
+	fileLength int64
+	sidecar    []byte
+	offset     int64
+	length     int64
+	progress   MediaProgressFunc
+}
+
+func (cli *Client) downloadMediaRange(ctx context.Context, url string, r *mediaRange) ([]byte, error) {
+	var buf bytes.Buffer
+	if r.newsletter {
+		// Unencrypted newsletter media, the range can be downloaded as-is
+		err := cli.downloadMediaResumable(ctx, url, r.offset, r.offset+r.length, &buf, r.progress)
+		return buf.Bytes(), err
+	}
+	iv, cipherKey, macKey, _ := getMediaKeys(r.mediaKey, r.mediaType)
+	// The range of ciphertext blocks that contain the requested plaintext.
+	// The block before the first one is also needed, as it's the IV for decrypting the first block.
+	blockStart := r.offset / aes.BlockSize * aes.BlockSize
+	blockEnd := (r.offset + r.length + aes.BlockSize - 1) / aes.BlockSize * aes.BlockSize
+	fetchStart, fetchEnd := blockStart-aes.BlockSize, blockEnd
+	var chunkStart, chunkEnd int64
+	if r.sidecar != nil {
+		// Verifying with the sidecar requires downloading whole chunks and the last block of the previous chunk
+		chunkStart = blockStart / sidecarChunkSize
+		chunkEnd = (blockEnd-1)/sidecarChunkSize + 1
+		fetchStart = chunkStart*sidecarChunkSize - aes.BlockSize
+		fetchEnd = chunkEnd * sidecarChunkSize
+		if encryptedSize := encryptedMediaSize(uint64(r.fileLength)); fetchEnd > encryptedSize {
+			fetchEnd = encryptedSize
+		}
+	}
+	if fetchStart < 0 {
+		fetchStart = 0
+	}
+	if err := cli.downloadMediaResumable(ctx, url, fetchStart, fetchEnd, &buf, r.progress); err != nil {
+		return nil, err
+	}
+	data := buf.Bytes()
+	// at returns the downloaded data starting from the given offset in the encrypted file.
+	at := func(pos int64) []byte {
+		return data[pos-fetchStart:]
+	}
+	if r.sidecar != nil {
+		chunkIV := iv
+		if chunkStart > 0 {
+			chunkIV = at(chunkStart*sidecarChunkSize - aes.BlockSize)[:aes.BlockSize]
+		}
+		for chunk := chunkStart; chunk < chunkEnd; chunk++ {
+			chunkData := at(chunk * sidecarChunkSize)
+			if len(chunkData) > sidecarChunkSize {
+				chunkData = chunkData[:sidecarChunkSize]
+			}
+			if err := verifySidecarChunk(r.sidecar, macKey, chunkIV, chunkData, int(chunk)); err != nil {
+				return nil, err
+			} else if len(chunkData) >= sidecarChunkSize {
+				chunkIV = chunkData[sidecarChunkSize-aes.BlockSize : sidecarChunkSize]
+			}
+		}
+	}
+	if blockStart > 0 {
+		iv = at(blockStart - aes.BlockSize)[:aes.BlockSize]
+	}
+	block, err := aes.NewCipher(cipherKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt file: %w", err)
+	}
+	ciphertext := at(blockStart)[:blockEnd-blockStart]
+	plaintext := make([]byte, len(ciphertext))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(plaintext, ciphertext)
+	skip := r.offset - blockStart
+	return plaintext[skip : skip+r.length], nil
+}
@@ -0,0 +1,115 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package whatsmeow
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-whatsapp/go-util/random"
+	"google.golang.org/protobuf/proto"
+
+	waProto "github.com/go-whatsapp/whatsmeow/binary/proto"
+	waLog "github.com/go-whatsapp/whatsmeow/util/log"
+)
+
+func TestDownloadMediaRange(t *testing.T) {
+	media := encryptTestMedia(t, random.Bytes(3*sidecarChunkSize+1000))
+	iv, _, macKey, _ := getMediaKeys(media.mediaKey, MediaDocument)
+	generator := newSidecarGenerator(iv, macKey)
+	_, _ = generator.Write(media.encrypted)
+	sidecar := generator.Sum()
+
+	served := media.encrypted
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		http.ServeContent(w, r, "media", time.Time{}, bytes.NewReader(served))
+	}))
+	defer server.Close()
+	cli := NewClient(newTestDevice(t), waLog.Noop)
+
+	fileLength := int64(len(media.plaintext))
+	ranges := [][2]int64{
+		{0, 1}, {0, 16}, {15, 2}, {16, 16}, {1000, 5000},
+		{sidecarChunkSize - 5, 10}, {sidecarChunkSize, sidecarChunkSize},
+		{2*sidecarChunkSize + 17, sidecarChunkSize}, {fileLength - 1, 1}, {fileLength - 1000, 1000}, {0, fileLength},
+	}
+	for _, withSidecar := range []bool{false, true} {
+		for _, rng := range ranges {
+			r := &mediaRange{mediaKey: media.mediaKey, mediaType: MediaDocument, fileLength: fileLength, offset: rng[0], length: rng[1]}
+			if withSidecar {
+				r.sidecar = sidecar
+			}
+			data, err := cli.downloadMediaRange(context.Background(), server.URL, r)
+			if err != nil {
+				t.Fatalf("range %d+%d (sidecar: %t): %v", rng[0], rng[1], withSidecar, err)
+			} else if !bytes.Equal(data, media.plaintext[rng[0]:rng[0]+rng[1]]) {
+				t.Fatalf("range %d+%d (sidecar: %t): data doesn't match", rng[0], rng[1], withSidecar)
+			}
+		}
+	}
+
+	// Corrupted chunks must be detected when there's a sidecar, even if they're only used as the IV
+	served = bytes.Clone(media.encrypted)
+	served[sidecarChunkSize-1] ^= 1
+	r := &mediaRange{mediaKey: media.mediaKey, mediaType: MediaDocument, fileLength: fileLength, offset: sidecarChunkSize + 100, length: 10, sidecar: sidecar}
+	if _, err := cli.downloadMediaRange(context.Background(), server.URL, r); !errors.Is(err, ErrInvalidStreamingSidecar) {
+		t.Fatalf("expected ErrInvalidStreamingSidecar, got %v", err)
+	}
+
+	// Unencrypted newsletter media is served as-is
+	served = media.plaintext
+	r = &mediaRange{newsletter: true, fileLength: fileLength, offset: 1000, length: 100}
+	if data, err := cli.downloadMediaRange(context.Background(), server.URL, r); err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(data, media.plaintext[1000:1100]) {
+		t.Fatal("newsletter range doesn't match")
+	}
+	if int(requests.Load()) != 2*len(ranges)+2 {
+		t.Fatalf("expected one request per range, got %d requests", requests.Load())
+	}
+}
+
+func TestDownloadRangeBounds(t *testing.T) {
+	media := encryptTestMedia(t, random.Bytes(1000))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "media", time.Time{}, bytes.NewReader(media.encrypted))
+	}))
+	defer server.Close()
+	cli := NewClient(newTestDevice(t), waLog.Noop)
+	msg := &waProto.DocumentMessage{
+		Url:           proto.String(server.URL),
+		MediaKey:      media.mediaKey,
+		FileSha256:    media.fileSha256,
+		FileEncSha256: media.fileEncSha256,
+		FileLength:    proto.Uint64(1000),
+	}
+
+	if data, err := cli.DownloadRange(context.Background(), msg, 990, 100); err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(data, media.plaintext[990:]) {
+		t.Fatal("range wasn't clamped to end of file")
+	} else if data, err = cli.DownloadRange(context.Background(), msg, 1000, 10); err != nil || len(data) != 0 {
+		t.Fatalf("expected empty range at end of file, got %d bytes (%v)", len(data), err)
+	}
+	for _, rng := range [][2]int64{{-1, 10}, {0, -1}, {1001, 1}} {
+		if _, err := cli.DownloadRange(context.Background(), msg, rng[0], rng[1]); !errors.Is(err, ErrInvalidMediaRange) {
+			t.Fatalf("range %d+%d: expected ErrInvalidMediaRange, got %v", rng[0], rng[1], err)
+		}
+	}
+	msg.FileLength = nil
+	if _, err := cli.DownloadRange(context.Background(), msg, 0, 10); !errors.Is(err, ErrInvalidMediaRange) {
+		t.Fatalf("expected ErrInvalidMediaRange without file length, got %v", err)
+	}
+}
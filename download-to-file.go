@@ -0,0 +1,383 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package whatsmeow
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+
+	waProto "github.com/go-whatsapp/whatsmeow/binary/proto"
+)
+
+// File is the interface that DownloadToFile needs from the output file. *os.File implements it.
+type File interface {
+	io.Writer
+	io.Seeker
+	Truncate(size int64) error
+}
+
+// DownloadAnyToWriter is like DownloadAny, but writes the decrypted data into the given writer instead of returning it.
+// See DownloadToWriter for more info.
+func (cli *Client) DownloadAnyToWriter(ctx context.Context, msg *waProto.Message, w io.Writer, extra ...DownloadExtra) error {
+	downloadable := getDownloadableMessage(msg)
+	if downloadable == nil {
+		return ErrNothingDownloadableFound
+	}
+	return cli.DownloadToWriter(ctx, downloadable, w, extra...)
+}
+
+// DownloadToWriter is like Download, but streams the decrypted data into the given writer
+// instead of buffering the whole file in memory.
+//
+// The hashes and the HMAC of the file can only be checked after the whole file has been downloaded,
+// so if an error is returned, the writer may already contain some (possibly invalid) data that must be discarded.
+// Use DownloadToFile to have the output truncated automatically on errors.
+//
+// If the message has a streaming sidecar (which is usually the case for videos and audio), each 64 KiB chunk
+// is verified against the sidecar before it's decrypted and written, so tampered data is never passed to the writer.
+// The hashes of the whole file are still checked at the end.
+//
+// If the connection is interrupted, the download is resumed using a HTTP Range request instead of starting over.
+//
+// Files in Client.MediaCache are used if present. Streamed downloads are only stored in the cache if they're
+// no larger than MediaCacheMaxStreamedSize, as storing them requires buffering a copy of the whole file.
+func (cli *Client) DownloadToWriter(ctx context.Context, msg DownloadableMessage, w io.Writer, extra ...DownloadExtra) error {
+	req, err := getDownloadExtra(extra)
+	if err != nil {
+		return err
+	}
+	return cli.downloadToOutput(ctx, msg, &mediaOutput{w: w, progress: req.Progress, newsletter: req.Newsletter})
+}
+
+// DownloadToFile is like DownloadToWriter, but writes into a file, which should be empty.
+// If the download fails (including when the hashes or HMAC don't match), the file is truncated
+// so that no unverified data is left behind.
+//
+// Unlike DownloadToWriter, this can also retry downloads with another media host if one fails halfway through,
+// as the file can be rewound before retrying.
+func (cli *Client) DownloadToFile(ctx context.Context, msg DownloadableMessage, file File, extra ...DownloadExtra) error {
+	req, err := getDownloadExtra(extra)
+	if err != nil {
+		return err
+	}
+	out := &mediaOutput{w: file, file: file, progress: req.Progress, newsletter: req.Newsletter}
+	err = cli.downloadToOutput(ctx, msg, out)
+	return out.truncateOnError(err)
+}
+
+// DownloadMediaWithPathToWriter is like DownloadMediaWithPath, but writes the decrypted data into the given writer
+// instead of returning it. See DownloadToWriter for more info.
+func (cli *Client) DownloadMediaWithPathToWriter(ctx context.Context, directPath string, encFileHash, fileHash, mediaKey []byte, fileLength int, mediaType MediaType, mmsType string, w io.Writer, extra ...DownloadExtra) error {
+	req, err := getDownloadExtra(extra)
+	if err != nil {
+		return err
+	}
+	return cli.downloadMediaWithPathToOutput(ctx, directPath, encFileHash, fileHash, mediaKey, fileLength, mediaType, mmsType, &mediaOutput{w: w, progress: req.Progress, newsletter: req.Newsletter})
+}
+
+// DownloadMediaWithPathToFile is like DownloadMediaWithPath, but writes the decrypted data into the given file
+// instead of returning it. See DownloadToFile for more info.
+func (cli *Client) DownloadMediaWithPathToFile(ctx context.Context, directPath string, encFileHash, fileHash, mediaKey []byte, fileLength int, mediaType MediaType, mmsType string, file File, extra ...DownloadExtra) error {
+	req, err := getDownloadExtra(extra)
+	if err != nil {
+		return err
+	}
+	out := &mediaOutput{w: file, file: file, progress: req.Progress, newsletter: req.Newsletter}
+	err = cli.downloadMediaWithPathToOutput(ctx, directPath, encFileHash, fileHash, mediaKey, fileLength, mediaType, mmsType, out)
+	return out.truncateOnError(err)
+}
+
+var errOutputNotRewindable = errors.New("can't retry download as the output can't be rewound")
+
+// mediaOutput wraps the writer passed to the streaming download functions
+// to keep track of whether anything has been written yet.
+type mediaOutput struct {
+	w        io.Writer
+	file     File
+	progress MediaProgressFunc
+	written  int64
+	// newsletter is set for unencrypted newsletter media, see DownloadExtra.Newsletter.
+	newsletter bool
+
+	// sidecar is the streaming sidecar of the file, which is used to verify chunks before writing them.
+	sidecar []byte
+	// cache collects a copy of the written data if the file should be stored in the media cache afterwards.
+	cache *bytes.Buffer
+}
+
+func (out *mediaOutput) Write(p []byte) (n int, err error) {
+	n, err = out.w.Write(p)
+	out.written += int64(n)
+	if out.cache != nil {
+		out.cache.Write(p[:n])
+	}
+	return
+}
+
+// rewind discards everything written so far, so that the download can be retried.
+func (out *mediaOutput) rewind() error {
+	if out.written == 0 {
+		return nil
+	} else if out.file == nil {
+		return errOutputNotRewindable
+	} else if _, err := out.file.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to seek to start of file: %w", err)
+	} else if err = out.file.Truncate(0); err != nil {
+		return fmt.Errorf("failed to truncate file: %w", err)
+	}
+	out.written = 0
+	if out.cache != nil {
+		out.cache.Reset()
+	}
+	return nil
+}
+
+func (out *mediaOutput) truncateOnError(err error) error {
+	if err != nil && out.written > 0 {
+		if rewindErr := out.rewind(); rewindErr != nil {
+			return fmt.Errorf("%w (also failed to clear output: %v)", err, rewindErr)
+		}
+	}
+	return err
+}
+
+func (cli *Client) downloadToOutput(ctx context.Context, msg DownloadableMessage, out *mediaOutput) error {
+	mediaType, url, err := cli.getDownloadInfo(msg)
+	if err != nil {
+		return err
+	}
+	if withSidecar, ok := msg.(downloadableMessageWithSidecar); ok {
+		out.sidecar = withSidecar.GetStreamingSidecar()
+	}
+	if len(url) > 0 {
+		if data := cli.getCachedMedia(msg.GetFileSha256(), msg.GetFileEncSha256(), out.progress); data != nil {
+			_, err = out.Write(data)
+			return err
+		}
+		cli.startCachingOutput(out, getSize(msg))
+		err = cli.downloadAndDecryptToOutput(ctx, url, msg.GetMediaKey(), mediaType, getSize(msg), msg.GetFileEncSha256(), msg.GetFileSha256(), out)
+		if err == nil {
+			cli.putCachedOutput(msg.GetFileSha256(), msg.GetFileEncSha256(), out)
+		}
+		return err
+	} else {
+		return cli.downloadMediaWithPathToOutput(ctx, msg.GetDirectPath(), msg.GetFileEncSha256(), msg.GetFileSha256(), msg.GetMediaKey(), getSize(msg), mediaType, mediaTypeToMMSType[mediaType], out)
+	}
+}
+
+func (cli *Client) downloadMediaWithPathToOutput(ctx context.Context, directPath string, encFileHash, fileHash, mediaKey []byte, fileLength int, mediaType MediaType, mmsType string, out *mediaOutput) error {
+	if data := cli.getCachedMedia(fileHash, encFileHash, out.progress); data != nil {
+		_, err := out.Write(data)
+		return err
+	}
+	cli.startCachingOutput(out, fileLength)
+	out.newsletter = isPlainMedia(out.newsletter, mediaKey, fileHash)
+	if !out.newsletter && len(mediaKey) == 0 {
+		// Other hosts won't help if there's no key to decrypt the file with, so don't try any of them
+		return ErrNoMediaKey
+	}
+	mediaConn, err := cli.refreshMediaConn(ctx, false)
+	if err != nil {
+		return fmt.Errorf("failed to refresh media connections: %w", err)
+	}
+	if len(mmsType) == 0 {
+		mmsType = mediaTypeToMMSType[mediaType]
+	}
+	for i, host := range mediaConn.Hosts {
+		mediaURL := getMediaURL(host.Hostname, directPath, encFileHash, mmsType, out.newsletter)
+		err = cli.downloadAndDecryptToOutput(ctx, mediaURL, mediaKey, mediaType, fileLength, encFileHash, fileHash, out)
+		if err == nil {
+			cli.putCachedOutput(fileHash, encFileHash, out)
+			return nil
+		} else if i >= len(mediaConn.Hosts)-1 {
+			return fmt.Errorf("failed to download media from last host: %w", err)
+		} else if rewindErr := out.rewind(); rewindErr != nil {
+			return fmt.Errorf("failed to download media: %w (%v)", err, rewindErr)
+		}
+		cli.Log.Warnf("Failed to download media: %s, trying with next host...", err)
+	}
+	return fmt.Errorf("failed to download media: no media hosts available")
+}
+
+// downloadAndDecryptToOutput streams the file at the given URL into the output, decrypting it on the fly.
+// Interrupted downloads are resumed from where they left off (or from the last verified chunk if there's a sidecar),
+// so the output only needs to be rewound if the resumed file fails verification at the end.
+func (cli *Client) downloadAndDecryptToOutput(ctx context.Context, url string, mediaKey []byte, appInfo MediaType, fileLength int, fileEncSha256, fileSha256 []byte, out *mediaOutput) error {
+	if isPlainMedia(out.newsletter, mediaKey, fileSha256) {
+		// Unencrypted newsletter media, just copy the data as-is and check the hash at the end
+		if len(fileSha256) != 32 {
+			return ErrNoMediaSHA256
+		}
+		verifier := newPlainMediaVerifier()
+		if err := cli.downloadMediaResumable(ctx, url, 0, -1, io.MultiWriter(out, verifier), out.progress); err != nil {
+			return err
+		}
+		return verifier.finish(fileLength, fileSha256)
+	} else if len(mediaKey) == 0 {
+		return ErrNoMediaKey
+	}
+	for restarted := false; ; restarted = true {
+		resumed, err := cli.downloadAndDecryptEncryptedToOutput(ctx, url, mediaKey, appInfo, fileLength, fileEncSha256, fileSha256, out)
+		if restarted || !shouldRestartMediaDownload(resumed, err) {
+			return err
+		} else if rewindErr := out.rewind(); rewindErr != nil {
+			return fmt.Errorf("%w (also failed to clear output: %v)", err, rewindErr)
+		}
+		cli.Log.Warnf("Resumed media download failed verification (%v), downloading again from the start", err)
+	}
+}
+
+func (cli *Client) downloadAndDecryptEncryptedToOutput(ctx context.Context, url string, mediaKey []byte, appInfo MediaType, fileLength int, fileEncSha256, fileSha256 []byte, out *mediaOutput) (resumed bool, err error) {
+	iv, cipherKey, macKey, _ := getMediaKeys(mediaKey, appInfo)
+	decrypter, err := newMediaDecrypter(out, iv, cipherKey, macKey)
+	if err != nil {
+		return false, fmt.Errorf("failed to decrypt file: %w", err)
+	}
+	var dst io.Writer = decrypter
+	var verifier *sidecarVerifier
+	if len(out.sidecar) > 0 && cli.sidecarMatchesFile(out.sidecar, fileLength) {
+		verifier = newSidecarVerifier(decrypter, iv, macKey, out.sidecar)
+		dst = verifier
+	}
+	if resumed, err = cli.downloadMediaWithResume(ctx, url, 0, -1, dst, out.progress); err != nil {
+		return
+	} else if verifier != nil {
+		if err = verifier.finish(); err != nil {
+			return
+		}
+	}
+	err = decrypter.finish(fileLength, fileEncSha256, fileSha256)
+	return
+}
+
+const mediaHMACLength = 10
+
+// mediaDecrypter is an io.Writer that decrypts media files on the fly and writes the plaintext into another writer.
+//
+// The HMAC at the end of the file and the last block of ciphertext (which contains the padding) are held back
+// until finish is called, as they can't be told apart from the rest of the data until the whole file has been read.
+type mediaDecrypter struct {
+	out io.Writer
+	cbc cipher.BlockMode
+
+	mac        hash.Hash
+	encHash    hash.Hash
+	hash       hash.Hash
+	fileLength int
+
+	pending   []byte
+	plaintext []byte
+}
+
+func newMediaDecrypter(out io.Writer, iv, cipherKey, macKey []byte) (*mediaDecrypter, error) {
+	block, err := aes.NewCipher(cipherKey)
+	if err != nil {
+		return nil, err
+	}
+	mac := hmac.New(sha256.New, macKey)
+	mac.Write(iv)
+	return &mediaDecrypter{
+		out:     out,
+		cbc:     cipher.NewCBCDecrypter(block, iv),
+		mac:     mac,
+		encHash: sha256.New(),
+		hash:    sha256.New(),
+	}, nil
+}
+
+func (md *mediaDecrypter) Write(p []byte) (int, error) {
+	md.encHash.Write(p)
+	md.pending = append(md.pending, p...)
+	if n := len(md.pending) - mediaHMACLength - aes.BlockSize; n >= aes.BlockSize {
+		n -= n % aes.BlockSize
+		md.mac.Write(md.pending[:n])
+		if err := md.writePlaintext(md.decrypt(md.pending[:n])); err != nil {
+			return 0, err
+		}
+		md.pending = append(md.pending[:0], md.pending[n:]...)
+	}
+	return len(p), nil
+}
+
+func (md *mediaDecrypter) decrypt(ciphertext []byte) []byte {
+	if cap(md.plaintext) < len(ciphertext) {
+		md.plaintext = make([]byte, len(ciphertext))
+	}
+	plaintext := md.plaintext[:len(ciphertext)]
+	md.cbc.CryptBlocks(plaintext, ciphertext)
+	return plaintext
+}
+
+func (md *mediaDecrypter) writePlaintext(plaintext []byte) error {
+	md.hash.Write(plaintext)
+	md.fileLength += len(plaintext)
+	_, err := md.out.Write(plaintext)
+	return err
+}
+
+// finish validates the hashes and the HMAC, then decrypts and writes the remaining data.
+func (md *mediaDecrypter) finish(fileLength int, fileEncSha256, fileSha256 []byte) error {
+	if len(md.pending) <= mediaHMACLength {
+		return ErrTooShortFile
+	} else if len(fileEncSha256) == 32 && !bytes.Equal(md.encHash.Sum(nil), fileEncSha256) {
+		return ErrInvalidMediaEncSHA256
+	}
+	ciphertext, mac := md.pending[:len(md.pending)-mediaHMACLength], md.pending[len(md.pending)-mediaHMACLength:]
+	md.mac.Write(ciphertext)
+	if !hmac.Equal(md.mac.Sum(nil)[:mediaHMACLength], mac) {
+		return ErrInvalidMediaHMAC
+	} else if len(ciphertext) == 0 || len(ciphertext)%aes.BlockSize != 0 {
+		return fmt.Errorf("failed to decrypt file: ciphertext is not a multiple of the block size")
+	}
+	plaintext := md.decrypt(ciphertext)
+	padLen := int(plaintext[len(plaintext)-1])
+	if padLen == 0 || padLen > aes.BlockSize {
+		return fmt.Errorf("failed to decrypt file: invalid padding length %d", padLen)
+	} else if err := md.writePlaintext(plaintext[:len(plaintext)-padLen]); err != nil {
+		return err
+	} else if fileLength >= 0 && md.fileLength != fileLength {
+		return fmt.Errorf("%w: expected %d, got %d", ErrFileLengthMismatch, fileLength, md.fileLength)
+	} else if len(fileSha256) == 32 && !bytes.Equal(md.hash.Sum(nil), fileSha256) {
+		return ErrInvalidMediaSHA256
+	}
+	return nil
+}
+
+// plainMediaVerifier is an io.Writer that checks the length and hash of unencrypted media.
+type plainMediaVerifier struct {
+	hash       hash.Hash
+	fileLength int
+}
+
+func newPlainMediaVerifier() *plainMediaVerifier {
+	return &plainMediaVerifier{hash: sha256.New()}
+}
+
+func (pmv *plainMediaVerifier) Write(p []byte) (int, error) {
+	pmv.hash.Write(p)
+	pmv.fileLength += len(p)
+	return len(p), nil
+}
+
+func (pmv *plainMediaVerifier) finish(fileLength int, fileSha256 []byte) error {
+	if fileLength >= 0 && pmv.fileLength != fileLength {
+		return fmt.Errorf("%w: expected %d, got %d", ErrFileLengthMismatch, fileLength, pmv.fileLength)
+	} else if len(fileSha256) != 32 {
+		return ErrNoMediaSHA256
+	} else if !bytes.Equal(pmv.hash.Sum(nil), fileSha256) {
+		return ErrInvalidMediaSHA256
+	}
+	return nil
+}
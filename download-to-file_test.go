@@ -0,0 +1,198 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package whatsmeow
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-whatsapp/go-util/random"
+
+	"github.com/go-whatsapp/whatsmeow/util/cbcutil"
+	waLog "github.com/go-whatsapp/whatsmeow/util/log"
+)
+
+type testEncryptedMedia struct {
+	mediaKey      []byte
+	plaintext     []byte
+	encrypted     []byte
+	fileSha256    []byte
+	fileEncSha256 []byte
+}
+
+// encryptTestMedia encrypts the given plaintext in one go, the same way as Upload.
+func encryptTestMedia(t *testing.T, plaintext []byte) *testEncryptedMedia {
+	t.Helper()
+	media := &testEncryptedMedia{mediaKey: random.Bytes(32), plaintext: plaintext}
+	iv, cipherKey, macKey, _ := getMediaKeys(media.mediaKey, MediaDocument)
+	ciphertext, err := cbcutil.Encrypt(cipherKey, iv, plaintext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mac := hmac.New(sha256.New, macKey)
+	mac.Write(iv)
+	mac.Write(ciphertext)
+	media.encrypted = append(ciphertext, mac.Sum(nil)[:mediaHMACLength]...)
+	fileSha256 := sha256.Sum256(plaintext)
+	fileEncSha256 := sha256.Sum256(media.encrypted)
+	media.fileSha256, media.fileEncSha256 = fileSha256[:], fileEncSha256[:]
+	return media
+}
+
+func (media *testEncryptedMedia) decrypt(chunkSize int, encrypted []byte) ([]byte, error) {
+	iv, cipherKey, macKey, _ := getMediaKeys(media.mediaKey, MediaDocument)
+	var out bytes.Buffer
+	decrypter, err := newMediaDecrypter(&out, iv, cipherKey, macKey)
+	if err != nil {
+		return nil, err
+	}
+	for len(encrypted) > 0 {
+		n := chunkSize
+		if n > len(encrypted) {
+			n = len(encrypted)
+		}
+		if _, err = decrypter.Write(encrypted[:n]); err != nil {
+			return nil, err
+		}
+		encrypted = encrypted[n:]
+	}
+	err = decrypter.finish(len(media.plaintext), media.fileEncSha256, media.fileSha256)
+	return out.Bytes(), err
+}
+
+func TestMediaDecrypter(t *testing.T) {
+	for _, size := range []int{0, 1, 15, 16, 17, 1000, 100 * 1024} {
+		media := encryptTestMedia(t, random.Bytes(size))
+		// Chunks that don't align with AES blocks or the HMAC must not matter
+		for _, chunkSize := range []int{1, 7, 16, 26, 4096, len(media.encrypted)} {
+			decrypted, err := media.decrypt(chunkSize, media.encrypted)
+			if err != nil {
+				t.Fatalf("size %d, chunk size %d: %v", size, chunkSize, err)
+			} else if !bytes.Equal(decrypted, media.plaintext) {
+				t.Fatalf("size %d, chunk size %d: decrypted data doesn't match", size, chunkSize)
+			}
+		}
+	}
+
+	media := encryptTestMedia(t, random.Bytes(1000))
+	if _, err := media.decrypt(100, media.encrypted[:5]); !errors.Is(err, ErrTooShortFile) {
+		t.Fatalf("expected ErrTooShortFile, got %v", err)
+	}
+	tampered := bytes.Clone(media.encrypted)
+	tampered[len(tampered)-1] ^= 1
+	if _, err := media.decrypt(100, tampered); !errors.Is(err, ErrInvalidMediaEncSHA256) {
+		t.Fatalf("expected ErrInvalidMediaEncSHA256, got %v", err)
+	}
+	media.fileEncSha256 = nil
+	if _, err := media.decrypt(100, tampered); !errors.Is(err, ErrInvalidMediaHMAC) {
+		t.Fatalf("expected ErrInvalidMediaHMAC, got %v", err)
+	}
+	media.plaintext = media.plaintext[:999]
+	if _, err := media.decrypt(100, media.encrypted); !errors.Is(err, ErrFileLengthMismatch) {
+		t.Fatalf("expected ErrFileLengthMismatch, got %v", err)
+	}
+	media.plaintext = make([]byte, 1000)
+	media.fileSha256 = make([]byte, 32)
+	if _, err := media.decrypt(100, media.encrypted); !errors.Is(err, ErrInvalidMediaSHA256) {
+		t.Fatalf("expected ErrInvalidMediaSHA256, got %v", err)
+	}
+}
+
+func TestMediaOutputTruncateOnError(t *testing.T) {
+	file, err := os.Create(filepath.Join(t.TempDir(), "media"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	out := &mediaOutput{w: file, file: file, cache: &bytes.Buffer{}}
+	_, _ = out.Write([]byte("partial data"))
+	testErr := errors.New("test")
+	if err = out.truncateOnError(testErr); err != testErr {
+		t.Fatalf("unexpected error %v", err)
+	} else if info, _ := file.Stat(); info.Size() != 0 || out.written != 0 || out.cache.Len() != 0 {
+		t.Fatal("output wasn't cleared after error")
+	}
+	_, _ = out.Write([]byte("data"))
+	if info, _ := file.Stat(); info.Size() != 4 {
+		t.Fatalf("unexpected file size %d after rewinding", info.Size())
+	}
+
+	// Plain writers can't be rewound, so the error must say that the output has partial data
+	var buf bytes.Buffer
+	out = &mediaOutput{w: &buf}
+	_, _ = out.Write([]byte("partial data"))
+	if err = out.truncateOnError(testErr); !errors.Is(err, testErr) || err == testErr {
+		t.Fatalf("expected wrapped error about rewinding, got %v", err)
+	} else if err = (&mediaOutput{w: &buf}).rewind(); err != nil {
+		t.Fatalf("rewinding empty output failed: %v", err)
+	}
+}
+
+func TestPlainMediaVerifier(t *testing.T) {
+	data := random.Bytes(1000)
+	hash := sha256.Sum256(data)
+	verify := func(data []byte, fileLength int, fileSha256 []byte) error {
+		verifier := newPlainMediaVerifier()
+		_, _ = verifier.Write(data[:10])
+		_, _ = verifier.Write(data[10:])
+		return verifier.finish(fileLength, fileSha256)
+	}
+	if err := verify(data, 1000, hash[:]); err != nil {
+		t.Fatal(err)
+	} else if err = verify(data, -1, hash[:]); err != nil {
+		t.Fatalf("unknown length wasn't accepted: %v", err)
+	} else if err = verify(data, 999, hash[:]); !errors.Is(err, ErrFileLengthMismatch) {
+		t.Fatalf("expected ErrFileLengthMismatch, got %v", err)
+	} else if err = verify(data, 1000, nil); !errors.Is(err, ErrNoMediaSHA256) {
+		t.Fatalf("expected ErrNoMediaSHA256, got %v", err)
+	} else if err = verify(random.Bytes(1000), 1000, hash[:]); !errors.Is(err, ErrInvalidMediaSHA256) {
+		t.Fatalf("expected ErrInvalidMediaSHA256, got %v", err)
+	}
+}
+
+func TestDownloadNewsletterToOutput(t *testing.T) {
+	data := random.Bytes(1000)
+	hash := sha256.Sum256(data)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(data)
+	}))
+	defer server.Close()
+	cli := NewClient(newTestDevice(t), waLog.Noop)
+
+	var buf bytes.Buffer
+	out := &mediaOutput{w: &buf, newsletter: true}
+	if err := cli.downloadAndDecryptToOutput(context.Background(), server.URL, nil, MediaImage, len(data), nil, hash[:], out); err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(buf.Bytes(), data) {
+		t.Fatal("downloaded data doesn't match")
+	}
+	// The plaintext hash is the only thing protecting unencrypted media, so it's required
+	out = &mediaOutput{w: &buf, newsletter: true}
+	if err := cli.downloadAndDecryptToOutput(context.Background(), server.URL, nil, MediaImage, len(data), nil, nil, out); !errors.Is(err, ErrNoMediaSHA256) {
+		t.Fatalf("expected ErrNoMediaSHA256, got %v", err)
+	}
+	// Without the newsletter flag, media without a key is detected as unencrypted if it has a plaintext hash
+	buf.Reset()
+	out = &mediaOutput{w: &buf}
+	if err := cli.downloadAndDecryptToOutput(context.Background(), server.URL, nil, MediaImage, len(data), nil, hash[:], out); err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(buf.Bytes(), data) {
+		t.Fatal("downloaded data doesn't match without newsletter flag")
+	}
+	out = &mediaOutput{w: &buf}
+	if err := cli.downloadAndDecryptToOutput(context.Background(), server.URL, nil, MediaImage, len(data), nil, nil, out); !errors.Is(err, ErrNoMediaKey) {
+		t.Fatalf("expected ErrNoMediaKey, got %v", err)
+	}
+}
@@ -7,6 +7,8 @@
 package whatsmeow
 
 import (
+	"bytes"
+	"context"
 	"crypto/hmac"
 	"crypto/sha256"
 	"errors"
@@ -14,6 +16,7 @@ import (
 	"io"
 	"net"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
@@ -24,6 +27,7 @@ import (
 
 	waProto "github.com/go-whatsapp/whatsmeow/binary/proto"
 	"github.com/go-whatsapp/whatsmeow/socket"
+	"github.com/go-whatsapp/whatsmeow/types/events"
 	"github.com/go-whatsapp/whatsmeow/util/cbcutil"
 	"github.com/go-whatsapp/whatsmeow/util/hkdfutil"
 )
@@ -95,6 +99,11 @@ type downloadableMessageWithURL interface {
 	GetUrl() string
 }
 
+type downloadableMessageWithSidecar interface {
+	DownloadableMessage
+	GetStreamingSidecar() []byte
+}
+
 var classToMediaType = map[protoreflect.Name]MediaType{
 	"ImageMessage":    MediaImage,
 	"AudioMessage":    MediaAudio,
@@ -122,24 +131,81 @@ var mediaTypeToMMSType = map[MediaType]string{
 	MediaLinkThumbnail: "thumbnail-link",
 }
 
+// DownloadExtra contains optional parameters for the Download methods.
+type DownloadExtra struct {
+	// Progress is called as the encrypted file is being downloaded.
+	Progress MediaProgressFunc
+	// Newsletter forces the media to be downloaded as unencrypted newsletter media. It's usually not necessary,
+	// as media without a media key but with a plaintext hash is detected as newsletter media automatically,
+	// and DownloadMessageMedia sets it for messages with NewsletterMeta.
+	Newsletter bool
+}
+
+// isPlainMedia returns true if the media should be downloaded as unencrypted newsletter media, i.e. as-is from the
+// newsletter path and verified using only the plaintext hash. Encrypted media always has a media key, so the lack of
+// one together with a plaintext hash means the file isn't encrypted.
+func isPlainMedia(newsletter bool, mediaKey, fileSha256 []byte) bool {
+	return newsletter || (len(mediaKey) == 0 && len(fileSha256) > 0)
+}
+
+// isUnverifiedMedia returns true if the media has neither a media key nor any hashes. There's nothing to decrypt or
+// verify such media with, so Download and DownloadMediaWithPath return it as-is, like they always have.
+func isUnverifiedMedia(mediaKey, fileEncSha256, fileSha256 []byte) bool {
+	return len(mediaKey) == 0 && len(fileEncSha256) == 0 && len(fileSha256) == 0
+}
+
+func getDownloadExtra(extra []DownloadExtra) (req DownloadExtra, err error) {
+	if len(extra) > 1 {
+		err = errors.New("only one extra parameter may be provided to Download")
+	} else if len(extra) == 1 {
+		req = extra[0]
+	}
+	return
+}
+
 // DownloadAny loops through the downloadable parts of the given message and downloads the first non-nil item.
 func (cli *Client) DownloadAny(msg *waProto.Message) (data []byte, err error) {
-	if msg == nil {
+	return cli.DownloadAnyContext(context.Background(), msg)
+}
+
+// DownloadAnyContext is like DownloadAny, but takes a context for cancellation and deadlines.
+func (cli *Client) DownloadAnyContext(ctx context.Context, msg *waProto.Message, extra ...DownloadExtra) (data []byte, err error) {
+	downloadable := getDownloadableMessage(msg)
+	if downloadable == nil {
 		return nil, ErrNothingDownloadableFound
 	}
+	return cli.DownloadContext(ctx, downloadable, extra...)
+}
+
+// DownloadMessageMedia is like DownloadAnyContext, but takes a message event. If the message is from a newsletter,
+// the media is always downloaded as unencrypted newsletter media.
+func (cli *Client) DownloadMessageMedia(ctx context.Context, evt *events.Message, extra ...DownloadExtra) ([]byte, error) {
+	req, err := getDownloadExtra(extra)
+	if err != nil {
+		return nil, err
+	}
+	if evt.NewsletterMeta != nil {
+		req.Newsletter = true
+	}
+	return cli.DownloadAnyContext(ctx, evt.Message, req)
+}
+
+func getDownloadableMessage(msg *waProto.Message) DownloadableMessage {
 	switch {
+	case msg == nil:
+		return nil
 	case msg.ImageMessage != nil:
-		return cli.Download(msg.ImageMessage)
+		return msg.ImageMessage
 	case msg.VideoMessage != nil:
-		return cli.Download(msg.VideoMessage)
+		return msg.VideoMessage
 	case msg.AudioMessage != nil:
-		return cli.Download(msg.AudioMessage)
+		return msg.AudioMessage
 	case msg.DocumentMessage != nil:
-		return cli.Download(msg.DocumentMessage)
+		return msg.DocumentMessage
 	case msg.StickerMessage != nil:
-		return cli.Download(msg.StickerMessage)
+		return msg.StickerMessage
 	default:
-		return nil, ErrNothingDownloadableFound
+		return nil
 	}
 }
 
@@ -162,11 +228,16 @@ func getSize(msg DownloadableMessage) int {
 //	...
 //	thumbnailImageBytes, err := cli.DownloadThumbnail(msg.GetExtendedTextMessage())
 func (cli *Client) DownloadThumbnail(msg DownloadableThumbnail) ([]byte, error) {
+	return cli.DownloadThumbnailContext(context.Background(), msg)
+}
+
+// DownloadThumbnailContext is like DownloadThumbnail, but takes a context for cancellation and deadlines.
+func (cli *Client) DownloadThumbnailContext(ctx context.Context, msg DownloadableThumbnail) ([]byte, error) {
 	mediaType, ok := classToThumbnailMediaType[msg.ProtoReflect().Descriptor().Name()]
 	if !ok {
 		return nil, fmt.Errorf("%w '%s'", ErrUnknownMediaType, string(msg.ProtoReflect().Descriptor().Name()))
 	} else if len(msg.GetThumbnailDirectPath()) > 0 {
-		return cli.DownloadMediaWithPath(msg.GetThumbnailDirectPath(), msg.GetThumbnailEncSha256(), msg.GetThumbnailSha256(), msg.GetMediaKey(), -1, mediaType, mediaTypeToMMSType[mediaType])
+		return cli.DownloadMediaWithPathContext(ctx, msg.GetThumbnailDirectPath(), msg.GetThumbnailEncSha256(), msg.GetThumbnailSha256(), msg.GetMediaKey(), -1, mediaType, mediaTypeToMMSType[mediaType])
 	} else {
 		return nil, ErrNoURLPresent
 	}
@@ -186,34 +257,83 @@ func GetMediaType(msg DownloadableMessage) MediaType {
 //	imageData, err := cli.Download(msg.GetImageMessage())
 //
 // You can also use DownloadAny to download the first non-nil sub-message.
+//
+// Media in newsletter messages (i.e. events.Message with NewsletterMeta set) isn't encrypted. It's detected by the
+// lack of a media key and downloaded as-is, verified using only the FileSha256 field. DownloadMessageMedia can be
+// used to download media from message events without relying on that detection.
 func (cli *Client) Download(msg DownloadableMessage) ([]byte, error) {
-	mediaType, ok := classToMediaType[msg.ProtoReflect().Descriptor().Name()]
+	return cli.DownloadContext(context.Background(), msg)
+}
+
+// DownloadContext is like Download, but takes a context for cancellation and deadlines.
+func (cli *Client) DownloadContext(ctx context.Context, msg DownloadableMessage, extra ...DownloadExtra) ([]byte, error) {
+	req, err := getDownloadExtra(extra)
+	if err != nil {
+		return nil, err
+	}
+	mediaType, url, err := cli.getDownloadInfo(msg)
+	if err != nil {
+		return nil, err
+	} else if len(url) > 0 {
+		if data := cli.getCachedMedia(msg.GetFileSha256(), msg.GetFileEncSha256(), req.Progress); data != nil {
+			return data, nil
+		}
+		data, err := cli.downloadAndDecrypt(ctx, url, msg.GetMediaKey(), mediaType, getSize(msg), msg.GetFileEncSha256(), msg.GetFileSha256(), req)
+		if err == nil {
+			cli.putCachedMedia(msg.GetFileSha256(), msg.GetFileEncSha256(), data)
+		}
+		return data, err
+	} else {
+		return cli.DownloadMediaWithPathContext(ctx, msg.GetDirectPath(), msg.GetFileEncSha256(), msg.GetFileSha256(), msg.GetMediaKey(), getSize(msg), mediaType, mediaTypeToMMSType[mediaType], req)
+	}
+}
+
+// getDownloadInfo finds the media type of the given message and the URL to download it from.
+// If the returned URL is empty, the media should be downloaded using the direct path.
+func (cli *Client) getDownloadInfo(msg DownloadableMessage) (mediaType MediaType, url string, err error) {
+	var ok bool
+	mediaType, ok = classToMediaType[msg.ProtoReflect().Descriptor().Name()]
 	if !ok {
-		return nil, fmt.Errorf("%w '%s'", ErrUnknownMediaType, string(msg.ProtoReflect().Descriptor().Name()))
+		err = fmt.Errorf("%w '%s'", ErrUnknownMediaType, string(msg.ProtoReflect().Descriptor().Name()))
+		return
 	}
 	urlable, ok := msg.(downloadableMessageWithURL)
-	var url string
 	var isWebWhatsappNetURL bool
 	if ok {
 		url = urlable.GetUrl()
 		isWebWhatsappNetURL = strings.HasPrefix(url, "https://web.whatsapp.net")
 	}
 	if len(url) > 0 && !isWebWhatsappNetURL {
-		return cli.downloadAndDecrypt(url, msg.GetMediaKey(), mediaType, getSize(msg), msg.GetFileEncSha256(), msg.GetFileSha256())
-	} else if len(msg.GetDirectPath()) > 0 {
-		return cli.DownloadMediaWithPath(msg.GetDirectPath(), msg.GetFileEncSha256(), msg.GetFileSha256(), msg.GetMediaKey(), getSize(msg), mediaType, mediaTypeToMMSType[mediaType])
-	} else {
+		return
+	}
+	url = ""
+	if len(msg.GetDirectPath()) == 0 {
 		if isWebWhatsappNetURL {
-			cli.Log.Warnf("Got a media message with a web.whatsapp.net URL (%s) and no direct path", url)
+			cli.Log.Warnf("Got a media message with a web.whatsapp.net URL (%s) and no direct path", urlable.GetUrl())
 		}
-		return nil, ErrNoURLPresent
+		err = ErrNoURLPresent
 	}
+	return
 }
 
 // DownloadMediaWithPath downloads an attachment by manually specifying the path and encryption details.
 func (cli *Client) DownloadMediaWithPath(directPath string, encFileHash, fileHash, mediaKey []byte, fileLength int, mediaType MediaType, mmsType string) (data []byte, err error) {
+	return cli.DownloadMediaWithPathContext(context.Background(), directPath, encFileHash, fileHash, mediaKey, fileLength, mediaType, mmsType)
+}
+
+// DownloadMediaWithPathContext is like DownloadMediaWithPath, but takes a context for cancellation and deadlines.
+func (cli *Client) DownloadMediaWithPathContext(ctx context.Context, directPath string, encFileHash, fileHash, mediaKey []byte, fileLength int, mediaType MediaType, mmsType string, extra ...DownloadExtra) (data []byte, err error) {
+	var req DownloadExtra
+	if req, err = getDownloadExtra(extra); err != nil {
+		return
+	} else if data = cli.getCachedMedia(fileHash, encFileHash, req.Progress); data != nil {
+		return
+	} else if len(mediaKey) == 0 && !isPlainMedia(req.Newsletter, mediaKey, fileHash) && !isUnverifiedMedia(mediaKey, encFileHash, fileHash) {
+		// Other hosts won't help if there's no key to decrypt the file with, so don't try any of them
+		return nil, ErrNoMediaKey
+	}
 	var mediaConn *MediaConn
-	mediaConn, err = cli.refreshMediaConn(false)
+	mediaConn, err = cli.refreshMediaConn(ctx, false)
 	if err != nil {
 		return nil, fmt.Errorf("failed to refresh media connections: %w", err)
 	}
@@ -221,29 +341,43 @@ func (cli *Client) DownloadMediaWithPath(directPath string, encFileHash, fileHas
 		mmsType = mediaTypeToMMSType[mediaType]
 	}
 	for i, host := range mediaConn.Hosts {
-		// TODO omit hash for unencrypted media?
-		mediaURL := fmt.Sprintf("https://%s%s&hash=%s&mms-type=%s&__wa-mms=", host.Hostname, directPath, base64.URLEncoding.EncodeToString(encFileHash), mmsType)
-		data, err = cli.downloadAndDecrypt(mediaURL, mediaKey, mediaType, fileLength, encFileHash, fileHash)
+		mediaURL := getMediaURL(host.Hostname, directPath, encFileHash, mmsType, isPlainMedia(req.Newsletter, mediaKey, fileHash))
+		data, err = cli.downloadAndDecrypt(ctx, mediaURL, mediaKey, mediaType, fileLength, encFileHash, fileHash, req)
 		// TODO there are probably some errors that shouldn't retry
-		if err != nil {
-			if i >= len(mediaConn.Hosts)-1 {
-				return nil, fmt.Errorf("failed to download media from last host: %w", err)
-			}
-			cli.Log.Warnf("Failed to download media: %s, trying with next host...", err)
+		if err == nil {
+			cli.putCachedMedia(fileHash, encFileHash, data)
+			return
+		} else if i >= len(mediaConn.Hosts)-1 {
+			return nil, fmt.Errorf("failed to download media from last host: %w", err)
 		}
+		cli.Log.Warnf("Failed to download media: %s, trying with next host...", err)
 	}
 	return
 }
 
-func (cli *Client) downloadAndDecrypt(url string, mediaKey []byte, appInfo MediaType, fileLength int, fileEncSha256, fileSha256 []byte) (data []byte, err error) {
+func (cli *Client) downloadAndDecrypt(ctx context.Context, url string, mediaKey []byte, appInfo MediaType, fileLength int, fileEncSha256, fileSha256 []byte, req DownloadExtra) (data []byte, err error) {
+	if isPlainMedia(req.Newsletter, mediaKey, fileSha256) {
+		return cli.downloadPlainMedia(ctx, url, fileLength, fileSha256, req.Progress)
+	} else if isUnverifiedMedia(mediaKey, fileEncSha256, fileSha256) {
+		data, _, _, err = cli.downloadPossiblyEncryptedMediaWithRetries(ctx, url, nil, req.Progress)
+		return
+	} else if len(mediaKey) == 0 {
+		return nil, ErrNoMediaKey
+	}
 	iv, cipherKey, macKey, _ := getMediaKeys(mediaKey, appInfo)
 	var ciphertext, mac []byte
-	if ciphertext, mac, err = cli.downloadPossiblyEncryptedMediaWithRetries(url, fileEncSha256); err != nil {
-
-	} else if mediaKey == nil && fileEncSha256 == nil && mac == nil {
-		// Unencrypted media, just return the downloaded data
-		data = ciphertext
-	} else if err = validateMedia(iv, ciphertext, macKey, mac); err != nil {
+	var resumed bool
+	for restarted := false; ; restarted = true {
+		ciphertext, mac, resumed, err = cli.downloadPossiblyEncryptedMediaWithRetries(ctx, url, fileEncSha256, req.Progress)
+		if err == nil {
+			err = validateMedia(iv, ciphertext, macKey, mac)
+		}
+		if restarted || !shouldRestartMediaDownload(resumed, err) {
+			break
+		}
+		cli.Log.Warnf("Resumed media download failed verification (%v), downloading again from the start", err)
+	}
+	if err != nil {
 
 	} else if data, err = cbcutil.Decrypt(cipherKey, iv, ciphertext); err != nil {
 		err = fmt.Errorf("failed to decrypt file: %w", err)
@@ -255,6 +389,34 @@ func (cli *Client) downloadAndDecrypt(url string, mediaKey []byte, appInfo Media
 	return
 }
 
+// downloadPlainMedia downloads unencrypted media, i.e. newsletter attachments. There's no HMAC or encrypted file hash,
+// so the file is only verified using the plaintext hash.
+func (cli *Client) downloadPlainMedia(ctx context.Context, url string, fileLength int, fileSha256 []byte, progress MediaProgressFunc) ([]byte, error) {
+	if len(fileSha256) != 32 {
+		return nil, ErrNoMediaSHA256
+	}
+	var buf bytes.Buffer
+	verifier := newPlainMediaVerifier()
+	if err := cli.downloadMediaResumable(ctx, url, 0, -1, io.MultiWriter(&buf, verifier), progress); err != nil {
+		return nil, err
+	} else if err = verifier.finish(fileLength, fileSha256); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// getMediaURL returns the URL for downloading a file from the given media host using its direct path.
+// Unencrypted newsletter media doesn't have an encrypted file hash and uses the newsletter variants of the mms types.
+func getMediaURL(hostname, directPath string, encFileHash []byte, mmsType string, newsletter bool) string {
+	if newsletter {
+		if !strings.HasPrefix(mmsType, "newsletter-") {
+			mmsType = "newsletter-" + mmsType
+		}
+		return fmt.Sprintf("https://%s%s&mms-type=%s&__wa-mms=", hostname, directPath, mmsType)
+	}
+	return fmt.Sprintf("https://%s%s&hash=%s&mms-type=%s&__wa-mms=", hostname, directPath, base64.URLEncoding.EncodeToString(encFileHash), mmsType)
+}
+
 func getMediaKeys(mediaKey []byte, appInfo MediaType) (iv, cipherKey, macKey, refKey []byte) {
 	mediaKeyExpanded := hkdfutil.SHA256(mediaKey, nil, []byte(appInfo), 112)
 	return mediaKeyExpanded[:16], mediaKeyExpanded[16:48], mediaKeyExpanded[48:80], mediaKeyExpanded[80:]
@@ -263,60 +425,188 @@ func getMediaKeys(mediaKey []byte, appInfo MediaType) (iv, cipherKey, macKey, re
 func shouldRetryMediaDownload(err error) bool {
 	var netErr net.Error
 	var httpErr DownloadHTTPError
-	return errors.As(err, &netErr) ||
+	return errors.As(err, &netErr) || errors.Is(err, io.ErrUnexpectedEOF) ||
 		(errors.As(err, &httpErr) && retryafter.Should(httpErr.StatusCode, true))
 }
 
-func (cli *Client) downloadPossiblyEncryptedMediaWithRetries(url string, checksum []byte) (file, mac []byte, err error) {
-	for retryNum := 0; retryNum < 5; retryNum++ {
-		if checksum == nil {
-			file, err = cli.downloadMedia(url)
-		} else {
-			file, mac, err = cli.downloadEncryptedMedia(url, checksum)
-		}
+func (cli *Client) downloadPossiblyEncryptedMediaWithRetries(ctx context.Context, url string, checksum []byte, progress MediaProgressFunc) (file, mac []byte, resumed bool, err error) {
+	var buf bytes.Buffer
+	if resumed, err = cli.downloadMediaWithResume(ctx, url, 0, -1, &buf, progress); err != nil {
+		return
+	}
+	data := buf.Bytes()
+	if checksum == nil {
+		file = data
+		return
+	} else if len(data) <= mediaHMACLength {
+		err = ErrTooShortFile
+		return
+	}
+	file, mac = data[:len(data)-mediaHMACLength], data[len(data)-mediaHMACLength:]
+	if len(checksum) == 32 && sha256.Sum256(data) != *(*[32]byte)(checksum) {
+		err = ErrInvalidMediaEncSHA256
+	}
+	return
+}
+
+// shouldRestartMediaDownload returns true if a download failed the final HMAC or hash check after being resumed.
+// Without a sidecar, nothing can be verified before the whole file has been downloaded, so a corrupted part received
+// before the interruption would survive every resume. The only way to recover is to download the whole file again.
+func shouldRestartMediaDownload(resumed bool, err error) bool {
+	return resumed && (errors.Is(err, ErrInvalidMediaHMAC) || errors.Is(err, ErrInvalidMediaEncSHA256))
+}
+
+// verifiedWriter is implemented by download destinations that verify the data in chunks before using it,
+// so that interrupted downloads can be resumed from the end of the last verified chunk.
+type verifiedWriter interface {
+	io.Writer
+	// discardUnverified drops all data that hasn't been verified yet and returns the number of bytes that have been.
+	discardUnverified() int64
+}
+
+// downloadMediaResumable downloads the given byte range of a media file into dst. If the connection fails,
+// the download is resumed from where it left off using a Range request instead of starting over.
+// An end of -1 means the rest of the file.
+//
+// If dst implements verifiedWriter, the download is resumed from the end of the last verified chunk instead.
+func (cli *Client) downloadMediaResumable(ctx context.Context, url string, start, end int64, dst io.Writer, progress MediaProgressFunc) error {
+	_, err := cli.downloadMediaWithResume(ctx, url, start, end, dst, progress)
+	return err
+}
+
+// downloadMediaWithResume is like downloadMediaResumable, but also returns whether the download had to be resumed.
+func (cli *Client) downloadMediaWithResume(ctx context.Context, url string, start, end int64, dst io.Writer, progress MediaProgressFunc) (resumed bool, err error) {
+	offset := start
+	failures := 0
+	for {
+		prevOffset := offset
+		var n int64
+		n, err = cli.downloadMediaFrom(ctx, url, offset, end, dst, progress)
+		offset += n
 		if err == nil || !shouldRetryMediaDownload(err) {
 			return
 		}
-		retryDuration := time.Duration(retryNum+1) * time.Second
-		var httpErr DownloadHTTPError
-		if errors.As(err, &httpErr) {
-			retryDuration = retryafter.Parse(httpErr.Response.Header.Get("Retry-After"), retryDuration)
+		if verified, ok := dst.(verifiedWriter); ok {
+			offset = start + verified.discardUnverified()
+		}
+		if offset > prevOffset {
+			// Only count consecutive failures, so that huge files on flaky connections can still finish
+			failures = 0
 		}
-		cli.Log.Warnf("Failed to download media due to network error: %w, retrying in %s...", err, retryDuration)
-		time.Sleep(retryDuration)
+		failures++
+		if failures >= 5 {
+			return
+		} else if err = cli.waitMediaDownloadRetry(ctx, failures-1, err); err != nil {
+			return
+		}
+		resumed = true
+	}
+}
+
+// downloadMediaFrom sends a single request for the given byte range of a media file and copies the response into dst.
+// It returns the number of bytes written, which may be non-zero even if an error is returned.
+func (cli *Client) downloadMediaFrom(ctx context.Context, url string, start, end int64, dst io.Writer, progress MediaProgressFunc) (int64, error) {
+	resp, err := cli.doMediaDownloadRequest(ctx, url, start, end, progress)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	n, err := io.Copy(dst, resp.Body)
+	if err == nil && end >= 0 && start+n < end {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+// waitMediaDownloadRetry sleeps before retrying a failed media download. It returns an error if the context is
+// cancelled while sleeping.
+func (cli *Client) waitMediaDownloadRetry(ctx context.Context, retryNum int, err error) error {
+	retryDuration := time.Duration(retryNum+1) * time.Second
+	var httpErr DownloadHTTPError
+	if errors.As(err, &httpErr) {
+		retryDuration = retryafter.Parse(httpErr.Response.Header.Get("Retry-After"), retryDuration)
+	}
+	cli.Log.Warnf("Failed to download media due to network error: %v, retrying in %s...", err, retryDuration)
+	select {
+	case <-time.After(retryDuration):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
 	}
-	return
 }
 
-func (cli *Client) downloadMedia(url string) ([]byte, error) {
-	req, err := http.NewRequest(http.MethodGet, url, nil)
+// doMediaDownloadRequest sends a GET request for the given byte range of a media file. If no error is returned,
+// the caller must close the response body. An end of -1 means the rest of the file.
+//
+// If the server ignores the Range header, the part of the response before the start offset is skipped,
+// so the returned body always starts at the requested offset.
+func (cli *Client) doMediaDownloadRequest(ctx context.Context, url string, start, end int64, progress MediaProgressFunc) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
 	if err != nil {
 		return nil, fmt.Errorf("failed to prepare request: %w", err)
 	}
 	req.Header.Set("Origin", socket.Origin)
 	req.Header.Set("Referer", socket.Origin+"/")
+	if start > 0 || end >= 0 {
+		rangeHeader := fmt.Sprintf("bytes=%d-", start)
+		if end >= 0 {
+			rangeHeader += strconv.FormatInt(end-1, 10)
+		}
+		req.Header.Set("Range", rangeHeader)
+	}
 	resp, err := cli.http.Do(req)
 	if err != nil {
 		return nil, err
 	}
-	defer resp.Body.Close()
-	if resp.StatusCode != http.StatusOK {
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		rangeStart, total, err := parseContentRange(resp.Header.Get("Content-Range"))
+		if err == nil && rangeStart != start {
+			err = fmt.Errorf("server returned range starting at %d instead of %d", rangeStart, start)
+		}
+		if err != nil {
+			_ = resp.Body.Close()
+			return nil, err
+		}
+		resp.Body = withProgressFrom(resp.Body, start, total, progress)
+	case http.StatusOK:
+		resp.Body = withProgress(resp.Body, resp.ContentLength, progress)
+		if start > 0 {
+			if _, err = io.CopyN(io.Discard, resp.Body, start); err != nil {
+				_ = resp.Body.Close()
+				return nil, err
+			}
+		}
+		if end >= 0 {
+			resp.Body = struct {
+				io.Reader
+				io.Closer
+			}{io.LimitReader(resp.Body, end-start), resp.Body}
+		}
+	default:
+		_ = resp.Body.Close()
 		return nil, DownloadHTTPError{Response: resp}
 	}
-	return io.ReadAll(resp.Body)
+	return resp, nil
 }
 
-func (cli *Client) downloadEncryptedMedia(url string, checksum []byte) (file, mac []byte, err error) {
-	data, err := cli.downloadMedia(url)
-	if err != nil {
+// parseContentRange parses the start offset and total size from a Content-Range header.
+// The total is -1 if the server didn't specify it.
+func parseContentRange(header string) (start, total int64, err error) {
+	rangePart, totalPart, ok := strings.Cut(strings.TrimPrefix(header, "bytes "), "/")
+	startPart, _, ok2 := strings.Cut(rangePart, "-")
+	if !ok || !ok2 || !strings.HasPrefix(header, "bytes ") {
+		err = fmt.Errorf("invalid Content-Range header %q", header)
 		return
-	} else if len(data) <= 10 {
-		err = ErrTooShortFile
+	} else if start, err = strconv.ParseInt(startPart, 10, 64); err != nil {
+		err = fmt.Errorf("invalid Content-Range header %q: %w", header, err)
 		return
 	}
-	file, mac = data[:len(data)-10], data[len(data)-10:]
-	if len(checksum) == 32 && sha256.Sum256(data) != *(*[32]byte)(checksum) {
-		err = ErrInvalidMediaEncSHA256
+	total = -1
+	if totalPart != "*" {
+		if total, err = strconv.ParseInt(totalPart, 10, 64); err != nil {
+			err = fmt.Errorf("invalid Content-Range header %q: %w", header, err)
+		}
 	}
 	return
 }
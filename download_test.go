@@ -0,0 +1,181 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package whatsmeow
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-whatsapp/go-util/random"
+
+	waLog "github.com/go-whatsapp/whatsmeow/util/log"
+)
+
+func TestParseContentRange(t *testing.T) {
+	tests := []struct {
+		header string
+		start  int64
+		total  int64
+		ok     bool
+	}{
+		{"bytes 0-99/1000", 0, 1000, true},
+		{"bytes 500-999/1000", 500, 1000, true},
+		{"bytes 500-999/*", 500, -1, true},
+		{"bytes */1000", 0, 0, false},
+		{"500-999/1000", 0, 0, false},
+		{"", 0, 0, false},
+	}
+	for _, test := range tests {
+		start, total, err := parseContentRange(test.header)
+		if (err == nil) != test.ok {
+			t.Errorf("%q: unexpected error %v", test.header, err)
+		} else if test.ok && (start != test.start || total != test.total) {
+			t.Errorf("%q: expected %d/%d, got %d/%d", test.header, test.start, test.total, start, total)
+		}
+	}
+}
+
+func TestDownloadMediaResumable(t *testing.T) {
+	data := random.Bytes(10000)
+	var lock sync.Mutex
+	var requests []string
+	ignoreRange := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		requests = append(requests, r.Header.Get("Range"))
+		first, ignore := len(requests) == 1, ignoreRange
+		lock.Unlock()
+		if ignore {
+			_, _ = w.Write(data)
+		} else if first {
+			// Cut off the first response halfway through
+			w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+			_, _ = w.Write(data[:4000])
+			panic(http.ErrAbortHandler)
+		} else {
+			http.ServeContent(w, r, "media", time.Time{}, bytes.NewReader(data))
+		}
+	}))
+	defer server.Close()
+	cli := NewClient(newTestDevice(t), waLog.Noop)
+
+	var buf bytes.Buffer
+	if err := cli.downloadMediaResumable(context.Background(), server.URL, 0, -1, &buf, nil); err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(buf.Bytes(), data) {
+		t.Fatal("resumed download doesn't match")
+	}
+	lock.Lock()
+	if len(requests) != 2 || requests[0] != "" || requests[1] != "bytes=4000-" {
+		lock.Unlock()
+		t.Fatalf("unexpected requests %q", requests)
+	}
+	// Servers that ignore the Range header must still produce the requested part
+	ignoreRange = true
+	lock.Unlock()
+	buf.Reset()
+	if err := cli.downloadMediaResumable(context.Background(), server.URL, 100, 200, &buf, nil); err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(buf.Bytes(), data[100:200]) {
+		t.Fatal("range from server without range support doesn't match")
+	}
+}
+
+// newFlakyMediaServer returns a server that cuts off the first response after cutoff bytes,
+// optionally corrupting the part it sent, and serves the given data normally after that.
+func newFlakyMediaServer(data []byte, cutoff int, corrupt bool) (*httptest.Server, func() []string) {
+	var lock sync.Mutex
+	var requests []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		requests = append(requests, r.Header.Get("Range"))
+		first := len(requests) == 1
+		lock.Unlock()
+		if first {
+			part := bytes.Clone(data[:cutoff])
+			if corrupt {
+				part[cutoff/2] ^= 1
+			}
+			w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+			_, _ = w.Write(part)
+			panic(http.ErrAbortHandler)
+		}
+		http.ServeContent(w, r, "media", time.Time{}, bytes.NewReader(data))
+	}))
+	return server, func() []string {
+		lock.Lock()
+		defer lock.Unlock()
+		return append([]string(nil), requests...)
+	}
+}
+
+func TestDownloadRestartAfterResume(t *testing.T) {
+	media := encryptTestMedia(t, random.Bytes(10000))
+	cli := NewClient(newTestDevice(t), waLog.Noop)
+	expectedRequests := func(requests []string) {
+		t.Helper()
+		if len(requests) != 3 || requests[0] != "" || requests[1] != "bytes=4000-" || requests[2] != "" {
+			t.Fatalf("unexpected requests %q", requests)
+		}
+	}
+
+	// The corrupted part before the interruption can't be detected until the end, so the whole file must be downloaded again
+	server, getRequests := newFlakyMediaServer(media.encrypted, 4000, true)
+	data, err := cli.downloadAndDecrypt(context.Background(), server.URL, media.mediaKey, MediaDocument, len(media.plaintext), media.fileEncSha256, media.fileSha256, DownloadExtra{})
+	server.Close()
+	if err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(data, media.plaintext) {
+		t.Fatal("downloaded data doesn't match")
+	}
+	expectedRequests(getRequests())
+
+	server, getRequests = newFlakyMediaServer(media.encrypted, 4000, true)
+	defer server.Close()
+	file, err := os.Create(filepath.Join(t.TempDir(), "media"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	out := &mediaOutput{w: file, file: file}
+	if err = cli.downloadAndDecryptToOutput(context.Background(), server.URL, media.mediaKey, MediaDocument, len(media.plaintext), media.fileEncSha256, media.fileSha256, out); err != nil {
+		t.Fatal(err)
+	} else if written, _ := os.ReadFile(file.Name()); !bytes.Equal(written, media.plaintext) {
+		t.Fatal("data written to file doesn't match")
+	}
+	expectedRequests(getRequests())
+}
+
+func TestDownloadResumeFromVerifiedChunk(t *testing.T) {
+	media := encryptTestMedia(t, random.Bytes(3*sidecarChunkSize))
+	iv, _, macKey, _ := getMediaKeys(media.mediaKey, MediaDocument)
+	generator := newSidecarGenerator(iv, macKey)
+	_, _ = generator.Write(media.encrypted)
+
+	// The first chunk can be verified and the second one can't, so the download must continue from the second one
+	server, getRequests := newFlakyMediaServer(media.encrypted, sidecarChunkSize+sidecarChunkSize/2, false)
+	defer server.Close()
+	cli := NewClient(newTestDevice(t), waLog.Noop)
+	var buf bytes.Buffer
+	out := &mediaOutput{w: &buf, sidecar: generator.Sum()}
+	if err := cli.downloadAndDecryptToOutput(context.Background(), server.URL, media.mediaKey, MediaDocument, len(media.plaintext), media.fileEncSha256, media.fileSha256, out); err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(buf.Bytes(), media.plaintext) {
+		t.Fatal("downloaded data doesn't match")
+	}
+	if requests := getRequests(); len(requests) != 2 || requests[1] != "bytes="+strconv.Itoa(sidecarChunkSize)+"-" {
+		t.Fatalf("unexpected requests %q", requests)
+	}
+}
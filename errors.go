@@ -12,6 +12,7 @@ import (
 	"net/http"
 
 	waBinary "github.com/go-whatsapp/whatsmeow/binary"
+	waProto "github.com/go-whatsapp/whatsmeow/binary/proto"
 )
 
 // Miscellaneous errors
@@ -23,6 +24,8 @@ var (
 	ErrMessageTimedOut = errors.New("timed out waiting for message send response")
 
 	ErrAlreadyConnected = errors.New("websocket is already connected")
+	// ErrTCPProxyUnsupported is returned by Connect if an HTTP proxy is set when using socket.TCPTransport.
+	ErrTCPProxyUnsupported = errors.New("HTTP proxies can't be used with the TCP transport (use SetSOCKSProxy or SetProxy(nil))")
 
 	ErrQRAlreadyConnected = errors.New("GetQRChannel must be called before connecting")
 	ErrQRStoreContainsID  = errors.New("GetQRChannel can only be called when there's no user JID in the client's Store")
@@ -96,6 +99,8 @@ var (
 	ErrMediaNotAvailableOnPhone = errors.New("media no longer available on phone")
 	// ErrUnknownMediaRetryError is returned by DecryptMediaRetryNotification if the given event contains an unknown error code.
 	ErrUnknownMediaRetryError = errors.New("unknown media retry error")
+	// ErrMediaRetryTimeout is returned by DownloadWithMediaRetry if the phone doesn't respond to the media retry request in time.
+	ErrMediaRetryTimeout = errors.New("timed out waiting for media retry response")
 	// ErrInvalidDisappearingTimer is returned by SetDisappearingTimer if the given timer is not one of the allowed values.
 	ErrInvalidDisappearingTimer = errors.New("invalid disappearing timer provided")
 )
@@ -121,6 +126,42 @@ func (dhe DownloadHTTPError) Is(other error) bool {
 	return errors.As(other, &otherDHE) && dhe.StatusCode == otherDHE.StatusCode
 }
 
+// MediaRetryResultError is returned by DownloadWithMediaRetry if the phone responded to the media retry request
+// with an unsuccessful result code.
+type MediaRetryResultError struct {
+	Result waProto.MediaRetryNotification_ResultType
+}
+
+func (mrre MediaRetryResultError) Error() string {
+	return fmt.Sprintf("media retry failed with result %s", mrre.Result.String())
+}
+
+func (mrre MediaRetryResultError) Is(other error) bool {
+	var otherMRRE MediaRetryResultError
+	return errors.As(other, &otherMRRE) && mrre.Result == otherMRRE.Result
+}
+
+// Errors that DownloadWithMediaRetry can return based on the result code in the media retry notification
+var (
+	ErrMediaRetryGeneralError    = MediaRetryResultError{Result: waProto.MediaRetryNotification_GENERAL_ERROR}
+	ErrMediaRetryNotFound        = MediaRetryResultError{Result: waProto.MediaRetryNotification_NOT_FOUND}
+	ErrMediaRetryDecryptionError = MediaRetryResultError{Result: waProto.MediaRetryNotification_DECRYPTION_ERROR}
+)
+
+// UploadHTTPError is returned by Client.Upload and Client.UploadReader if the media server returns a non-200 status code.
+type UploadHTTPError struct {
+	*http.Response
+}
+
+func (uhe UploadHTTPError) Error() string {
+	return fmt.Sprintf("upload failed with status code %d", uhe.StatusCode)
+}
+
+func (uhe UploadHTTPError) Is(other error) bool {
+	var otherUHE UploadHTTPError
+	return errors.As(other, &otherUHE) && uhe.StatusCode == otherUHE.StatusCode
+}
+
 // Some errors that Client.Download can return
 var (
 	ErrMediaDownloadFailedWith403 = DownloadHTTPError{Response: &http.Response{StatusCode: 403}}
@@ -132,8 +173,18 @@ var (
 	ErrInvalidMediaHMAC           = errors.New("invalid media hmac")
 	ErrInvalidMediaEncSHA256      = errors.New("hash of media ciphertext doesn't match")
 	ErrInvalidMediaSHA256         = errors.New("hash of media plaintext doesn't match")
+	ErrInvalidStreamingSidecar    = errors.New("media doesn't match streaming sidecar")
+	ErrInvalidMediaRange          = errors.New("invalid byte range for media file")
 	ErrUnknownMediaType           = errors.New("unknown media type")
 	ErrNothingDownloadableFound   = errors.New("didn't find any attachments in message")
+	ErrNoMediaKey                 = errors.New("media doesn't have a media key (set DownloadExtra.Newsletter for newsletter media)")
+	ErrNoMediaSHA256              = errors.New("unencrypted newsletter media doesn't have a valid plaintext hash")
+)
+
+// Errors that the media message builders (e.g. Client.BuildImageMessage) can return
+var (
+	ErrMediaTooLarge        = errors.New("file is too large for media type")
+	ErrUnsupportedMediaType = errors.New("unsupported file type for media message")
 )
 
 var (
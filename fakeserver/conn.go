@@ -0,0 +1,279 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package fakeserver
+
+import (
+	"context"
+	"crypto/cipher"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	waBinary "github.com/go-whatsapp/whatsmeow/binary"
+	"github.com/go-whatsapp/whatsmeow/socket"
+	"github.com/go-whatsapp/whatsmeow/types"
+	waLog "github.com/go-whatsapp/whatsmeow/util/log"
+)
+
+// ErrConnClosed is returned when trying to send data to a connection that has already been closed.
+var ErrConnClosed = errors.New("connection is closed")
+
+// frameReader buffers data from a transport connection and splits it into frames.
+type frameReader struct {
+	chunks <-chan []byte
+	buf    []byte
+	err    error
+}
+
+func newFrameReader(ctx context.Context, conn socket.TransportConn) *frameReader {
+	chunks := make(chan []byte, 16)
+	fr := &frameReader{chunks: chunks}
+	go func() {
+		defer close(chunks)
+		for {
+			data, err := conn.ReadMessage()
+			if err != nil {
+				fr.err = err
+				return
+			}
+			select {
+			case chunks <- data:
+			case <-ctx.Done():
+				fr.err = ctx.Err()
+				return
+			}
+		}
+	}()
+	return fr
+}
+
+func (fr *frameReader) readBytes(n int, timeout time.Duration) ([]byte, error) {
+	var timeoutChan <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		timeoutChan = timer.C
+	}
+	for len(fr.buf) < n {
+		select {
+		case chunk, ok := <-fr.chunks:
+			if !ok {
+				if fr.err == nil {
+					return nil, io.EOF
+				}
+				return nil, fr.err
+			}
+			fr.buf = append(fr.buf, chunk...)
+		case <-timeoutChan:
+			return nil, fmt.Errorf("timed out waiting for data")
+		}
+	}
+	data := fr.buf[:n]
+	fr.buf = fr.buf[n:]
+	return data, nil
+}
+
+func (fr *frameReader) readHeader(length int, timeout time.Duration) ([]byte, error) {
+	return fr.readBytes(length, timeout)
+}
+
+func (fr *frameReader) readFrame(timeout time.Duration) ([]byte, error) {
+	lengthBytes, err := fr.readBytes(socket.FrameLengthSize, timeout)
+	if err != nil {
+		return nil, err
+	}
+	length := (int(lengthBytes[0]) << 16) + (int(lengthBytes[1]) << 8) + int(lengthBytes[2])
+	return fr.readBytes(length, timeout)
+}
+
+// Conn is a single client connection to the fake server.
+type Conn struct {
+	srv  *Server
+	log  waLog.Logger
+	raw  socket.TransportConn
+	ctx  context.Context
+	stop context.CancelFunc
+
+	frames       *frameReader
+	clientStatic [32]byte
+	readKey      cipher.AEAD
+	writeKey     cipher.AEAD
+	readCounter  uint32
+	writeCounter uint32
+	writeLock    sync.Mutex
+	closeOnce    sync.Once
+
+	// JID is the device JID that the client logged in as.
+	JID types.JID
+}
+
+func generateIV(count uint32) []byte {
+	iv := make([]byte, 12)
+	binary.BigEndian.PutUint32(iv[8:], count)
+	return iv
+}
+
+// Context returns a context that is cancelled when the connection is closed.
+func (conn *Conn) Context() context.Context {
+	return conn.ctx
+}
+
+func (conn *Conn) writeFrame(data []byte) error {
+	if len(data) >= socket.FrameMaxSize {
+		return fmt.Errorf("%w (got %d bytes, max %d bytes)", socket.ErrFrameTooLarge, len(data), socket.FrameMaxSize)
+	}
+	wholeFrame := make([]byte, socket.FrameLengthSize+len(data))
+	wholeFrame[0] = byte(len(data) >> 16)
+	wholeFrame[1] = byte(len(data) >> 8)
+	wholeFrame[2] = byte(len(data))
+	copy(wholeFrame[socket.FrameLengthSize:], data)
+	return conn.raw.WriteMessage(wholeFrame)
+}
+
+func (conn *Conn) sendEncryptedFrame(plaintext []byte) error {
+	conn.writeLock.Lock()
+	defer conn.writeLock.Unlock()
+	if conn.ctx.Err() != nil {
+		return ErrConnClosed
+	}
+	ciphertext := conn.writeKey.Seal(nil, generateIV(conn.writeCounter), plaintext, nil)
+	conn.writeCounter++
+	return conn.writeFrame(ciphertext)
+}
+
+func (conn *Conn) readNode() (*waBinary.Node, error) {
+	frame, err := conn.frames.readFrame(0)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := conn.readKey.Open(nil, generateIV(conn.readCounter), frame, nil)
+	conn.readCounter++
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt frame: %w", err)
+	}
+	unpacked, err := waBinary.Unpack(plaintext)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unpack frame: %w", err)
+	}
+	return waBinary.Unmarshal(unpacked)
+}
+
+// SendNode sends the given node to the client.
+func (conn *Conn) SendNode(node waBinary.Node) error {
+	payload, err := waBinary.Marshal(node)
+	if err != nil {
+		return fmt.Errorf("failed to marshal node: %w", err)
+	}
+	conn.log.Debugf("Send: %s", node.XMLString())
+	return conn.sendEncryptedFrame(payload)
+}
+
+// SendNotification sends a <notification> node of the given type to the client.
+func (conn *Conn) SendNotification(notifType string, from types.JID, content ...waBinary.Node) error {
+	attrs := waBinary.Attrs{
+		"id":   conn.srv.generateID(),
+		"type": notifType,
+		"t":    time.Now().Unix(),
+	}
+	if !from.IsEmpty() {
+		attrs["from"] = from
+	} else {
+		attrs["from"] = types.ServerJID
+	}
+	node := waBinary.Node{Tag: "notification", Attrs: attrs}
+	if len(content) > 0 {
+		node.Content = content
+	}
+	return conn.SendNode(node)
+}
+
+// SendReceipt sends a receipt for the given message IDs to the client, as if the given user sent it.
+//
+// An empty receipt type means a delivery receipt.
+func (conn *Conn) SendReceipt(from types.JID, receiptType types.ReceiptType, ids ...types.MessageID) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	attrs := waBinary.Attrs{
+		"id":   ids[0],
+		"from": from,
+		"t":    time.Now().Unix(),
+	}
+	if receiptType != types.ReceiptTypeDelivered {
+		attrs["type"] = string(receiptType)
+	}
+	node := waBinary.Node{Tag: "receipt", Attrs: attrs}
+	if len(ids) > 1 {
+		items := make([]waBinary.Node, len(ids)-1)
+		for i, id := range ids[1:] {
+			items[i] = waBinary.Node{Tag: "item", Attrs: waBinary.Attrs{"id": id}}
+		}
+		node.Content = []waBinary.Node{{Tag: "list", Content: items}}
+	}
+	return conn.SendNode(node)
+}
+
+// SendStreamError sends a <stream:error> with the given code and closes the connection.
+func (conn *Conn) SendStreamError(code string, content ...waBinary.Node) error {
+	node := waBinary.Node{Tag: "stream:error", Attrs: waBinary.Attrs{"code": code}}
+	if len(content) > 0 {
+		node.Content = content
+	}
+	err := conn.SendNode(node)
+	conn.Close()
+	return err
+}
+
+// Drop closes the underlying transport connection without sending anything,
+// which looks like a network failure to the client.
+func (conn *Conn) Drop() {
+	conn.closeWithCode(0)
+}
+
+// Close closes the connection cleanly.
+func (conn *Conn) Close() {
+	conn.closeWithCode(1000)
+}
+
+func (conn *Conn) closeWithCode(code int) {
+	conn.closeOnce.Do(func() {
+		conn.writeLock.Lock()
+		conn.stop()
+		err := conn.raw.Close(code)
+		conn.writeLock.Unlock()
+		if err != nil {
+			conn.log.Warnf("Error closing connection: %v", err)
+		}
+		conn.srv.removeConn(conn)
+	})
+}
+
+func (conn *Conn) serve() {
+	defer conn.Drop()
+	payload, err := conn.doHandshake()
+	if err != nil {
+		conn.log.Warnf("Handshake failed: %v", err)
+		return
+	}
+	if !conn.srv.login(conn, payload) {
+		return
+	}
+	for {
+		node, err := conn.readNode()
+		if err != nil {
+			if conn.ctx.Err() == nil && !errors.Is(err, io.EOF) {
+				conn.log.Warnf("Error reading from connection: %v", err)
+			}
+			return
+		}
+		conn.log.Debugf("Recv: %s", node.XMLString())
+		conn.srv.handleNode(conn, node)
+	}
+}
@@ -0,0 +1,310 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package fakeserver_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-whatsapp/go-util/random"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/go-whatsapp/whatsmeow"
+	waProto "github.com/go-whatsapp/whatsmeow/binary/proto"
+	"github.com/go-whatsapp/whatsmeow/fakeserver"
+	"github.com/go-whatsapp/whatsmeow/types"
+	"github.com/go-whatsapp/whatsmeow/types/events"
+)
+
+func TestStreamingDownload(t *testing.T) {
+	env := setupMedia(t)
+	plaintext := random.Bytes(200*1024 + 7)
+	resp, err := env.cli.Upload(env.ctx, plaintext, whatsmeow.MediaDocument)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := documentMessage(resp)
+	msg.Url = &resp.URL
+
+	var buf bytes.Buffer
+	if err = env.cli.DownloadToWriter(env.ctx, msg, &buf); err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(buf.Bytes(), plaintext) {
+		t.Fatal("downloaded data doesn't match uploaded data")
+	}
+
+	file, err := os.Create(filepath.Join(t.TempDir(), "download"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	if err = env.cli.DownloadToFile(env.ctx, msg, file); err != nil {
+		t.Fatal(err)
+	} else if data, _ := os.ReadFile(file.Name()); !bytes.Equal(data, plaintext) {
+		t.Fatal("downloaded file doesn't match uploaded data")
+	}
+
+	corrupted := bytes.Clone(env.srv.Media(resp.DirectPath))
+	corrupted[len(corrupted)/2] ^= 0xff
+	env.srv.SetMedia(resp.DirectPath, corrupted)
+	msg.FileEncSha256 = nil
+	if err = file.Truncate(0); err != nil {
+		t.Fatal(err)
+	} else if _, err = file.Seek(0, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	err = env.cli.DownloadToFile(env.ctx, msg, file)
+	if !errors.Is(err, whatsmeow.ErrInvalidMediaHMAC) {
+		t.Fatalf("expected invalid hmac error, got %v", err)
+	} else if info, _ := file.Stat(); info.Size() != 0 {
+		t.Fatalf("expected file to be truncated after error, but size is %d", info.Size())
+	}
+}
+
+func TestResumableDownload(t *testing.T) {
+	env := setupMedia(t)
+	plaintext := random.Bytes(300 * 1024)
+	resp, err := env.cli.Upload(env.ctx, plaintext, whatsmeow.MediaDocument)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := documentMessage(resp)
+
+	var lastTransferred, lastTotal int64
+	progress := func(transferred, total int64) {
+		if transferred < lastTransferred {
+			t.Errorf("progress went backwards from %d to %d", lastTransferred, transferred)
+		}
+		lastTransferred, lastTotal = transferred, total
+	}
+	env.srv.InterruptMediaDownloads(1, 100*1024)
+	data, err := env.cli.DownloadContext(env.ctx, msg, whatsmeow.DownloadExtra{Progress: progress})
+	if err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(data, plaintext) {
+		t.Fatal("resumed download doesn't match uploaded data")
+	} else if lastTransferred != lastTotal || lastTotal != int64(len(env.srv.Media(resp.DirectPath))) {
+		t.Fatalf("unexpected final progress %d/%d", lastTransferred, lastTotal)
+	}
+
+	// Plain writers can't be rewound, so interrupted streaming downloads only work if they're resumed.
+	// Servers that ignore the Range header should work too.
+	env.srv.SetMediaIgnoreRange(true)
+	env.srv.InterruptMediaDownloads(1, 100*1024)
+	var buf bytes.Buffer
+	if err = env.cli.DownloadToWriter(env.ctx, msg, &buf); err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(buf.Bytes(), plaintext) {
+		t.Fatal("resumed streaming download doesn't match uploaded data")
+	}
+}
+
+func TestDownloadRange(t *testing.T) {
+	env := setupMedia(t)
+	plaintext := random.Bytes(300*1024 + 5)
+	videoMsg, err := env.cli.BuildVideoMessage(env.ctx, plaintext, whatsmeow.MediaMessageExtra{MimeType: "video/mp4"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := env.cli.Upload(env.ctx, plaintext, whatsmeow.MediaDocument)
+	if err != nil {
+		t.Fatal(err)
+	}
+	docMsg := documentMessage(resp)
+	ranges := [][2]int64{{0, 10}, {5, 16}, {65530, 20}, {100000, 100000}, {int64(len(plaintext)) - 7, 100}}
+	for _, msg := range []whatsmeow.DownloadableMessage{videoMsg, docMsg} {
+		for _, r := range ranges {
+			data, err := env.cli.DownloadRange(env.ctx, msg, r[0], r[1])
+			if err != nil {
+				t.Fatalf("failed to download %d+%d: %v", r[0], r[1], err)
+			}
+			end := r[0] + r[1]
+			if end > int64(len(plaintext)) {
+				end = int64(len(plaintext))
+			}
+			if !bytes.Equal(data, plaintext[r[0]:end]) {
+				t.Fatalf("range %d+%d doesn't match uploaded data", r[0], r[1])
+			}
+		}
+	}
+	if _, err = env.cli.DownloadRange(env.ctx, docMsg, int64(len(plaintext))+1, 1); !errors.Is(err, whatsmeow.ErrInvalidMediaRange) {
+		t.Fatalf("expected ErrInvalidMediaRange, got %v", err)
+	}
+
+	// Corrupt the fourth chunk of the video: ranges in it should fail verification, but other ranges should still work
+	corrupted := bytes.Clone(env.srv.Media(videoMsg.GetDirectPath()))
+	corrupted[3*64*1024+100] ^= 0xff
+	env.srv.SetMedia(videoMsg.GetDirectPath(), corrupted)
+	if _, err = env.cli.DownloadRange(env.ctx, videoMsg, 3*64*1024+200, 10); !errors.Is(err, whatsmeow.ErrInvalidStreamingSidecar) {
+		t.Fatalf("expected ErrInvalidStreamingSidecar, got %v", err)
+	} else if data, err := env.cli.DownloadRange(env.ctx, videoMsg, 1000, 1000); err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(data, plaintext[1000:2000]) {
+		t.Fatal("range before corrupted chunk doesn't match uploaded data")
+	}
+}
+
+func TestNewsletterMediaDownload(t *testing.T) {
+	env := setupMedia(t)
+	plaintext := random.Bytes(100 * 1024)
+	resp, err := env.cli.UploadNewsletter(env.ctx, plaintext, whatsmeow.MediaImage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := &waProto.Message{ImageMessage: &waProto.ImageMessage{
+		DirectPath: &resp.DirectPath,
+		FileSha256: resp.FileSHA256,
+		FileLength: &resp.FileLength,
+	}}
+	newsletter := whatsmeow.DownloadExtra{Newsletter: true}
+	data, err := env.cli.DownloadAnyContext(env.ctx, msg, newsletter)
+	if err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(data, plaintext) {
+		t.Fatal("downloaded newsletter media doesn't match uploaded data")
+	}
+	var buf bytes.Buffer
+	if err = env.cli.DownloadAnyToWriter(env.ctx, msg, &buf, newsletter); err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(buf.Bytes(), plaintext) {
+		t.Fatal("streamed newsletter media doesn't match uploaded data")
+	}
+	if data, err = env.cli.DownloadRange(env.ctx, msg.ImageMessage, 1000, 50, newsletter); err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(data, plaintext[1000:1050]) {
+		t.Fatal("newsletter media range doesn't match uploaded data")
+	}
+
+	// Media without a media key but with a plaintext hash is detected as newsletter media without the flag
+	if data, err = env.cli.Download(msg.ImageMessage); err != nil {
+		t.Fatalf("download without newsletter flag failed: %v", err)
+	} else if !bytes.Equal(data, plaintext) {
+		t.Fatal("newsletter media downloaded without flag doesn't match uploaded data")
+	}
+	buf.Reset()
+	if err = env.cli.DownloadAnyToWriter(env.ctx, msg, &buf); err != nil {
+		t.Fatalf("streamed download without newsletter flag failed: %v", err)
+	} else if !bytes.Equal(buf.Bytes(), plaintext) {
+		t.Fatal("newsletter media streamed without flag doesn't match uploaded data")
+	} else if data, err = env.cli.DownloadRange(env.ctx, msg.ImageMessage, 1000, 50); err != nil || !bytes.Equal(data, plaintext[1000:1050]) {
+		t.Fatalf("newsletter media range without flag doesn't match uploaded data (%v)", err)
+	}
+	evt := &events.Message{Message: msg, NewsletterMeta: &events.NewsletterMessageMeta{}}
+	if data, err = env.cli.DownloadMessageMedia(env.ctx, evt); err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(data, plaintext) {
+		t.Fatal("newsletter media downloaded from event doesn't match uploaded data")
+	}
+
+	// Plain media can only be verified with the plaintext hash, so it's required
+	noHashMsg := proto.Clone(msg).(*waProto.Message)
+	noHashMsg.ImageMessage.FileSha256 = nil
+	if _, err = env.cli.DownloadAnyContext(env.ctx, noHashMsg, newsletter); !errors.Is(err, whatsmeow.ErrNoMediaSHA256) {
+		t.Fatalf("expected ErrNoMediaSHA256, got %v", err)
+	} else if err = env.cli.DownloadAnyToWriter(env.ctx, noHashMsg, io.Discard, newsletter); !errors.Is(err, whatsmeow.ErrNoMediaSHA256) {
+		t.Fatalf("expected ErrNoMediaSHA256 from streamed download, got %v", err)
+	} else if _, err = env.cli.DownloadMessageMedia(env.ctx, &events.Message{Message: noHashMsg, NewsletterMeta: &events.NewsletterMessageMeta{}}); !errors.Is(err, whatsmeow.ErrNoMediaSHA256) {
+		t.Fatalf("expected ErrNoMediaSHA256 from event download, got %v", err)
+	}
+	// Without a media key or any hashes, there's nothing to verify, so the file is returned as-is
+	if data, err = env.cli.DownloadAny(noHashMsg); err != nil {
+		t.Fatalf("unverified download failed: %v", err)
+	} else if !bytes.Equal(data, plaintext) {
+		t.Fatal("unverified download doesn't match uploaded data")
+	}
+	// Encrypted media without a key is rejected before trying any hosts
+	noKeyMsg := proto.Clone(noHashMsg).(*waProto.Message)
+	noKeyMsg.ImageMessage.FileEncSha256 = random.Bytes(32)
+	if _, err = env.cli.DownloadAny(noKeyMsg); err != whatsmeow.ErrNoMediaKey {
+		t.Fatalf("expected unwrapped ErrNoMediaKey, got %v", err)
+	} else if err = env.cli.DownloadAnyToWriter(env.ctx, noKeyMsg, io.Discard); err != whatsmeow.ErrNoMediaKey {
+		t.Fatalf("expected unwrapped ErrNoMediaKey from streamed download, got %v", err)
+	} else if _, err = env.cli.DownloadRange(env.ctx, noKeyMsg.ImageMessage, 0, 10); err != whatsmeow.ErrNoMediaKey {
+		t.Fatalf("expected unwrapped ErrNoMediaKey from range download, got %v", err)
+	}
+
+	msg.ImageMessage.Url = &resp.URL
+	msg.ImageMessage.FileSha256 = bytes.Clone(resp.FileSHA256)
+	msg.ImageMessage.FileSha256[0] ^= 0xff
+	if _, err = env.cli.DownloadAnyContext(env.ctx, msg, newsletter); !errors.Is(err, whatsmeow.ErrInvalidMediaSHA256) {
+		t.Fatalf("expected ErrInvalidMediaSHA256, got %v", err)
+	}
+}
+
+func TestDownloadWithMediaRetry(t *testing.T) {
+	env := setupMedia(t)
+	plaintext := random.Bytes(1024)
+	resp, err := env.cli.Upload(env.ctx, plaintext, whatsmeow.MediaImage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encrypted := env.srv.Media(resp.DirectPath)
+	env.srv.SetMedia(resp.DirectPath, nil)
+	msg := &waProto.ImageMessage{
+		Url:           &resp.URL,
+		DirectPath:    &resp.DirectPath,
+		MediaKey:      resp.MediaKey,
+		FileEncSha256: resp.FileEncSHA256,
+		FileSha256:    resp.FileSHA256,
+		FileLength:    &resp.FileLength,
+	}
+	info := &types.MessageInfo{
+		MessageSource: types.MessageSource{Chat: env.peer.ToNonAD(), Sender: env.peer},
+		ID:            "3EB0123456789ABCDEF0",
+	}
+
+	const newPath = "/v/t62/reuploaded?ccb=11-4"
+	result := waProto.MediaRetryNotification_SUCCESS
+	env.srv.HandleMediaRetry(func(conn *fakeserver.Conn, req *fakeserver.MediaRetryRequest) {
+		if req.MessageID != info.ID || req.Chat != info.Chat {
+			t.Errorf("unexpected media retry request %+v", req)
+		}
+		env.srv.SetMedia(newPath, encrypted)
+		err := conn.SendMediaRetryNotification(req, resp.MediaKey, &waProto.MediaRetryNotification{
+			DirectPath: proto.String(newPath),
+			Result:     result.Enum(),
+		})
+		if err != nil {
+			t.Error(err)
+		}
+	})
+	data, err := env.cli.DownloadWithMediaRetry(env.ctx, info, msg)
+	if err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(data, plaintext) {
+		t.Fatal("downloaded data doesn't match uploaded data")
+	}
+
+	env.srv.SetMedia(newPath, nil)
+	result = waProto.MediaRetryNotification_NOT_FOUND
+	_, err = env.cli.DownloadWithMediaRetry(env.ctx, info, msg)
+	if !errors.Is(err, whatsmeow.ErrMediaRetryNotFound) {
+		t.Fatalf("expected media retry not found error, got %v", err)
+	}
+
+	env.srv.HandleMediaRetry(nil)
+	ctx, cancel := context.WithTimeout(env.ctx, 100*time.Millisecond)
+	defer cancel()
+	_, err = env.cli.DownloadWithMediaRetry(ctx, info, msg)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline exceeded error, got %v", err)
+	}
+
+	env.srv.HandleMediaRetry(func(conn *fakeserver.Conn, req *fakeserver.MediaRetryRequest) {
+		_ = conn.SendMediaRetryError(req, 2)
+	})
+	_, err = env.cli.DownloadWithMediaRetry(env.ctx, info, msg)
+	if !errors.Is(err, whatsmeow.ErrMediaNotAvailableOnPhone) {
+		t.Fatalf("expected media not available error, got %v", err)
+	}
+}
@@ -0,0 +1,136 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package fakeserver_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/go-whatsapp/go-util/random"
+	_ "github.com/mattn/go-sqlite3"
+
+	"github.com/go-whatsapp/whatsmeow"
+	waProto "github.com/go-whatsapp/whatsmeow/binary/proto"
+	"github.com/go-whatsapp/whatsmeow/fakeserver"
+	"github.com/go-whatsapp/whatsmeow/socket"
+	"github.com/go-whatsapp/whatsmeow/store"
+	"github.com/go-whatsapp/whatsmeow/store/sqlstore"
+	"github.com/go-whatsapp/whatsmeow/types"
+	"github.com/go-whatsapp/whatsmeow/types/events"
+	waLog "github.com/go-whatsapp/whatsmeow/util/log"
+)
+
+type testEnv struct {
+	ctx    context.Context
+	srv    *fakeserver.Server
+	cli    *whatsmeow.Client
+	peer   types.JID
+	events chan interface{}
+}
+
+func newTestDevice(t *testing.T, jid types.JID) *store.Device {
+	// Every device gets its own in-memory database, which stays alive as long as the container has connections open.
+	container, err := sqlstore.New("sqlite3", fmt.Sprintf("file:%x?mode=memory&cache=shared&_foreign_keys=on", random.Bytes(8)), waLog.Noop)
+	if err != nil {
+		t.Fatal(err)
+	}
+	device := container.NewDevice()
+	device.JID = &jid
+	// The fake server doesn't verify the device identity, but the database requires one to be present
+	device.Account = &waProto.ADVSignedDeviceIdentity{
+		Details:             []byte{},
+		AccountSignature:    make([]byte, 64),
+		AccountSignatureKey: make([]byte, 32),
+		DeviceSignature:     make([]byte, 64),
+	}
+	if err = device.Save(); err != nil {
+		t.Fatal(err)
+	}
+	return device
+}
+
+func setup(t *testing.T) *testEnv {
+	transport := socket.NewPipeTransport()
+	t.Cleanup(transport.Close)
+	return setupWithTransport(t, transport, transport, nil)
+}
+
+func setupWithTransport(t *testing.T, transport socket.Transport, listener fakeserver.Listener, configure func(cli *whatsmeow.Client)) *testEnv {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	t.Cleanup(cancel)
+
+	srv := fakeserver.New(waLog.Noop)
+	go func() {
+		_ = srv.Serve(ctx, listener)
+	}()
+
+	device := newTestDevice(t, types.NewADJID("1111", 0, 1))
+	if err := srv.AddDevice(device); err != nil {
+		t.Fatal(err)
+	}
+	peer := newTestDevice(t, types.NewADJID("2222", 0, 0))
+	if err := srv.AddPeer(peer); err != nil {
+		t.Fatal(err)
+	}
+
+	env := &testEnv{
+		ctx:    ctx,
+		srv:    srv,
+		cli:    whatsmeow.NewClient(device, waLog.Noop),
+		peer:   *peer.JID,
+		events: make(chan interface{}, 64),
+	}
+	env.cli.Transport = transport
+	if configure != nil {
+		configure(env.cli)
+	}
+	env.cli.AddEventHandler(func(evt interface{}) {
+		env.events <- evt
+	})
+	if err := env.cli.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(env.cli.Disconnect)
+	waitForEvent[*events.Connected](t, env)
+	return env
+}
+
+// setupMedia is like setup, but also routes the client's media requests to the fake server.
+func setupMedia(t *testing.T) *testEnv {
+	env := setup(t)
+	env.cli.SetMediaRoundTripper(env.srv.MediaRoundTripper())
+	return env
+}
+
+func waitForEvent[T any](t *testing.T, env *testEnv) T {
+	t.Helper()
+	for {
+		select {
+		case evt := <-env.events:
+			if typed, ok := evt.(T); ok {
+				return typed
+			}
+		case <-env.ctx.Done():
+			var zero T
+			t.Fatalf("timed out waiting for %T", zero)
+			return zero
+		}
+	}
+}
+
+// documentMessage returns a downloadable message pointing at the uploaded file.
+func documentMessage(resp whatsmeow.UploadResponse) *waProto.DocumentMessage {
+	return &waProto.DocumentMessage{
+		DirectPath:    &resp.DirectPath,
+		MediaKey:      resp.MediaKey,
+		FileEncSha256: resp.FileEncSHA256,
+		FileSha256:    resp.FileSHA256,
+		FileLength:    &resp.FileLength,
+	}
+}
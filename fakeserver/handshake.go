@@ -0,0 +1,134 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package fakeserver
+
+import (
+	"bytes"
+	"fmt"
+	"time"
+
+	"github.com/go-whatsapp/go-util/random"
+	"google.golang.org/protobuf/proto"
+
+	waProto "github.com/go-whatsapp/whatsmeow/binary/proto"
+	"github.com/go-whatsapp/whatsmeow/socket"
+	"github.com/go-whatsapp/whatsmeow/util/keys"
+)
+
+// HandshakeTimeout is the maximum time to wait for each handshake message from the client.
+var HandshakeTimeout = 20 * time.Second
+
+// doHandshake implements the responder side of the Noise_XX_25519_AESGCM_SHA256 handshake.
+// It's the mirror image of Client.doHandshake in the whatsmeow package.
+func (conn *Conn) doHandshake() (*waProto.ClientPayload, error) {
+	header, err := conn.frames.readHeader(len(socket.WAConnHeader), HandshakeTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read connection header: %w", err)
+	} else if !bytes.Equal(header, socket.WAConnHeader) {
+		return nil, fmt.Errorf("unexpected connection header %x", header)
+	}
+	data, err := conn.frames.readFrame(HandshakeTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read client hello: %w", err)
+	}
+	var hello waProto.HandshakeMessage
+	err = proto.Unmarshal(data, &hello)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal client hello: %w", err)
+	}
+	clientEphemeral := hello.GetClientHello().GetEphemeral()
+	if len(clientEphemeral) != 32 {
+		return nil, fmt.Errorf("missing ephemeral key in client hello")
+	}
+	clientEphemeralArr := *(*[32]byte)(clientEphemeral)
+
+	ephemeralKP := keys.NewKeyPair()
+	nh := socket.NewNoiseHandshake()
+	nh.Start(socket.NoiseStartPattern, header)
+	nh.Authenticate(clientEphemeral)
+	nh.Authenticate(ephemeralKP.Pub[:])
+	err = nh.MixSharedSecretIntoKey(*ephemeralKP.Priv, clientEphemeralArr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to mix client ephemeral key in: %w", err)
+	}
+	encryptedStatic := nh.Encrypt(conn.srv.StaticKey.Pub[:])
+	err = nh.MixSharedSecretIntoKey(*conn.srv.StaticKey.Priv, clientEphemeralArr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to mix server static key in: %w", err)
+	}
+
+	certDetails, err := proto.Marshal(&waProto.NoiseCertificate_Details{
+		Serial:  proto.Uint32(0),
+		Issuer:  proto.String("WhatsAppLongTerm1"),
+		Expires: proto.Uint64(uint64(time.Now().Add(24 * time.Hour).Unix())),
+		Subject: proto.String("CERT"),
+		Key:     conn.srv.StaticKey.Pub[:],
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal certificate details: %w", err)
+	}
+	cert, err := proto.Marshal(&waProto.NoiseCertificate{
+		Details: certDetails,
+		// The client doesn't verify the signature, so random data is good enough
+		Signature: random.Bytes(64),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal certificate: %w", err)
+	}
+	encryptedCert := nh.Encrypt(cert)
+	data, err = proto.Marshal(&waProto.HandshakeMessage{
+		ServerHello: &waProto.HandshakeServerHello{
+			Ephemeral: ephemeralKP.Pub[:],
+			Static:    encryptedStatic,
+			Payload:   encryptedCert,
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal server hello: %w", err)
+	}
+	err = conn.writeFrame(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send server hello: %w", err)
+	}
+
+	data, err = conn.frames.readFrame(HandshakeTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read client finish: %w", err)
+	}
+	var finish waProto.HandshakeMessage
+	err = proto.Unmarshal(data, &finish)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal client finish: %w", err)
+	}
+	clientStatic, err := nh.Decrypt(finish.GetClientFinish().GetStatic())
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt client static key: %w", err)
+	} else if len(clientStatic) != 32 {
+		return nil, fmt.Errorf("unexpected length of client static key %d (expected 32)", len(clientStatic))
+	}
+	err = nh.MixSharedSecretIntoKey(*ephemeralKP.Priv, *(*[32]byte)(clientStatic))
+	if err != nil {
+		return nil, fmt.Errorf("failed to mix client static key in: %w", err)
+	}
+	payloadBytes, err := nh.Decrypt(finish.GetClientFinish().GetPayload())
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt client payload: %w", err)
+	}
+	var payload waProto.ClientPayload
+	err = proto.Unmarshal(payloadBytes, &payload)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal client payload: %w", err)
+	}
+
+	// The client writes with the initiator key, so we read with it and write with the responder key.
+	conn.readKey, conn.writeKey, err = nh.Split()
+	if err != nil {
+		return nil, err
+	}
+	conn.clientStatic = *(*[32]byte)(clientStatic)
+	return &payload, nil
+}
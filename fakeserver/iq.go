@@ -0,0 +1,388 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package fakeserver
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+
+	"go.mau.fi/libsignal/ecc"
+
+	waBinary "github.com/go-whatsapp/whatsmeow/binary"
+	"github.com/go-whatsapp/whatsmeow/types"
+	"github.com/go-whatsapp/whatsmeow/util/keys"
+)
+
+// IQHandler handles an info query from a client. The returned nodes are used as the content of the
+// result. If the handler returns an *IQError, it will be sent to the client as-is, other errors are
+// sent as a 500 internal-server-error.
+type IQHandler func(conn *Conn, iq *waBinary.Node) ([]waBinary.Node, error)
+
+// IQError is an error response to an info query.
+type IQError struct {
+	Code int
+	Text string
+}
+
+func (iqe *IQError) Error() string {
+	return fmt.Sprintf("info query error %d: %s", iqe.Code, iqe.Text)
+}
+
+// Common info query errors
+var (
+	ErrIQBadRequest         = &IQError{Code: 400, Text: "bad-request"}
+	ErrIQNotAuthorized      = &IQError{Code: 401, Text: "not-authorized"}
+	ErrIQForbidden          = &IQError{Code: 403, Text: "forbidden"}
+	ErrIQNotFound           = &IQError{Code: 404, Text: "item-not-found"}
+	ErrIQNotAllowed         = &IQError{Code: 405, Text: "not-allowed"}
+	ErrIQInternalServer     = &IQError{Code: 500, Text: "internal-server-error"}
+	ErrIQNotImplemented     = &IQError{Code: 501, Text: "feature-not-implemented"}
+	ErrIQServiceUnavailable = &IQError{Code: 503, Text: "service-unavailable"}
+)
+
+func (srv *Server) handleIQ(conn *Conn, node *waBinary.Node) {
+	ag := node.AttrGetter()
+	id := ag.String("id")
+	iqType := ag.String("type")
+	xmlns := ag.OptionalString("xmlns")
+	if !ag.OK() {
+		conn.log.Warnf("Got invalid info query: %v", ag.Error())
+		return
+	} else if iqType == "result" || iqType == "error" {
+		// Responses to server-initiated queries aren't tracked
+		return
+	}
+	srv.lock.RLock()
+	handler, ok := srv.iqHandlers[xmlns]
+	srv.lock.RUnlock()
+	var content []waBinary.Node
+	var err error
+	if ok {
+		content, err = handler(conn, node)
+	} else {
+		conn.log.Debugf("No handler for %s info query", xmlns)
+		err = ErrIQNotImplemented
+	}
+	attrs := waBinary.Attrs{
+		"id":   id,
+		"type": "result",
+	}
+	if from, ok := node.Attrs["to"]; ok {
+		attrs["from"] = from
+	} else {
+		attrs["from"] = types.ServerJID
+	}
+	resp := waBinary.Node{Tag: "iq", Attrs: attrs}
+	if err != nil {
+		var iqErr *IQError
+		if !errors.As(err, &iqErr) {
+			conn.log.Warnf("Error handling %s info query: %v", xmlns, err)
+			iqErr = ErrIQInternalServer
+		}
+		attrs["type"] = "error"
+		resp.Content = []waBinary.Node{{
+			Tag:   "error",
+			Attrs: waBinary.Attrs{"code": iqErr.Code, "text": iqErr.Text},
+		}}
+	} else if len(content) > 0 {
+		resp.Content = content
+	}
+	err = conn.SendNode(resp)
+	if err != nil {
+		conn.log.Warnf("Failed to send response to %s info query: %v", xmlns, err)
+	}
+}
+
+func handlePing(_ *Conn, _ *waBinary.Node) ([]waBinary.Node, error) {
+	return nil, nil
+}
+
+func handlePassive(_ *Conn, _ *waBinary.Node) ([]waBinary.Node, error) {
+	return nil, nil
+}
+
+func (srv *Server) handleEncryptIQ(conn *Conn, iq *waBinary.Node) ([]waBinary.Node, error) {
+	iqType, _ := iq.Attrs["type"].(string)
+	if iqType == "set" {
+		return nil, srv.handlePreKeyUpload(conn, iq)
+	}
+	if _, ok := iq.GetOptionalChildByTag("count"); ok {
+		var count int
+		srv.lock.RLock()
+		if acc, ok := srv.accounts[conn.JID]; ok {
+			count = len(acc.PreKeys)
+		}
+		srv.lock.RUnlock()
+		return []waBinary.Node{{Tag: "count", Attrs: waBinary.Attrs{"value": count}}}, nil
+	} else if keyNode, ok := iq.GetOptionalChildByTag("key"); ok {
+		users := keyNode.GetChildrenByTag("user")
+		list := make([]waBinary.Node, len(users))
+		for i, user := range users {
+			jid, ok := user.Attrs["jid"].(types.JID)
+			if !ok {
+				return nil, ErrIQBadRequest
+			}
+			list[i] = srv.getPreKeyBundleNode(jid)
+		}
+		return []waBinary.Node{{Tag: "list", Content: list}}, nil
+	}
+	return nil, ErrIQNotImplemented
+}
+
+func (srv *Server) handlePreKeyUpload(conn *Conn, iq *waBinary.Node) error {
+	list, ok := iq.GetOptionalChildByTag("list")
+	if !ok {
+		return ErrIQBadRequest
+	}
+	preKeys := make([]*keys.PreKey, 0, len(list.GetChildren()))
+	for _, child := range list.GetChildrenByTag("key") {
+		preKey, err := nodeToPreKey(child)
+		if err != nil {
+			conn.log.Warnf("Invalid prekey in upload: %v", err)
+			return ErrIQBadRequest
+		}
+		preKeys = append(preKeys, preKey)
+	}
+	srv.lock.Lock()
+	acc, ok := srv.accounts[conn.JID]
+	if ok {
+		acc.PreKeys = append(acc.PreKeys, preKeys...)
+	}
+	srv.lock.Unlock()
+	if !ok {
+		return ErrIQNotAuthorized
+	}
+	conn.log.Debugf("%s uploaded %d prekeys", conn.JID, len(preKeys))
+	return nil
+}
+
+func (srv *Server) getPreKeyBundleNode(jid types.JID) waBinary.Node {
+	node := waBinary.Node{Tag: "user", Attrs: waBinary.Attrs{"jid": jid}}
+	var registrationID uint32
+	var identityKey []byte
+	var preKey, signedPreKey *keys.PreKey
+	srv.lock.Lock()
+	if acc, ok := srv.accounts[jid]; ok && len(acc.PreKeys) > 0 {
+		registrationID = acc.RegistrationID
+		identityKey = acc.IdentityKey[:]
+		preKey, acc.PreKeys = acc.PreKeys[0], acc.PreKeys[1:]
+		signedPreKey = acc.SignedPreKey
+	} else if peer, ok := srv.peers[jid]; ok {
+		var err error
+		preKey, err = peer.PreKeys.GenOnePreKey()
+		if err != nil {
+			srv.Log.Warnf("Failed to generate prekey for peer %s: %v", jid, err)
+		} else {
+			registrationID = peer.RegistrationID
+			identityKey = peer.IdentityKey.Pub[:]
+			signedPreKey = peer.SignedPreKey
+		}
+	}
+	srv.lock.Unlock()
+	if preKey == nil {
+		node.Content = []waBinary.Node{{
+			Tag:   "error",
+			Attrs: waBinary.Attrs{"code": ErrIQNotFound.Code, "text": ErrIQNotFound.Text},
+		}}
+		return node
+	}
+	var registrationIDBytes [4]byte
+	binary.BigEndian.PutUint32(registrationIDBytes[:], registrationID)
+	node.Content = []waBinary.Node{
+		{Tag: "registration", Content: registrationIDBytes[:]},
+		{Tag: "type", Content: []byte{ecc.DjbType}},
+		{Tag: "identity", Content: identityKey},
+		preKeyToNode(preKey),
+		preKeyToNode(signedPreKey),
+	}
+	return node
+}
+
+func preKeyToNode(key *keys.PreKey) waBinary.Node {
+	var keyID [4]byte
+	binary.BigEndian.PutUint32(keyID[:], key.KeyID)
+	node := waBinary.Node{
+		Tag: "key",
+		Content: []waBinary.Node{
+			{Tag: "id", Content: keyID[1:]},
+			{Tag: "value", Content: key.Pub[:]},
+		},
+	}
+	if key.Signature != nil {
+		node.Tag = "skey"
+		node.Content = append(node.GetChildren(), waBinary.Node{
+			Tag:     "signature",
+			Content: key.Signature[:],
+		})
+	}
+	return node
+}
+
+func nodeToPreKey(node waBinary.Node) (*keys.PreKey, error) {
+	idBytes, ok := node.GetChildByTag("id").Content.([]byte)
+	if !ok || len(idBytes) != 3 {
+		return nil, fmt.Errorf("invalid prekey ID")
+	}
+	pub, ok := node.GetChildByTag("value").Content.([]byte)
+	if !ok || len(pub) != 32 {
+		return nil, fmt.Errorf("invalid prekey value")
+	}
+	return &keys.PreKey{
+		KeyPair: keys.KeyPair{Pub: (*[32]byte)(pub)},
+		KeyID:   binary.BigEndian.Uint32(append([]byte{0}, idBytes...)),
+	}, nil
+}
+
+func (srv *Server) handleUsyncIQ(_ *Conn, iq *waBinary.Node) ([]waBinary.Node, error) {
+	usync, ok := iq.GetOptionalChildByTag("usync")
+	if !ok {
+		return nil, ErrIQBadRequest
+	}
+	query := usync.GetChildByTag("query")
+	_, wantDevices := query.GetOptionalChildByTag("devices")
+	_, wantContact := query.GetOptionalChildByTag("contact")
+	list := usync.GetChildByTag("list")
+	users := list.GetChildrenByTag("user")
+	result := make([]waBinary.Node, 0, len(users))
+	for _, user := range users {
+		jid, ok := user.Attrs["jid"].(types.JID)
+		var contactQuery []byte
+		if !ok {
+			contactQuery, ok = user.GetChildByTag("contact").Content.([]byte)
+			if !ok {
+				return nil, ErrIQBadRequest
+			}
+			phone := strings.TrimPrefix(strings.Split(string(contactQuery), "@")[0], "+")
+			jid = types.NewJID(phone, types.DefaultUserServer)
+		}
+		devices := srv.devicesOf(jid.User)
+		var content []waBinary.Node
+		if wantDevices {
+			deviceNodes := make([]waBinary.Node, len(devices))
+			for i, device := range devices {
+				deviceNodes[i] = waBinary.Node{Tag: "device", Attrs: waBinary.Attrs{"id": int(device.Device)}}
+			}
+			content = append(content, waBinary.Node{
+				Tag: "devices",
+				Content: []waBinary.Node{{
+					Tag:     "device-list",
+					Content: deviceNodes,
+				}},
+			})
+		}
+		if wantContact {
+			contactType := "out"
+			if len(devices) > 0 {
+				contactType = "in"
+			}
+			content = append(content, waBinary.Node{
+				Tag:     "contact",
+				Attrs:   waBinary.Attrs{"type": contactType},
+				Content: contactQuery,
+			})
+		}
+		result = append(result, waBinary.Node{
+			Tag:     "user",
+			Attrs:   waBinary.Attrs{"jid": jid},
+			Content: content,
+		})
+	}
+	return []waBinary.Node{{
+		Tag:   "usync",
+		Attrs: usync.Attrs,
+		Content: []waBinary.Node{{
+			Tag:     "list",
+			Content: result,
+		}},
+	}}, nil
+}
+
+func (srv *Server) handleGroupIQ(conn *Conn, iq *waBinary.Node) ([]waBinary.Node, error) {
+	children := iq.GetChildren()
+	if len(children) != 1 {
+		return nil, ErrIQBadRequest
+	}
+	srv.lock.RLock()
+	defer srv.lock.RUnlock()
+	switch children[0].Tag {
+	case "query":
+		to, _ := iq.Attrs["to"].(types.JID)
+		group, ok := srv.groups[to]
+		if !ok {
+			return nil, ErrIQNotFound
+		} else if !isParticipant(group, conn.JID) {
+			return nil, ErrIQForbidden
+		}
+		return []waBinary.Node{groupToNode(group)}, nil
+	case "participating":
+		var groups []waBinary.Node
+		for _, group := range srv.groups {
+			if isParticipant(group, conn.JID) {
+				groups = append(groups, groupToNode(group))
+			}
+		}
+		return []waBinary.Node{{Tag: "groups", Content: groups}}, nil
+	default:
+		return nil, ErrIQNotImplemented
+	}
+}
+
+func isParticipant(group *types.GroupInfo, jid types.JID) bool {
+	for _, participant := range group.Participants {
+		if participant.JID.User == jid.User {
+			return true
+		}
+	}
+	return false
+}
+
+func groupToNode(group *types.GroupInfo) waBinary.Node {
+	attrs := waBinary.Attrs{
+		"id":       group.JID.User,
+		"subject":  group.Name,
+		"s_t":      group.NameSetAt.Unix(),
+		"creation": group.GroupCreated.Unix(),
+	}
+	if !group.OwnerJID.IsEmpty() {
+		attrs["creator"] = group.OwnerJID
+	}
+	participants := make([]waBinary.Node, len(group.Participants))
+	for i, participant := range group.Participants {
+		pcpAttrs := waBinary.Attrs{"jid": participant.JID}
+		if participant.IsSuperAdmin {
+			pcpAttrs["type"] = "superadmin"
+		} else if participant.IsAdmin {
+			pcpAttrs["type"] = "admin"
+		}
+		participants[i] = waBinary.Node{Tag: "participant", Attrs: pcpAttrs}
+	}
+	return waBinary.Node{Tag: "group", Attrs: attrs, Content: participants}
+}
+
+func (srv *Server) handleMediaConnIQ(_ *Conn, iq *waBinary.Node) ([]waBinary.Node, error) {
+	if _, ok := iq.GetOptionalChildByTag("media_conn"); !ok {
+		return nil, ErrIQNotImplemented
+	}
+	srv.lock.RLock()
+	hosts := make([]waBinary.Node, len(srv.MediaHosts))
+	for i, host := range srv.MediaHosts {
+		hosts[i] = waBinary.Node{Tag: "host", Attrs: waBinary.Attrs{"hostname": host}}
+	}
+	srv.lock.RUnlock()
+	return []waBinary.Node{{
+		Tag: "media_conn",
+		Attrs: waBinary.Attrs{
+			"auth":        srv.newMediaAuth(),
+			"ttl":         3600,
+			"auth_ttl":    21600,
+			"max_buckets": 12,
+		},
+		Content: hosts,
+	}}, nil
+}
@@ -0,0 +1,78 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package fakeserver_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/go-whatsapp/whatsmeow"
+	waBinary "github.com/go-whatsapp/whatsmeow/binary"
+	"github.com/go-whatsapp/whatsmeow/fakeserver"
+	"github.com/go-whatsapp/whatsmeow/types"
+)
+
+func TestQueries(t *testing.T) {
+	env := setup(t)
+
+	resp, err := env.cli.IsOnWhatsApp([]string{"+2222", "+3333"})
+	if err != nil {
+		t.Fatal(err)
+	} else if len(resp) != 2 || !resp[0].IsIn || resp[1].IsIn {
+		t.Fatalf("unexpected IsOnWhatsApp response %+v", resp)
+	}
+
+	groupJID := types.NewJID("123456", types.GroupServer)
+	env.srv.AddGroup(&types.GroupInfo{
+		JID:       groupJID,
+		GroupName: types.GroupName{Name: "Test group", NameSetAt: time.Unix(1700000000, 0)},
+		Participants: []types.GroupParticipant{
+			{JID: env.cli.Store.JID.ToNonAD(), IsAdmin: true, IsSuperAdmin: true},
+			{JID: env.peer.ToNonAD()},
+		},
+	})
+	groups, err := env.cli.GetJoinedGroups()
+	if err != nil {
+		t.Fatal(err)
+	} else if len(groups) != 1 || groups[0].JID != groupJID || groups[0].Name != "Test group" || len(groups[0].Participants) != 2 {
+		t.Fatalf("unexpected joined groups %+v", groups)
+	}
+	_, err = env.cli.GetGroupInfo(types.NewJID("654321", types.GroupServer))
+	if !errors.Is(err, whatsmeow.ErrGroupNotFound) {
+		t.Fatalf("expected group not found error, got %v", err)
+	}
+
+	env.srv.HandleIQ("w:g2", func(conn *fakeserver.Conn, iq *waBinary.Node) ([]waBinary.Node, error) {
+		return nil, fakeserver.ErrIQForbidden
+	})
+	_, err = env.cli.GetGroupInfo(groupJID)
+	if !errors.Is(err, whatsmeow.ErrNotInGroup) {
+		t.Fatalf("expected not in group error, got %v", err)
+	}
+}
+
+func TestInfoQueryContext(t *testing.T) {
+	env := setup(t)
+	release := make(chan struct{})
+	env.srv.HandleIQ("w:g2", func(conn *fakeserver.Conn, iq *waBinary.Node) ([]waBinary.Node, error) {
+		<-release
+		return nil, fakeserver.ErrIQNotFound
+	})
+	ctx, cancel := context.WithTimeout(env.ctx, 50*time.Millisecond)
+	defer cancel()
+	_, err := env.cli.GetGroupInfoContext(ctx, types.NewJID("123456", types.GroupServer))
+	close(release)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline exceeded error, got %v", err)
+	}
+	_, err = env.cli.GetGroupInfoContext(env.ctx, types.NewJID("123456", types.GroupServer))
+	if !errors.Is(err, whatsmeow.ErrGroupNotFound) {
+		t.Fatalf("expected group not found error, got %v", err)
+	}
+}
@@ -0,0 +1,227 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package fakeserver
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"syscall"
+	"time"
+)
+
+type uploadResponse struct {
+	URL        string `json:"url"`
+	DirectPath string `json:"direct_path"`
+	Handle     string `json:"handle,omitempty"`
+}
+
+// MediaHandler returns a HTTP handler that implements the media upload and download endpoints.
+// Uploaded files are stored in memory and can be downloaded using the returned direct path on any host.
+func (srv *Server) MediaHandler() http.Handler {
+	return http.HandlerFunc(srv.serveMedia)
+}
+
+// MediaRoundTripper returns a HTTP round tripper that sends all requests to MediaHandler without going through
+// the network. It can be passed to Client.SetMediaRoundTripper.
+func (srv *Server) MediaRoundTripper() http.RoundTripper {
+	return mediaRoundTripper{srv}
+}
+
+type mediaRoundTripper struct {
+	srv *Server
+}
+
+func (mrt mediaRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body != nil {
+		defer req.Body.Close()
+	}
+	mrt.srv.lock.Lock()
+	reset := req.Method == http.MethodPost && mrt.srv.mediaUploadResets[req.URL.Host] > 0
+	if reset {
+		mrt.srv.mediaUploadResets[req.URL.Host]--
+	}
+	mrt.srv.lock.Unlock()
+	if reset {
+		// Read part of the body before dropping the connection, like a real server would
+		if req.Body != nil {
+			_, _ = io.CopyN(io.Discard, req.Body, 1024)
+		}
+		return nil, &net.OpError{Op: "write", Net: "tcp", Err: syscall.ECONNRESET}
+	}
+	rec := httptest.NewRecorder()
+	mrt.srv.serveMedia(rec, req)
+	resp := rec.Result()
+	resp.Request = req
+	// Hide the io.WriterTo implementation, so that clients read the body in chunks like a real network response
+	var body io.Reader = struct{ io.Reader }{resp.Body}
+	mrt.srv.lock.Lock()
+	if req.Method == http.MethodGet && mrt.srv.mediaInterruptions > 0 && resp.ContentLength > int64(mrt.srv.mediaInterruptAfter) {
+		mrt.srv.mediaInterruptions--
+		body = io.LimitReader(body, int64(mrt.srv.mediaInterruptAfter))
+	}
+	mrt.srv.lock.Unlock()
+	resp.Body = io.NopCloser(&contentLengthReader{Reader: body, remaining: resp.ContentLength})
+	return resp, nil
+}
+
+// contentLengthReader returns io.ErrUnexpectedEOF if the body ends before Content-Length bytes have been read,
+// like net/http does for real responses.
+type contentLengthReader struct {
+	io.Reader
+	remaining int64
+}
+
+func (clr *contentLengthReader) Read(p []byte) (n int, err error) {
+	n, err = clr.Reader.Read(p)
+	clr.remaining -= int64(n)
+	if err == io.EOF && clr.remaining > 0 {
+		err = io.ErrUnexpectedEOF
+	}
+	return
+}
+
+// Media returns the stored file with the given direct path, or nil if there's no such file.
+func (srv *Server) Media(directPath string) []byte {
+	srv.lock.RLock()
+	defer srv.lock.RUnlock()
+	return srv.media[stripQuery(directPath)]
+}
+
+// SetMedia replaces the file with the given direct path, e.g. to test how clients handle corrupted files.
+func (srv *Server) SetMedia(directPath string, data []byte) {
+	srv.lock.Lock()
+	srv.media[stripQuery(directPath)] = data
+	srv.lock.Unlock()
+}
+
+// SetMediaHostStatus makes all media requests to the given host fail with the given HTTP status code.
+// Setting the status to zero makes the host work normally again.
+func (srv *Server) SetMediaHostStatus(host string, status int) {
+	srv.lock.Lock()
+	if status == 0 {
+		delete(srv.mediaHostStatus, host)
+	} else {
+		srv.mediaHostStatus[host] = status
+	}
+	srv.lock.Unlock()
+}
+
+// InterruptMediaDownloads makes the next count media downloads fail after the given number of bytes,
+// as if the connection was dropped. It only affects requests sent through MediaRoundTripper.
+func (srv *Server) InterruptMediaDownloads(count, afterBytes int) {
+	srv.lock.Lock()
+	srv.mediaInterruptions = count
+	srv.mediaInterruptAfter = afterBytes
+	srv.lock.Unlock()
+}
+
+// ResetMediaUploads makes the next count uploads to the given host fail with a connection reset after part of
+// the body has been sent. It only affects requests sent through MediaRoundTripper.
+func (srv *Server) ResetMediaUploads(host string, count int) {
+	srv.lock.Lock()
+	srv.mediaUploadResets[host] = count
+	srv.lock.Unlock()
+}
+
+// SetMediaIgnoreRange makes the media server ignore Range headers and always return the whole file.
+func (srv *Server) SetMediaIgnoreRange(ignore bool) {
+	srv.lock.Lock()
+	srv.mediaIgnoreRange = ignore
+	srv.lock.Unlock()
+}
+
+// ExpireMediaAuth invalidates all media auth tokens returned in media_conn responses so far,
+// which makes uploads fail with 401 until the client fetches a new token.
+func (srv *Server) ExpireMediaAuth() {
+	srv.lock.Lock()
+	srv.mediaAuth = make(map[string]struct{})
+	srv.lock.Unlock()
+}
+
+func (srv *Server) newMediaAuth() string {
+	auth := srv.generateID()
+	srv.lock.Lock()
+	srv.mediaAuth[auth] = struct{}{}
+	srv.lock.Unlock()
+	return auth
+}
+
+func stripQuery(directPath string) string {
+	path, _, _ := strings.Cut(directPath, "?")
+	return path
+}
+
+func (srv *Server) serveMedia(w http.ResponseWriter, r *http.Request) {
+	srv.lock.RLock()
+	status := srv.mediaHostStatus[r.Host]
+	srv.lock.RUnlock()
+	if status != 0 {
+		http.Error(w, http.StatusText(status), status)
+		return
+	}
+	switch {
+	case r.Method == http.MethodPost && (strings.HasPrefix(r.URL.Path, "/mms/") || strings.HasPrefix(r.URL.Path, "/newsletter/")):
+		srv.handleUpload(w, r)
+	case r.Method == http.MethodGet:
+		data := srv.Media(r.URL.Path)
+		if data == nil {
+			http.Error(w, "Not Found", http.StatusNotFound)
+			return
+		}
+		srv.lock.RLock()
+		ignoreRange := srv.mediaIgnoreRange
+		srv.lock.RUnlock()
+		if ignoreRange {
+			r.Header.Del("Range")
+		}
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(data))
+	default:
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (srv *Server) handleUpload(w http.ResponseWriter, r *http.Request) {
+	srv.lock.RLock()
+	_, authOK := srv.mediaAuth[r.URL.Query().Get("auth")]
+	srv.lock.RUnlock()
+	if !authOK {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+	hash := sha256.Sum256(data)
+	parts := strings.Split(r.URL.Path, "/")
+	if token := parts[len(parts)-1]; token != base64.URLEncoding.EncodeToString(hash[:]) {
+		srv.Log.Warnf("Upload token %s doesn't match hash of uploaded data", token)
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+	directPath := fmt.Sprintf("/v/t62/%s?ccb=11-4", hex.EncodeToString(hash[:]))
+	resp := uploadResponse{
+		URL:        fmt.Sprintf("https://%s%s", r.Host, directPath),
+		DirectPath: directPath,
+	}
+	if strings.HasPrefix(r.URL.Path, "/newsletter/") {
+		resp.Handle = srv.generateID()
+	}
+	srv.SetMedia(directPath, data)
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(&resp)
+}
@@ -0,0 +1,94 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package fakeserver_test
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"testing"
+
+	"github.com/go-whatsapp/go-util/random"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/go-whatsapp/whatsmeow"
+	waProto "github.com/go-whatsapp/whatsmeow/binary/proto"
+	"github.com/go-whatsapp/whatsmeow/mediacache"
+)
+
+func TestMediaCache(t *testing.T) {
+	env := setupMedia(t)
+	cache, err := mediacache.NewFilesystem(t.TempDir(), 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	env.cli.MediaCache = cache
+
+	plaintext := random.Bytes(32 * 1024)
+	resp, err := env.cli.Upload(env.ctx, plaintext, whatsmeow.MediaDocument)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := documentMessage(resp)
+	if _, err = env.cli.Download(msg); err != nil {
+		t.Fatal(err)
+	}
+	streamedPlaintext := random.Bytes(16 * 1024)
+	streamedResp, err := env.cli.Upload(env.ctx, streamedPlaintext, whatsmeow.MediaDocument)
+	if err != nil {
+		t.Fatal(err)
+	}
+	streamedMsg := documentMessage(streamedResp)
+	if err = env.cli.DownloadToWriter(env.ctx, streamedMsg, io.Discard); err != nil {
+		t.Fatal(err)
+	}
+
+	// With the media server down, both uploading and downloading the same file should still work using the cache
+	env.srv.SetMediaHostStatus("mmg.whatsapp.net", http.StatusServiceUnavailable)
+	resp2, err := env.cli.Upload(env.ctx, plaintext, whatsmeow.MediaDocument)
+	if err != nil {
+		t.Fatal(err)
+	} else if resp2.DirectPath != resp.DirectPath || !bytes.Equal(resp2.MediaKey, resp.MediaKey) {
+		t.Fatal("second upload didn't reuse cached upload response")
+	}
+	for _, reader := range []io.Reader{bytes.NewReader(plaintext), io.MultiReader(bytes.NewReader(plaintext))} {
+		resp2, err = env.cli.UploadReader(env.ctx, reader, int64(len(plaintext)), whatsmeow.MediaDocument)
+		if err != nil {
+			t.Fatal(err)
+		} else if resp2.DirectPath != resp.DirectPath || !bytes.Equal(resp2.MediaKey, resp.MediaKey) {
+			t.Fatalf("UploadReader with %T didn't reuse cached upload response", reader)
+		}
+	}
+	if data, err := env.cli.Download(streamedMsg); err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(data, streamedPlaintext) {
+		t.Fatal("cached streamed download doesn't match uploaded data")
+	}
+	// The cache is keyed by both hashes, so a message pointing at different encrypted data can't use the entry
+	otherEncMsg := proto.Clone(msg).(*waProto.DocumentMessage)
+	otherEncMsg.FileEncSha256 = random.Bytes(32)
+	if _, err = env.cli.Download(otherEncMsg); err == nil {
+		t.Fatal("expected download with different encrypted hash to hit the media server")
+	}
+	data, err := env.cli.Download(msg)
+	if err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(data, plaintext) {
+		t.Fatal("cached data doesn't match uploaded data")
+	}
+	var buf bytes.Buffer
+	if err = env.cli.DownloadToWriter(env.ctx, msg, &buf); err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(buf.Bytes(), plaintext) {
+		t.Fatal("streamed cached data doesn't match uploaded data")
+	}
+
+	// Different media types use different keys, so they can't be reused
+	if _, err = env.cli.Upload(env.ctx, plaintext, whatsmeow.MediaImage); err == nil {
+		t.Fatal("expected upload with different media type to hit the media server")
+	}
+}
@@ -0,0 +1,84 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package fakeserver_test
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/gif"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-whatsapp/go-util/random"
+
+	"github.com/go-whatsapp/whatsmeow"
+	"github.com/go-whatsapp/whatsmeow/util/mediautil"
+)
+
+func TestMediaMessageBuilders(t *testing.T) {
+	env := setupMedia(t)
+	img := image.NewRGBA(image.Rect(0, 0, 300, 200))
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	imageMsg, err := env.cli.BuildImageMessage(env.ctx, buf.Bytes(), whatsmeow.MediaMessageExtra{Caption: "meow"})
+	if err != nil {
+		t.Fatal(err)
+	} else if imageMsg.GetMimetype() != "image/png" || imageMsg.GetWidth() != 300 || imageMsg.GetHeight() != 200 || imageMsg.GetCaption() != "meow" {
+		t.Fatalf("unexpected image message metadata: %v", imageMsg)
+	} else if len(imageMsg.GetJpegThumbnail()) == 0 {
+		t.Fatal("image message doesn't have a thumbnail")
+	} else if data, err := env.cli.Download(imageMsg); err != nil || !bytes.Equal(data, buf.Bytes()) {
+		t.Fatalf("failed to download built image message: %v", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "file.pdf")
+	pdf := []byte("%PDF-1.4\n1 0 obj << /Type /Pages /Kids [] /Count 3 >> endobj\n%%EOF")
+	if err = os.WriteFile(path, pdf, 0600); err != nil {
+		t.Fatal(err)
+	}
+	msg, err := env.cli.BuildMediaMessageFromFile(env.ctx, path)
+	if err != nil {
+		t.Fatal(err)
+	} else if doc := msg.GetDocumentMessage(); doc.GetFileName() != "file.pdf" || doc.GetMimetype() != "application/pdf" || doc.GetPageCount() != 3 {
+		t.Fatalf("unexpected document message metadata: %v", doc)
+	}
+
+	if _, err = env.cli.BuildVideoMessage(env.ctx, buf.Bytes()); !errors.Is(err, whatsmeow.ErrUnsupportedMediaType) {
+		t.Fatalf("expected unsupported media type error, got %v", err)
+	} else if _, err = env.cli.BuildImageMessage(env.ctx, random.Bytes(int(whatsmeow.MaxMediaSize[whatsmeow.MediaImage])+1)); !errors.Is(err, whatsmeow.ErrMediaTooLarge) {
+		t.Fatalf("expected media too large error, got %v", err)
+	} else if _, err = env.cli.BuildImageMessage(env.ctx, buf.Bytes(), whatsmeow.MediaMessageExtra{MimeType: "image/webp"}); !errors.Is(err, whatsmeow.ErrUnsupportedMediaType) {
+		t.Fatalf("expected unsupported media type error for WebP image message, got %v", err)
+	}
+	var gifBuf bytes.Buffer
+	if err = gif.Encode(&gifBuf, img, nil); err != nil {
+		t.Fatal(err)
+	} else if _, err = env.cli.BuildImageMessage(env.ctx, gifBuf.Bytes()); !errors.Is(err, whatsmeow.ErrUnsupportedMediaType) {
+		t.Fatalf("expected unsupported media type error for GIF image message, got %v", err)
+	}
+
+	pack := &mediautil.StickerPackMetadata{PackID: "meow", PackName: "Cats", Publisher: "whatsmeow", Emojis: []string{"🐈"}}
+	stickerMsg, err := env.cli.BuildStickerMessage(env.ctx, buf.Bytes(), whatsmeow.MediaMessageExtra{StickerPack: pack})
+	if err != nil {
+		t.Fatal(err)
+	} else if stickerMsg.GetMimetype() != "image/webp" || stickerMsg.GetWidth() != 512 || stickerMsg.GetIsAnimated() {
+		t.Fatalf("unexpected sticker message metadata: %v", stickerMsg)
+	}
+	sticker, err := env.cli.Download(stickerMsg)
+	if err != nil {
+		t.Fatal(err)
+	} else if width, height, err := mediautil.ImageSize(sticker); err != nil || width != 512 || height != 512 {
+		t.Fatalf("unexpected sticker size %dx%d (%v)", width, height, err)
+	} else if meta, err := mediautil.GetStickerMetadata(sticker); err != nil || meta.PackName != pack.PackName || len(meta.Emojis) != 1 {
+		t.Fatalf("unexpected sticker pack metadata %+v (%v)", meta, err)
+	}
+}
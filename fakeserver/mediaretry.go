@@ -0,0 +1,147 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package fakeserver
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/go-whatsapp/go-util/random"
+	"google.golang.org/protobuf/proto"
+
+	waBinary "github.com/go-whatsapp/whatsmeow/binary"
+	waProto "github.com/go-whatsapp/whatsmeow/binary/proto"
+	"github.com/go-whatsapp/whatsmeow/types"
+	"github.com/go-whatsapp/whatsmeow/util/gcmutil"
+	"github.com/go-whatsapp/whatsmeow/util/hkdfutil"
+)
+
+// MediaRetryRequest is a media retry receipt sent by a client, i.e. a request for the phone to re-upload media.
+type MediaRetryRequest struct {
+	MessageID   types.MessageID
+	Chat        types.JID
+	Participant types.JID
+	FromMe      bool
+
+	Ciphertext []byte
+	IV         []byte
+}
+
+// MediaRetryHandler handles media retry requests from clients. It's called synchronously from the connection's
+// read loop, so it should respond quickly using Conn.SendMediaRetryNotification or Conn.SendMediaRetryError.
+type MediaRetryHandler func(conn *Conn, req *MediaRetryRequest)
+
+// HandleMediaRetry sets the handler for media retry requests. Requests are ignored if no handler is set,
+// like when the phone is offline.
+func (srv *Server) HandleMediaRetry(handler MediaRetryHandler) {
+	srv.lock.Lock()
+	srv.mediaRetryHandler = handler
+	srv.lock.Unlock()
+}
+
+func (srv *Server) handleMediaRetryReceipt(conn *Conn, node *waBinary.Node) {
+	srv.lock.RLock()
+	handler := srv.mediaRetryHandler
+	srv.lock.RUnlock()
+	if handler == nil {
+		return
+	}
+	req, err := parseMediaRetryRequest(node)
+	if err != nil {
+		conn.log.Warnf("Got invalid media retry receipt: %v", err)
+		return
+	}
+	handler(conn, req)
+}
+
+func parseMediaRetryRequest(node *waBinary.Node) (*MediaRetryRequest, error) {
+	var req MediaRetryRequest
+	req.MessageID = node.AttrGetter().String("id")
+	rmr := node.GetChildByTag("rmr")
+	rmrAG := rmr.AttrGetter()
+	req.Chat = rmrAG.JID("jid")
+	req.FromMe = rmrAG.Bool("from_me")
+	req.Participant = rmrAG.OptionalJIDOrEmpty("participant")
+	if !rmrAG.OK() {
+		return nil, fmt.Errorf("invalid <rmr> tag: %w", rmrAG.Error())
+	}
+	var ok bool
+	req.Ciphertext, ok = node.GetChildByTag("encrypt", "enc_p").Content.([]byte)
+	if !ok {
+		return nil, fmt.Errorf("missing <enc_p> tag")
+	}
+	req.IV, ok = node.GetChildByTag("encrypt", "enc_iv").Content.([]byte)
+	if !ok {
+		return nil, fmt.Errorf("missing <enc_iv> tag")
+	}
+	return &req, nil
+}
+
+func getMediaRetryKey(mediaKey []byte) []byte {
+	return hkdfutil.SHA256(mediaKey, nil, []byte("WhatsApp Media Retry Notification"), 32)
+}
+
+func (req *MediaRetryRequest) rmrNode() waBinary.Node {
+	attrs := waBinary.Attrs{
+		"jid":     req.Chat,
+		"from_me": req.FromMe,
+	}
+	if !req.Participant.IsEmpty() {
+		attrs["participant"] = req.Participant
+	}
+	return waBinary.Node{Tag: "rmr", Attrs: attrs}
+}
+
+func (conn *Conn) sendMediaRetryNotification(req *MediaRetryRequest, content waBinary.Node) error {
+	return conn.SendNode(waBinary.Node{
+		Tag: "notification",
+		Attrs: waBinary.Attrs{
+			"id":   req.MessageID,
+			"type": "mediaretry",
+			"from": types.ServerJID,
+			"t":    time.Now().Unix(),
+		},
+		Content: []waBinary.Node{content, req.rmrNode()},
+	})
+}
+
+// SendMediaRetryNotification responds to a media retry request with the given result. The media key is needed
+// to encrypt the response. It's also used to check that the client encrypted the request correctly.
+func (conn *Conn) SendMediaRetryNotification(req *MediaRetryRequest, mediaKey []byte, notif *waProto.MediaRetryNotification) error {
+	key := getMediaRetryKey(mediaKey)
+	var receipt waProto.ServerErrorReceipt
+	if plaintext, err := gcmutil.Decrypt(key, req.IV, req.Ciphertext, []byte(req.MessageID)); err != nil {
+		return fmt.Errorf("failed to decrypt media retry request: %w", err)
+	} else if err = proto.Unmarshal(plaintext, &receipt); err != nil {
+		return fmt.Errorf("failed to unmarshal media retry request: %w", err)
+	} else if receipt.GetStanzaId() != req.MessageID {
+		return fmt.Errorf("unexpected stanza ID %q in media retry request %s", receipt.GetStanzaId(), req.MessageID)
+	}
+	if notif.StanzaId == nil {
+		notif = proto.Clone(notif).(*waProto.MediaRetryNotification)
+		notif.StanzaId = proto.String(req.MessageID)
+	}
+	plaintext, err := proto.Marshal(notif)
+	if err != nil {
+		return fmt.Errorf("failed to marshal media retry notification: %w", err)
+	}
+	iv := random.Bytes(12)
+	ciphertext, err := gcmutil.Encrypt(key, iv, plaintext, []byte(req.MessageID))
+	if err != nil {
+		return fmt.Errorf("failed to encrypt media retry notification: %w", err)
+	}
+	return conn.sendMediaRetryNotification(req, waBinary.Node{Tag: "encrypt", Content: []waBinary.Node{
+		{Tag: "enc_p", Content: ciphertext},
+		{Tag: "enc_iv", Content: iv},
+	}})
+}
+
+// SendMediaRetryError responds to a media retry request with an unencrypted error code,
+// e.g. 2 if the media is no longer available on the phone.
+func (conn *Conn) SendMediaRetryError(req *MediaRetryRequest, code int) error {
+	return conn.sendMediaRetryNotification(req, waBinary.Node{Tag: "error", Attrs: waBinary.Attrs{"code": code}})
+}
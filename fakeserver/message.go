@@ -0,0 +1,351 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package fakeserver
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/go-whatsapp/go-util/random"
+	"go.mau.fi/libsignal/ecc"
+	"go.mau.fi/libsignal/groups"
+	"go.mau.fi/libsignal/keys/identity"
+	"go.mau.fi/libsignal/keys/prekey"
+	"go.mau.fi/libsignal/protocol"
+	"go.mau.fi/libsignal/session"
+	"go.mau.fi/libsignal/util/optional"
+	"google.golang.org/protobuf/proto"
+
+	waBinary "github.com/go-whatsapp/whatsmeow/binary"
+	waProto "github.com/go-whatsapp/whatsmeow/binary/proto"
+	"github.com/go-whatsapp/whatsmeow/store"
+	"github.com/go-whatsapp/whatsmeow/types"
+	"github.com/go-whatsapp/whatsmeow/util/keys"
+)
+
+var pbSerializer = store.SignalProtobufSerializer
+
+func (srv *Server) handleMessage(conn *Conn, node *waBinary.Node) {
+	ag := node.AttrGetter()
+	id := ag.String("id")
+	to := ag.JID("to")
+	if !ag.OK() {
+		conn.log.Warnf("Got invalid message node: %v", ag.Error())
+		return
+	}
+	ts := time.Now()
+	err := conn.SendNode(waBinary.Node{
+		Tag: "ack",
+		Attrs: waBinary.Attrs{
+			"class": "message",
+			"id":    id,
+			"from":  to,
+			"t":     ts.Unix(),
+		},
+	})
+	if err != nil {
+		conn.log.Warnf("Failed to send ack for message %s: %v", id, err)
+	}
+
+	// Collect the encrypted payloads for each recipient device
+	payloads := make(map[types.JID][]waBinary.Node)
+	var groupPayload *waBinary.Node
+	for _, child := range node.GetChildren() {
+		switch child.Tag {
+		case "participants":
+			for _, participant := range child.GetChildrenByTag("to") {
+				jid, ok := participant.Attrs["jid"].(types.JID)
+				if !ok {
+					continue
+				}
+				payloads[jid] = append(payloads[jid], participant.GetChildrenByTag("enc")...)
+			}
+		case "enc":
+			groupPayload = &child
+		}
+	}
+	if groupPayload != nil && to.Server == types.GroupServer {
+		srv.lock.RLock()
+		group, ok := srv.groups[to]
+		srv.lock.RUnlock()
+		if !ok {
+			conn.log.Warnf("Got message %s to unknown group %s", id, to)
+			return
+		}
+		for _, participant := range group.Participants {
+			for _, device := range srv.devicesOf(participant.JID.User) {
+				if device != conn.JID {
+					payloads[device] = append(payloads[device], *groupPayload)
+				}
+			}
+		}
+	}
+
+	for recipient, encs := range payloads {
+		if len(encs) == 0 {
+			continue
+		}
+		srv.lock.RLock()
+		peer, isPeer := srv.peers[recipient]
+		recipientConn := srv.conns[recipient]
+		srv.lock.RUnlock()
+		if isPeer {
+			msg, err := srv.decryptForPeer(peer, conn.JID, to, encs)
+			if err != nil {
+				conn.log.Warnf("Failed to decrypt message %s for peer %s: %v", id, recipient, err)
+				continue
+			}
+			chat := to
+			if to.Server != types.GroupServer {
+				chat = to.ToNonAD()
+			}
+			select {
+			case srv.messages <- &ReceivedMessage{
+				ID:      id,
+				Chat:    chat,
+				Sender:  conn.JID,
+				Peer:    recipient,
+				Message: msg,
+				Raw:     node,
+			}:
+			default:
+				conn.log.Warnf("Received message buffer is full, dropping message %s to %s", id, recipient)
+			}
+			if srv.AutoDeliveryReceipts {
+				from := recipient
+				if chat.Server == types.GroupServer {
+					from = chat
+				}
+				err = conn.SendReceipt(from, types.ReceiptTypeDelivered, id)
+				if err != nil {
+					conn.log.Warnf("Failed to send delivery receipt for %s: %v", id, err)
+				}
+			}
+		} else if recipientConn != nil {
+			attrs := waBinary.Attrs{
+				"id":   id,
+				"from": conn.JID,
+				"t":    ts.Unix(),
+				"type": "text",
+			}
+			if to.Server == types.GroupServer {
+				attrs["from"] = to
+				attrs["participant"] = conn.JID
+			} else if recipient.User == conn.JID.User {
+				attrs["recipient"] = to.ToNonAD()
+			}
+			err = recipientConn.SendNode(waBinary.Node{Tag: "message", Attrs: attrs, Content: encs})
+			if err != nil {
+				conn.log.Warnf("Failed to forward message %s to %s: %v", id, recipient, err)
+			}
+		} else {
+			conn.log.Debugf("Dropping message %s to %s as the device isn't connected", id, recipient)
+		}
+	}
+}
+
+func (srv *Server) handleReceipt(conn *Conn, node *waBinary.Node) {
+	attrs := waBinary.Attrs{
+		"class": "receipt",
+		"id":    node.Attrs["id"],
+	}
+	if receiptType, ok := node.Attrs["type"]; ok {
+		attrs["type"] = receiptType
+	}
+	if to, ok := node.Attrs["to"]; ok {
+		attrs["from"] = to
+	}
+	err := conn.SendNode(waBinary.Node{Tag: "ack", Attrs: attrs})
+	if err != nil {
+		conn.log.Warnf("Failed to send ack for receipt: %v", err)
+	}
+	if attrs["type"] == "server-error" {
+		srv.handleMediaRetryReceipt(conn, node)
+	}
+}
+
+// decryptForPeer decrypts the given encrypted nodes with the signal store of a peer.
+// The last successfully decrypted message is returned. In group messages, that's the skmsg,
+// while the pkmsg before it only contains the sender key distribution message.
+func (srv *Server) decryptForPeer(peer *store.Device, from, chat types.JID, encs []waBinary.Node) (*waProto.Message, error) {
+	srv.signalLock.Lock()
+	defer srv.signalLock.Unlock()
+	var msg *waProto.Message
+	for _, enc := range encs {
+		content, _ := enc.Content.([]byte)
+		encType, _ := enc.Attrs["type"].(string)
+		var plaintext []byte
+		var err error
+		switch encType {
+		case "pkmsg", "msg":
+			builder := session.NewBuilderFromSignal(peer, from.SignalAddress(), pbSerializer)
+			cipher := session.NewCipher(builder, from.SignalAddress())
+			if encType == "pkmsg" {
+				var preKeyMsg *protocol.PreKeySignalMessage
+				preKeyMsg, err = protocol.NewPreKeySignalMessageFromBytes(content, pbSerializer.PreKeySignalMessage, pbSerializer.SignalMessage)
+				if err == nil {
+					plaintext, _, err = cipher.DecryptMessageReturnKey(preKeyMsg)
+				}
+			} else {
+				var signalMsg *protocol.SignalMessage
+				signalMsg, err = protocol.NewSignalMessageFromBytes(content, pbSerializer.SignalMessage)
+				if err == nil {
+					plaintext, err = cipher.Decrypt(signalMsg)
+				}
+			}
+		case "skmsg":
+			senderKeyName := protocol.NewSenderKeyName(chat.String(), from.SignalAddress())
+			builder := groups.NewGroupSessionBuilder(peer, pbSerializer)
+			cipher := groups.NewGroupCipher(builder, senderKeyName, peer)
+			var skMsg *protocol.SenderKeyMessage
+			skMsg, err = protocol.NewSenderKeyMessageFromBytes(content, pbSerializer.SenderKeyMessage)
+			if err == nil {
+				plaintext, err = cipher.Decrypt(skMsg)
+			}
+		default:
+			err = fmt.Errorf("unsupported encryption type %q", encType)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt %s: %w", encType, err)
+		}
+		plaintext, err = unpadMessage(plaintext)
+		if err != nil {
+			return nil, err
+		}
+		msg = &waProto.Message{}
+		err = proto.Unmarshal(plaintext, msg)
+		if err != nil {
+			return nil, fmt.Errorf("failed to unmarshal %s: %w", encType, err)
+		}
+		if skdm := msg.GetSenderKeyDistributionMessage(); skdm != nil && chat.Server == types.GroupServer {
+			skdMsg, err := protocol.NewSenderKeyDistributionMessageFromBytes(skdm.AxolotlSenderKeyDistributionMessage, pbSerializer.SenderKeyDistributionMessage)
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse sender key distribution message: %w", err)
+			}
+			senderKeyName := protocol.NewSenderKeyName(chat.String(), from.SignalAddress())
+			groups.NewGroupSessionBuilder(peer, pbSerializer).Process(senderKeyName, skdMsg)
+		}
+	}
+	return msg, nil
+}
+
+// SendMessage sends a message from a simulated peer to all connected devices of the given user.
+// The message is end-to-end encrypted using the peer's signal store.
+func (srv *Server) SendMessage(ctx context.Context, from, to types.JID, message *waProto.Message) (types.MessageID, error) {
+	srv.lock.RLock()
+	peer, ok := srv.peers[from]
+	srv.lock.RUnlock()
+	if !ok {
+		return "", fmt.Errorf("%w %s", ErrUnknownPeer, from)
+	}
+	plaintext, err := proto.Marshal(message)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal message: %w", err)
+	}
+	id := srv.generateID()
+	sent := 0
+	for _, device := range srv.devicesOf(to.User) {
+		if err = ctx.Err(); err != nil {
+			return id, err
+		}
+		conn := srv.Conn(device)
+		if conn == nil {
+			continue
+		}
+		enc, err := srv.encryptForDevice(peer, device, plaintext)
+		if err != nil {
+			return id, fmt.Errorf("failed to encrypt message for %s: %w", device, err)
+		}
+		err = conn.SendNode(waBinary.Node{
+			Tag: "message",
+			Attrs: waBinary.Attrs{
+				"id":   id,
+				"from": from,
+				"t":    time.Now().Unix(),
+				"type": "text",
+			},
+			Content: []waBinary.Node{*enc},
+		})
+		if err != nil {
+			return id, fmt.Errorf("failed to send message to %s: %w", device, err)
+		}
+		sent++
+	}
+	if sent == 0 {
+		return id, ErrNotConnected
+	}
+	return id, nil
+}
+
+func (srv *Server) encryptForDevice(peer *store.Device, to types.JID, plaintext []byte) (*waBinary.Node, error) {
+	srv.signalLock.Lock()
+	defer srv.signalLock.Unlock()
+	builder := session.NewBuilderFromSignal(peer, to.SignalAddress(), pbSerializer)
+	if !peer.ContainsSession(to.SignalAddress()) {
+		bundle, err := srv.getAccountBundle(to)
+		if err != nil {
+			return nil, err
+		}
+		err = builder.ProcessBundle(bundle)
+		if err != nil {
+			return nil, fmt.Errorf("failed to process prekey bundle: %w", err)
+		}
+	}
+	cipher := session.NewCipher(builder, to.SignalAddress())
+	ciphertext, err := cipher.Encrypt(padMessage(plaintext))
+	if err != nil {
+		return nil, fmt.Errorf("cipher encryption failed: %w", err)
+	}
+	encType := "msg"
+	if ciphertext.Type() == protocol.PREKEY_TYPE {
+		encType = "pkmsg"
+	}
+	return &waBinary.Node{
+		Tag:     "enc",
+		Attrs:   waBinary.Attrs{"v": "2", "type": encType},
+		Content: ciphertext.Serialize(),
+	}, nil
+}
+
+func (srv *Server) getAccountBundle(jid types.JID) (*prekey.Bundle, error) {
+	srv.lock.Lock()
+	defer srv.lock.Unlock()
+	acc, ok := srv.accounts[jid]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrDeviceNotRegistered, jid)
+	}
+	preKeyID := optional.NewEmptyUint32()
+	var preKeyPub ecc.ECPublicKeyable
+	if len(acc.PreKeys) > 0 {
+		var preKey *keys.PreKey
+		preKey, acc.PreKeys = acc.PreKeys[0], acc.PreKeys[1:]
+		preKeyID = optional.NewOptionalUint32(preKey.KeyID)
+		preKeyPub = ecc.NewDjbECPublicKey(*preKey.Pub)
+	}
+	return prekey.NewBundle(acc.RegistrationID, uint32(jid.Device),
+		preKeyID, acc.SignedPreKey.KeyID,
+		preKeyPub, ecc.NewDjbECPublicKey(*acc.SignedPreKey.Pub), *acc.SignedPreKey.Signature,
+		identity.NewKey(ecc.NewDjbECPublicKey(acc.IdentityKey))), nil
+}
+
+func unpadMessage(plaintext []byte) ([]byte, error) {
+	if len(plaintext) == 0 {
+		return nil, fmt.Errorf("plaintext is empty")
+	}
+	return plaintext[:len(plaintext)-int(plaintext[len(plaintext)-1])], nil
+}
+
+func padMessage(plaintext []byte) []byte {
+	pad := random.Bytes(1)
+	pad[0] &= 0xf
+	if pad[0] == 0 {
+		pad[0] = 0xf
+	}
+	return append(plaintext, bytes.Repeat(pad, int(pad[0]))...)
+}
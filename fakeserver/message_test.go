@@ -0,0 +1,50 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package fakeserver_test
+
+import (
+	"testing"
+
+	"google.golang.org/protobuf/proto"
+
+	waProto "github.com/go-whatsapp/whatsmeow/binary/proto"
+	"github.com/go-whatsapp/whatsmeow/types"
+	"github.com/go-whatsapp/whatsmeow/types/events"
+)
+
+func TestDirectMessages(t *testing.T) {
+	env := setup(t)
+	env.srv.AutoDeliveryReceipts = true
+
+	resp, err := env.cli.SendMessage(env.ctx, env.peer.ToNonAD(), &waProto.Message{Conversation: proto.String("hello")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg, err := env.srv.WaitForMessage(env.ctx)
+	if err != nil {
+		t.Fatal(err)
+	} else if msg.ID != resp.ID || msg.Message.GetConversation() != "hello" {
+		t.Fatalf("unexpected message %s: %v", msg.ID, msg.Message)
+	} else if msg.Sender != *env.cli.Store.JID || msg.Peer != env.peer {
+		t.Fatalf("unexpected sender %s or peer %s", msg.Sender, msg.Peer)
+	}
+	receipt := waitForEvent[*events.Receipt](t, env)
+	if receipt.Type != types.ReceiptTypeDelivered || len(receipt.MessageIDs) != 1 || receipt.MessageIDs[0] != resp.ID {
+		t.Fatalf("unexpected receipt %+v", receipt)
+	}
+
+	for _, text := range []string{"first reply", "second reply"} {
+		id, err := env.srv.SendMessage(env.ctx, env.peer, env.cli.Store.JID.ToNonAD(), &waProto.Message{Conversation: proto.String(text)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		evt := waitForEvent[*events.Message](t, env)
+		if evt.Info.ID != id || evt.Message.GetConversation() != text || evt.Info.Sender != env.peer {
+			t.Fatalf("unexpected message event %+v", evt)
+		}
+	}
+}
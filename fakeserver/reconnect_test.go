@@ -0,0 +1,140 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package fakeserver_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/go-whatsapp/whatsmeow"
+	"github.com/go-whatsapp/whatsmeow/socket"
+	"github.com/go-whatsapp/whatsmeow/types"
+	"github.com/go-whatsapp/whatsmeow/types/events"
+)
+
+func TestReconnect(t *testing.T) {
+	env := setup(t)
+	conn := env.srv.Conn(*env.cli.Store.JID)
+	if conn == nil {
+		t.Fatal("client isn't connected to fake server")
+	}
+	conn.Drop()
+	waitForEvent[*events.Disconnected](t, env)
+	waitForEvent[*events.Connected](t, env)
+	if newConn := env.srv.Conn(*env.cli.Store.JID); newConn == nil || newConn == conn {
+		t.Fatal("client didn't reconnect to fake server")
+	}
+}
+
+func TestConnectionState(t *testing.T) {
+	env := setup(t)
+	if state := env.cli.State(); state != types.ConnectionStateConnected {
+		t.Fatalf("expected state to be %s after connecting, got %s", types.ConnectionStateConnected, state)
+	}
+	// Skip the state changes from the initial connection
+	for {
+		if waitForEvent[*events.ConnectionState](t, env).State == types.ConnectionStateConnected {
+			break
+		}
+	}
+
+	env.srv.Conn(*env.cli.Store.JID).Drop()
+	expected := []types.ConnectionState{
+		types.ConnectionStateBackingOff,
+		types.ConnectionStateConnecting,
+		types.ConnectionStateHandshaking,
+		types.ConnectionStateAuthenticating,
+		types.ConnectionStateConnected,
+	}
+	for _, state := range expected {
+		evt := waitForEvent[*events.ConnectionState](t, env)
+		if evt.State != state {
+			t.Fatalf("expected state change to %s, got %s (from %s)", state, evt.State, evt.Previous)
+		}
+	}
+
+	env.srv.RemoveDevice(*env.cli.Store.JID)
+	// The server closes the connection right after the stream error, so the client may start
+	// backing off before it handles the error.
+	for {
+		evt := waitForEvent[*events.ConnectionState](t, env)
+		if evt.State == types.ConnectionStateLoggedOut {
+			break
+		} else if evt.State == types.ConnectionStateConnected {
+			t.Fatal("client reconnected after device was removed")
+		}
+	}
+	if state := env.cli.State(); !state.IsTerminal() {
+		t.Fatalf("expected terminal state after device removal, got %s", state)
+	}
+}
+
+func TestReconnectPolicy(t *testing.T) {
+	attempts := make(chan whatsmeow.ReconnectAttempt, 8)
+	transport := socket.NewPipeTransport()
+	t.Cleanup(transport.Close)
+	env := setupWithTransport(t, transport, transport, func(cli *whatsmeow.Client) {
+		cli.ReconnectPolicy = whatsmeow.ReconnectPolicyFunc(func(attempt whatsmeow.ReconnectAttempt) (time.Duration, bool) {
+			attempts <- attempt
+			return 10 * time.Millisecond, attempt.Reason != whatsmeow.ReconnectReasonStreamError
+		})
+	})
+	expectAttempt := func(reason whatsmeow.ReconnectReason) {
+		t.Helper()
+		select {
+		case attempt := <-attempts:
+			if attempt.Reason != reason || attempt.Attempt != 1 {
+				t.Fatalf("expected first attempt with reason %s, got #%d with %s", reason, attempt.Attempt, attempt.Reason)
+			}
+		case <-env.ctx.Done():
+			t.Fatal("timed out waiting for reconnect policy to be called")
+		}
+	}
+
+	env.srv.Conn(*env.cli.Store.JID).Drop()
+	expectAttempt(whatsmeow.ReconnectReasonConnectionLost)
+	waitForEvent[*events.Connected](t, env)
+
+	// The policy doesn't allow reconnecting after stream errors
+	if err := env.srv.Conn(*env.cli.Store.JID).SendStreamError("503"); err != nil {
+		t.Fatal(err)
+	}
+	expectAttempt(whatsmeow.ReconnectReasonStreamError)
+	for {
+		evt := waitForEvent[*events.ConnectionState](t, env)
+		if evt.State == types.ConnectionStateDisconnected {
+			break
+		} else if evt.State == types.ConnectionStateConnecting {
+			t.Fatal("client reconnected even though reconnect policy returned false")
+		}
+	}
+}
+
+func TestKeepAliveStats(t *testing.T) {
+	transport := socket.NewPipeTransport()
+	t.Cleanup(transport.Close)
+	env := setupWithTransport(t, transport, transport, func(cli *whatsmeow.Client) {
+		cli.KeepAlive = whatsmeow.KeepAliveConfig{
+			IntervalMin: 5 * time.Millisecond,
+			IntervalMax: 10 * time.Millisecond,
+		}
+	})
+	for {
+		stats := env.cli.KeepAliveStats()
+		if stats.Samples >= 3 {
+			if !stats.Healthy() || stats.MinRTT <= 0 || stats.MinRTT > stats.AverageRTT || stats.AverageRTT > stats.MaxRTT {
+				t.Fatalf("unexpected keepalive stats: %+v", stats)
+			}
+			break
+		}
+		select {
+		case <-time.After(10 * time.Millisecond):
+		case <-env.ctx.Done():
+			t.Fatal("timed out waiting for keepalive pings")
+		}
+	}
+}
@@ -0,0 +1,335 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+// Package fakeserver implements a minimal in-process imitation of the WhatsApp multidevice server.
+//
+// It speaks the same Noise handshake and binary XML protocol as the real server, which means a
+// normal whatsmeow Client can connect to it (usually through a socket.PipeTransport) and run
+// integration tests without network access. Only a small subset of the protocol is implemented:
+// logging in with pre-provisioned devices, prekeys, usync device lists, basic group info,
+// media_conn and sending/receiving end-to-end encrypted messages between clients and
+// simulated peers. Tests can override or add info query handlers with Server.HandleIQ.
+package fakeserver
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-whatsapp/go-util/random"
+
+	waBinary "github.com/go-whatsapp/whatsmeow/binary"
+	waProto "github.com/go-whatsapp/whatsmeow/binary/proto"
+	"github.com/go-whatsapp/whatsmeow/socket"
+	"github.com/go-whatsapp/whatsmeow/store"
+	"github.com/go-whatsapp/whatsmeow/types"
+	"github.com/go-whatsapp/whatsmeow/util/keys"
+	waLog "github.com/go-whatsapp/whatsmeow/util/log"
+)
+
+// Listener is the server side of a socket.Transport. socket.PipeTransport implements this interface.
+type Listener interface {
+	Accept(ctx context.Context) (socket.TransportConn, error)
+}
+
+var (
+	// ErrDeviceNotRegistered is returned when trying to send something to a device that hasn't been added with AddDevice.
+	ErrDeviceNotRegistered = errors.New("device is not registered on the fake server")
+	// ErrNotConnected is returned by Server.SendMessage if none of the target devices are currently connected.
+	ErrNotConnected = errors.New("no target devices are connected")
+	// ErrUnknownPeer is returned by Server.SendMessage if the sender wasn't added with AddPeer.
+	ErrUnknownPeer = errors.New("unknown peer")
+)
+
+// ReceivedMessage is a message that a client sent to a simulated peer, as decrypted by the peer.
+type ReceivedMessage struct {
+	ID      types.MessageID
+	Chat    types.JID
+	Sender  types.JID
+	Peer    types.JID
+	Message *waProto.Message
+	Raw     *waBinary.Node
+}
+
+// account is a real client device whose keys are known to the server.
+type account struct {
+	JID            types.JID
+	NoiseKey       [32]byte
+	IdentityKey    [32]byte
+	RegistrationID uint32
+	SignedPreKey   *keys.PreKey
+	PreKeys        []*keys.PreKey
+}
+
+// Server is a fake WhatsApp server. Create one with New and start it with Serve.
+type Server struct {
+	Log waLog.Logger
+	// StaticKey is the Noise static key of the server. The client doesn't verify it, so a random key is fine.
+	StaticKey *keys.KeyPair
+	// AutoDeliveryReceipts makes simulated peers send delivery receipts for messages they receive from clients.
+	AutoDeliveryReceipts bool
+	// MediaHosts is the list of hostnames returned in media_conn responses.
+	MediaHosts []string
+
+	lock       sync.RWMutex
+	accounts   map[types.JID]*account
+	peers      map[types.JID]*store.Device
+	groups     map[types.JID]*types.GroupInfo
+	conns      map[types.JID]*Conn
+	iqHandlers map[string]IQHandler
+	media      map[string][]byte
+	// mediaAuth contains the valid auth tokens for media uploads
+	mediaAuth       map[string]struct{}
+	mediaHostStatus map[string]int
+	// mediaInterruptions is the number of downloads to cut off after mediaInterruptAfter bytes
+	mediaInterruptions  int
+	mediaInterruptAfter int
+	mediaIgnoreRange    bool
+	// mediaUploadResets is the number of uploads to each host to fail with a connection reset
+	mediaUploadResets map[string]int
+
+	mediaRetryHandler MediaRetryHandler
+	// signalLock protects the signal stores of peers, which may be used from multiple connections at once.
+	signalLock sync.Mutex
+
+	newConns    chan *Conn
+	messages    chan *ReceivedMessage
+	connCounter uint32
+}
+
+// New creates a new fake server with the default info query handlers.
+func New(log waLog.Logger) *Server {
+	if log == nil {
+		log = waLog.Noop
+	}
+	srv := &Server{
+		Log:        log,
+		StaticKey:  keys.NewKeyPair(),
+		MediaHosts: []string{"mmg.whatsapp.net"},
+
+		accounts:   make(map[types.JID]*account),
+		peers:      make(map[types.JID]*store.Device),
+		groups:     make(map[types.JID]*types.GroupInfo),
+		conns:      make(map[types.JID]*Conn),
+		iqHandlers: make(map[string]IQHandler),
+		media:      make(map[string][]byte),
+
+		mediaAuth:         make(map[string]struct{}),
+		mediaHostStatus:   make(map[string]int),
+		mediaUploadResets: make(map[string]int),
+
+		newConns: make(chan *Conn, 16),
+		messages: make(chan *ReceivedMessage, 64),
+	}
+	srv.HandleIQ("w:p", handlePing)
+	srv.HandleIQ("passive", handlePassive)
+	srv.HandleIQ("encrypt", srv.handleEncryptIQ)
+	srv.HandleIQ("usync", srv.handleUsyncIQ)
+	srv.HandleIQ("w:g2", srv.handleGroupIQ)
+	srv.HandleIQ("w:m", srv.handleMediaConnIQ)
+	return srv
+}
+
+// Serve accepts connections from the given listener until the context is cancelled or Accept returns an error.
+func (srv *Server) Serve(ctx context.Context, listener Listener) error {
+	for {
+		raw, err := listener.Accept(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		connCtx, cancel := context.WithCancel(ctx)
+		conn := &Conn{
+			srv:  srv,
+			log:  srv.Log.Sub(fmt.Sprintf("Conn-%d", atomic.AddUint32(&srv.connCounter, 1))),
+			raw:  raw,
+			ctx:  connCtx,
+			stop: cancel,
+		}
+		conn.frames = newFrameReader(connCtx, raw)
+		go conn.serve()
+	}
+}
+
+// AddDevice registers a client device, so that it can log in to the server.
+//
+// The device must have a JID and the keys generated by the store container.
+// Prekeys are uploaded by the client itself after connecting.
+func (srv *Server) AddDevice(device *store.Device) error {
+	if device.JID == nil || device.NoiseKey == nil || device.IdentityKey == nil || device.SignedPreKey == nil {
+		return fmt.Errorf("device must have a JID and keys")
+	}
+	srv.lock.Lock()
+	srv.accounts[*device.JID] = &account{
+		JID:            *device.JID,
+		NoiseKey:       *device.NoiseKey.Pub,
+		IdentityKey:    *device.IdentityKey.Pub,
+		RegistrationID: device.RegistrationID,
+		SignedPreKey: &keys.PreKey{
+			KeyPair:   keys.KeyPair{Pub: device.SignedPreKey.Pub},
+			KeyID:     device.SignedPreKey.KeyID,
+			Signature: device.SignedPreKey.Signature,
+		},
+	}
+	srv.lock.Unlock()
+	return nil
+}
+
+// RemoveDevice unregisters a client device. If the device is connected, it will receive a device_removed stream error.
+func (srv *Server) RemoveDevice(jid types.JID) {
+	srv.lock.Lock()
+	delete(srv.accounts, jid)
+	conn := srv.conns[jid]
+	srv.lock.Unlock()
+	if conn != nil {
+		_ = conn.SendStreamError("401", waBinary.Node{Tag: "conflict", Attrs: waBinary.Attrs{"type": "device_removed"}})
+	}
+}
+
+// AddPeer registers a simulated peer device. The server uses the given device store to encrypt
+// and decrypt messages on behalf of the peer, so it must be fully functional (including prekeys).
+func (srv *Server) AddPeer(device *store.Device) error {
+	if device.JID == nil || device.IdentityKey == nil || device.SignedPreKey == nil {
+		return fmt.Errorf("peer must have a JID and keys")
+	}
+	srv.lock.Lock()
+	srv.peers[*device.JID] = device
+	srv.lock.Unlock()
+	return nil
+}
+
+// AddGroup adds or replaces a group. Only the JID, name, creation info and participants are used.
+func (srv *Server) AddGroup(group *types.GroupInfo) {
+	srv.lock.Lock()
+	srv.groups[group.JID] = group
+	srv.lock.Unlock()
+}
+
+// HandleIQ sets the handler for info queries with the given namespace (xmlns), replacing the previous one.
+// Setting a nil handler makes the server respond to the namespace with a 501 error.
+func (srv *Server) HandleIQ(xmlns string, handler IQHandler) {
+	srv.lock.Lock()
+	if handler == nil {
+		delete(srv.iqHandlers, xmlns)
+	} else {
+		srv.iqHandlers[xmlns] = handler
+	}
+	srv.lock.Unlock()
+}
+
+// Conn returns the current connection of the given device, or nil if it's not connected.
+func (srv *Server) Conn(jid types.JID) *Conn {
+	srv.lock.RLock()
+	defer srv.lock.RUnlock()
+	return srv.conns[jid]
+}
+
+// WaitForConn waits until a client successfully logs in and returns the connection.
+func (srv *Server) WaitForConn(ctx context.Context) (*Conn, error) {
+	select {
+	case conn := <-srv.newConns:
+		return conn, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// WaitForMessage waits until a client sends a message to a simulated peer and returns the decrypted message.
+func (srv *Server) WaitForMessage(ctx context.Context) (*ReceivedMessage, error) {
+	select {
+	case msg := <-srv.messages:
+		return msg, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (srv *Server) generateID() string {
+	return strings.ToUpper(hex.EncodeToString(random.Bytes(8)))
+}
+
+func (srv *Server) removeConn(conn *Conn) {
+	srv.lock.Lock()
+	if srv.conns[conn.JID] == conn {
+		delete(srv.conns, conn.JID)
+	}
+	srv.lock.Unlock()
+}
+
+func (srv *Server) login(conn *Conn, payload *waProto.ClientPayload) bool {
+	if payload.Username == nil {
+		conn.log.Warnf("Client tried to register a new device, which isn't supported")
+		return false
+	}
+	jid := types.NewADJID(strconv.FormatUint(payload.GetUsername(), 10), 0, uint8(payload.GetDevice()))
+	srv.lock.Lock()
+	acc, ok := srv.accounts[jid]
+	if !ok || acc.NoiseKey != conn.clientStatic {
+		srv.lock.Unlock()
+		conn.log.Debugf("Rejecting login from %s (registered: %t)", jid, ok)
+		_ = conn.SendNode(waBinary.Node{Tag: "failure", Attrs: waBinary.Attrs{"reason": 401}})
+		return false
+	}
+	conn.JID = jid
+	prevConn := srv.conns[jid]
+	srv.conns[jid] = conn
+	srv.lock.Unlock()
+	if prevConn != nil {
+		_ = prevConn.SendStreamError("conflict", waBinary.Node{Tag: "conflict", Attrs: waBinary.Attrs{"type": "replaced"}})
+	}
+	conn.log.Debugf("%s logged in", jid)
+	err := conn.SendNode(waBinary.Node{Tag: "success", Attrs: waBinary.Attrs{"t": time.Now().Unix()}})
+	if err != nil {
+		conn.log.Warnf("Failed to send success node: %v", err)
+		return false
+	}
+	select {
+	case srv.newConns <- conn:
+	default:
+	}
+	return true
+}
+
+func (srv *Server) handleNode(conn *Conn, node *waBinary.Node) {
+	switch node.Tag {
+	case "iq":
+		srv.handleIQ(conn, node)
+	case "message":
+		srv.handleMessage(conn, node)
+	case "receipt":
+		srv.handleReceipt(conn, node)
+	case "ack", "presence", "chatstate":
+		// Nothing to do
+	default:
+		conn.log.Debugf("Ignoring unsupported node %s", node.Tag)
+	}
+}
+
+// devicesOf returns all known devices (both clients and peers) of the given user.
+func (srv *Server) devicesOf(user string) []types.JID {
+	srv.lock.RLock()
+	defer srv.lock.RUnlock()
+	var devices []types.JID
+	for jid := range srv.accounts {
+		if jid.User == user {
+			devices = append(devices, jid)
+		}
+	}
+	for jid := range srv.peers {
+		if jid.User == user {
+			devices = append(devices, jid)
+		}
+	}
+	return devices
+}
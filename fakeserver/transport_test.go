@@ -0,0 +1,84 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package fakeserver_test
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+
+	"github.com/go-whatsapp/whatsmeow"
+	waProto "github.com/go-whatsapp/whatsmeow/binary/proto"
+	"github.com/go-whatsapp/whatsmeow/socket"
+	"github.com/go-whatsapp/whatsmeow/types/events"
+	waLog "github.com/go-whatsapp/whatsmeow/util/log"
+)
+
+func TestTCPTransport(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = ln.Close() })
+	env := setupWithTransport(t, socket.NewTCPTransport(waLog.Noop, ln.Addr().String()), &socket.TCPListener{Listener: ln}, nil)
+	_, err = env.cli.SendMessage(env.ctx, env.peer.ToNonAD(), &waProto.Message{Conversation: proto.String("over tcp")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg, err := env.srv.WaitForMessage(env.ctx)
+	if err != nil {
+		t.Fatal(err)
+	} else if msg.Message.GetConversation() != "over tcp" {
+		t.Fatalf("unexpected message %v", msg.Message)
+	}
+}
+
+func TestFrameRecording(t *testing.T) {
+	var recording bytes.Buffer
+	transport := socket.NewPipeTransport()
+	t.Cleanup(transport.Close)
+	env := setupWithTransport(t, transport, transport, func(cli *whatsmeow.Client) {
+		cli.FrameRecorder = whatsmeow.NewFrameRecorder(&recording)
+	})
+	env.srv.AutoDeliveryReceipts = true
+	resp, err := env.cli.SendMessage(env.ctx, env.peer.ToNonAD(), &waProto.Message{Conversation: proto.String("hello")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitForEvent[*events.Receipt](t, env)
+	env.cli.Disconnect()
+	// Acks for the receipt may still be sent asynchronously, so stop recording before reading the buffer
+	if err = env.cli.FrameRecorder.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	replayEvents := make(chan interface{}, 64)
+	replayCli := whatsmeow.NewClient(env.cli.Store, waLog.Noop)
+	replayCli.AddEventHandler(func(evt interface{}) {
+		replayEvents <- evt
+	})
+	err = replayCli.ReplayFrames(env.ctx, &recording)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for {
+		select {
+		case evt := <-replayEvents:
+			if receipt, ok := evt.(*events.Receipt); ok {
+				if receipt.MessageIDs[0] != resp.ID {
+					t.Fatalf("unexpected replayed receipt %+v", receipt)
+				}
+				return
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("replaying didn't dispatch receipt event")
+		}
+	}
+}
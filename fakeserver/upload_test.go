@@ -0,0 +1,145 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package fakeserver_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"testing"
+
+	"github.com/go-whatsapp/go-util/random"
+
+	"github.com/go-whatsapp/whatsmeow"
+)
+
+func TestUploadReader(t *testing.T) {
+	env := setupMedia(t)
+	plaintext := random.Bytes(100*1024 + 3)
+	readers := map[string]io.Reader{
+		"seekable":     bytes.NewReader(plaintext),
+		"non-seekable": struct{ io.Reader }{bytes.NewReader(plaintext)},
+	}
+	for name, reader := range readers {
+		resp, err := env.cli.UploadReader(env.ctx, reader, int64(len(plaintext)), whatsmeow.MediaDocument)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		data, err := env.cli.Download(documentMessage(resp))
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		} else if !bytes.Equal(data, plaintext) {
+			t.Fatalf("%s: downloaded data doesn't match uploaded data", name)
+		}
+	}
+
+	_, err := env.cli.UploadReader(env.ctx, bytes.NewReader(plaintext), int64(len(plaintext))+1, whatsmeow.MediaDocument)
+	if !errors.Is(err, whatsmeow.ErrFileLengthMismatch) {
+		t.Fatalf("expected file length mismatch error, got %v", err)
+	}
+}
+
+func TestMediaFailover(t *testing.T) {
+	env := setupMedia(t)
+	env.srv.MediaHosts = []string{"mmg1.whatsapp.net", "mmg2.whatsapp.net"}
+	env.srv.SetMediaHostStatus("mmg1.whatsapp.net", http.StatusServiceUnavailable)
+
+	plaintext := random.Bytes(64 * 1024)
+	var uploaded, uploadTotal int64
+	resp, err := env.cli.Upload(env.ctx, plaintext, whatsmeow.MediaDocument, whatsmeow.UploadExtra{
+		Progress: func(transferred, total int64) {
+			uploaded, uploadTotal = transferred, total
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	} else if uploaded != uploadTotal || uploadTotal <= int64(len(plaintext)) {
+		t.Fatalf("unexpected upload progress %d/%d", uploaded, uploadTotal)
+	}
+
+	_, err = env.cli.UploadReader(env.ctx, bytes.NewReader(plaintext), -1, whatsmeow.MediaDocument)
+	if err != nil {
+		t.Fatalf("streaming upload failed: %v", err)
+	}
+
+	env.srv.ExpireMediaAuth()
+	_, err = env.cli.Upload(env.ctx, plaintext, whatsmeow.MediaDocument)
+	if err != nil {
+		t.Fatalf("upload with expired auth failed: %v", err)
+	}
+
+	// Plain internal server errors and connections that break while sending the body should also fail over
+	env.srv.SetMediaHostStatus("mmg1.whatsapp.net", http.StatusInternalServerError)
+	if _, err = env.cli.Upload(env.ctx, random.Bytes(1024), whatsmeow.MediaDocument); err != nil {
+		t.Fatalf("upload with internal server error on first host failed: %v", err)
+	}
+	env.srv.SetMediaHostStatus("mmg1.whatsapp.net", 0)
+	env.srv.ResetMediaUploads("mmg1.whatsapp.net", 2)
+	if _, err = env.cli.Upload(env.ctx, random.Bytes(64*1024), whatsmeow.MediaDocument); err != nil {
+		t.Fatalf("upload with connection reset on first host failed: %v", err)
+	} else if _, err = env.cli.UploadReader(env.ctx, bytes.NewReader(random.Bytes(64*1024)), -1, whatsmeow.MediaDocument); err != nil {
+		t.Fatalf("streaming upload with connection reset on first host failed: %v", err)
+	}
+	env.srv.ResetMediaUploads("mmg1.whatsapp.net", 1)
+	env.srv.SetMediaHostStatus("mmg2.whatsapp.net", http.StatusBadRequest)
+	if _, err = env.cli.Upload(env.ctx, random.Bytes(1024), whatsmeow.MediaDocument); err == nil {
+		t.Fatal("upload succeeded even though all hosts failed")
+	}
+	env.srv.SetMediaHostStatus("mmg2.whatsapp.net", 0)
+
+	env.srv.SetMediaHostStatus("mmg1.whatsapp.net", 0)
+	var downloaded, downloadTotal int64
+	data, err := env.cli.DownloadContext(env.ctx, documentMessage(resp), whatsmeow.DownloadExtra{
+		Progress: func(transferred, total int64) {
+			downloaded, downloadTotal = transferred, total
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(data, plaintext) {
+		t.Fatal("downloaded data doesn't match uploaded data")
+	} else if downloaded != uploadTotal || downloadTotal != uploadTotal {
+		t.Fatalf("unexpected download progress %d/%d", downloaded, downloadTotal)
+	}
+}
+
+func TestStreamingSidecar(t *testing.T) {
+	env := setupMedia(t)
+	plaintext := random.Bytes(200 * 1024)
+	videoMsg, err := env.cli.BuildVideoMessage(env.ctx, plaintext, whatsmeow.MediaMessageExtra{MimeType: "video/mp4"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The encrypted file is 200 KiB + padding + HMAC, so there should be 4 chunks
+	if len(videoMsg.StreamingSidecar) != 4*10 {
+		t.Fatalf("unexpected sidecar length %d", len(videoMsg.StreamingSidecar))
+	}
+	readerResp, err := env.cli.UploadReader(env.ctx, io.MultiReader(bytes.NewReader(plaintext)), int64(len(plaintext)), whatsmeow.MediaVideo)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(readerResp.StreamingSidecar) != 4*10 {
+		t.Fatalf("unexpected sidecar length %d from UploadReader", len(readerResp.StreamingSidecar))
+	}
+
+	var buf bytes.Buffer
+	if err = env.cli.DownloadToWriter(env.ctx, videoMsg, &buf); err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(buf.Bytes(), plaintext) {
+		t.Fatal("downloaded data doesn't match uploaded data")
+	}
+
+	// Corrupting the second chunk's HMAC should stop the download before anything past the first chunk is written
+	videoMsg.StreamingSidecar[10] ^= 0xff
+	buf.Reset()
+	err = env.cli.DownloadToWriter(env.ctx, videoMsg, &buf)
+	if !errors.Is(err, whatsmeow.ErrInvalidStreamingSidecar) {
+		t.Fatalf("expected ErrInvalidStreamingSidecar, got %v", err)
+	} else if buf.Len() > 64*1024 {
+		t.Fatalf("%d bytes were written before sidecar verification failed", buf.Len())
+	}
+}
@@ -4,13 +4,20 @@ go 1.20
 
 require (
 	github.com/cristalhq/base64 v0.1.2
+	github.com/go-sql-driver/mysql v1.7.1
 	github.com/go-whatsapp/go-util v0.1.0
 	github.com/goccy/go-json v0.10.2
 	github.com/gorilla/websocket v1.5.0
+	github.com/mattn/go-sqlite3 v1.14.18
 	github.com/puzpuzpuz/xsync/v3 v3.0.2
 	go.mau.fi/libsignal v0.1.0
 	golang.org/x/crypto v0.15.0
+	golang.org/x/image v0.18.0
+	golang.org/x/net v0.18.0
 	google.golang.org/protobuf v1.31.0
 )
 
-require filippo.io/edwards25519 v1.0.0 // indirect
+require (
+	filippo.io/edwards25519 v1.0.0 // indirect
+	golang.org/x/sys v0.14.0 // indirect
+)
@@ -49,6 +49,11 @@ type ReqCreateGroup struct {
 //
 // See ReqCreateGroup for parameters.
 func (cli *Client) CreateGroup(req ReqCreateGroup) (*types.GroupInfo, error) {
+	return cli.CreateGroupContext(context.Background(), req)
+}
+
+// CreateGroupContext is like CreateGroup, but takes a context for cancellation and deadlines.
+func (cli *Client) CreateGroupContext(ctx context.Context, req ReqCreateGroup) (*types.GroupInfo, error) {
 	participantNodes := make([]waBinary.Node, len(req.Participants), len(req.Participants)+1)
 	for i, participant := range req.Participants {
 		participantNodes[i] = waBinary.Node{
@@ -77,7 +82,7 @@ func (cli *Client) CreateGroup(req ReqCreateGroup) (*types.GroupInfo, error) {
 	}
 	// WhatsApp web doesn't seem to include the static prefix for these
 	key := strings.TrimPrefix(req.CreateKey, "3EB0")
-	resp, err := cli.sendGroupIQ(context.TODO(), iqSet, types.GroupServerJID, waBinary.Node{
+	resp, err := cli.sendGroupIQ(ctx, iqSet, types.GroupServerJID, waBinary.Node{
 		Tag: "create",
 		Attrs: waBinary.Attrs{
 			"subject": req.Name,
@@ -97,7 +102,12 @@ func (cli *Client) CreateGroup(req ReqCreateGroup) (*types.GroupInfo, error) {
 
 // UnlinkGroup removes a child group from a parent community.
 func (cli *Client) UnlinkGroup(parent, child types.JID) error {
-	_, err := cli.sendGroupIQ(context.TODO(), iqSet, parent, waBinary.Node{
+	return cli.UnlinkGroupContext(context.Background(), parent, child)
+}
+
+// UnlinkGroupContext is like UnlinkGroup, but takes a context for cancellation and deadlines.
+func (cli *Client) UnlinkGroupContext(ctx context.Context, parent, child types.JID) error {
+	_, err := cli.sendGroupIQ(ctx, iqSet, parent, waBinary.Node{
 		Tag:   "unlink",
 		Attrs: waBinary.Attrs{"unlink_type": string(types.GroupLinkChangeTypeSub)},
 		Content: []waBinary.Node{{
@@ -112,7 +122,12 @@ func (cli *Client) UnlinkGroup(parent, child types.JID) error {
 //
 // To create a new group within a community, set LinkedParentJID in the CreateGroup request.
 func (cli *Client) LinkGroup(parent, child types.JID) error {
-	_, err := cli.sendGroupIQ(context.TODO(), iqSet, parent, waBinary.Node{
+	return cli.LinkGroupContext(context.Background(), parent, child)
+}
+
+// LinkGroupContext is like LinkGroup, but takes a context for cancellation and deadlines.
+func (cli *Client) LinkGroupContext(ctx context.Context, parent, child types.JID) error {
+	_, err := cli.sendGroupIQ(ctx, iqSet, parent, waBinary.Node{
 		Tag: "links",
 		Content: []waBinary.Node{{
 			Tag:   "link",
@@ -128,7 +143,12 @@ func (cli *Client) LinkGroup(parent, child types.JID) error {
 
 // LeaveGroup leaves the specified group on WhatsApp.
 func (cli *Client) LeaveGroup(jid types.JID) error {
-	_, err := cli.sendGroupIQ(context.TODO(), iqSet, types.GroupServerJID, waBinary.Node{
+	return cli.LeaveGroupContext(context.Background(), jid)
+}
+
+// LeaveGroupContext is like LeaveGroup, but takes a context for cancellation and deadlines.
+func (cli *Client) LeaveGroupContext(ctx context.Context, jid types.JID) error {
+	_, err := cli.sendGroupIQ(ctx, iqSet, types.GroupServerJID, waBinary.Node{
 		Tag: "leave",
 		Content: []waBinary.Node{{
 			Tag:   "group",
@@ -149,6 +169,11 @@ const (
 
 // UpdateGroupParticipants can be used to add, remove, promote and demote members in a WhatsApp group.
 func (cli *Client) UpdateGroupParticipants(jid types.JID, participantChanges []types.JID, action ParticipantChange) ([]types.GroupParticipant, error) {
+	return cli.UpdateGroupParticipantsContext(context.Background(), jid, participantChanges, action)
+}
+
+// UpdateGroupParticipantsContext is like UpdateGroupParticipants, but takes a context for cancellation and deadlines.
+func (cli *Client) UpdateGroupParticipantsContext(ctx context.Context, jid types.JID, participantChanges []types.JID, action ParticipantChange) ([]types.GroupParticipant, error) {
 	content := make([]waBinary.Node, len(participantChanges))
 	for i, participantJID := range participantChanges {
 		content[i] = waBinary.Node{
@@ -156,7 +181,7 @@ func (cli *Client) UpdateGroupParticipants(jid types.JID, participantChanges []t
 			Attrs: waBinary.Attrs{"jid": participantJID},
 		}
 	}
-	resp, err := cli.sendGroupIQ(context.TODO(), iqSet, jid, waBinary.Node{
+	resp, err := cli.sendGroupIQ(ctx, iqSet, jid, waBinary.Node{
 		Tag:     string(action),
 		Content: content,
 	})
@@ -177,7 +202,12 @@ func (cli *Client) UpdateGroupParticipants(jid types.JID, participantChanges []t
 
 // GetGroupRequestParticipants gets the list of participants that have requested to join the group.
 func (cli *Client) GetGroupRequestParticipants(jid types.JID) ([]types.JID, error) {
-	resp, err := cli.sendGroupIQ(context.TODO(), iqGet, jid, waBinary.Node{
+	return cli.GetGroupRequestParticipantsContext(context.Background(), jid)
+}
+
+// GetGroupRequestParticipantsContext is like GetGroupRequestParticipants, but takes a context for cancellation and deadlines.
+func (cli *Client) GetGroupRequestParticipantsContext(ctx context.Context, jid types.JID) ([]types.JID, error) {
+	resp, err := cli.sendGroupIQ(ctx, iqGet, jid, waBinary.Node{
 		Tag: "membership_approval_requests",
 	})
 	if err != nil {
@@ -204,6 +234,11 @@ const (
 
 // UpdateGroupRequestParticipants can be used to approve or reject requests to join the group.
 func (cli *Client) UpdateGroupRequestParticipants(jid types.JID, participantChanges []types.JID, action ParticipantRequestChange) ([]types.GroupParticipant, error) {
+	return cli.UpdateGroupRequestParticipantsContext(context.Background(), jid, participantChanges, action)
+}
+
+// UpdateGroupRequestParticipantsContext is like UpdateGroupRequestParticipants, but takes a context for cancellation and deadlines.
+func (cli *Client) UpdateGroupRequestParticipantsContext(ctx context.Context, jid types.JID, participantChanges []types.JID, action ParticipantRequestChange) ([]types.GroupParticipant, error) {
 	content := make([]waBinary.Node, len(participantChanges))
 	for i, participantJID := range participantChanges {
 		content[i] = waBinary.Node{
@@ -211,7 +246,7 @@ func (cli *Client) UpdateGroupRequestParticipants(jid types.JID, participantChan
 			Attrs: waBinary.Attrs{"jid": participantJID},
 		}
 	}
-	resp, err := cli.sendGroupIQ(context.TODO(), iqSet, jid, waBinary.Node{
+	resp, err := cli.sendGroupIQ(ctx, iqSet, jid, waBinary.Node{
 		Tag: "membership_requests_action",
 		Content: []waBinary.Node{{
 			Tag:     string(action),
@@ -241,6 +276,11 @@ func (cli *Client) UpdateGroupRequestParticipants(jid types.JID, participantChan
 // The avatar should be a JPEG photo, other formats may be rejected with ErrInvalidImageFormat.
 // The bytes can be nil to remove the photo. Returns the new picture ID.
 func (cli *Client) SetGroupPhoto(jid types.JID, avatar []byte) (string, error) {
+	return cli.SetGroupPhotoContext(context.Background(), jid, avatar)
+}
+
+// SetGroupPhotoContext is like SetGroupPhoto, but takes a context for cancellation and deadlines.
+func (cli *Client) SetGroupPhotoContext(ctx context.Context, jid types.JID, avatar []byte) (string, error) {
 	var content interface{}
 	if avatar != nil {
 		content = []waBinary.Node{{
@@ -250,6 +290,7 @@ func (cli *Client) SetGroupPhoto(jid types.JID, avatar []byte) (string, error) {
 		}}
 	}
 	resp, err := cli.sendIQ(infoQuery{
+		Context:   ctx,
 		Namespace: "w:profile:picture",
 		Type:      iqSet,
 		To:        types.ServerJID,
@@ -273,7 +314,12 @@ func (cli *Client) SetGroupPhoto(jid types.JID, avatar []byte) (string, error) {
 
 // SetGroupName updates the name (subject) of the given group on WhatsApp.
 func (cli *Client) SetGroupName(jid types.JID, name string) error {
-	_, err := cli.sendGroupIQ(context.TODO(), iqSet, jid, waBinary.Node{
+	return cli.SetGroupNameContext(context.Background(), jid, name)
+}
+
+// SetGroupNameContext is like SetGroupName, but takes a context for cancellation and deadlines.
+func (cli *Client) SetGroupNameContext(ctx context.Context, jid types.JID, name string) error {
+	_, err := cli.sendGroupIQ(ctx, iqSet, jid, waBinary.Node{
 		Tag:     "subject",
 		Content: []byte(name),
 	})
@@ -286,8 +332,13 @@ func (cli *Client) SetGroupName(jid types.JID, name string) error {
 // automatically fetch the current group info to find the previous topic ID. If the new ID is not
 // specified, one will be generated with Client.GenerateMessageID().
 func (cli *Client) SetGroupTopic(jid types.JID, previousID, newID, topic string) error {
+	return cli.SetGroupTopicContext(context.Background(), jid, previousID, newID, topic)
+}
+
+// SetGroupTopicContext is like SetGroupTopic, but takes a context for cancellation and deadlines.
+func (cli *Client) SetGroupTopicContext(ctx context.Context, jid types.JID, previousID, newID, topic string) error {
 	if previousID == "" {
-		oldInfo, err := cli.GetGroupInfo(jid)
+		oldInfo, err := cli.GetGroupInfoContext(ctx, jid)
 		if err != nil {
 			return fmt.Errorf("failed to get old group info to update topic: %v", err)
 		}
@@ -310,7 +361,7 @@ func (cli *Client) SetGroupTopic(jid types.JID, previousID, newID, topic string)
 		attrs["delete"] = "true"
 		content = nil
 	}
-	_, err := cli.sendGroupIQ(context.TODO(), iqSet, jid, waBinary.Node{
+	_, err := cli.sendGroupIQ(ctx, iqSet, jid, waBinary.Node{
 		Tag:     "description",
 		Attrs:   attrs,
 		Content: content,
@@ -320,21 +371,31 @@ func (cli *Client) SetGroupTopic(jid types.JID, previousID, newID, topic string)
 
 // SetGroupLocked changes whether the group is locked (i.e. whether only admins can modify group info).
 func (cli *Client) SetGroupLocked(jid types.JID, locked bool) error {
+	return cli.SetGroupLockedContext(context.Background(), jid, locked)
+}
+
+// SetGroupLockedContext is like SetGroupLocked, but takes a context for cancellation and deadlines.
+func (cli *Client) SetGroupLockedContext(ctx context.Context, jid types.JID, locked bool) error {
 	tag := "locked"
 	if !locked {
 		tag = "unlocked"
 	}
-	_, err := cli.sendGroupIQ(context.TODO(), iqSet, jid, waBinary.Node{Tag: tag})
+	_, err := cli.sendGroupIQ(ctx, iqSet, jid, waBinary.Node{Tag: tag})
 	return err
 }
 
 // SetGroupAnnounce changes whether the group is in announce mode (i.e. whether only admins can send messages).
 func (cli *Client) SetGroupAnnounce(jid types.JID, announce bool) error {
+	return cli.SetGroupAnnounceContext(context.Background(), jid, announce)
+}
+
+// SetGroupAnnounceContext is like SetGroupAnnounce, but takes a context for cancellation and deadlines.
+func (cli *Client) SetGroupAnnounceContext(ctx context.Context, jid types.JID, announce bool) error {
 	tag := "announcement"
 	if !announce {
 		tag = "not_announcement"
 	}
-	_, err := cli.sendGroupIQ(context.TODO(), iqSet, jid, waBinary.Node{Tag: tag})
+	_, err := cli.sendGroupIQ(ctx, iqSet, jid, waBinary.Node{Tag: tag})
 	return err
 }
 
@@ -342,11 +403,16 @@ func (cli *Client) SetGroupAnnounce(jid types.JID, announce bool) error {
 //
 // If reset is true, then the old invite link will be revoked and a new one generated.
 func (cli *Client) GetGroupInviteLink(jid types.JID, reset bool) (string, error) {
+	return cli.GetGroupInviteLinkContext(context.Background(), jid, reset)
+}
+
+// GetGroupInviteLinkContext is like GetGroupInviteLink, but takes a context for cancellation and deadlines.
+func (cli *Client) GetGroupInviteLinkContext(ctx context.Context, jid types.JID, reset bool) (string, error) {
 	iqType := iqGet
 	if reset {
 		iqType = iqSet
 	}
-	resp, err := cli.sendGroupIQ(context.TODO(), iqType, jid, waBinary.Node{Tag: "invite"})
+	resp, err := cli.sendGroupIQ(ctx, iqType, jid, waBinary.Node{Tag: "invite"})
 	if errors.Is(err, ErrIQNotAuthorized) {
 		return "", wrapIQError(ErrGroupInviteLinkUnauthorized, err)
 	} else if errors.Is(err, ErrIQNotFound) {
@@ -367,7 +433,12 @@ func (cli *Client) GetGroupInviteLink(jid types.JID, reset bool) (string, error)
 //
 // Note that this is specifically for invite messages, not invite links. Use GetGroupInfoFromLink for resolving chat.whatsapp.com links.
 func (cli *Client) GetGroupInfoFromInvite(jid, inviter types.JID, code string, expiration int64) (*types.GroupInfo, error) {
-	resp, err := cli.sendGroupIQ(context.TODO(), iqGet, jid, waBinary.Node{
+	return cli.GetGroupInfoFromInviteContext(context.Background(), jid, inviter, code, expiration)
+}
+
+// GetGroupInfoFromInviteContext is like GetGroupInfoFromInvite, but takes a context for cancellation and deadlines.
+func (cli *Client) GetGroupInfoFromInviteContext(ctx context.Context, jid, inviter types.JID, code string, expiration int64) (*types.GroupInfo, error) {
+	resp, err := cli.sendGroupIQ(ctx, iqGet, jid, waBinary.Node{
 		Tag: "query",
 		Content: []waBinary.Node{{
 			Tag: "add_request",
@@ -392,7 +463,12 @@ func (cli *Client) GetGroupInfoFromInvite(jid, inviter types.JID, code string, e
 //
 // Note that this is specifically for invite messages, not invite links. Use JoinGroupWithLink for joining with chat.whatsapp.com links.
 func (cli *Client) JoinGroupWithInvite(jid, inviter types.JID, code string, expiration int64) error {
-	_, err := cli.sendGroupIQ(context.TODO(), iqSet, jid, waBinary.Node{
+	return cli.JoinGroupWithInviteContext(context.Background(), jid, inviter, code, expiration)
+}
+
+// JoinGroupWithInviteContext is like JoinGroupWithInvite, but takes a context for cancellation and deadlines.
+func (cli *Client) JoinGroupWithInviteContext(ctx context.Context, jid, inviter types.JID, code string, expiration int64) error {
+	_, err := cli.sendGroupIQ(ctx, iqSet, jid, waBinary.Node{
 		Tag: "accept",
 		Attrs: waBinary.Attrs{
 			"code":       code,
@@ -406,8 +482,13 @@ func (cli *Client) JoinGroupWithInvite(jid, inviter types.JID, code string, expi
 // GetGroupInfoFromLink resolves the given invite link and asks the WhatsApp servers for info about the group.
 // This will not cause the user to join the group.
 func (cli *Client) GetGroupInfoFromLink(code string) (*types.GroupInfo, error) {
+	return cli.GetGroupInfoFromLinkContext(context.Background(), code)
+}
+
+// GetGroupInfoFromLinkContext is like GetGroupInfoFromLink, but takes a context for cancellation and deadlines.
+func (cli *Client) GetGroupInfoFromLinkContext(ctx context.Context, code string) (*types.GroupInfo, error) {
 	code = strings.TrimPrefix(code, InviteLinkPrefix)
-	resp, err := cli.sendGroupIQ(context.TODO(), iqGet, types.GroupServerJID, waBinary.Node{
+	resp, err := cli.sendGroupIQ(ctx, iqGet, types.GroupServerJID, waBinary.Node{
 		Tag:   "invite",
 		Attrs: waBinary.Attrs{"code": code},
 	})
@@ -427,8 +508,13 @@ func (cli *Client) GetGroupInfoFromLink(code string) (*types.GroupInfo, error) {
 
 // JoinGroupWithLink joins the group using the given invite link.
 func (cli *Client) JoinGroupWithLink(code string) (types.JID, error) {
+	return cli.JoinGroupWithLinkContext(context.Background(), code)
+}
+
+// JoinGroupWithLinkContext is like JoinGroupWithLink, but takes a context for cancellation and deadlines.
+func (cli *Client) JoinGroupWithLinkContext(ctx context.Context, code string) (types.JID, error) {
 	code = strings.TrimPrefix(code, InviteLinkPrefix)
-	resp, err := cli.sendGroupIQ(context.TODO(), iqSet, types.GroupServerJID, waBinary.Node{
+	resp, err := cli.sendGroupIQ(ctx, iqSet, types.GroupServerJID, waBinary.Node{
 		Tag:   "invite",
 		Attrs: waBinary.Attrs{"code": code},
 	})
@@ -448,7 +534,12 @@ func (cli *Client) JoinGroupWithLink(code string) (types.JID, error) {
 
 // GetJoinedGroups returns the list of groups the user is participating in.
 func (cli *Client) GetJoinedGroups() ([]*types.GroupInfo, error) {
-	resp, err := cli.sendGroupIQ(context.TODO(), iqGet, types.GroupServerJID, waBinary.Node{
+	return cli.GetJoinedGroupsContext(context.Background())
+}
+
+// GetJoinedGroupsContext is like GetJoinedGroups, but takes a context for cancellation and deadlines.
+func (cli *Client) GetJoinedGroupsContext(ctx context.Context) ([]*types.GroupInfo, error) {
+	resp, err := cli.sendGroupIQ(ctx, iqGet, types.GroupServerJID, waBinary.Node{
 		Tag: "participating",
 		Content: []waBinary.Node{
 			{Tag: "participants"},
@@ -480,7 +571,12 @@ func (cli *Client) GetJoinedGroups() ([]*types.GroupInfo, error) {
 
 // GetSubGroups gets the subgroups of the given community.
 func (cli *Client) GetSubGroups(community types.JID) ([]*types.GroupLinkTarget, error) {
-	res, err := cli.sendGroupIQ(context.TODO(), iqGet, community, waBinary.Node{Tag: "sub_groups"})
+	return cli.GetSubGroupsContext(context.Background(), community)
+}
+
+// GetSubGroupsContext is like GetSubGroups, but takes a context for cancellation and deadlines.
+func (cli *Client) GetSubGroupsContext(ctx context.Context, community types.JID) ([]*types.GroupLinkTarget, error) {
+	res, err := cli.sendGroupIQ(ctx, iqGet, community, waBinary.Node{Tag: "sub_groups"})
 	if err != nil {
 		return nil, err
 	}
@@ -503,7 +599,12 @@ func (cli *Client) GetSubGroups(community types.JID) ([]*types.GroupLinkTarget,
 
 // GetLinkedGroupsParticipants gets all the participants in the groups of the given community.
 func (cli *Client) GetLinkedGroupsParticipants(community types.JID) ([]types.JID, error) {
-	res, err := cli.sendGroupIQ(context.TODO(), iqGet, community, waBinary.Node{Tag: "linked_groups_participants"})
+	return cli.GetLinkedGroupsParticipantsContext(context.Background(), community)
+}
+
+// GetLinkedGroupsParticipantsContext is like GetLinkedGroupsParticipants, but takes a context for cancellation and deadlines.
+func (cli *Client) GetLinkedGroupsParticipantsContext(ctx context.Context, community types.JID) ([]types.JID, error) {
+	res, err := cli.sendGroupIQ(ctx, iqGet, community, waBinary.Node{Tag: "linked_groups_participants"})
 	if err != nil {
 		return nil, err
 	}
@@ -516,7 +617,12 @@ func (cli *Client) GetLinkedGroupsParticipants(community types.JID) ([]types.JID
 
 // GetGroupInfo requests basic info about a group chat from the WhatsApp servers.
 func (cli *Client) GetGroupInfo(jid types.JID) (*types.GroupInfo, error) {
-	return cli.getGroupInfo(context.TODO(), jid)
+	return cli.GetGroupInfoContext(context.Background(), jid)
+}
+
+// GetGroupInfoContext is like GetGroupInfo, but takes a context for cancellation and deadlines.
+func (cli *Client) GetGroupInfoContext(ctx context.Context, jid types.JID) (*types.GroupInfo, error) {
+	return cli.getGroupInfo(ctx, jid)
 }
 
 func (cli *Client) getGroupInfo(ctx context.Context, jid types.JID) (*types.GroupInfo, error) {
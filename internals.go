@@ -50,11 +50,11 @@ func (int *DangerousInternalClient) CancelResponse(reqID string, ch chan *waBina
 }
 
 func (int *DangerousInternalClient) QueryMediaConn() (*MediaConn, error) {
-	return int.c.queryMediaConn()
+	return int.c.queryMediaConn(context.Background())
 }
 
 func (int *DangerousInternalClient) RefreshMediaConn(force bool) (*MediaConn, error) {
-	return int.c.refreshMediaConn(force)
+	return int.c.refreshMediaConn(context.Background(), force)
 }
 
 func (int *DangerousInternalClient) GetServerPreKeyCount() (int, error) {
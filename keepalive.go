@@ -9,6 +9,7 @@ package whatsmeow
 import (
 	"context"
 	"math/rand"
+	"sync"
 	"time"
 
 	waBinary "github.com/go-whatsapp/whatsmeow/binary"
@@ -17,44 +18,174 @@ import (
 )
 
 var (
-	// KeepAliveResponseDeadline specifies the duration to wait for a response to websocket keepalive pings.
+	// KeepAliveResponseDeadline specifies the default duration to wait for a response to websocket keepalive pings.
 	KeepAliveResponseDeadline = 10 * time.Second
-	// KeepAliveIntervalMin specifies the minimum interval for websocket keepalive pings.
+	// KeepAliveIntervalMin specifies the default minimum interval for websocket keepalive pings.
 	KeepAliveIntervalMin = 20 * time.Second
-	// KeepAliveIntervalMax specifies the maximum interval for websocket keepalive pings.
+	// KeepAliveIntervalMax specifies the default maximum interval for websocket keepalive pings.
 	KeepAliveIntervalMax = 30 * time.Second
 
-	// KeepAliveMaxFailTime specifies the maximum time to wait before forcing a reconnect if keepalives fail repeatedly.
+	// KeepAliveMaxFailTime specifies the default maximum time to wait before forcing a reconnect if keepalives fail repeatedly.
 	KeepAliveMaxFailTime = 3 * time.Minute
 )
 
+// keepAliveRTTWindow is the number of successful pings that KeepAliveStats are calculated from.
+const keepAliveRTTWindow = 10
+
+// KeepAliveConfig contains the keepalive settings of a single Client.
+//
+// Zero values mean the package-level defaults (KeepAliveIntervalMin, etc.) are used.
+type KeepAliveConfig struct {
+	// IntervalMin and IntervalMax specify the range of the random interval between pings.
+	IntervalMin time.Duration
+	IntervalMax time.Duration
+	// ResponseDeadline specifies how long to wait for a response to each ping.
+	ResponseDeadline time.Duration
+	// MaxFailTime specifies how long pings can fail before the client forces a reconnect.
+	// Reconnecting is only done if Client.EnableAutoReconnect is true.
+	MaxFailTime time.Duration
+}
+
+func (kac KeepAliveConfig) withDefaults() KeepAliveConfig {
+	if kac.IntervalMin <= 0 {
+		kac.IntervalMin = KeepAliveIntervalMin
+	}
+	if kac.IntervalMax <= 0 {
+		kac.IntervalMax = KeepAliveIntervalMax
+	}
+	if kac.ResponseDeadline <= 0 {
+		kac.ResponseDeadline = KeepAliveResponseDeadline
+	}
+	if kac.MaxFailTime <= 0 {
+		kac.MaxFailTime = KeepAliveMaxFailTime
+	}
+	return kac
+}
+
+func (kac KeepAliveConfig) nextInterval() time.Duration {
+	if kac.IntervalMax <= kac.IntervalMin {
+		return kac.IntervalMin
+	}
+	return time.Duration(rand.Int63n(kac.IntervalMax.Milliseconds()-kac.IntervalMin.Milliseconds())+kac.IntervalMin.Milliseconds()) * time.Millisecond
+}
+
+// KeepAliveStats is a summary of the keepalive pings sent on the current (or most recent) connection.
+type KeepAliveStats struct {
+	// LastRTT is the round-trip time of the latest successful ping.
+	LastRTT time.Duration
+	// AverageRTT, MinRTT and MaxRTT are calculated from the last 10 successful pings.
+	AverageRTT time.Duration
+	MinRTT     time.Duration
+	MaxRTT     time.Duration
+	// Samples is the number of pings the RTT values are calculated from.
+	Samples int
+	// LastSuccess is the time when the latest successful ping was sent, or the connection time if no pings have succeeded yet.
+	LastSuccess time.Time
+	// FailureCount is the number of consecutive failed pings.
+	FailureCount int
+}
+
+// Healthy returns true if the latest keepalive ping didn't fail.
+func (kas KeepAliveStats) Healthy() bool {
+	return kas.FailureCount == 0
+}
+
+type keepAliveMetrics struct {
+	lock        sync.Mutex
+	rtts        [keepAliveRTTWindow]time.Duration
+	total       int
+	lastSuccess time.Time
+	failures    int
+}
+
+func (kam *keepAliveMetrics) reset() {
+	kam.lock.Lock()
+	kam.total = 0
+	kam.lastSuccess = time.Now()
+	kam.failures = 0
+	kam.lock.Unlock()
+}
+
+func (kam *keepAliveMetrics) recordSuccess(sent time.Time, rtt time.Duration) (prevFailures int) {
+	kam.lock.Lock()
+	defer kam.lock.Unlock()
+	kam.rtts[kam.total%keepAliveRTTWindow] = rtt
+	kam.total++
+	kam.lastSuccess = sent
+	prevFailures = kam.failures
+	kam.failures = 0
+	return
+}
+
+func (kam *keepAliveMetrics) recordFailure() (failures int, lastSuccess time.Time) {
+	kam.lock.Lock()
+	defer kam.lock.Unlock()
+	kam.failures++
+	return kam.failures, kam.lastSuccess
+}
+
+func (kam *keepAliveMetrics) stats() (stats KeepAliveStats) {
+	kam.lock.Lock()
+	defer kam.lock.Unlock()
+	stats.LastSuccess = kam.lastSuccess
+	stats.FailureCount = kam.failures
+	stats.Samples = kam.total
+	if stats.Samples > keepAliveRTTWindow {
+		stats.Samples = keepAliveRTTWindow
+	}
+	if stats.Samples == 0 {
+		return
+	}
+	stats.LastRTT = kam.rtts[(kam.total-1)%keepAliveRTTWindow]
+	stats.MinRTT = stats.LastRTT
+	var sum time.Duration
+	for _, rtt := range kam.rtts[:stats.Samples] {
+		sum += rtt
+		if rtt < stats.MinRTT {
+			stats.MinRTT = rtt
+		}
+		if rtt > stats.MaxRTT {
+			stats.MaxRTT = rtt
+		}
+	}
+	stats.AverageRTT = sum / time.Duration(stats.Samples)
+	return
+}
+
+// KeepAliveStats returns a summary of the round-trip times and failures of keepalive pings.
+// The stats are reset whenever the client connects.
+func (cli *Client) KeepAliveStats() KeepAliveStats {
+	return cli.keepAliveMetrics.stats()
+}
+
 func (cli *Client) keepAliveLoop(ctx context.Context) {
-	lastSuccess := time.Now()
-	var errorCount int
+	cli.keepAliveMetrics.reset()
+	config := cli.KeepAlive.withDefaults()
 	for {
-		interval := rand.Int63n(KeepAliveIntervalMax.Milliseconds()-KeepAliveIntervalMin.Milliseconds()) + KeepAliveIntervalMin.Milliseconds()
 		select {
-		case <-time.After(time.Duration(interval) * time.Millisecond):
-			isSuccess, shouldContinue := cli.sendKeepAlive(ctx)
+		case <-time.After(config.nextInterval()):
+			sent := time.Now()
+			isSuccess, shouldContinue := cli.sendKeepAlive(ctx, config.ResponseDeadline)
 			if !shouldContinue {
 				return
 			} else if !isSuccess {
-				errorCount++
+				errorCount, lastSuccess := cli.keepAliveMetrics.recordFailure()
 				go cli.dispatchEvent(&events.KeepAliveTimeout{
 					ErrorCount:  errorCount,
 					LastSuccess: lastSuccess,
 				})
-				if cli.EnableAutoReconnect && time.Since(lastSuccess) > KeepAliveMaxFailTime {
+				if cli.EnableAutoReconnect && time.Since(lastSuccess) > config.MaxFailTime {
 					cli.Log.Debugf("Forcing reconnect due to keepalive failure")
-					cli.Disconnect()
-					go cli.autoReconnect()
+					cli.socketLock.Lock()
+					cli.unlockedDisconnect()
+					cli.socketLock.Unlock()
+					go cli.autoReconnect(ReconnectAttempt{Reason: ReconnectReasonKeepAliveTimeout})
 				}
 			} else {
-				if errorCount > 0 {
-					errorCount = 0
-					go cli.dispatchEvent(&events.KeepAliveRestored{})
+				rtt := time.Since(sent)
+				if prevErrorCount := cli.keepAliveMetrics.recordSuccess(sent, rtt); prevErrorCount > 0 {
+					go cli.dispatchEvent(&events.KeepAliveRestored{RTT: rtt})
 				}
-				lastSuccess = time.Now()
 			}
 		case <-ctx.Done():
 			return
@@ -62,7 +193,7 @@ func (cli *Client) keepAliveLoop(ctx context.Context) {
 	}
 }
 
-func (cli *Client) sendKeepAlive(ctx context.Context) (isSuccess, shouldContinue bool) {
+func (cli *Client) sendKeepAlive(ctx context.Context, deadline time.Duration) (isSuccess, shouldContinue bool) {
 	respCh, err := cli.sendIQAsync(infoQuery{
 		Namespace: "w:p",
 		Type:      iqGet,
@@ -77,7 +208,7 @@ func (cli *Client) sendKeepAlive(ctx context.Context) (isSuccess, shouldContinue
 	case <-respCh:
 		// All good
 		return true, true
-	case <-time.After(KeepAliveResponseDeadline):
+	case <-time.After(deadline):
 		cli.Log.Warnf("Keepalive timed out")
 		return false, true
 	case <-ctx.Done():
@@ -0,0 +1,68 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package whatsmeow
+
+import (
+	"testing"
+	"time"
+)
+
+func TestKeepAliveConfig(t *testing.T) {
+	config := KeepAliveConfig{IntervalMin: 5 * time.Second}.withDefaults()
+	if config.IntervalMin != 5*time.Second || config.IntervalMax != KeepAliveIntervalMax ||
+		config.ResponseDeadline != KeepAliveResponseDeadline || config.MaxFailTime != KeepAliveMaxFailTime {
+		t.Fatalf("unexpected config with defaults %+v", config)
+	}
+	for i := 0; i < 100; i++ {
+		if interval := config.nextInterval(); interval < config.IntervalMin || interval >= config.IntervalMax {
+			t.Fatalf("interval %s out of range", interval)
+		}
+	}
+	config.IntervalMax = config.IntervalMin
+	if interval := config.nextInterval(); interval != config.IntervalMin {
+		t.Fatalf("expected fixed interval, got %s", interval)
+	}
+}
+
+func TestKeepAliveMetrics(t *testing.T) {
+	var metrics keepAliveMetrics
+	metrics.reset()
+	if stats := metrics.stats(); stats.Samples != 0 || !stats.Healthy() || stats.LastSuccess.IsZero() {
+		t.Fatalf("unexpected initial stats %+v", stats)
+	}
+
+	if failures, _ := metrics.recordFailure(); failures != 1 {
+		t.Fatalf("unexpected failure count %d", failures)
+	} else if failures, _ = metrics.recordFailure(); failures != 2 {
+		t.Fatalf("unexpected failure count %d", failures)
+	} else if stats := metrics.stats(); stats.Healthy() || stats.FailureCount != 2 {
+		t.Fatalf("failures weren't counted: %+v", stats)
+	}
+	sent := time.Now()
+	if prevFailures := metrics.recordSuccess(sent, 100*time.Millisecond); prevFailures != 2 {
+		t.Fatalf("unexpected previous failure count %d", prevFailures)
+	}
+	stats := metrics.stats()
+	if !stats.Healthy() || !stats.LastSuccess.Equal(sent) || stats.Samples != 1 || stats.LastRTT != 100*time.Millisecond {
+		t.Fatalf("success wasn't recorded: %+v", stats)
+	}
+
+	// Only the latest samples are included once the window is full
+	for i := 1; i <= keepAliveRTTWindow+2; i++ {
+		metrics.recordSuccess(sent, time.Duration(i)*time.Millisecond)
+	}
+	stats = metrics.stats()
+	if stats.Samples != keepAliveRTTWindow || stats.LastRTT != 12*time.Millisecond ||
+		stats.MinRTT != 3*time.Millisecond || stats.MaxRTT != 12*time.Millisecond || stats.AverageRTT != 7500*time.Microsecond {
+		t.Fatalf("unexpected windowed stats %+v", stats)
+	}
+
+	metrics.reset()
+	if stats = metrics.stats(); stats.Samples != 0 || stats.LastRTT != 0 {
+		t.Fatalf("stats weren't reset: %+v", stats)
+	}
+}
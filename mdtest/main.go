@@ -43,7 +43,7 @@ var log waLog.Logger
 
 var logLevel = "INFO"
 var debugLogs = flag.Bool("debug", false, "Enable debug logs?")
-var dbDialect = flag.String("db-dialect", "sqlite3", "Database dialect (sqlite3 or postgres)")
+var dbDialect = flag.String("db-dialect", "sqlite3", "Database dialect (sqlite3, postgres or mysql)")
 var dbAddress = flag.String("db-address", "file:mdtest.db?_foreign_keys=on&_journal_mode=WAL", "Database address")
 var requestFullSync = flag.Bool("request-full-sync", false, "Request full (1 year) history sync when logging in?")
 var pairRejectChan = make(chan bool, 1)
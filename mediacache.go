@@ -0,0 +1,177 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package whatsmeow
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"github.com/goccy/go-json"
+
+	"github.com/go-whatsapp/whatsmeow/mediacache"
+)
+
+// MediaCacheUploadTTL is how long upload responses stored in Client.MediaCache are reused when uploading
+// identical files. Files on the WhatsApp media servers expire after a while, so this should stay well below that.
+var MediaCacheUploadTTL = 7 * 24 * time.Hour
+
+// MediaCacheMaxStreamedSize is the maximum size of files downloaded with the streaming functions
+// (e.g. Client.DownloadToWriter) that are stored in Client.MediaCache. Storing a streamed file requires
+// keeping a copy of it in memory until the download is complete, so larger files are not cached.
+var MediaCacheMaxStreamedSize int64 = 32 * 1024 * 1024
+
+// cachedUpload is the format that upload responses are stored in the media cache in.
+type cachedUpload struct {
+	URL           string `json:"url"`
+	DirectPath    string `json:"direct_path"`
+	MediaKey      []byte `json:"media_key"`
+	FileEncSHA256 []byte `json:"file_enc_sha256"`
+	FileSHA256    []byte `json:"file_sha256"`
+	FileLength    uint64 `json:"file_length"`
+	UploadedAt    int64  `json:"uploaded_at"`
+
+	StreamingSidecar []byte `json:"streaming_sidecar,omitempty"`
+}
+
+// mediaCacheKey returns the cache key for a downloaded file. The encrypted hash is included when it's known,
+// so that a file is only reused for messages that point at the exact same encrypted blob.
+func mediaCacheKey(fileSHA256, fileEncSHA256 []byte) string {
+	if len(fileEncSHA256) != 32 {
+		return "media-" + hex.EncodeToString(fileSHA256)
+	}
+	return "media-" + hex.EncodeToString(fileSHA256) + "-" + hex.EncodeToString(fileEncSHA256)
+}
+
+func uploadCacheKey(fileSHA256 []byte, appInfo MediaType) (string, bool) {
+	mmsType, ok := mediaTypeToMMSType[appInfo]
+	if !ok {
+		return "", false
+	}
+	return "upload-" + mmsType + "-" + hex.EncodeToString(fileSHA256), true
+}
+
+// getCachedMedia returns the file with the given hashes from the media cache,
+// or nil if there's no cache or the file isn't in it.
+func (cli *Client) getCachedMedia(fileSHA256, fileEncSHA256 []byte, progress MediaProgressFunc) []byte {
+	if cli.MediaCache == nil || len(fileSHA256) != 32 {
+		return nil
+	}
+	key := mediaCacheKey(fileSHA256, fileEncSHA256)
+	data, err := cli.MediaCache.Get(key)
+	if err != nil {
+		cli.Log.Warnf("Failed to get %s from media cache: %v", key, err)
+		return nil
+	} else if data == nil {
+		return nil
+	} else if hash := sha256.Sum256(data); !bytes.Equal(hash[:], fileSHA256) {
+		cli.Log.Warnf("Hash of cached media %s doesn't match, removing it from cache", key)
+		if err = cli.MediaCache.Delete(key); err != nil {
+			cli.Log.Warnf("Failed to delete %s from media cache: %v", key, err)
+		}
+		return nil
+	}
+	if progress != nil {
+		progress(int64(len(data)), int64(len(data)))
+	}
+	return data
+}
+
+// putCachedMedia stores a downloaded file in the media cache. The file is only stored if it matches the hash.
+func (cli *Client) putCachedMedia(fileSHA256, fileEncSHA256, data []byte) {
+	if cli.MediaCache == nil || len(fileSHA256) != 32 {
+		return
+	} else if hash := sha256.Sum256(data); !bytes.Equal(hash[:], fileSHA256) {
+		return
+	}
+	key := mediaCacheKey(fileSHA256, fileEncSHA256)
+	if err := cli.MediaCache.Put(key, data); errors.Is(err, mediacache.ErrEntryTooLarge) {
+		cli.Log.Debugf("Not storing %s in media cache: %v", key, err)
+	} else if err != nil {
+		cli.Log.Warnf("Failed to store %s in media cache: %v", key, err)
+	}
+}
+
+// startCachingOutput makes the output keep a copy of the written data, so that it can be stored
+// in the media cache with putCachedOutput after the download succeeds.
+func (cli *Client) startCachingOutput(out *mediaOutput, fileLength int) {
+	if cli.MediaCache != nil && out.written == 0 && fileLength > 0 && int64(fileLength) <= MediaCacheMaxStreamedSize {
+		out.cache = bytes.NewBuffer(make([]byte, 0, fileLength))
+	}
+}
+
+// putCachedOutput stores the data collected by startCachingOutput in the media cache.
+func (cli *Client) putCachedOutput(fileSHA256, fileEncSHA256 []byte, out *mediaOutput) {
+	if out.cache != nil {
+		cli.putCachedMedia(fileSHA256, fileEncSHA256, out.cache.Bytes())
+		out.cache = nil
+	}
+}
+
+// getCachedUpload returns a previous upload response for an identical file, if one was uploaded recently enough.
+func (cli *Client) getCachedUpload(fileSHA256 []byte, appInfo MediaType) *UploadResponse {
+	if cli.MediaCache == nil {
+		return nil
+	}
+	key, ok := uploadCacheKey(fileSHA256, appInfo)
+	if !ok {
+		return nil
+	}
+	data, err := cli.MediaCache.Get(key)
+	if err != nil {
+		cli.Log.Warnf("Failed to get %s from media cache: %v", key, err)
+		return nil
+	} else if data == nil {
+		return nil
+	}
+	var cached cachedUpload
+	if err = json.Unmarshal(data, &cached); err != nil {
+		cli.Log.Warnf("Failed to parse cached upload %s: %v", key, err)
+		return nil
+	} else if time.Since(time.Unix(cached.UploadedAt, 0)) > MediaCacheUploadTTL || !bytes.Equal(cached.FileSHA256, fileSHA256) {
+		return nil
+	}
+	return &UploadResponse{
+		URL:           cached.URL,
+		DirectPath:    cached.DirectPath,
+		MediaKey:      cached.MediaKey,
+		FileEncSHA256: cached.FileEncSHA256,
+		FileSHA256:    cached.FileSHA256,
+		FileLength:    cached.FileLength,
+
+		StreamingSidecar: cached.StreamingSidecar,
+	}
+}
+
+// putCachedUpload stores an upload response in the media cache, so that it can be reused for identical files.
+func (cli *Client) putCachedUpload(appInfo MediaType, resp *UploadResponse) {
+	if cli.MediaCache == nil {
+		return
+	}
+	key, ok := uploadCacheKey(resp.FileSHA256, appInfo)
+	if !ok {
+		return
+	}
+	data, err := json.Marshal(&cachedUpload{
+		URL:           resp.URL,
+		DirectPath:    resp.DirectPath,
+		MediaKey:      resp.MediaKey,
+		FileEncSHA256: resp.FileEncSHA256,
+		FileSHA256:    resp.FileSHA256,
+		FileLength:    resp.FileLength,
+		UploadedAt:    time.Now().Unix(),
+
+		StreamingSidecar: resp.StreamingSidecar,
+	})
+	if err != nil {
+		cli.Log.Warnf("Failed to marshal upload response for media cache: %v", err)
+	} else if err = cli.MediaCache.Put(key, data); err != nil {
+		cli.Log.Warnf("Failed to store %s in media cache: %v", key, err)
+	}
+}
@@ -0,0 +1,233 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+// Package mediacache contains the interface and a filesystem implementation of the local media cache
+// that can be set in whatsmeow.Client.MediaCache.
+package mediacache
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Cache is a key-value store for cached media files and upload responses.
+//
+// Keys only contain ASCII letters, digits, dashes and underscores, so they're safe to use as file names.
+// Implementations may drop entries at any time, e.g. to stay within a size limit.
+type Cache interface {
+	// Get returns the data stored with the given key, or nil if there's no such entry.
+	Get(key string) ([]byte, error)
+	// Put stores the given data, replacing any existing entry with the same key.
+	Put(key string, data []byte) error
+	// Delete removes the entry with the given key. Deleting a non-existent entry is not an error.
+	Delete(key string) error
+}
+
+var (
+	// ErrInvalidKey is returned by Filesystem if a key contains characters that aren't allowed in cache keys.
+	ErrInvalidKey = errors.New("invalid media cache key")
+	// ErrEntryTooLarge is returned by Filesystem.Put if the entry alone is larger than MaxSize.
+	ErrEntryTooLarge = errors.New("media cache entry is larger than the maximum cache size")
+)
+
+const tempFilePrefix = ".tmp-"
+
+// Filesystem is a Cache that stores each entry as a file in a directory.
+//
+// The modification time of each file is used as its last access time: it's updated whenever the entry is read.
+// Entries that haven't been stored or read within MaxAge are dropped when they're accessed or when Prune is called.
+// If the total size of entries exceeds MaxSize after a Put, the least recently used entries are removed until
+// the cache is down to 90% of MaxSize, so that the directory doesn't have to be scanned again on every Put.
+// Entries larger than MaxSize are rejected without touching the existing entries.
+type Filesystem struct {
+	Dir string
+	// MaxSize is the maximum total size of all entries in bytes. Zero means no limit.
+	MaxSize int64
+	// MaxAge is the maximum time entries are kept after they were last stored or read. Zero means no limit.
+	MaxAge time.Duration
+
+	lock sync.Mutex
+	size int64
+}
+
+var _ Cache = (*Filesystem)(nil)
+
+// NewFilesystem creates a filesystem cache in the given directory, creating the directory if it doesn't exist.
+func NewFilesystem(dir string, maxSize int64, maxAge time.Duration) (*Filesystem, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	fs := &Filesystem{Dir: dir, MaxSize: maxSize, MaxAge: maxAge}
+	if err := fs.Prune(); err != nil {
+		return nil, err
+	}
+	return fs, nil
+}
+
+func isValidKey(key string) bool {
+	if len(key) == 0 {
+		return false
+	}
+	for _, char := range key {
+		if (char < 'a' || char > 'z') && (char < 'A' || char > 'Z') && (char < '0' || char > '9') && char != '-' && char != '_' {
+			return false
+		}
+	}
+	return true
+}
+
+func (fs *Filesystem) path(key string) (string, error) {
+	if !isValidKey(key) {
+		return "", ErrInvalidKey
+	}
+	return filepath.Join(fs.Dir, key), nil
+}
+
+func (fs *Filesystem) isExpired(info os.FileInfo) bool {
+	return fs.MaxAge > 0 && time.Since(info.ModTime()) > fs.MaxAge
+}
+
+// Get implements Cache.
+func (fs *Filesystem) Get(key string) ([]byte, error) {
+	path, err := fs.path(key)
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	} else if fs.isExpired(info) {
+		return nil, fs.Delete(key)
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		// The entry was evicted between the stat and read
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	if err = os.Chtimes(path, now, now); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	return data, nil
+}
+
+// Put implements Cache.
+func (fs *Filesystem) Put(key string, data []byte) error {
+	path, err := fs.path(key)
+	if err != nil {
+		return err
+	} else if fs.MaxSize > 0 && int64(len(data)) > fs.MaxSize {
+		return ErrEntryTooLarge
+	}
+	tempFile, err := os.CreateTemp(fs.Dir, tempFilePrefix+"*")
+	if err != nil {
+		return err
+	}
+	_, err = tempFile.Write(data)
+	if closeErr := tempFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(tempFile.Name())
+		return err
+	}
+
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+	if info, err := os.Stat(path); err == nil {
+		fs.size -= info.Size()
+	}
+	if err = os.Rename(tempFile.Name(), path); err != nil {
+		_ = os.Remove(tempFile.Name())
+		return err
+	}
+	fs.size += int64(len(data))
+	if fs.MaxSize > 0 && fs.size > fs.MaxSize {
+		return fs.prune()
+	}
+	return nil
+}
+
+// Delete implements Cache.
+func (fs *Filesystem) Delete(key string) error {
+	path, err := fs.path(key)
+	if err != nil {
+		return err
+	}
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+	info, err := os.Stat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	} else if err = os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	fs.size -= info.Size()
+	return nil
+}
+
+// Prune removes expired entries and, if the cache is over MaxSize, the least recently used entries until
+// it's down to 90% of MaxSize.
+// It also recalculates the total size of the cache, in case files were changed by something else.
+func (fs *Filesystem) Prune() error {
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+	return fs.prune()
+}
+
+func (fs *Filesystem) prune() error {
+	entries, err := os.ReadDir(fs.Dir)
+	if err != nil {
+		return err
+	}
+	files := make([]os.FileInfo, 0, len(entries))
+	var size int64
+	for _, entry := range entries {
+		// Temporary files from Put are skipped here, as the dot in the prefix makes them invalid keys
+		if !entry.Type().IsRegular() || !isValidKey(entry.Name()) {
+			continue
+		}
+		info, err := entry.Info()
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return err
+		} else if fs.isExpired(info) {
+			if err = os.Remove(filepath.Join(fs.Dir, info.Name())); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return err
+			}
+			continue
+		}
+		files = append(files, info)
+		size += info.Size()
+	}
+	if fs.MaxSize > 0 && size > fs.MaxSize {
+		sort.Slice(files, func(i, j int) bool {
+			return files[i].ModTime().Before(files[j].ModTime())
+		})
+		target := fs.MaxSize - fs.MaxSize/10
+		for _, info := range files {
+			if size <= target {
+				break
+			} else if err = os.Remove(filepath.Join(fs.Dir, info.Name())); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return err
+			}
+			size -= info.Size()
+		}
+	}
+	fs.size = size
+	return nil
+}
@@ -0,0 +1,106 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package mediacache
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFilesystemEviction(t *testing.T) {
+	fs, err := NewFilesystem(t.TempDir(), 250, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, key := range []string{"a", "b", "c"} {
+		if err = fs.Put(key, bytes.Repeat([]byte{byte(i)}, 100)); err != nil {
+			t.Fatal(err)
+		}
+		// Make sure the modification times are in order
+		modTime := time.Now().Add(time.Duration(i-10) * time.Minute)
+		if err = os.Chtimes(filepath.Join(fs.Dir, key), modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if data, err := fs.Get("a"); err != nil || data != nil {
+		t.Fatalf("expected oldest entry to be evicted, got %v/%v", data, err)
+	} else if data, err = fs.Get("c"); err != nil || len(data) != 100 {
+		t.Fatalf("expected newest entry to be kept, got %v/%v", data, err)
+	}
+
+	expired := time.Now().Add(-2 * time.Hour)
+	if err = os.Chtimes(filepath.Join(fs.Dir, "b"), expired, expired); err != nil {
+		t.Fatal(err)
+	} else if data, err := fs.Get("b"); err != nil || data != nil {
+		t.Fatalf("expected expired entry to be removed, got %v/%v", data, err)
+	} else if _, err = os.Stat(filepath.Join(fs.Dir, "b")); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected expired entry file to be deleted, got %v", err)
+	}
+
+	if err = fs.Put("../escape", nil); !errors.Is(err, ErrInvalidKey) {
+		t.Fatalf("expected invalid key error, got %v", err)
+	}
+}
+
+func TestFilesystemEvictsLeastRecentlyUsed(t *testing.T) {
+	fs, err := NewFilesystem(t.TempDir(), 250, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, key := range []string{"a", "b"} {
+		if err = fs.Put(key, bytes.Repeat([]byte{byte(i)}, 100)); err != nil {
+			t.Fatal(err)
+		}
+		modTime := time.Now().Add(time.Duration(i-10) * time.Minute)
+		if err = os.Chtimes(filepath.Join(fs.Dir, key), modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Reading the oldest entry should make it the most recently used one
+	if data, err := fs.Get("a"); err != nil || len(data) != 100 {
+		t.Fatalf("failed to get entry: %v/%v", data, err)
+	} else if err = fs.Put("c", bytes.Repeat([]byte{2}, 100)); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := fs.Get("b"); err != nil || data != nil {
+		t.Fatalf("expected least recently used entry to be evicted, got %v/%v", data, err)
+	} else if data, err = fs.Get("a"); err != nil || len(data) != 100 {
+		t.Fatalf("expected recently read entry to be kept, got %v/%v", data, err)
+	}
+}
+
+func TestFilesystemRejectsOversizedEntries(t *testing.T) {
+	fs, err := NewFilesystem(t.TempDir(), 250, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = fs.Put("a", bytes.Repeat([]byte{1}, 100)); err != nil {
+		t.Fatal(err)
+	} else if err = fs.Put("b", bytes.Repeat([]byte{2}, 251)); !errors.Is(err, ErrEntryTooLarge) {
+		t.Fatalf("expected entry too large error, got %v", err)
+	} else if data, err := fs.Get("a"); err != nil || len(data) != 100 {
+		t.Fatalf("expected existing entry to be kept, got %v/%v", data, err)
+	}
+
+	// Pruning should leave some room, so that the next Put doesn't have to prune again
+	for i, key := range []string{"b", "c"} {
+		if err = fs.Put(key, bytes.Repeat([]byte{byte(i)}, 100)); err != nil {
+			t.Fatal(err)
+		}
+		modTime := time.Now().Add(time.Duration(i-10) * time.Minute)
+		if err = os.Chtimes(filepath.Join(fs.Dir, key), modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if fs.size > 225 {
+		t.Fatalf("expected cache to be pruned to 90%% of the maximum size, got %d bytes", fs.size)
+	}
+}
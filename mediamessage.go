@@ -0,0 +1,501 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package whatsmeow
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+
+	waProto "github.com/go-whatsapp/whatsmeow/binary/proto"
+	"github.com/go-whatsapp/whatsmeow/util/mediautil"
+)
+
+// MaxMediaSize contains the maximum file sizes that the media message builders accept for each media type.
+var MaxMediaSize = map[MediaType]int64{
+	MediaImage:    16 * 1024 * 1024,
+	MediaVideo:    64 * 1024 * 1024,
+	MediaAudio:    16 * 1024 * 1024,
+	MediaDocument: 2 * 1024 * 1024 * 1024,
+}
+
+// MaxStickerSize is the maximum size of the WebP file produced by BuildStickerMessage.
+var MaxStickerSize int64 = 500 * 1024
+
+// MediaThumbnailSize is the maximum width and height of the JPEG thumbnails generated by the media message builders.
+var MediaThumbnailSize = 72
+
+// MediaMessageExtra contains optional parameters for the media message builders like BuildImageMessage.
+type MediaMessageExtra struct {
+	// MimeType overrides the MIME type detected from the file contents.
+	MimeType string
+	// Caption is the text shown under the media. Not applicable to audio or stickers.
+	Caption string
+	// FileName is the name of the file shown for documents. It's also used to guess the MIME type
+	// if it can't be detected from the contents.
+	FileName string
+	// Thumbnail is an image that the JPEG thumbnail will be generated from. It's needed for video and document
+	// thumbnails, as those can't be generated from the file itself. For images, it overrides the thumbnail
+	// that would be generated from the image.
+	Thumbnail []byte
+	// ContextInfo is included in the message as-is, e.g. for replies and mentions.
+	ContextInfo *waProto.ContextInfo
+	// StickerPack is embedded into the EXIF data of stickers. Only used by BuildStickerMessage.
+	StickerPack *mediautil.StickerPackMetadata
+	// Upload contains extra parameters for the upload.
+	Upload UploadExtra
+}
+
+func getMediaMessageExtra(extra []MediaMessageExtra) (req MediaMessageExtra, err error) {
+	if len(extra) > 1 {
+		err = errors.New("only one extra parameter may be provided to media message builders")
+	} else if len(extra) == 1 {
+		req = extra[0]
+	}
+	return
+}
+
+func (req *MediaMessageExtra) getMimeType(data []byte) string {
+	if len(req.MimeType) > 0 {
+		return req.MimeType
+	}
+	return mediautil.DetectMIMEType(data, req.FileName)
+}
+
+func checkMediaSize(size, maxSize int64) error {
+	if maxSize > 0 && size > maxSize {
+		return fmt.Errorf("%w: %d bytes is over the limit of %d bytes", ErrMediaTooLarge, size, maxSize)
+	}
+	return nil
+}
+
+// mediaReader is a file that the media message builders can read metadata from and stream while uploading,
+// like an *os.File or a *bytes.Reader.
+type mediaReader interface {
+	io.ReaderAt
+	io.ReadSeeker
+}
+
+// mimeSniffLength is how much of the start of the file is used to detect the MIME type.
+// It's the same amount that http.DetectContentType looks at.
+const mimeSniffLength = 512
+
+func readMediaPrefix(file io.ReaderAt, size int64) ([]byte, error) {
+	prefix := make([]byte, mimeSniffLength)
+	if size < mimeSniffLength {
+		prefix = prefix[:size]
+	}
+	if _, err := file.ReadAt(prefix, 0); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("failed to read file: %w", err)
+	}
+	return prefix, nil
+}
+
+func optionalString(val string) *string {
+	if len(val) == 0 {
+		return nil
+	}
+	return proto.String(val)
+}
+
+func optionalUint32(val int) *uint32 {
+	if val <= 0 {
+		return nil
+	}
+	return proto.Uint32(uint32(val))
+}
+
+// imageMessageTypes contains the MIME types that can be sent as image messages.
+// GIFs aren't included, as clients expect them as video messages with GIF playback, which requires converting to MP4.
+var imageMessageTypes = map[string]bool{
+	"image/jpeg": true,
+	"image/png":  true,
+}
+
+// BuildImageMessage uploads the given image and returns a message that's ready to be sent,
+// including the dimensions and a JPEG thumbnail of the image.
+//
+// Only JPEG and PNG images are supported, other types return ErrUnsupportedMediaType.
+// The file size is limited by MaxMediaSize, and the dimensions by mediautil.MaxImagePixels.
+func (cli *Client) BuildImageMessage(ctx context.Context, data []byte, extra ...MediaMessageExtra) (*waProto.ImageMessage, error) {
+	req, err := getMediaMessageExtra(extra)
+	if err != nil {
+		return nil, err
+	} else if err = checkMediaSize(int64(len(data)), MaxMediaSize[MediaImage]); err != nil {
+		return nil, err
+	}
+	mimeType := req.getMimeType(data)
+	if !imageMessageTypes[mimeType] {
+		return nil, fmt.Errorf("%w: %s is not a JPEG or PNG image", ErrUnsupportedMediaType, mimeType)
+	}
+	thumbnail, width, height, err := mediautil.Thumbnail(data, MediaThumbnailSize)
+	if err != nil {
+		return nil, err
+	} else if req.Thumbnail != nil {
+		if thumbnail, _, _, err = mediautil.Thumbnail(req.Thumbnail, MediaThumbnailSize); err != nil {
+			return nil, fmt.Errorf("failed to generate thumbnail: %w", err)
+		}
+	}
+	resp, err := cli.Upload(ctx, data, MediaImage, req.Upload)
+	if err != nil {
+		return nil, err
+	}
+	return &waProto.ImageMessage{
+		Url:               proto.String(resp.URL),
+		DirectPath:        proto.String(resp.DirectPath),
+		MediaKey:          resp.MediaKey,
+		MediaKeyTimestamp: proto.Int64(time.Now().Unix()),
+		FileEncSha256:     resp.FileEncSHA256,
+		FileSha256:        resp.FileSHA256,
+		FileLength:        proto.Uint64(resp.FileLength),
+		Mimetype:          proto.String(mimeType),
+		Caption:           optionalString(req.Caption),
+		Width:             optionalUint32(width),
+		Height:            optionalUint32(height),
+		JpegThumbnail:     thumbnail,
+		ContextInfo:       req.ContextInfo,
+	}, nil
+}
+
+// BuildDocumentMessage uploads the given file and returns a document message that's ready to be sent.
+//
+// The file name should be set in the extra parameter, as it's shown to the recipient.
+// The page count is filled automatically for PDF files. The file size is limited by MaxMediaSize.
+func (cli *Client) BuildDocumentMessage(ctx context.Context, data []byte, extra ...MediaMessageExtra) (*waProto.DocumentMessage, error) {
+	req, err := getMediaMessageExtra(extra)
+	if err != nil {
+		return nil, err
+	}
+	return cli.buildDocumentMessage(ctx, bytes.NewReader(data), int64(len(data)), req)
+}
+
+// buildDocumentMessage builds a document message from a file that's streamed while uploading,
+// so that large documents don't need to be copied in memory.
+func (cli *Client) buildDocumentMessage(ctx context.Context, file mediaReader, size int64, req MediaMessageExtra) (*waProto.DocumentMessage, error) {
+	if err := checkMediaSize(size, MaxMediaSize[MediaDocument]); err != nil {
+		return nil, err
+	}
+	prefix, err := readMediaPrefix(file, size)
+	if err != nil {
+		return nil, err
+	}
+	mimeType := req.getMimeType(prefix)
+	var pageCount int
+	if mimeType == "application/pdf" {
+		pageCount, err = mediautil.PDFPageCountReaderAt(file, size)
+		if err != nil {
+			cli.Log.Debugf("Failed to get page count of %s: %v", req.FileName, err)
+		}
+	}
+	var thumbnail []byte
+	var thumbWidth, thumbHeight int
+	if req.Thumbnail != nil {
+		if thumbnail, _, _, err = mediautil.Thumbnail(req.Thumbnail, MediaThumbnailSize); err != nil {
+			return nil, fmt.Errorf("failed to generate thumbnail: %w", err)
+		}
+		thumbWidth, thumbHeight, _ = mediautil.ImageSize(thumbnail)
+	}
+	resp, err := cli.UploadReader(ctx, file, size, MediaDocument, req.Upload)
+	if err != nil {
+		return nil, err
+	}
+	return &waProto.DocumentMessage{
+		Url:               proto.String(resp.URL),
+		DirectPath:        proto.String(resp.DirectPath),
+		MediaKey:          resp.MediaKey,
+		MediaKeyTimestamp: proto.Int64(time.Now().Unix()),
+		FileEncSha256:     resp.FileEncSHA256,
+		FileSha256:        resp.FileSHA256,
+		FileLength:        proto.Uint64(resp.FileLength),
+		Mimetype:          proto.String(mimeType),
+		FileName:          optionalString(req.FileName),
+		Title:             optionalString(req.FileName),
+		Caption:           optionalString(req.Caption),
+		PageCount:         optionalUint32(pageCount),
+		JpegThumbnail:     thumbnail,
+		ThumbnailWidth:    optionalUint32(thumbWidth),
+		ThumbnailHeight:   optionalUint32(thumbHeight),
+		ContextInfo:       req.ContextInfo,
+	}, nil
+}
+
+// BuildAudioMessage uploads the given audio file and returns a message that's ready to be sent.
+//
+// The duration is filled automatically for MP4 (M4A) and Ogg Opus files. The file size is limited by MaxMediaSize.
+// To send a voice message instead of an audio file, use BuildVoiceNoteMessage.
+func (cli *Client) BuildAudioMessage(ctx context.Context, data []byte, extra ...MediaMessageExtra) (*waProto.AudioMessage, error) {
+	req, err := getMediaMessageExtra(extra)
+	if err != nil {
+		return nil, err
+	} else if err = checkMediaSize(int64(len(data)), MaxMediaSize[MediaAudio]); err != nil {
+		return nil, err
+	}
+	mimeType := req.getMimeType(data)
+	if !strings.HasPrefix(mimeType, "audio/") {
+		return nil, fmt.Errorf("%w: %s is not audio", ErrUnsupportedMediaType, mimeType)
+	}
+	var duration time.Duration
+	switch mimeType {
+	case "audio/mp4":
+		if info, err := mediautil.ParseMP4(data); err != nil {
+			cli.Log.Debugf("Failed to parse MP4 audio: %v", err)
+		} else {
+			duration = info.Duration
+		}
+	case "audio/ogg; codecs=opus":
+		if info, err := mediautil.ParseOggOpus(data); err != nil {
+			cli.Log.Debugf("Failed to parse Ogg Opus audio: %v", err)
+		} else {
+			duration = info.Duration
+		}
+	}
+	resp, err := cli.Upload(ctx, data, MediaAudio, req.Upload)
+	if err != nil {
+		return nil, err
+	}
+	return &waProto.AudioMessage{
+		Url:               proto.String(resp.URL),
+		DirectPath:        proto.String(resp.DirectPath),
+		MediaKey:          resp.MediaKey,
+		MediaKeyTimestamp: proto.Int64(time.Now().Unix()),
+		FileEncSha256:     resp.FileEncSHA256,
+		FileSha256:        resp.FileSHA256,
+		FileLength:        proto.Uint64(resp.FileLength),
+		Mimetype:          proto.String(mimeType),
+		Seconds:           optionalUint32(int(duration.Round(time.Second) / time.Second)),
+		StreamingSidecar:  resp.StreamingSidecar,
+		ContextInfo:       req.ContextInfo,
+	}, nil
+}
+
+// BuildVoiceNoteMessage uploads the given Ogg Opus file and returns a voice message (push-to-talk audio)
+// that's ready to be sent, including the duration and waveform.
+//
+// Voice messages must be Opus audio in an Ogg container, other formats will return ErrUnsupportedMediaType.
+// To render the waveform of received voice messages, use mediautil.DecodeWaveform.
+func (cli *Client) BuildVoiceNoteMessage(ctx context.Context, data []byte, extra ...MediaMessageExtra) (*waProto.AudioMessage, error) {
+	req, err := getMediaMessageExtra(extra)
+	if err != nil {
+		return nil, err
+	} else if err = checkMediaSize(int64(len(data)), MaxMediaSize[MediaAudio]); err != nil {
+		return nil, err
+	}
+	info, err := mediautil.ParseOggOpus(data)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrUnsupportedMediaType, err)
+	}
+	resp, err := cli.Upload(ctx, data, MediaAudio, req.Upload)
+	if err != nil {
+		return nil, err
+	}
+	return &waProto.AudioMessage{
+		Url:               proto.String(resp.URL),
+		DirectPath:        proto.String(resp.DirectPath),
+		MediaKey:          resp.MediaKey,
+		MediaKeyTimestamp: proto.Int64(time.Now().Unix()),
+		FileEncSha256:     resp.FileEncSHA256,
+		FileSha256:        resp.FileSHA256,
+		FileLength:        proto.Uint64(resp.FileLength),
+		Mimetype:          proto.String("audio/ogg; codecs=opus"),
+		Ptt:               proto.Bool(true),
+		Seconds:           optionalUint32(int(info.Duration.Round(time.Second) / time.Second)),
+		Waveform:          info.Waveform,
+		StreamingSidecar:  resp.StreamingSidecar,
+		ContextInfo:       req.ContextInfo,
+	}, nil
+}
+
+// BuildVideoMessage uploads the given video and returns a message that's ready to be sent.
+//
+// The duration and dimensions are filled automatically for MP4 files. Thumbnails can't be generated from
+// videos in pure Go, so the thumbnail is only included if a frame of the video is passed in the extra parameter.
+// The file size is limited by MaxMediaSize.
+func (cli *Client) BuildVideoMessage(ctx context.Context, data []byte, extra ...MediaMessageExtra) (*waProto.VideoMessage, error) {
+	req, err := getMediaMessageExtra(extra)
+	if err != nil {
+		return nil, err
+	}
+	return cli.buildVideoMessage(ctx, bytes.NewReader(data), int64(len(data)), req)
+}
+
+// buildVideoMessage builds a video message from a file that's streamed while uploading.
+// Only the moov box is read into memory for the metadata.
+func (cli *Client) buildVideoMessage(ctx context.Context, file mediaReader, size int64, req MediaMessageExtra) (*waProto.VideoMessage, error) {
+	if err := checkMediaSize(size, MaxMediaSize[MediaVideo]); err != nil {
+		return nil, err
+	}
+	prefix, err := readMediaPrefix(file, size)
+	if err != nil {
+		return nil, err
+	}
+	mimeType := req.getMimeType(prefix)
+	if !strings.HasPrefix(mimeType, "video/") {
+		return nil, fmt.Errorf("%w: %s is not a video", ErrUnsupportedMediaType, mimeType)
+	}
+	var info mediautil.MP4Info
+	if mimeType == "video/mp4" || mimeType == "video/quicktime" {
+		if parsed, err := mediautil.ParseMP4ReaderAt(file, size); err != nil {
+			cli.Log.Debugf("Failed to parse MP4 video: %v", err)
+		} else {
+			info = *parsed
+		}
+	}
+	var thumbnail []byte
+	if req.Thumbnail != nil {
+		if thumbnail, _, _, err = mediautil.Thumbnail(req.Thumbnail, MediaThumbnailSize); err != nil {
+			return nil, fmt.Errorf("failed to generate thumbnail: %w", err)
+		}
+	}
+	resp, err := cli.UploadReader(ctx, file, size, MediaVideo, req.Upload)
+	if err != nil {
+		return nil, err
+	}
+	return &waProto.VideoMessage{
+		Url:               proto.String(resp.URL),
+		DirectPath:        proto.String(resp.DirectPath),
+		MediaKey:          resp.MediaKey,
+		MediaKeyTimestamp: proto.Int64(time.Now().Unix()),
+		FileEncSha256:     resp.FileEncSHA256,
+		FileSha256:        resp.FileSHA256,
+		FileLength:        proto.Uint64(resp.FileLength),
+		Mimetype:          proto.String(mimeType),
+		Caption:           optionalString(req.Caption),
+		Seconds:           optionalUint32(int(info.Duration.Round(time.Second) / time.Second)),
+		Width:             optionalUint32(info.Width),
+		Height:            optionalUint32(info.Height),
+		JpegThumbnail:     thumbnail,
+		StreamingSidecar:  resp.StreamingSidecar,
+		ContextInfo:       req.ContextInfo,
+	}, nil
+}
+
+// BuildStickerMessage converts the given image into a sticker, uploads it and returns a sticker message
+// that's ready to be sent.
+//
+// JPEG, PNG, GIF and static WebP images are scaled to fit 512x512 and converted to WebP (see mediautil.ConvertToSticker).
+// Animated stickers must already be 512x512 WebP files. If StickerPack is set in the extra parameter,
+// the pack metadata is embedded into the file. The final file size is limited by MaxStickerSize.
+func (cli *Client) BuildStickerMessage(ctx context.Context, data []byte, extra ...MediaMessageExtra) (*waProto.StickerMessage, error) {
+	req, err := getMediaMessageExtra(extra)
+	if err != nil {
+		return nil, err
+	} else if err = checkMediaSize(int64(len(data)), MaxMediaSize[MediaImage]); err != nil {
+		return nil, err
+	} else if mimeType := req.getMimeType(data); !strings.HasPrefix(mimeType, "image/") {
+		return nil, fmt.Errorf("%w: %s is not an image", ErrUnsupportedMediaType, mimeType)
+	}
+	if data, err = mediautil.ConvertToSticker(data); err != nil {
+		return nil, fmt.Errorf("failed to convert sticker: %w", err)
+	}
+	if req.StickerPack != nil {
+		var exif []byte
+		if exif, err = req.StickerPack.EXIF(); err != nil {
+			return nil, fmt.Errorf("failed to encode sticker pack metadata: %w", err)
+		} else if data, err = mediautil.SetWebPEXIF(data, exif); err != nil {
+			return nil, fmt.Errorf("failed to add sticker pack metadata: %w", err)
+		}
+	}
+	if err = checkMediaSize(int64(len(data)), MaxStickerSize); err != nil {
+		return nil, err
+	}
+	resp, err := cli.Upload(ctx, data, MediaImage, req.Upload)
+	if err != nil {
+		return nil, err
+	}
+	return &waProto.StickerMessage{
+		Url:               proto.String(resp.URL),
+		DirectPath:        proto.String(resp.DirectPath),
+		MediaKey:          resp.MediaKey,
+		MediaKeyTimestamp: proto.Int64(time.Now().Unix()),
+		FileEncSha256:     resp.FileEncSHA256,
+		FileSha256:        resp.FileSHA256,
+		FileLength:        proto.Uint64(resp.FileLength),
+		Mimetype:          proto.String("image/webp"),
+		Width:             proto.Uint32(mediautil.StickerSize),
+		Height:            proto.Uint32(mediautil.StickerSize),
+		IsAnimated:        proto.Bool(mediautil.IsAnimatedWebP(data)),
+		ContextInfo:       req.ContextInfo,
+	}, nil
+}
+
+// BuildMediaMessageFromFile builds a media message of the appropriate type from the given file based on the
+// MIME type: WebP images become stickers, JPEG and PNG images, videos and audio files become the corresponding
+// message types, and everything else (including GIFs) is sent as a document.
+//
+// Videos and documents are streamed from the file while uploading, and only the parts needed for the metadata
+// are read into memory. Images, stickers and audio files are read fully, as generating thumbnails and waveforms
+// requires the whole file, but their size is checked against MaxMediaSize before reading.
+//
+// If the extra parameter doesn't specify a file name, the base name of the path is used.
+func (cli *Client) BuildMediaMessageFromFile(ctx context.Context, path string, extra ...MediaMessageExtra) (*waProto.Message, error) {
+	req, err := getMediaMessageExtra(extra)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file: %w", err)
+	}
+	defer file.Close()
+	stat, err := file.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat file: %w", err)
+	}
+	size := stat.Size()
+	if len(req.FileName) == 0 {
+		req.FileName = filepath.Base(path)
+	}
+	if len(req.MimeType) == 0 {
+		prefix, err := readMediaPrefix(file, size)
+		if err != nil {
+			return nil, err
+		}
+		req.MimeType = req.getMimeType(prefix)
+	}
+	readFile := func(mediaType MediaType) ([]byte, error) {
+		if err := checkMediaSize(size, MaxMediaSize[mediaType]); err != nil {
+			return nil, err
+		}
+		data, err := io.ReadAll(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read file: %w", err)
+		}
+		return data, nil
+	}
+	var msg waProto.Message
+	var data []byte
+	switch {
+	case req.MimeType == "image/webp":
+		if data, err = readFile(MediaImage); err == nil {
+			msg.StickerMessage, err = cli.BuildStickerMessage(ctx, data, req)
+		}
+	case imageMessageTypes[req.MimeType]:
+		if data, err = readFile(MediaImage); err == nil {
+			msg.ImageMessage, err = cli.BuildImageMessage(ctx, data, req)
+		}
+	case strings.HasPrefix(req.MimeType, "audio/"):
+		if data, err = readFile(MediaAudio); err == nil {
+			msg.AudioMessage, err = cli.BuildAudioMessage(ctx, data, req)
+		}
+	case strings.HasPrefix(req.MimeType, "video/"):
+		msg.VideoMessage, err = cli.buildVideoMessage(ctx, file, size, req)
+	default:
+		msg.DocumentMessage, err = cli.buildDocumentMessage(ctx, file, size, req)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &msg, nil
+}
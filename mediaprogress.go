@@ -0,0 +1,49 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package whatsmeow
+
+import (
+	"io"
+)
+
+// MediaProgressFunc is called during media uploads and downloads after each chunk of data is transferred.
+//
+// The total is the size of the encrypted file, or -1 if it's not known (e.g. if the server didn't send a
+// Content-Length header). If the transfer is retried with another host, the progress starts again from zero,
+// but downloads that are resumed after a network error continue from where they left off.
+//
+// The function is called synchronously from the transfer loop, so it should return quickly.
+type MediaProgressFunc func(transferred, total int64)
+
+// progressReader calls a MediaProgressFunc whenever data is read through it.
+type progressReader struct {
+	io.ReadCloser
+	progress    MediaProgressFunc
+	transferred int64
+	total       int64
+}
+
+func withProgress(body io.ReadCloser, total int64, progress MediaProgressFunc) io.ReadCloser {
+	return withProgressFrom(body, 0, total, progress)
+}
+
+// withProgressFrom is like withProgress, but for transfers that are resumed at the given offset.
+func withProgressFrom(body io.ReadCloser, transferred, total int64, progress MediaProgressFunc) io.ReadCloser {
+	if progress == nil {
+		return body
+	}
+	return &progressReader{ReadCloser: body, progress: progress, transferred: transferred, total: total}
+}
+
+func (pr *progressReader) Read(p []byte) (n int, err error) {
+	n, err = pr.ReadCloser.Read(p)
+	if n > 0 {
+		pr.transferred += int64(n)
+		pr.progress(pr.transferred, pr.total)
+	}
+	return
+}
@@ -7,7 +7,10 @@
 package whatsmeow
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"time"
 
 	"github.com/go-whatsapp/go-util/random"
 	"google.golang.org/protobuf/proto"
@@ -42,6 +45,8 @@ func encryptMediaRetryReceipt(messageID types.MessageID, mediaKey []byte) (ciphe
 // SendMediaRetryReceipt sends a request to the phone to re-upload the media in a message.
 //
 // This is mostly relevant when handling history syncs and getting a 404 or 410 error downloading media.
+// DownloadWithMediaRetry can be used to do the whole round trip automatically.
+//
 // Rough example on how to use it (will not work out of the box, you must adjust it depending on what you need exactly):
 //
 //	var mediaRetryCache map[types.MessageID]*waProto.ImageMessage
@@ -74,6 +79,14 @@ func encryptMediaRetryReceipt(messageID types.MessageID, mediaKey []byte) (ciphe
 //	  }
 //	}
 func (cli *Client) SendMediaRetryReceipt(message *types.MessageInfo, mediaKey []byte) error {
+	return cli.SendMediaRetryReceiptContext(context.Background(), message, mediaKey)
+}
+
+// SendMediaRetryReceiptContext is like SendMediaRetryReceipt, but takes a context for cancellation and deadlines.
+func (cli *Client) SendMediaRetryReceiptContext(ctx context.Context, message *types.MessageInfo, mediaKey []byte) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 	ciphertext, iv, err := encryptMediaRetryReceipt(message.ID, mediaKey)
 	if err != nil {
 		return fmt.Errorf("failed to prepare encrypted retry receipt: %w", err)
@@ -177,5 +190,99 @@ func (cli *Client) handleMediaRetryNotification(node *waBinary.Node) {
 		cli.Log.Warnf("Failed to parse media retry notification: %v", err)
 		return
 	}
+	cli.mediaRetryWaitersLock.Lock()
+	for _, waiter := range cli.mediaRetryWaiters[evt.MessageID] {
+		select {
+		case waiter <- evt:
+		default:
+		}
+	}
+	cli.mediaRetryWaitersLock.Unlock()
 	cli.dispatchEvent(evt)
 }
+
+func (cli *Client) addMediaRetryWaiter(id types.MessageID) chan *events.MediaRetry {
+	waiter := make(chan *events.MediaRetry, 1)
+	cli.mediaRetryWaitersLock.Lock()
+	cli.mediaRetryWaiters[id] = append(cli.mediaRetryWaiters[id], waiter)
+	cli.mediaRetryWaitersLock.Unlock()
+	return waiter
+}
+
+func (cli *Client) removeMediaRetryWaiter(id types.MessageID, waiter chan *events.MediaRetry) {
+	cli.mediaRetryWaitersLock.Lock()
+	defer cli.mediaRetryWaitersLock.Unlock()
+	waiters := cli.mediaRetryWaiters[id]
+	for i, existing := range waiters {
+		if existing == waiter {
+			waiters = append(waiters[:i], waiters[i+1:]...)
+			break
+		}
+	}
+	if len(waiters) == 0 {
+		delete(cli.mediaRetryWaiters, id)
+	} else {
+		cli.mediaRetryWaiters[id] = waiters
+	}
+}
+
+// MediaRetryTimeout is the default time that DownloadWithMediaRetry waits for the phone to respond to the
+// media retry request if the context doesn't have a deadline.
+var MediaRetryTimeout = 1 * time.Minute
+
+// DownloadWithMediaRetry downloads the given attachment like DownloadContext, but if the download fails with
+// a 404 or 410 error (i.e. the media has expired from the servers), it automatically asks the phone to re-upload
+// the media using SendMediaRetryReceipt, waits for the response and downloads the file from the new path.
+//
+// The message info must be the info of the message that contains the attachment. If the phone responds with an
+// error, a MediaRetryResultError (e.g. ErrMediaRetryNotFound) or ErrMediaNotAvailableOnPhone is returned.
+// If the context has no deadline, MediaRetryTimeout is used as the timeout for waiting for the response.
+//
+// The new direct path is not stored in the given message. Use DownloadWithMediaRetry again for later downloads,
+// or listen for *events.MediaRetry and use DecryptMediaRetryNotification if you need the new path.
+func (cli *Client) DownloadWithMediaRetry(ctx context.Context, info *types.MessageInfo, msg DownloadableMessage, extra ...DownloadExtra) ([]byte, error) {
+	data, err := cli.DownloadContext(ctx, msg, extra...)
+	if !errors.Is(err, ErrMediaDownloadFailedWith404) && !errors.Is(err, ErrMediaDownloadFailedWith410) {
+		return data, err
+	}
+	cli.Log.Debugf("Got %v while downloading media in %s, requesting media retry from phone", err, info.ID)
+	directPath, err := cli.requestMediaRetry(ctx, info, msg.GetMediaKey())
+	if err != nil {
+		return nil, err
+	}
+	mediaType := GetMediaType(msg)
+	return cli.DownloadMediaWithPathContext(ctx, directPath, msg.GetFileEncSha256(), msg.GetFileSha256(), msg.GetMediaKey(), getSize(msg), mediaType, mediaTypeToMMSType[mediaType], extra...)
+}
+
+// requestMediaRetry sends a media retry receipt and waits for the phone to respond with the new direct path.
+func (cli *Client) requestMediaRetry(ctx context.Context, info *types.MessageInfo, mediaKey []byte) (string, error) {
+	waiter := cli.addMediaRetryWaiter(info.ID)
+	defer cli.removeMediaRetryWaiter(info.ID, waiter)
+	err := cli.SendMediaRetryReceiptContext(ctx, info, mediaKey)
+	if err != nil {
+		return "", fmt.Errorf("failed to send media retry receipt: %w", err)
+	}
+	var timeoutChan <-chan time.Time
+	if _, hasDeadline := ctx.Deadline(); !hasDeadline {
+		timer := time.NewTimer(MediaRetryTimeout)
+		defer timer.Stop()
+		timeoutChan = timer.C
+	}
+	var evt *events.MediaRetry
+	select {
+	case evt = <-waiter:
+	case <-ctx.Done():
+		return "", ctx.Err()
+	case <-timeoutChan:
+		return "", ErrMediaRetryTimeout
+	}
+	notif, err := DecryptMediaRetryNotification(evt, mediaKey)
+	if err != nil {
+		return "", err
+	} else if notif.GetResult() != waProto.MediaRetryNotification_SUCCESS {
+		return "", MediaRetryResultError{Result: notif.GetResult()}
+	} else if len(notif.GetDirectPath()) == 0 {
+		return "", fmt.Errorf("media retry notification didn't contain a direct path")
+	}
+	return notif.GetDirectPath(), nil
+}
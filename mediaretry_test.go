@@ -0,0 +1,121 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package whatsmeow
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/go-whatsapp/go-util/random"
+	"google.golang.org/protobuf/proto"
+
+	waBinary "github.com/go-whatsapp/whatsmeow/binary"
+	waProto "github.com/go-whatsapp/whatsmeow/binary/proto"
+	"github.com/go-whatsapp/whatsmeow/types"
+	"github.com/go-whatsapp/whatsmeow/util/gcmutil"
+	waLog "github.com/go-whatsapp/whatsmeow/util/log"
+)
+
+func mediaRetryNotificationNode(t *testing.T, messageID types.MessageID, mediaKey []byte, notif *waProto.MediaRetryNotification) *waBinary.Node {
+	t.Helper()
+	plaintext, err := proto.Marshal(notif)
+	if err != nil {
+		t.Fatal(err)
+	}
+	iv := random.Bytes(12)
+	ciphertext, err := gcmutil.Encrypt(getMediaRetryKey(mediaKey), iv, plaintext, []byte(messageID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &waBinary.Node{
+		Tag:   "notification",
+		Attrs: waBinary.Attrs{"id": messageID, "t": "1700000000", "type": "mediaretry"},
+		Content: []waBinary.Node{
+			{Tag: "encrypt", Content: []waBinary.Node{
+				{Tag: "enc_p", Content: ciphertext},
+				{Tag: "enc_iv", Content: iv},
+			}},
+			{Tag: "rmr", Attrs: waBinary.Attrs{"jid": types.NewJID("1", types.DefaultUserServer), "from_me": "true"}},
+		},
+	}
+}
+
+func TestMediaRetryNotification(t *testing.T) {
+	mediaKey := random.Bytes(32)
+	node := mediaRetryNotificationNode(t, "ABCD", mediaKey, &waProto.MediaRetryNotification{
+		StanzaId:   proto.String("ABCD"),
+		DirectPath: proto.String("/v/t62.7119-24/new"),
+		Result:     waProto.MediaRetryNotification_SUCCESS.Enum(),
+	})
+	evt, err := parseMediaRetryNotification(node)
+	if err != nil {
+		t.Fatal(err)
+	} else if evt.MessageID != "ABCD" || !evt.FromMe || evt.ChatID.User != "1" {
+		t.Fatalf("unexpected event %+v", evt)
+	}
+	if notif, err := DecryptMediaRetryNotification(evt, mediaKey); err != nil {
+		t.Fatal(err)
+	} else if notif.GetDirectPath() != "/v/t62.7119-24/new" {
+		t.Fatalf("unexpected direct path %q", notif.GetDirectPath())
+	}
+	if _, err = DecryptMediaRetryNotification(evt, random.Bytes(32)); err == nil {
+		t.Fatal("decrypting with wrong key succeeded")
+	}
+
+	errorNode := &waBinary.Node{
+		Tag:   "notification",
+		Attrs: waBinary.Attrs{"id": "ABCD", "t": "1700000000"},
+		Content: []waBinary.Node{
+			{Tag: "rmr", Attrs: waBinary.Attrs{"jid": types.NewJID("1", types.DefaultUserServer), "from_me": "true"}},
+			{Tag: "error", Attrs: waBinary.Attrs{"code": "2"}},
+		},
+	}
+	if evt, err = parseMediaRetryNotification(errorNode); err != nil {
+		t.Fatal(err)
+	} else if _, err = DecryptMediaRetryNotification(evt, mediaKey); !errors.Is(err, ErrMediaNotAvailableOnPhone) {
+		t.Fatalf("expected ErrMediaNotAvailableOnPhone, got %v", err)
+	}
+
+	if !errors.Is(MediaRetryResultError{Result: waProto.MediaRetryNotification_NOT_FOUND}, ErrMediaRetryNotFound) ||
+		errors.Is(ErrMediaRetryNotFound, ErrMediaRetryGeneralError) {
+		t.Fatal("MediaRetryResultError.Is doesn't compare result codes")
+	}
+}
+
+func TestMediaRetryWaiters(t *testing.T) {
+	cli := NewClient(newTestDevice(t), waLog.Noop)
+	mediaKey := random.Bytes(32)
+	waiter1, waiter2 := cli.addMediaRetryWaiter("ABCD"), cli.addMediaRetryWaiter("ABCD")
+	otherWaiter := cli.addMediaRetryWaiter("EFGH")
+	cli.removeMediaRetryWaiter("ABCD", waiter2)
+
+	cli.handleMediaRetryNotification(mediaRetryNotificationNode(t, "ABCD", mediaKey, &waProto.MediaRetryNotification{
+		Result: waProto.MediaRetryNotification_NOT_FOUND.Enum(),
+	}))
+	select {
+	case evt := <-waiter1:
+		if evt.MessageID != "ABCD" {
+			t.Fatalf("unexpected event for %s", evt.MessageID)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("waiter didn't receive notification")
+	}
+	select {
+	case <-waiter2:
+		t.Fatal("removed waiter received notification")
+	case <-otherWaiter:
+		t.Fatal("waiter for other message received notification")
+	default:
+	}
+
+	cli.removeMediaRetryWaiter("ABCD", waiter1)
+	cli.removeMediaRetryWaiter("EFGH", otherWaiter)
+	if len(cli.mediaRetryWaiters) != 0 {
+		t.Fatalf("%d media retry waiter lists leaked", len(cli.mediaRetryWaiters))
+	}
+}
@@ -41,6 +41,11 @@ func (cli *Client) NewsletterSubscribeLiveUpdates(ctx context.Context, jid types
 //
 // This is not the same as marking the channel as read on your other devices, use the usual MarkRead function for that.
 func (cli *Client) NewsletterMarkViewed(jid types.JID, serverIDs []types.MessageServerID) error {
+	return cli.NewsletterMarkViewedContext(context.Background(), jid, serverIDs)
+}
+
+// NewsletterMarkViewedContext is like NewsletterMarkViewed, but takes a context for cancellation and deadlines.
+func (cli *Client) NewsletterMarkViewedContext(ctx context.Context, jid types.JID, serverIDs []types.MessageServerID) error {
 	items := make([]waBinary.Node, len(serverIDs))
 	for i, id := range serverIDs {
 		items[i] = waBinary.Node{
@@ -69,8 +74,13 @@ func (cli *Client) NewsletterMarkViewed(jid types.JID, serverIDs []types.Message
 		return err
 	}
 	// TODO handle response?
-	<-resp
-	return nil
+	select {
+	case <-resp:
+		return nil
+	case <-ctx.Done():
+		cli.cancelResponse(reqID, resp)
+		return ctx.Err()
+	}
 }
 
 // NewsletterSendReaction sends a reaction to a channel message.
@@ -78,6 +88,14 @@ func (cli *Client) NewsletterMarkViewed(jid types.JID, serverIDs []types.Message
 //
 // The last parameter is the message ID of the reaction itself. It can be left empty to let whatsmeow generate a random one.
 func (cli *Client) NewsletterSendReaction(jid types.JID, serverID types.MessageServerID, reaction string, messageID types.MessageID) error {
+	return cli.NewsletterSendReactionContext(context.Background(), jid, serverID, reaction, messageID)
+}
+
+// NewsletterSendReactionContext is like NewsletterSendReaction, but takes a context for cancellation and deadlines.
+func (cli *Client) NewsletterSendReactionContext(ctx context.Context, jid types.JID, serverID types.MessageServerID, reaction string, messageID types.MessageID) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 	if messageID == "" {
 		messageID = cli.GenerateMessageID()
 	}
@@ -163,8 +181,8 @@ type respGetNewsletterInfo struct {
 	Newsletter *types.NewsletterMetadata `json:"xwa2_newsletter"`
 }
 
-func (cli *Client) getNewsletterInfo(input map[string]any, fetchViewerMeta bool) (*types.NewsletterMetadata, error) {
-	data, err := cli.sendMexIQ(context.TODO(), queryFetchNewsletter, map[string]any{
+func (cli *Client) getNewsletterInfo(ctx context.Context, input map[string]any, fetchViewerMeta bool) (*types.NewsletterMetadata, error) {
+	data, err := cli.sendMexIQ(ctx, queryFetchNewsletter, map[string]any{
 		"fetch_creation_time":   true,
 		"fetch_full_image":      true,
 		"fetch_viewer_metadata": fetchViewerMeta,
@@ -182,7 +200,12 @@ func (cli *Client) getNewsletterInfo(input map[string]any, fetchViewerMeta bool)
 
 // GetNewsletterInfo gets the info of a newsletter that you're joined to.
 func (cli *Client) GetNewsletterInfo(jid types.JID) (*types.NewsletterMetadata, error) {
-	return cli.getNewsletterInfo(map[string]any{
+	return cli.GetNewsletterInfoContext(context.Background(), jid)
+}
+
+// GetNewsletterInfoContext is like GetNewsletterInfo, but takes a context for cancellation and deadlines.
+func (cli *Client) GetNewsletterInfoContext(ctx context.Context, jid types.JID) (*types.NewsletterMetadata, error) {
+	return cli.getNewsletterInfo(ctx, map[string]any{
 		"key":  jid.String(),
 		"type": types.NewsletterKeyTypeJID,
 	}, true)
@@ -194,7 +217,12 @@ func (cli *Client) GetNewsletterInfo(jid types.JID) (*types.NewsletterMetadata,
 //
 // Note that the ViewerMeta field of the returned NewsletterMetadata will be nil.
 func (cli *Client) GetNewsletterInfoWithInvite(key string) (*types.NewsletterMetadata, error) {
-	return cli.getNewsletterInfo(map[string]any{
+	return cli.GetNewsletterInfoWithInviteContext(context.Background(), key)
+}
+
+// GetNewsletterInfoWithInviteContext is like GetNewsletterInfoWithInvite, but takes a context for cancellation and deadlines.
+func (cli *Client) GetNewsletterInfoWithInviteContext(ctx context.Context, key string) (*types.NewsletterMetadata, error) {
+	return cli.getNewsletterInfo(ctx, map[string]any{
 		"key":  strings.TrimPrefix(key, NewsletterLinkPrefix),
 		"type": types.NewsletterKeyTypeInvite,
 	}, false)
@@ -206,7 +234,12 @@ type respGetSubscribedNewsletters struct {
 
 // GetSubscribedNewsletters gets the info of all newsletters that you're joined to.
 func (cli *Client) GetSubscribedNewsletters() ([]*types.NewsletterMetadata, error) {
-	data, err := cli.sendMexIQ(context.TODO(), querySubscribedNewsletters, map[string]any{})
+	return cli.GetSubscribedNewslettersContext(context.Background())
+}
+
+// GetSubscribedNewslettersContext is like GetSubscribedNewsletters, but takes a context for cancellation and deadlines.
+func (cli *Client) GetSubscribedNewslettersContext(ctx context.Context) ([]*types.NewsletterMetadata, error) {
+	data, err := cli.sendMexIQ(ctx, querySubscribedNewsletters, map[string]any{})
 	var respData respGetSubscribedNewsletters
 	if data != nil {
 		jsonErr := json.Unmarshal(data, &respData)
@@ -229,7 +262,12 @@ type respCreateNewsletter struct {
 
 // CreateNewsletter creates a new WhatsApp channel.
 func (cli *Client) CreateNewsletter(params CreateNewsletterParams) (*types.NewsletterMetadata, error) {
-	resp, err := cli.sendMexIQ(context.TODO(), mutationCreateNewsletter, map[string]any{
+	return cli.CreateNewsletterContext(context.Background(), params)
+}
+
+// CreateNewsletterContext is like CreateNewsletter, but takes a context for cancellation and deadlines.
+func (cli *Client) CreateNewsletterContext(ctx context.Context, params CreateNewsletterParams) (*types.NewsletterMetadata, error) {
+	resp, err := cli.sendMexIQ(ctx, mutationCreateNewsletter, map[string]any{
 		"newsletter_input": &params,
 	})
 	if err != nil {
@@ -249,7 +287,13 @@ func (cli *Client) CreateNewsletter(params CreateNewsletterParams) (*types.Newsl
 //
 //	cli.AcceptTOSNotice("20601218", "5")
 func (cli *Client) AcceptTOSNotice(noticeID, stage string) error {
+	return cli.AcceptTOSNoticeContext(context.Background(), noticeID, stage)
+}
+
+// AcceptTOSNoticeContext is like AcceptTOSNotice, but takes a context for cancellation and deadlines.
+func (cli *Client) AcceptTOSNoticeContext(ctx context.Context, noticeID, stage string) error {
 	_, err := cli.sendIQ(infoQuery{
+		Context:   ctx,
 		Namespace: "tos",
 		Type:      iqSet,
 		To:        types.ServerJID,
@@ -266,11 +310,16 @@ func (cli *Client) AcceptTOSNotice(noticeID, stage string) error {
 
 // NewsletterToggleMute changes the mute status of a newsletter.
 func (cli *Client) NewsletterToggleMute(jid types.JID, mute bool) error {
+	return cli.NewsletterToggleMuteContext(context.Background(), jid, mute)
+}
+
+// NewsletterToggleMuteContext is like NewsletterToggleMute, but takes a context for cancellation and deadlines.
+func (cli *Client) NewsletterToggleMuteContext(ctx context.Context, jid types.JID, mute bool) error {
 	query := mutationUnmuteNewsletter
 	if mute {
 		query = mutationMuteNewsletter
 	}
-	_, err := cli.sendMexIQ(context.TODO(), query, map[string]any{
+	_, err := cli.sendMexIQ(ctx, query, map[string]any{
 		"newsletter_id": jid.String(),
 	})
 	return err
@@ -278,7 +327,12 @@ func (cli *Client) NewsletterToggleMute(jid types.JID, mute bool) error {
 
 // FollowNewsletter makes the user follow (join) a WhatsApp channel.
 func (cli *Client) FollowNewsletter(jid types.JID) error {
-	_, err := cli.sendMexIQ(context.TODO(), mutationFollowNewsletter, map[string]any{
+	return cli.FollowNewsletterContext(context.Background(), jid)
+}
+
+// FollowNewsletterContext is like FollowNewsletter, but takes a context for cancellation and deadlines.
+func (cli *Client) FollowNewsletterContext(ctx context.Context, jid types.JID) error {
+	_, err := cli.sendMexIQ(ctx, mutationFollowNewsletter, map[string]any{
 		"newsletter_id": jid.String(),
 	})
 	return err
@@ -286,7 +340,12 @@ func (cli *Client) FollowNewsletter(jid types.JID) error {
 
 // UnfollowNewsletter makes the user unfollow (leave) a WhatsApp channel.
 func (cli *Client) UnfollowNewsletter(jid types.JID) error {
-	_, err := cli.sendMexIQ(context.TODO(), mutationUnfollowNewsletter, map[string]any{
+	return cli.UnfollowNewsletterContext(context.Background(), jid)
+}
+
+// UnfollowNewsletterContext is like UnfollowNewsletter, but takes a context for cancellation and deadlines.
+func (cli *Client) UnfollowNewsletterContext(ctx context.Context, jid types.JID) error {
+	_, err := cli.sendMexIQ(ctx, mutationUnfollowNewsletter, map[string]any{
 		"newsletter_id": jid.String(),
 	})
 	return err
@@ -299,6 +358,11 @@ type GetNewsletterMessagesParams struct {
 
 // GetNewsletterMessages gets messages in a WhatsApp channel.
 func (cli *Client) GetNewsletterMessages(jid types.JID, params *GetNewsletterMessagesParams) ([]*types.NewsletterMessage, error) {
+	return cli.GetNewsletterMessagesContext(context.Background(), jid, params)
+}
+
+// GetNewsletterMessagesContext is like GetNewsletterMessages, but takes a context for cancellation and deadlines.
+func (cli *Client) GetNewsletterMessagesContext(ctx context.Context, jid types.JID, params *GetNewsletterMessagesParams) ([]*types.NewsletterMessage, error) {
 	attrs := waBinary.Attrs{
 		"type": "jid",
 		"jid":  jid,
@@ -319,7 +383,7 @@ func (cli *Client) GetNewsletterMessages(jid types.JID, params *GetNewsletterMes
 			Tag:   "messages",
 			Attrs: attrs,
 		}},
-		Context: context.TODO(),
+		Context: ctx,
 	})
 	if err != nil {
 		return nil, err
@@ -341,6 +405,11 @@ type GetNewsletterUpdatesParams struct {
 //
 // These are the same kind of updates that NewsletterSubscribeLiveUpdates triggers (reaction and view counts).
 func (cli *Client) GetNewsletterMessageUpdates(jid types.JID, params *GetNewsletterUpdatesParams) ([]*types.NewsletterMessage, error) {
+	return cli.GetNewsletterMessageUpdatesContext(context.Background(), jid, params)
+}
+
+// GetNewsletterMessageUpdatesContext is like GetNewsletterMessageUpdates, but takes a context for cancellation and deadlines.
+func (cli *Client) GetNewsletterMessageUpdatesContext(ctx context.Context, jid types.JID, params *GetNewsletterUpdatesParams) ([]*types.NewsletterMessage, error) {
 	attrs := waBinary.Attrs{}
 	if params != nil {
 		if params.Count != 0 {
@@ -361,7 +430,7 @@ func (cli *Client) GetNewsletterMessageUpdates(jid types.JID, params *GetNewslet
 			Tag:   "message_updates",
 			Attrs: attrs,
 		}},
-		Context: context.TODO(),
+		Context: ctx,
 	})
 	if err != nil {
 		return nil, err
@@ -7,6 +7,7 @@
 package whatsmeow
 
 import (
+	"context"
 	"fmt"
 	"sync/atomic"
 
@@ -62,6 +63,14 @@ func (cli *Client) handlePresence(node *waBinary.Node) {
 // You should call this at least once after connecting so that the server has your pushname.
 // Otherwise, other users will see "-" as the name.
 func (cli *Client) SendPresence(state types.Presence) error {
+	return cli.SendPresenceContext(context.Background(), state)
+}
+
+// SendPresenceContext is like SendPresence, but takes a context for cancellation and deadlines.
+func (cli *Client) SendPresenceContext(ctx context.Context, state types.Presence) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 	if len(cli.Store.PushName) == 0 {
 		return ErrNoPushName
 	}
@@ -88,6 +97,14 @@ func (cli *Client) SendPresence(state types.Presence) error {
 //
 //	cli.SendPresence(types.PresenceAvailable)
 func (cli *Client) SubscribePresence(jid types.JID) error {
+	return cli.SubscribePresenceContext(context.Background(), jid)
+}
+
+// SubscribePresenceContext is like SubscribePresence, but takes a context for cancellation and deadlines.
+func (cli *Client) SubscribePresenceContext(ctx context.Context, jid types.JID) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 	privacyToken, err := cli.Store.PrivacyTokens.GetPrivacyToken(jid)
 	if err != nil {
 		return fmt.Errorf("failed to get privacy token: %w", err)
@@ -118,6 +135,14 @@ func (cli *Client) SubscribePresence(jid types.JID) error {
 //
 // The media parameter can be set to indicate the user is recording media (like a voice message) rather than typing a text message.
 func (cli *Client) SendChatPresence(jid types.JID, state types.ChatPresence, media types.ChatPresenceMedia) error {
+	return cli.SendChatPresenceContext(context.Background(), jid, state, media)
+}
+
+// SendChatPresenceContext is like SendChatPresence, but takes a context for cancellation and deadlines.
+func (cli *Client) SendChatPresenceContext(ctx context.Context, jid types.JID, state types.ChatPresence, media types.ChatPresenceMedia) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 	ownID := cli.getOwnJID()
 	if ownID.IsEmpty() {
 		return ErrNotLoggedIn
@@ -0,0 +1,194 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package whatsmeow
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+
+	"github.com/go-whatsapp/whatsmeow/socket"
+	"github.com/go-whatsapp/whatsmeow/store/memstore"
+	waLog "github.com/go-whatsapp/whatsmeow/util/log"
+)
+
+var errTestDial = errors.New("test dialer refused connection")
+
+type recordingDialer struct {
+	lock  sync.Mutex
+	addrs []string
+}
+
+func (rd *recordingDialer) DialContext(_ context.Context, _, addr string) (net.Conn, error) {
+	rd.lock.Lock()
+	rd.addrs = append(rd.addrs, addr)
+	rd.lock.Unlock()
+	return nil, errTestDial
+}
+
+func (rd *recordingDialer) dialed() []string {
+	rd.lock.Lock()
+	defer rd.lock.Unlock()
+	return append([]string(nil), rd.addrs...)
+}
+
+type recordingProxy struct {
+	*httptest.Server
+	lock  sync.Mutex
+	hosts []string
+}
+
+// newRecordingProxy starts a HTTP server that records the target host of every proxied request and rejects them.
+func newRecordingProxy(t *testing.T) *recordingProxy {
+	rp := &recordingProxy{}
+	rp.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rp.lock.Lock()
+		rp.hosts = append(rp.hosts, r.Host)
+		rp.lock.Unlock()
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	t.Cleanup(rp.Close)
+	return rp
+}
+
+func (rp *recordingProxy) proxied() []string {
+	rp.lock.Lock()
+	defer rp.lock.Unlock()
+	return append([]string(nil), rp.hosts...)
+}
+
+func (rp *recordingProxy) url(t *testing.T) *url.URL {
+	parsed, err := url.Parse(rp.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return parsed
+}
+
+func newProxyTestClient() *Client {
+	cli := NewClient(memstore.New(waLog.Noop).NewDevice(), waLog.Noop)
+	cli.EnableAutoReconnect = false
+	return cli
+}
+
+func doMediaRequest(t *testing.T, cli *Client) {
+	req, err := http.NewRequest(http.MethodGet, "http://media.example.com/file", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := cli.http.Do(req)
+	if err == nil {
+		_ = resp.Body.Close()
+	}
+}
+
+func TestSetDialerSeparately(t *testing.T) {
+	cli := newProxyTestClient()
+	wsDialer := &recordingDialer{}
+	mediaDialer := &recordingDialer{}
+	cli.SetDialer(wsDialer, SetProxyOptions{NoMedia: true})
+	cli.SetDialer(mediaDialer, SetProxyOptions{NoWebsocket: true})
+
+	if err := cli.Connect(); !errors.Is(err, errTestDial) {
+		t.Fatalf("expected websocket connection to fail with test dialer error, got %v", err)
+	}
+	doMediaRequest(t, cli)
+
+	if addrs := wsDialer.dialed(); len(addrs) != 1 || addrs[0] != "web.whatsapp.com:443" {
+		t.Fatalf("unexpected websocket dialer calls %v", addrs)
+	} else if addrs = mediaDialer.dialed(); len(addrs) != 1 || addrs[0] != "media.example.com:80" {
+		t.Fatalf("unexpected media dialer calls %v", addrs)
+	}
+}
+
+func TestSetProxySeparately(t *testing.T) {
+	cli := newProxyTestClient()
+	wsProxy := newRecordingProxy(t)
+	mediaProxy := newRecordingProxy(t)
+	cli.SetProxy(http.ProxyURL(wsProxy.url(t)), SetProxyOptions{NoMedia: true})
+	cli.SetProxy(http.ProxyURL(mediaProxy.url(t)), SetProxyOptions{NoWebsocket: true})
+
+	if err := cli.Connect(); err == nil {
+		t.Fatal("websocket connection through rejecting proxy succeeded")
+	}
+	doMediaRequest(t, cli)
+
+	if hosts := wsProxy.proxied(); len(hosts) != 1 || hosts[0] != "web.whatsapp.com:443" {
+		t.Fatalf("unexpected websocket proxy requests %v", hosts)
+	} else if hosts = mediaProxy.proxied(); len(hosts) != 1 || hosts[0] != "media.example.com" {
+		t.Fatalf("unexpected media proxy requests %v", hosts)
+	}
+}
+
+func TestSetSOCKSProxyForMedia(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = listener.Close()
+	})
+	accepted := make(chan struct{}, 4)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			accepted <- struct{}{}
+			_ = conn.Close()
+		}
+	}()
+
+	cli := newProxyTestClient()
+	wsDialer := &recordingDialer{}
+	cli.SetDialer(wsDialer, SetProxyOptions{NoMedia: true})
+	if err = cli.SetProxyAddress("socks5://"+listener.Addr().String(), SetProxyOptions{NoWebsocket: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	doMediaRequest(t, cli)
+	select {
+	case <-accepted:
+	default:
+		t.Fatal("media request didn't connect to SOCKS5 proxy")
+	}
+	if err = cli.Connect(); !errors.Is(err, errTestDial) {
+		t.Fatalf("expected websocket connection to use its own dialer, got %v", err)
+	} else if len(accepted) != 0 {
+		t.Fatal("websocket connected to SOCKS5 proxy")
+	}
+}
+
+func TestTCPTransportUsesClientDialer(t *testing.T) {
+	cli := newProxyTestClient()
+	cli.SetProxy(nil)
+	dialer := &recordingDialer{}
+	cli.SetDialer(dialer)
+	transport := socket.NewTCPTransport(waLog.Noop, "")
+	cli.Transport = transport
+
+	if err := cli.Connect(); !errors.Is(err, errTestDial) {
+		t.Fatalf("expected TCP connection to fail with test dialer error, got %v", err)
+	} else if addrs := dialer.dialed(); len(addrs) != 1 || addrs[0] != socket.TCPAddress {
+		t.Fatalf("unexpected dialer calls %v", addrs)
+	} else if transport.Dialer != nil {
+		t.Fatal("connecting modified the transport set by the caller")
+	}
+
+	cli.SetProxy(http.ProxyURL(newRecordingProxy(t).url(t)))
+	if err := cli.Connect(); !errors.Is(err, ErrTCPProxyUnsupported) {
+		t.Fatalf("expected unsupported proxy error, got %v", err)
+	} else if len(dialer.dialed()) != 1 {
+		t.Fatal("TCP transport connected without the HTTP proxy")
+	}
+}
@@ -7,6 +7,7 @@
 package whatsmeow
 
 import (
+	"context"
 	"fmt"
 	"sync/atomic"
 	"time"
@@ -131,6 +132,14 @@ func (cli *Client) sendAck(node *waBinary.Node) {
 // To mark a voice message as played, specify types.ReceiptTypePlayed as the last parameter.
 // Providing more than one receipt type will panic: the parameter is only a vararg for backwards compatibility.
 func (cli *Client) MarkRead(ids []types.MessageID, timestamp time.Time, chat, sender types.JID, receiptTypeExtra ...types.ReceiptType) error {
+	return cli.MarkReadContext(context.Background(), ids, timestamp, chat, sender, receiptTypeExtra...)
+}
+
+// MarkReadContext is like MarkRead, but takes a context for cancellation and deadlines.
+func (cli *Client) MarkReadContext(ctx context.Context, ids []types.MessageID, timestamp time.Time, chat, sender types.JID, receiptTypeExtra ...types.ReceiptType) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 	if len(ids) == 0 {
 		return fmt.Errorf("no message IDs specified")
 	}
@@ -149,7 +158,7 @@ func (cli *Client) MarkRead(ids []types.MessageID, timestamp time.Time, chat, se
 			"t":    timestamp.Unix(),
 		},
 	}
-	if chat.Server == types.NewsletterServer || cli.GetPrivacySettings().ReadReceipts == types.PrivacySettingNone {
+	if chat.Server == types.NewsletterServer || cli.GetPrivacySettingsContext(ctx).ReadReceipts == types.PrivacySettingNone {
 		switch receiptType {
 		case types.ReceiptTypeRead:
 			node.Attrs["type"] = string(types.ReceiptTypeReadSelf)
@@ -0,0 +1,174 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package whatsmeow
+
+import (
+	"math"
+	"math/rand"
+	"time"
+)
+
+// ReconnectReason specifies why the client is automatically reconnecting.
+type ReconnectReason string
+
+const (
+	// ReconnectReasonConnectionLost means the connection was closed by the server or a network error.
+	ReconnectReasonConnectionLost ReconnectReason = "connection_lost"
+	// ReconnectReasonStreamError means the server sent a stream error (e.g. 503) before closing the connection.
+	ReconnectReasonStreamError ReconnectReason = "stream_error"
+	// ReconnectReasonConnectFailure means the server rejected the login with a temporary error (e.g. 503).
+	ReconnectReasonConnectFailure ReconnectReason = "connect_failure"
+	// ReconnectReasonKeepAliveTimeout means the client closed the connection after keepalive pings failed
+	// for longer than the MaxFailTime in Client.KeepAlive.
+	ReconnectReasonKeepAliveTimeout ReconnectReason = "keepalive_timeout"
+	// ReconnectReasonTemporaryBan means the server refused the login with a temporary ban.
+	// The BanExpire field in ReconnectAttempt contains the duration of the ban.
+	ReconnectReasonTemporaryBan ReconnectReason = "temporary_ban"
+)
+
+// ReconnectAttempt contains information about an automatic reconnection attempt for ReconnectPolicy.
+type ReconnectAttempt struct {
+	Reason ReconnectReason
+	// Attempt is the number of the upcoming attempt, starting from 1.
+	Attempt int
+	// Error is the error returned by the previous attempt, or nil if this is the first attempt.
+	Error error
+	// BanExpire is the duration of the temporary ban, only set if the reason is ReconnectReasonTemporaryBan.
+	BanExpire time.Duration
+}
+
+// ReconnectPolicy decides when the client should automatically reconnect.
+//
+// Policies are only used if Client.EnableAutoReconnect is true.
+type ReconnectPolicy interface {
+	// NextDelay is called before every reconnection attempt. It returns how long to wait before the
+	// attempt, or false if the client should stop reconnecting.
+	NextDelay(attempt ReconnectAttempt) (time.Duration, bool)
+}
+
+// ReconnectPolicyFunc is a function that implements ReconnectPolicy.
+type ReconnectPolicyFunc func(attempt ReconnectAttempt) (time.Duration, bool)
+
+// NextDelay calls the function.
+func (fn ReconnectPolicyFunc) NextDelay(attempt ReconnectAttempt) (time.Duration, bool) {
+	return fn(attempt)
+}
+
+// NeverReconnect is a ReconnectPolicy that never reconnects. It's mostly useful as an override in
+// BackoffReconnectPolicy.Reasons.
+var NeverReconnect ReconnectPolicy = ReconnectPolicyFunc(func(ReconnectAttempt) (time.Duration, bool) {
+	return 0, false
+})
+
+// linearReconnectPolicy is the policy used when Client.ReconnectPolicy is not set.
+// It waits 2 seconds more after each attempt and doesn't reconnect after temporary bans.
+var linearReconnectPolicy ReconnectPolicy = ReconnectPolicyFunc(func(attempt ReconnectAttempt) (time.Duration, bool) {
+	if attempt.Reason == ReconnectReasonTemporaryBan {
+		return 0, false
+	}
+	return time.Duration(attempt.Attempt-1) * 2 * time.Second, true
+})
+
+// BackoffReconnectPolicy is a ReconnectPolicy that uses exponential backoff with random jitter.
+//
+// Use NewBackoffReconnectPolicy to get a policy with sensible defaults.
+type BackoffReconnectPolicy struct {
+	// InitialDelay is the delay before the first attempt.
+	InitialDelay time.Duration
+	// MaxDelay is the maximum delay between attempts, not including temporary bans. Zero means no limit.
+	MaxDelay time.Duration
+	// Multiplier is the factor the delay is multiplied with after each failed attempt.
+	Multiplier float64
+	// Jitter is the fraction of the delay that is randomized, between 0 and 1. For example, with 0.5,
+	// a computed delay of 10 seconds will be turned into a random delay between 5 and 10 seconds.
+	// Randomizing delays prevents lots of clients from reconnecting at the exact same time after a network issue.
+	Jitter float64
+	// MaxAttempts is the maximum number of attempts before giving up. Zero means no limit.
+	MaxAttempts int
+
+	// Reasons can be used to override the policy for specific reasons, e.g. map to NeverReconnect
+	// to disable reconnecting after temporary bans.
+	Reasons map[ReconnectReason]ReconnectPolicy
+}
+
+// NewBackoffReconnectPolicy returns a BackoffReconnectPolicy that starts at 1 second, doubles the
+// delay after each attempt up to 5 minutes and randomizes half of each delay.
+func NewBackoffReconnectPolicy() *BackoffReconnectPolicy {
+	return &BackoffReconnectPolicy{
+		InitialDelay: 1 * time.Second,
+		MaxDelay:     5 * time.Minute,
+		Multiplier:   2,
+		Jitter:       0.5,
+	}
+}
+
+var _ ReconnectPolicy = (*BackoffReconnectPolicy)(nil)
+
+// NextDelay implements ReconnectPolicy.
+//
+// After temporary bans, the delay is the duration of the ban plus the normal backoff delay.
+func (brp *BackoffReconnectPolicy) NextDelay(attempt ReconnectAttempt) (time.Duration, bool) {
+	if override, ok := brp.Reasons[attempt.Reason]; ok {
+		return override.NextDelay(attempt)
+	} else if brp.MaxAttempts > 0 && attempt.Attempt > brp.MaxAttempts {
+		return 0, false
+	}
+	multiplier := brp.Multiplier
+	if multiplier < 1 {
+		multiplier = 1
+	}
+	attemptNum := attempt.Attempt
+	if attemptNum < 1 {
+		attemptNum = 1
+	}
+	banExpire := attempt.BanExpire
+	if banExpire < 0 {
+		banExpire = 0
+	}
+	// Even without MaxDelay, the delay must fit in a time.Duration, as converting larger floats
+	// is implementation-defined (and usually results in a negative value, i.e. no delay at all).
+	maxDelay := time.Duration(math.MaxInt64) - banExpire
+	if brp.MaxDelay > 0 && brp.MaxDelay < maxDelay {
+		maxDelay = brp.MaxDelay
+	}
+	delay := float64(brp.InitialDelay) * math.Pow(multiplier, float64(attemptNum-1))
+	if math.IsNaN(delay) || delay < 0 {
+		delay = 0
+	} else if delay > float64(maxDelay) {
+		delay = float64(maxDelay)
+	}
+	if brp.Jitter > 0 {
+		jitter := brp.Jitter
+		if jitter > 1 {
+			jitter = 1
+		}
+		delay -= delay * jitter * rand.Float64()
+	}
+	if delay >= float64(maxDelay) {
+		return banExpire + maxDelay, true
+	}
+	return banExpire + time.Duration(delay), true
+}
+
+func (cli *Client) getReconnectPolicy() ReconnectPolicy {
+	if cli.ReconnectPolicy != nil {
+		return cli.ReconnectPolicy
+	}
+	return linearReconnectPolicy
+}
+
+func (cli *Client) setReconnectReason(reason ReconnectReason) {
+	cli.connStateLock.Lock()
+	cli.reconnectReason = reason
+	cli.connStateLock.Unlock()
+}
+
+func (cli *Client) getReconnectReason() ReconnectReason {
+	cli.connStateLock.Lock()
+	defer cli.connStateLock.Unlock()
+	return cli.reconnectReason
+}
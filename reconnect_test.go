@@ -0,0 +1,76 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package whatsmeow
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBackoffReconnectPolicy(t *testing.T) {
+	policy := &BackoffReconnectPolicy{
+		InitialDelay: 1 * time.Second,
+		MaxDelay:     10 * time.Second,
+		Multiplier:   2,
+		MaxAttempts:  6,
+		Reasons:      map[ReconnectReason]ReconnectPolicy{ReconnectReasonKeepAliveTimeout: NeverReconnect},
+	}
+	expected := []time.Duration{1 * time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second, 10 * time.Second, 10 * time.Second}
+	for i, exp := range expected {
+		delay, ok := policy.NextDelay(ReconnectAttempt{Reason: ReconnectReasonConnectionLost, Attempt: i + 1})
+		if !ok || delay != exp {
+			t.Fatalf("attempt %d: expected %s, got %s (%t)", i+1, exp, delay, ok)
+		}
+	}
+	if _, ok := policy.NextDelay(ReconnectAttempt{Reason: ReconnectReasonConnectionLost, Attempt: 7}); ok {
+		t.Fatal("policy didn't give up after MaxAttempts")
+	}
+	if _, ok := policy.NextDelay(ReconnectAttempt{Reason: ReconnectReasonKeepAliveTimeout, Attempt: 1}); ok {
+		t.Fatal("reason override wasn't used")
+	}
+	ban := ReconnectAttempt{Reason: ReconnectReasonTemporaryBan, Attempt: 2, BanExpire: time.Hour}
+	if delay, ok := policy.NextDelay(ban); !ok || delay != time.Hour+2*time.Second {
+		t.Fatalf("unexpected delay after temporary ban: %s (%t)", delay, ok)
+	}
+
+	// Without MaxDelay, huge attempt numbers must not overflow into negative or zero delays
+	policy = &BackoffReconnectPolicy{InitialDelay: 1 * time.Second, Multiplier: 2}
+	for _, attemptNum := range []int{35, 64, 100, 2000} {
+		if delay, ok := policy.NextDelay(ReconnectAttempt{Reason: ReconnectReasonConnectionLost, Attempt: attemptNum}); !ok || delay <= 0 {
+			t.Fatalf("attempt %d: expected positive delay, got %s (%t)", attemptNum, delay, ok)
+		}
+	}
+	if delay, ok := policy.NextDelay(ReconnectAttempt{Reason: ReconnectReasonTemporaryBan, Attempt: 100, BanExpire: time.Hour}); !ok || delay <= time.Hour {
+		t.Fatalf("expected positive delay after temporary ban, got %s (%t)", delay, ok)
+	} else if delay, ok = policy.NextDelay(ReconnectAttempt{Reason: ReconnectReasonConnectionLost, Attempt: 0}); !ok || delay != time.Second {
+		t.Fatalf("expected initial delay for attempt 0, got %s (%t)", delay, ok)
+	}
+
+	policy = NewBackoffReconnectPolicy()
+	for i := 0; i < 100; i++ {
+		delay, ok := policy.NextDelay(ReconnectAttempt{Reason: ReconnectReasonConnectionLost, Attempt: 20})
+		if !ok || delay < policy.MaxDelay/2 || delay > policy.MaxDelay {
+			t.Fatalf("jittered delay %s out of range", delay)
+		}
+	}
+}
+
+func TestDefaultReconnectPolicy(t *testing.T) {
+	if delay, ok := linearReconnectPolicy.NextDelay(ReconnectAttempt{Reason: ReconnectReasonStreamError, Attempt: 3}); !ok || delay != 4*time.Second {
+		t.Fatalf("unexpected delay %s (%t)", delay, ok)
+	} else if _, ok = linearReconnectPolicy.NextDelay(ReconnectAttempt{Reason: ReconnectReasonTemporaryBan, Attempt: 1}); ok {
+		t.Fatal("default policy reconnected after temporary ban")
+	}
+	cli := &Client{}
+	if _, ok := cli.getReconnectPolicy().NextDelay(ReconnectAttempt{Reason: ReconnectReasonConnectionLost, Attempt: 1}); !ok {
+		t.Fatal("default policy wasn't used")
+	}
+	cli.ReconnectPolicy = NeverReconnect
+	if _, ok := cli.getReconnectPolicy().NextDelay(ReconnectAttempt{Reason: ReconnectReasonConnectionLost, Attempt: 1}); ok {
+		t.Fatal("custom policy wasn't used")
+	}
+}
@@ -0,0 +1,277 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package whatsmeow
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	waBinary "github.com/go-whatsapp/whatsmeow/binary"
+)
+
+// FrameDirection specifies whether a recorded frame was received or sent by the client.
+type FrameDirection byte
+
+const (
+	FrameInbound  FrameDirection = 'i'
+	FrameOutbound FrameDirection = 'o'
+)
+
+var frameRecordingMagic = []byte("WMRC\x01")
+
+// ErrInvalidFrameRecording is returned by FrameRecordingReader if the input isn't a frame recording.
+var ErrInvalidFrameRecording = errors.New("input is not a whatsmeow frame recording")
+
+// RecordedFrame is a single decrypted frame in a recording made with FrameRecorder.
+type RecordedFrame struct {
+	Direction FrameDirection
+	Timestamp time.Time
+	// Data is the raw frame content, which can be decoded with waBinary.Unpack and waBinary.Unmarshal.
+	Data []byte
+}
+
+// Node decodes the frame data into a binary XML node.
+func (rf *RecordedFrame) Node() (*waBinary.Node, error) {
+	unpacked, err := waBinary.Unpack(rf.Data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress frame: %w", err)
+	}
+	return waBinary.Unmarshal(unpacked)
+}
+
+// FrameRecorder writes every decrypted frame sent and received by a Client into a file.
+//
+// Set Client.FrameRecorder to start recording. The recording contains everything the client
+// sees after the noise handshake, including login data and signal message ciphertexts,
+// so it should be handled as sensitively as the device store itself.
+//
+// Frames are written to the underlying writer in a background goroutine, so recording never blocks
+// the client's read loop on disk I/O. The writer is flushed whenever the goroutine has caught up
+// with the recorded frames, and on Close.
+type FrameRecorder struct {
+	lock    sync.Mutex
+	pending []RecordedFrame
+	closed  bool
+	// err is the first error from writing to the underlying writer.
+	err error
+
+	wake   chan struct{}
+	done   chan struct{}
+	w      *bufio.Writer
+	closer io.Closer
+}
+
+// NewFrameRecorder creates a recorder that writes to the given writer.
+func NewFrameRecorder(w io.Writer) *FrameRecorder {
+	fr := &FrameRecorder{
+		w:    bufio.NewWriter(w),
+		wake: make(chan struct{}, 1),
+		done: make(chan struct{}),
+	}
+	if closer, ok := w.(io.Closer); ok {
+		fr.closer = closer
+	}
+	_, fr.err = fr.w.Write(frameRecordingMagic)
+	go fr.writeLoop()
+	return fr
+}
+
+// CreateFrameRecorder creates a file at the given path and returns a recorder that writes into it.
+func CreateFrameRecorder(path string) (*FrameRecorder, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create recording file: %w", err)
+	}
+	return NewFrameRecorder(file), nil
+}
+
+// Record adds a frame to the recording. The frame is copied and queued for the background writer,
+// so errors from writing are only returned by later Record calls and Close.
+func (fr *FrameRecorder) Record(direction FrameDirection, data []byte) error {
+	fr.lock.Lock()
+	defer fr.lock.Unlock()
+	if fr.closed {
+		return os.ErrClosed
+	} else if fr.err != nil {
+		return fr.err
+	}
+	fr.pending = append(fr.pending, RecordedFrame{
+		Direction: direction,
+		Timestamp: time.Now(),
+		Data:      append([]byte{}, data...),
+	})
+	select {
+	case fr.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+func (fr *FrameRecorder) writeLoop() {
+	defer close(fr.done)
+	for range fr.wake {
+		fr.writePending()
+	}
+	// Close closes the wake channel after it stops accepting frames, so this gets everything that's left
+	fr.writePending()
+}
+
+func (fr *FrameRecorder) writePending() {
+	fr.lock.Lock()
+	frames := fr.pending
+	fr.pending = nil
+	failed := fr.err != nil
+	fr.lock.Unlock()
+	if len(frames) == 0 || failed {
+		return
+	}
+	if err := fr.writeFrames(frames); err != nil {
+		fr.lock.Lock()
+		fr.err = err
+		fr.lock.Unlock()
+	}
+}
+
+func (fr *FrameRecorder) writeFrames(frames []RecordedFrame) error {
+	var header [13]byte
+	for _, frame := range frames {
+		header[0] = byte(frame.Direction)
+		binary.BigEndian.PutUint64(header[1:9], uint64(frame.Timestamp.UnixNano()))
+		binary.BigEndian.PutUint32(header[9:13], uint32(len(frame.Data)))
+		if _, err := fr.w.Write(header[:]); err != nil {
+			return err
+		} else if _, err = fr.w.Write(frame.Data); err != nil {
+			return err
+		}
+	}
+	return fr.w.Flush()
+}
+
+// Close waits for the queued frames to be written, flushes the recording and closes the underlying writer
+// if it implements io.Closer. Frames recorded after closing are discarded, so the recording can be read
+// safely after Close returns.
+func (fr *FrameRecorder) Close() error {
+	fr.lock.Lock()
+	if fr.closed {
+		fr.lock.Unlock()
+		return os.ErrClosed
+	}
+	fr.closed = true
+	close(fr.wake)
+	fr.lock.Unlock()
+	<-fr.done
+
+	err := fr.err
+	if err == nil {
+		err = fr.w.Flush()
+	}
+	if fr.closer != nil {
+		closeErr := fr.closer.Close()
+		if err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+// FrameRecordingReader reads frames from a recording made with FrameRecorder.
+type FrameRecordingReader struct {
+	r         *bufio.Reader
+	readMagic bool
+}
+
+// NewFrameRecordingReader creates a reader for the recording in the given reader.
+func NewFrameRecordingReader(r io.Reader) *FrameRecordingReader {
+	return &FrameRecordingReader{r: bufio.NewReader(r)}
+}
+
+// Next returns the next frame in the recording, or io.EOF if the recording has ended.
+func (frr *FrameRecordingReader) Next() (*RecordedFrame, error) {
+	if !frr.readMagic {
+		magic := make([]byte, len(frameRecordingMagic))
+		_, err := io.ReadFull(frr.r, magic)
+		if err != nil || !bytes.Equal(magic, frameRecordingMagic) {
+			return nil, ErrInvalidFrameRecording
+		}
+		frr.readMagic = true
+	}
+	var header [13]byte
+	_, err := io.ReadFull(frr.r, header[:])
+	if errors.Is(err, io.EOF) {
+		return nil, io.EOF
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read frame header: %w", err)
+	}
+	frame := &RecordedFrame{
+		Direction: FrameDirection(header[0]),
+		Timestamp: time.Unix(0, int64(binary.BigEndian.Uint64(header[1:9]))),
+		Data:      make([]byte, binary.BigEndian.Uint32(header[9:13])),
+	}
+	_, err = io.ReadFull(frr.r, frame.Data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read frame data: %w", err)
+	}
+	return frame, nil
+}
+
+func (cli *Client) recordFrame(direction FrameDirection, data []byte) {
+	if cli.FrameRecorder == nil {
+		return
+	}
+	err := cli.FrameRecorder.Record(direction, data)
+	if err != nil && !errors.Is(err, os.ErrClosed) {
+		cli.Log.Warnf("Failed to record %c frame: %v", direction, err)
+	}
+}
+
+// ReplayFrames feeds the inbound frames from a recording made with FrameRecorder into the client's
+// node handlers, without connecting to WhatsApp. Outbound frames are skipped.
+//
+// Nodes are passed to the handlers one by one in the order they were recorded, and any events are
+// dispatched to event handlers like they would be normally (some of them asynchronously).
+// Anything the handlers try to send (e.g. acks and receipts) will fail with ErrNotConnected,
+// unless the client is connected. To decrypt messages, the client's store must be in the same
+// state as the recorded client's store was at the start of the recording.
+func (cli *Client) ReplayFrames(ctx context.Context, r io.Reader) error {
+	reader := NewFrameRecordingReader(r)
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		frame, err := reader.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		} else if err != nil {
+			return err
+		} else if frame.Direction != FrameInbound {
+			continue
+		}
+		node, err := frame.Node()
+		if err != nil {
+			cli.Log.Warnf("Failed to decode recorded frame from %s: %v", frame.Timestamp, err)
+			continue
+		}
+		cli.recvLog.Debugf("%s", node.XMLString())
+		if cli.receiveResponse(node) {
+			continue
+		}
+		handler, ok := cli.nodeHandlers.Load(node.Tag)
+		if ok {
+			handler(node)
+		} else if node.Tag != "ack" {
+			cli.Log.Debugf("Didn't handle replayed node %s", node.Tag)
+		}
+	}
+}
@@ -0,0 +1,149 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package whatsmeow
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"testing"
+
+	waBinary "github.com/go-whatsapp/whatsmeow/binary"
+	waLog "github.com/go-whatsapp/whatsmeow/util/log"
+)
+
+type closeRecorder struct {
+	bytes.Buffer
+	closed bool
+}
+
+func (cr *closeRecorder) Close() error {
+	cr.closed = true
+	return nil
+}
+
+func marshalTestNode(t *testing.T, tag, id string) []byte {
+	t.Helper()
+	data, err := waBinary.Marshal(waBinary.Node{Tag: tag, Attrs: waBinary.Attrs{"id": id}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestFrameRecorder(t *testing.T) {
+	var output closeRecorder
+	recorder := NewFrameRecorder(&output)
+	frames := []RecordedFrame{
+		{Direction: FrameOutbound, Data: marshalTestNode(t, "iq", "1")},
+		{Direction: FrameInbound, Data: marshalTestNode(t, "iq", "2")},
+		{Direction: FrameInbound, Data: []byte{}},
+	}
+	for _, frame := range frames {
+		if err := recorder.Record(frame.Direction, frame.Data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := recorder.Close(); err != nil {
+		t.Fatal(err)
+	} else if !output.closed {
+		t.Fatal("closing recorder didn't close underlying writer")
+	} else if err = recorder.Record(FrameInbound, []byte("late")); !errors.Is(err, os.ErrClosed) {
+		t.Fatalf("expected ErrClosed after closing recorder, got %v", err)
+	}
+	recording := output.Bytes()
+
+	reader := NewFrameRecordingReader(bytes.NewReader(recording))
+	var prev *RecordedFrame
+	for i, expected := range frames {
+		frame, err := reader.Next()
+		if err != nil {
+			t.Fatal(err)
+		} else if frame.Direction != expected.Direction || !bytes.Equal(frame.Data, expected.Data) {
+			t.Fatalf("frame #%d doesn't match: %+v", i+1, frame)
+		} else if prev != nil && frame.Timestamp.Before(prev.Timestamp) {
+			t.Fatalf("frame #%d timestamp went backwards", i+1)
+		}
+		prev = frame
+	}
+	if _, err := reader.Next(); !errors.Is(err, io.EOF) {
+		t.Fatalf("expected EOF at end of recording, got %v", err)
+	}
+	if node, err := (&frames[1]).Node(); err != nil || node.Tag != "iq" || node.AttrGetter().String("id") != "2" {
+		t.Fatalf("unexpected decoded node %v (%v)", node, err)
+	}
+
+	if _, err := NewFrameRecordingReader(bytes.NewReader([]byte("not a recording"))).Next(); !errors.Is(err, ErrInvalidFrameRecording) {
+		t.Fatalf("expected ErrInvalidFrameRecording, got %v", err)
+	}
+	truncated := NewFrameRecordingReader(bytes.NewReader(recording[:len(recording)-5]))
+	var err error
+	for err == nil {
+		_, err = truncated.Next()
+	}
+	if errors.Is(err, io.EOF) {
+		t.Fatal("truncated recording ended cleanly")
+	}
+}
+
+type blockingWriter struct {
+	unblock chan struct{}
+	err     error
+}
+
+func (bw *blockingWriter) Write(p []byte) (int, error) {
+	<-bw.unblock
+	return len(p), bw.err
+}
+
+func TestFrameRecorderDoesntBlock(t *testing.T) {
+	writer := &blockingWriter{unblock: make(chan struct{}), err: errors.New("disk full")}
+	recorder := NewFrameRecorder(writer)
+	// The writer is blocked until the end, so these would hang if Record wrote to it directly
+	for i := 0; i < 100; i++ {
+		if err := recorder.Record(FrameInbound, marshalTestNode(t, "iq", "1")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	close(writer.unblock)
+	if err := recorder.Close(); !errors.Is(err, writer.err) {
+		t.Fatalf("expected write error from Close, got %v", err)
+	} else if err = recorder.Record(FrameInbound, nil); !errors.Is(err, os.ErrClosed) {
+		t.Fatalf("expected ErrClosed after closing recorder, got %v", err)
+	}
+}
+
+func TestReplayFramesSkipsOutbound(t *testing.T) {
+	var recording bytes.Buffer
+	recorder := NewFrameRecorder(&recording)
+	_ = recorder.Record(FrameOutbound, marshalTestNode(t, "test", "out"))
+	_ = recorder.Record(FrameInbound, marshalTestNode(t, "test", "in"))
+	_ = recorder.Record(FrameInbound, []byte{0, 0xff})
+	_ = recorder.Record(FrameInbound, marshalTestNode(t, "test", "in2"))
+	if err := recorder.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	cli := NewClient(newTestDevice(t), waLog.Noop)
+	var handled []string
+	cli.nodeHandlers.Store("test", func(node *waBinary.Node) {
+		handled = append(handled, node.AttrGetter().String("id"))
+	})
+	if err := cli.ReplayFrames(context.Background(), bytes.NewReader(recording.Bytes())); err != nil {
+		t.Fatal(err)
+	} else if len(handled) != 2 || handled[0] != "in" || handled[1] != "in2" {
+		t.Fatalf("unexpected replayed nodes %v", handled)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := cli.ReplayFrames(ctx, bytes.NewReader(recording.Bytes())); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+}
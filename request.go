@@ -13,8 +13,6 @@ import (
 	"sync/atomic"
 	"time"
 
-	"github.com/puzpuzpuz/xsync/v3"
-
 	waBinary "github.com/go-whatsapp/whatsmeow/binary"
 	"github.com/go-whatsapp/whatsmeow/types"
 )
@@ -43,17 +41,24 @@ func isAuthErrorDisconnect(node *waBinary.Node) bool {
 	return false
 }
 
+// clearResponseWaiters sends the given node to all pending response waiters.
+//
+// Waiters are removed from the map one by one instead of replacing the map, so that this is safe to call
+// concurrently from multiple disconnect paths: whoever removes a waiter from the map owns its channel,
+// which ensures each channel is only sent to or closed once.
 func (cli *Client) clearResponseWaiters(node *waBinary.Node) {
-	cli.responseWaiters.Range(func(_ string, waiter chan<- *waBinary.Node) bool {
+	cli.responseWaiters.Range(func(id string, _ chan<- *waBinary.Node) bool {
+		waiter, ok := cli.responseWaiters.LoadAndDelete(id)
+		if !ok {
+			return true
+		}
 		select {
 		case waiter <- node:
-			return true
 		default:
 			close(waiter)
-			return true
 		}
+		return true
 	})
-	cli.responseWaiters = xsync.NewMapOf[string, chan<- *waBinary.Node]()
 }
 
 func (cli *Client) waitResponse(reqID string) chan *waBinary.Node {
@@ -63,8 +68,10 @@ func (cli *Client) waitResponse(reqID string) chan *waBinary.Node {
 }
 
 func (cli *Client) cancelResponse(reqID string, ch chan *waBinary.Node) {
-	close(ch)
-	cli.responseWaiters.Delete(reqID)
+	// The channel may have already been taken by receiveResponse or clearResponseWaiters
+	if waiter, ok := cli.responseWaiters.LoadAndDelete(reqID); ok && waiter == ch {
+		close(ch)
+	}
 }
 
 func (cli *Client) receiveResponse(data *waBinary.Node) bool {
@@ -72,11 +79,10 @@ func (cli *Client) receiveResponse(data *waBinary.Node) bool {
 	if !ok || (data.Tag != "iq" && data.Tag != "ack") {
 		return false
 	}
-	waiter, ok := cli.responseWaiters.Load(id)
+	waiter, ok := cli.responseWaiters.LoadAndDelete(id)
 	if !ok {
 		return false
 	}
-	cli.responseWaiters.Delete(id)
 	waiter <- data
 	return true
 }
@@ -101,7 +107,7 @@ type infoQuery struct {
 	Context context.Context
 }
 
-func (cli *Client) sendIQAsyncAndGetData(query *infoQuery) (<-chan *waBinary.Node, []byte, error) {
+func (cli *Client) sendIQAsyncAndGetData(query *infoQuery) (chan *waBinary.Node, []byte, error) {
 	if len(query.ID) == 0 {
 		query.ID = cli.generateRequestID()
 	}
@@ -141,12 +147,21 @@ func (cli *Client) sendIQ(query infoQuery) (*waBinary.Node, error) {
 	if err != nil {
 		return nil, err
 	}
-	if query.Timeout == 0 {
-		query.Timeout = defaultRequestTimeout
-	}
 	if query.Context == nil {
 		query.Context = context.Background()
 	}
+	var timeoutChan <-chan time.Time
+	if query.Timeout == 0 {
+		// Only apply the default timeout if the caller didn't provide their own deadline
+		if _, hasDeadline := query.Context.Deadline(); !hasDeadline {
+			query.Timeout = defaultRequestTimeout
+		}
+	}
+	if query.Timeout > 0 {
+		timer := time.NewTimer(query.Timeout)
+		defer timer.Stop()
+		timeoutChan = timer.C
+	}
 	select {
 	case res := <-resChan:
 		if isDisconnectNode(res) {
@@ -166,8 +181,10 @@ func (cli *Client) sendIQ(query infoQuery) (*waBinary.Node, error) {
 		}
 		return res, nil
 	case <-query.Context.Done():
+		cli.cancelResponse(query.ID, resChan)
 		return nil, query.Context.Err()
-	case <-time.After(query.Timeout):
+	case <-timeoutChan:
+		cli.cancelResponse(query.ID, resChan)
 		return nil, ErrIQTimedOut
 	}
 }
@@ -205,8 +222,10 @@ func (cli *Client) retryFrame(reqType, id string, data []byte, origResp *waBinar
 	select {
 	case resp = <-respChan:
 	case <-ctx.Done():
+		cli.cancelResponse(id, respChan)
 		return nil, ctx.Err()
 	case <-timeoutChan:
+		cli.cancelResponse(id, respChan)
 		// FIXME this error isn't technically correct (but works for now - the timeout param is only used from sendIQ)
 		return nil, ErrIQTimedOut
 	}
@@ -0,0 +1,65 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package whatsmeow
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	waBinary "github.com/go-whatsapp/whatsmeow/binary"
+	waLog "github.com/go-whatsapp/whatsmeow/util/log"
+)
+
+func TestResponseWaiters(t *testing.T) {
+	cli := NewClient(newTestDevice(t), waLog.Noop)
+
+	// Cancelling a pending request removes the waiter and closes the channel
+	ch := cli.waitResponse("1")
+	cli.cancelResponse("1", ch)
+	if _, ok := <-ch; ok {
+		t.Fatal("cancelled waiter channel wasn't closed")
+	} else if cli.receiveResponse(&waBinary.Node{Tag: "iq", Attrs: waBinary.Attrs{"id": "1"}}) {
+		t.Fatal("response was delivered to cancelled waiter")
+	}
+
+	// Cancelling after the response arrived must not close the channel (or panic)
+	ch = cli.waitResponse("2")
+	if !cli.receiveResponse(&waBinary.Node{Tag: "iq", Attrs: waBinary.Attrs{"id": "2"}}) {
+		t.Fatal("response wasn't delivered")
+	}
+	cli.cancelResponse("2", ch)
+	if resp, ok := <-ch; !ok || resp.Attrs["id"] != "2" {
+		t.Fatal("response was lost after cancelling")
+	}
+
+	// Disconnections are sent to every waiter, and cancelling afterwards is a no-op
+	ch3, ch4 := cli.waitResponse("3"), cli.waitResponse("4")
+	disconnect := &waBinary.Node{Tag: "xmlstreamend"}
+	cli.clearResponseWaiters(disconnect)
+	cli.cancelResponse("3", ch3)
+	if resp := <-ch3; resp != disconnect {
+		t.Fatalf("unexpected response %v", resp)
+	} else if resp = <-ch4; resp != disconnect {
+		t.Fatalf("unexpected response %v", resp)
+	}
+	if cli.responseWaiters.Size() != 0 {
+		t.Fatalf("%d response waiters leaked", cli.responseWaiters.Size())
+	}
+}
+
+func TestSendIQNotConnected(t *testing.T) {
+	cli := NewClient(newTestDevice(t), waLog.Noop)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := cli.sendIQ(infoQuery{Namespace: "w:p", Type: iqGet, Context: ctx, Content: []waBinary.Node{{Tag: "ping"}}})
+	if !errors.Is(err, ErrNotConnected) {
+		t.Fatalf("expected ErrNotConnected, got %v", err)
+	} else if cli.responseWaiters.Size() != 0 {
+		t.Fatal("response waiter wasn't removed after failed send")
+	}
+}
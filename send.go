@@ -259,7 +259,12 @@ func (cli *Client) SendMessage(ctx context.Context, to types.JID, message *waPro
 //
 // Deprecated: This method is deprecated in favor of BuildRevoke
 func (cli *Client) RevokeMessage(chat types.JID, id types.MessageID) (SendResponse, error) {
-	return cli.SendMessage(context.TODO(), chat, cli.BuildRevoke(chat, types.EmptyJID, id))
+	return cli.RevokeMessageContext(context.Background(), chat, id)
+}
+
+// RevokeMessageContext is like RevokeMessage, but takes a context for cancellation and deadlines.
+func (cli *Client) RevokeMessageContext(ctx context.Context, chat types.JID, id types.MessageID) (SendResponse, error) {
+	return cli.SendMessage(ctx, chat, cli.BuildRevoke(chat, types.EmptyJID, id))
 }
 
 // BuildMessageKey builds a MessageKey object, which is used to refer to previous messages
@@ -419,9 +424,14 @@ func ParseDisappearingTimerString(val string) (time.Duration, bool) {
 //
 // In groups, the server will echo the change as a notification, so it'll show up as a *events.GroupInfo update.
 func (cli *Client) SetDisappearingTimer(chat types.JID, timer time.Duration) (err error) {
+	return cli.SetDisappearingTimerContext(context.Background(), chat, timer)
+}
+
+// SetDisappearingTimerContext is like SetDisappearingTimer, but takes a context for cancellation and deadlines.
+func (cli *Client) SetDisappearingTimerContext(ctx context.Context, chat types.JID, timer time.Duration) (err error) {
 	switch chat.Server {
 	case types.DefaultUserServer:
-		_, err = cli.SendMessage(context.TODO(), chat, &waProto.Message{
+		_, err = cli.SendMessage(ctx, chat, &waProto.Message{
 			ProtocolMessage: &waProto.ProtocolMessage{
 				Type:                waProto.ProtocolMessage_EPHEMERAL_SETTING.Enum(),
 				EphemeralExpiration: waProto.Uint32(uint32(timer.Seconds())),
@@ -429,9 +439,9 @@ func (cli *Client) SetDisappearingTimer(chat types.JID, timer time.Duration) (er
 		})
 	case types.GroupServer:
 		if timer == 0 {
-			_, err = cli.sendGroupIQ(context.TODO(), iqSet, chat, waBinary.Node{Tag: "not_ephemeral"})
+			_, err = cli.sendGroupIQ(ctx, iqSet, chat, waBinary.Node{Tag: "not_ephemeral"})
 		} else {
-			_, err = cli.sendGroupIQ(context.TODO(), iqSet, chat, waBinary.Node{
+			_, err = cli.sendGroupIQ(ctx, iqSet, chat, waBinary.Node{
 				Tag: "ephemeral",
 				Attrs: waBinary.Attrs{
 					"expiration": strconv.Itoa(int(timer.Seconds())),
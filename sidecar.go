@@ -0,0 +1,171 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package whatsmeow
+
+import (
+	"crypto/aes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"fmt"
+	"io"
+)
+
+// The streaming sidecar of a media file contains a truncated HMAC for every 64 KiB chunk of the encrypted file,
+// which allows receivers to verify and decrypt parts of the file without downloading the whole thing.
+//
+// Each HMAC covers the IV for decrypting the chunk (i.e. the last block of the previous chunk, or the media IV
+// for the first chunk) and the chunk itself. In other words, the HMAC of chunk n is computed over
+// (iv + encrypted file)[n*64K : n*64K+64K+16].
+const (
+	sidecarChunkSize  = 64 * 1024
+	sidecarHMACLength = 10
+)
+
+// sidecarTypes contains the media types that streaming sidecars are generated for when uploading.
+var sidecarTypes = map[MediaType]bool{
+	MediaVideo: true,
+	MediaAudio: true,
+}
+
+func sidecarChunkHMAC(macKey, iv, chunk []byte) []byte {
+	h := hmac.New(sha256.New, macKey)
+	h.Write(iv)
+	h.Write(chunk)
+	return h.Sum(nil)[:sidecarHMACLength]
+}
+
+// expectedSidecarLength returns the length of the streaming sidecar for an encrypted file of the given size.
+func expectedSidecarLength(encryptedSize int64) int {
+	return int((encryptedSize+sidecarChunkSize-1)/sidecarChunkSize) * sidecarHMACLength
+}
+
+// sidecarMatchesFile checks whether the length of a streaming sidecar matches a file of the given plaintext length.
+// Sidecars that don't match are ignored instead of failing the download, as they can't be used for anything.
+func (cli *Client) sidecarMatchesFile(sidecar []byte, fileLength int) bool {
+	if fileLength >= 0 && len(sidecar) != expectedSidecarLength(encryptedMediaSize(uint64(fileLength))) {
+		cli.Log.Debugf("Not verifying streaming sidecar: length %d doesn't match file length %d", len(sidecar), fileLength)
+		return false
+	}
+	return true
+}
+
+// verifySidecarChunk checks the HMAC of the chunk with the given index against the sidecar.
+// The IV is the last block of the previous chunk, or the media IV for the first chunk.
+func verifySidecarChunk(sidecar, macKey, iv, chunk []byte, index int) error {
+	start := index * sidecarHMACLength
+	if start+sidecarHMACLength > len(sidecar) {
+		return fmt.Errorf("%w: file is longer than sidecar", ErrInvalidStreamingSidecar)
+	} else if !hmac.Equal(sidecarChunkHMAC(macKey, iv, chunk), sidecar[start:start+sidecarHMACLength]) {
+		return fmt.Errorf("%w: HMAC of chunk #%d doesn't match", ErrInvalidStreamingSidecar, index)
+	}
+	return nil
+}
+
+// sidecarGenerator is an io.Writer that computes the streaming sidecar of the encrypted file written into it.
+type sidecarGenerator struct {
+	macKey  []byte
+	iv      []byte
+	buf     []byte
+	sidecar []byte
+}
+
+func newSidecarGenerator(iv, macKey []byte) *sidecarGenerator {
+	return &sidecarGenerator{macKey: macKey, iv: iv}
+}
+
+func (sg *sidecarGenerator) Write(p []byte) (int, error) {
+	sg.buf = append(sg.buf, p...)
+	for len(sg.buf) >= sidecarChunkSize {
+		sg.sidecar = append(sg.sidecar, sidecarChunkHMAC(sg.macKey, sg.iv, sg.buf[:sidecarChunkSize])...)
+		sg.iv = append(sg.iv[:0:0], sg.buf[sidecarChunkSize-aes.BlockSize:sidecarChunkSize]...)
+		sg.buf = append(sg.buf[:0], sg.buf[sidecarChunkSize:]...)
+	}
+	return len(p), nil
+}
+
+// Sum hashes the last chunk and returns the sidecar.
+func (sg *sidecarGenerator) Sum() []byte {
+	if len(sg.buf) > 0 {
+		sg.sidecar = append(sg.sidecar, sidecarChunkHMAC(sg.macKey, sg.iv, sg.buf)...)
+		sg.buf = nil
+	}
+	return sg.sidecar
+}
+
+// sidecarVerifier is an io.Writer that checks each chunk of the encrypted file against the streaming sidecar
+// and only passes verified chunks to the next writer.
+type sidecarVerifier struct {
+	out     io.Writer
+	macKey  []byte
+	iv      []byte
+	sidecar []byte
+	chunk   int
+	buf     []byte
+	// verified is the number of bytes that have been verified and passed to the next writer.
+	verified int64
+}
+
+var _ verifiedWriter = (*sidecarVerifier)(nil)
+
+func newSidecarVerifier(out io.Writer, iv, macKey, sidecar []byte) *sidecarVerifier {
+	return &sidecarVerifier{out: out, macKey: macKey, iv: iv, sidecar: sidecar}
+}
+
+func (sv *sidecarVerifier) verify(data []byte) error {
+	if err := verifySidecarChunk(sv.sidecar, sv.macKey, sv.iv, data, sv.chunk); err != nil {
+		return err
+	}
+	sv.chunk++
+	return nil
+}
+
+func (sv *sidecarVerifier) Write(p []byte) (int, error) {
+	sv.buf = append(sv.buf, p...)
+	for len(sv.buf) >= sidecarChunkSize {
+		if err := sv.verify(sv.buf[:sidecarChunkSize]); err != nil {
+			return 0, err
+		} else if _, err = sv.out.Write(sv.buf[:sidecarChunkSize]); err != nil {
+			return 0, err
+		}
+		sv.verified += sidecarChunkSize
+		sv.iv = append(sv.iv[:0:0], sv.buf[sidecarChunkSize-aes.BlockSize:sidecarChunkSize]...)
+		sv.buf = append(sv.buf[:0], sv.buf[sidecarChunkSize:]...)
+	}
+	return len(p), nil
+}
+
+// discardUnverified implements verifiedWriter. The data after the last verified chunk is dropped,
+// so that it's downloaded again when resuming.
+func (sv *sidecarVerifier) discardUnverified() int64 {
+	sv.buf = sv.buf[:0]
+	return sv.verified
+}
+
+// finish verifies the last chunk and passes it to the next writer.
+func (sv *sidecarVerifier) finish() error {
+	if len(sv.buf) > 0 {
+		if err := sv.verify(sv.buf); err != nil {
+			return err
+		} else if _, err = sv.out.Write(sv.buf); err != nil {
+			return err
+		}
+		sv.buf = nil
+	}
+	if sv.chunk*sidecarHMACLength != len(sv.sidecar) {
+		return fmt.Errorf("%w: file is shorter than sidecar", ErrInvalidStreamingSidecar)
+	}
+	return nil
+}
+
+// newUploadSidecar returns a sidecar generator for an upload, or nil if sidecars aren't used for the media type.
+func newUploadSidecar(mediaKey []byte, appInfo MediaType) *sidecarGenerator {
+	if !sidecarTypes[appInfo] {
+		return nil
+	}
+	iv, _, macKey, _ := getMediaKeys(mediaKey, appInfo)
+	return newSidecarGenerator(iv, macKey)
+}
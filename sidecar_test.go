@@ -0,0 +1,100 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package whatsmeow
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/go-whatsapp/go-util/random"
+)
+
+// sidecarFromLayout computes a sidecar directly from the chunk layout: the HMAC of chunk n covers
+// (iv + encrypted file)[n*64K : n*64K+64K+16], i.e. the 16 bytes before the chunk and the chunk itself.
+func sidecarFromLayout(iv, macKey, encrypted []byte) []byte {
+	data := append(bytes.Clone(iv), encrypted...)
+	var sidecar []byte
+	for start := 0; start < len(encrypted); start += sidecarChunkSize {
+		end := start + sidecarChunkSize + len(iv)
+		if end > len(data) {
+			end = len(data)
+		}
+		h := hmac.New(sha256.New, macKey)
+		h.Write(data[start:end])
+		sidecar = append(sidecar, h.Sum(nil)[:sidecarHMACLength]...)
+	}
+	return sidecar
+}
+
+func TestSidecarLayout(t *testing.T) {
+	for _, size := range []int{0, 1000, sidecarChunkSize - 26, sidecarChunkSize - 10, 2*sidecarChunkSize + 1000, 150000} {
+		mediaKey := random.Bytes(32)
+		var encrypted bytes.Buffer
+		generator := newUploadSidecar(mediaKey, MediaVideo)
+		_, _, _, err := encryptMediaStream(io.MultiWriter(&encrypted, generator), bytes.NewReader(random.Bytes(size)), mediaKey, MediaVideo)
+		if err != nil {
+			t.Fatal(err)
+		}
+		iv, _, macKey, _ := getMediaKeys(mediaKey, MediaVideo)
+		expected := sidecarFromLayout(iv, macKey, encrypted.Bytes())
+		if sidecar := generator.Sum(); !bytes.Equal(sidecar, expected) {
+			t.Fatalf("size %d: generated sidecar doesn't match layout", size)
+		} else if len(sidecar) != expectedSidecarLength(int64(encrypted.Len())) {
+			t.Fatalf("size %d: unexpected sidecar length %d", size, len(sidecar))
+		}
+	}
+}
+
+func TestSidecarVerifier(t *testing.T) {
+	mediaKey := random.Bytes(32)
+	var encrypted bytes.Buffer
+	_, _, _, err := encryptMediaStream(&encrypted, bytes.NewReader(random.Bytes(150000)), mediaKey, MediaVideo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	iv, _, macKey, _ := getMediaKeys(mediaKey, MediaVideo)
+	sidecar := sidecarFromLayout(iv, macKey, encrypted.Bytes())
+
+	var verified bytes.Buffer
+	verifier := newSidecarVerifier(&verified, iv, macKey, sidecar)
+	if _, err = verifier.Write(encrypted.Bytes()); err != nil {
+		t.Fatal(err)
+	} else if err = verifier.finish(); err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(verified.Bytes(), encrypted.Bytes()) {
+		t.Fatal("verifier output doesn't match input")
+	}
+
+	// The first chunk is complete without any data from the second chunk, so it's passed through immediately
+	verified.Reset()
+	verifier = newSidecarVerifier(&verified, iv, macKey, sidecar)
+	if _, err = verifier.Write(encrypted.Bytes()[:sidecarChunkSize]); err != nil {
+		t.Fatal(err)
+	} else if verified.Len() != sidecarChunkSize {
+		t.Fatalf("expected first chunk to be verified, got %d bytes", verified.Len())
+	}
+
+	// The HMAC of a chunk covers the whole chunk, including its last block
+	tampered := bytes.Clone(encrypted.Bytes())
+	tampered[sidecarChunkSize-1] ^= 1
+	verifier = newSidecarVerifier(io.Discard, iv, macKey, sidecar)
+	if _, err = verifier.Write(tampered[:sidecarChunkSize]); !errors.Is(err, ErrInvalidStreamingSidecar) {
+		t.Fatalf("expected invalid sidecar error for first chunk, got %v", err)
+	}
+	tampered = bytes.Clone(encrypted.Bytes())
+	tampered[sidecarChunkSize] ^= 1
+	verifier = newSidecarVerifier(io.Discard, iv, macKey, sidecar)
+	if _, err = verifier.Write(tampered); !errors.Is(err, ErrInvalidStreamingSidecar) {
+		t.Fatalf("expected invalid sidecar error, got %v", err)
+	} else if verifier.discardUnverified() != sidecarChunkSize {
+		t.Fatal("first chunk should've been verified before the tampered one")
+	}
+}
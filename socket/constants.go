@@ -21,6 +21,8 @@ const (
 	Origin = "https://web.whatsapp.com"
 	// URL is the websocket URL for the new multidevice protocol
 	URL = "wss://web.whatsapp.com/ws/chat"
+	// TCPAddress is the address of the raw TCP endpoint used by the official mobile clients
+	TCPAddress = "g.whatsapp.net:443"
 )
 
 const (
@@ -10,20 +10,19 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"net/url"
 	"sync"
 	"time"
 
-	"github.com/gorilla/websocket"
-
 	waLog "github.com/go-whatsapp/whatsmeow/util/log"
 )
 
 type Proxy = func(*http.Request) (*url.URL, error)
 
 type FrameSocket struct {
-	conn   *websocket.Conn
+	conn   TransportConn
 	ctx    context.Context
 	cancel func()
 	log    waLog.Logger
@@ -33,8 +32,8 @@ type FrameSocket struct {
 	OnDisconnect func(remote bool)
 	WriteTimeout time.Duration
 
-	Header []byte
-	Proxy  Proxy
+	Header    []byte
+	Transport Transport
 
 	incomingLength int
 	receivedLength int
@@ -42,22 +41,32 @@ type FrameSocket struct {
 	partialHeader  []byte
 }
 
-func NewFrameSocket(log waLog.Logger, header []byte, proxy Proxy) *FrameSocket {
+// NewFrameSocket creates a FrameSocket that will connect using the given transport.
+//
+// If transport is nil, a WebsocketTransport for the default WhatsApp web URL will be used.
+func NewFrameSocket(log waLog.Logger, header []byte, transport Transport) *FrameSocket {
+	if transport == nil {
+		transport = NewWebsocketTransport(log, http.ProxyFromEnvironment)
+	}
 	return &FrameSocket{
 		conn:   nil,
 		log:    log,
 		Header: header,
 		Frames: make(chan []byte),
 
-		Proxy: proxy,
+		Transport: transport,
 	}
 }
 
 func (fs *FrameSocket) IsConnected() bool {
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
 	return fs.conn != nil
 }
 
 func (fs *FrameSocket) Context() context.Context {
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
 	return fs.ctx
 }
 
@@ -69,16 +78,8 @@ func (fs *FrameSocket) Close(code int) {
 		return
 	}
 
-	if code > 0 {
-		message := websocket.FormatCloseMessage(code, "")
-		err := fs.conn.WriteControl(websocket.CloseMessage, message, time.Now().Add(time.Second))
-		if err != nil {
-			fs.log.Warnf("Error sending close message: %v", err)
-		}
-	}
-
 	fs.cancel()
-	err := fs.conn.Close()
+	err := fs.conn.Close(code)
 	if err != nil {
 		fs.log.Errorf("Error closing websocket: %v", err)
 	}
@@ -98,53 +99,48 @@ func (fs *FrameSocket) Connect() error {
 		return ErrSocketAlreadyOpen
 	}
 	ctx, cancel := context.WithCancel(context.Background())
-	dialer := websocket.Dialer{
-		Proxy: fs.Proxy,
-	}
-
-	headers := http.Header{"Origin": []string{Origin}}
-	fs.log.Debugf("Dialing %s", URL)
-	conn, _, err := dialer.Dial(URL, headers)
+	conn, err := fs.Transport.Dial(ctx)
 	if err != nil {
 		cancel()
-		return fmt.Errorf("couldn't dial whatsapp web websocket: %w", err)
+		return err
 	}
 
 	fs.ctx, fs.cancel = ctx, cancel
 	fs.conn = conn
-	conn.SetCloseHandler(func(code int, text string) error {
-		fs.log.Debugf("Server closed websocket with status %d/%s", code, text)
-		cancel()
-		// from default CloseHandler
-		message := websocket.FormatCloseMessage(code, "")
-		_ = conn.WriteControl(websocket.CloseMessage, message, time.Now().Add(time.Second))
-		return nil
-	})
 
 	go fs.readPump(conn, ctx)
 	return nil
 }
 
-func (fs *FrameSocket) SendFrame(data []byte) error {
-	conn := fs.conn
-	if conn == nil {
-		return ErrSocketClosed
+// takeConnAndHeader returns the current connection and the header that needs to be sent before the next frame.
+func (fs *FrameSocket) takeConnAndHeader() (TransportConn, []byte) {
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+	if fs.conn == nil {
+		return nil, nil
 	}
+	header := fs.Header
+	// We only want to send the header once
+	fs.Header = nil
+	return fs.conn, header
+}
+
+func (fs *FrameSocket) SendFrame(data []byte) error {
 	dataLength := len(data)
 	if dataLength >= FrameMaxSize {
 		return fmt.Errorf("%w (got %d bytes, max %d bytes)", ErrFrameTooLarge, len(data), FrameMaxSize)
 	}
+	conn, header := fs.takeConnAndHeader()
+	if conn == nil {
+		return ErrSocketClosed
+	}
 
-	headerLength := len(fs.Header)
+	headerLength := len(header)
 	// Whole frame is header + 3 bytes for length + data
 	wholeFrame := make([]byte, headerLength+FrameLengthSize+dataLength)
 
 	// Copy the header if it's there
-	if fs.Header != nil {
-		copy(wholeFrame[:headerLength], fs.Header)
-		// We only want to send the header once
-		fs.Header = nil
-	}
+	copy(wholeFrame[:headerLength], header)
 
 	// Encode length of frame
 	wholeFrame[headerLength] = byte(dataLength >> 16)
@@ -160,7 +156,7 @@ func (fs *FrameSocket) SendFrame(data []byte) error {
 			fs.log.Warnf("Failed to set write deadline: %v", err)
 		}
 	}
-	return conn.WriteMessage(websocket.BinaryMessage, wholeFrame)
+	return conn.WriteMessage(wholeFrame)
 }
 
 func (fs *FrameSocket) frameComplete() {
@@ -183,8 +179,8 @@ func (fs *FrameSocket) processData(msg []byte) {
 			if len(msg) >= FrameLengthSize {
 				length := (int(msg[0]) << 16) + (int(msg[1]) << 8) + int(msg[2])
 				fs.incomingLength = length
-				fs.receivedLength = len(msg)
 				msg = msg[FrameLengthSize:]
+				fs.receivedLength = len(msg)
 				if len(msg) >= length {
 					fs.incoming = msg[:length]
 					msg = msg[length:]
@@ -200,7 +196,7 @@ func (fs *FrameSocket) processData(msg []byte) {
 				msg = nil
 			}
 		} else {
-			if len(fs.incoming)+len(msg) >= fs.incomingLength {
+			if fs.receivedLength+len(msg) >= fs.incomingLength {
 				copy(fs.incoming[fs.receivedLength:], msg[:fs.incomingLength-fs.receivedLength])
 				msg = msg[fs.incomingLength-fs.receivedLength:]
 				fs.frameComplete()
@@ -213,23 +209,20 @@ func (fs *FrameSocket) processData(msg []byte) {
 	}
 }
 
-func (fs *FrameSocket) readPump(conn *websocket.Conn, ctx context.Context) {
+func (fs *FrameSocket) readPump(conn TransportConn, ctx context.Context) {
 	fs.log.Debugf("Frame websocket read pump starting %p", fs)
 	defer func() {
 		fs.log.Debugf("Frame websocket read pump exiting %p", fs)
 		go fs.Close(0)
 	}()
 	for {
-		msgType, data, err := conn.ReadMessage()
+		data, err := conn.ReadMessage()
 		if err != nil {
-			// Ignore the error if the context has been closed
-			if !errors.Is(ctx.Err(), context.Canceled) {
+			// Ignore the error if the context has been closed or the server closed the connection cleanly
+			if !errors.Is(ctx.Err(), context.Canceled) && !errors.Is(err, io.EOF) {
 				fs.log.Errorf("Error reading from websocket: %v", err)
 			}
 			return
-		} else if msgType != websocket.BinaryMessage {
-			fs.log.Warnf("Got unexpected websocket message type %d", msgType)
-			continue
 		}
 		fs.processData(data)
 	}
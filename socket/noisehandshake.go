@@ -74,13 +74,24 @@ func (nh *NoiseHandshake) Decrypt(ciphertext []byte) (plaintext []byte, err erro
 	return
 }
 
+// Split derives the final transport ciphers after the handshake is complete.
+//
+// The first cipher is used for data sent by the initiator (the client) and the second one
+// for data sent by the responder (the server).
+func (nh *NoiseHandshake) Split() (initiatorKey, responderKey cipher.AEAD, err error) {
+	if write, read, extractErr := nh.extractAndExpand(nh.salt, nil); extractErr != nil {
+		err = fmt.Errorf("failed to extract final keys: %w", extractErr)
+	} else if initiatorKey, err = gcmutil.Prepare(write); err != nil {
+		err = fmt.Errorf("failed to create final write cipher: %w", err)
+	} else if responderKey, err = gcmutil.Prepare(read); err != nil {
+		err = fmt.Errorf("failed to create final read cipher: %w", err)
+	}
+	return
+}
+
 func (nh *NoiseHandshake) Finish(fs *FrameSocket, frameHandler FrameHandler, disconnectHandler DisconnectHandler) (*NoiseSocket, error) {
-	if write, read, err := nh.extractAndExpand(nh.salt, nil); err != nil {
-		return nil, fmt.Errorf("failed to extract final keys: %w", err)
-	} else if writeKey, err := gcmutil.Prepare(write); err != nil {
-		return nil, fmt.Errorf("failed to create final write cipher: %w", err)
-	} else if readKey, err := gcmutil.Prepare(read); err != nil {
-		return nil, fmt.Errorf("failed to create final read cipher: %w", err)
+	if writeKey, readKey, err := nh.Split(); err != nil {
+		return nil, err
 	} else if ns, err := newNoiseSocket(fs, writeKey, readKey, frameHandler, disconnectHandler); err != nil {
 		return nil, fmt.Errorf("failed to create noise socket: %w", err)
 	} else {
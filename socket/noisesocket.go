@@ -26,6 +26,7 @@ type NoiseSocket struct {
 	writeLock    sync.Mutex
 	destroyed    uint32
 	stopConsumer chan struct{}
+	consumerDone chan struct{}
 }
 
 type DisconnectHandler func(socket *NoiseSocket, remote bool)
@@ -38,15 +39,24 @@ func newNoiseSocket(fs *FrameSocket, writeKey, readKey cipher.AEAD, frameHandler
 		readKey:      readKey,
 		onFrame:      frameHandler,
 		stopConsumer: make(chan struct{}),
+		consumerDone: make(chan struct{}),
 	}
+	// The frame socket may be closed by its read pump at any time, so the handler and context are accessed with the lock
+	fs.lock.Lock()
 	fs.OnDisconnect = func(remote bool) {
+		// Make sure the last received frame has been handled before reporting the disconnection,
+		// so that the handler knows about e.g. stream errors the server sent before closing the connection.
+		<-ns.consumerDone
 		disconnectHandler(ns, remote)
 	}
-	go ns.consumeFrames(fs.ctx, fs.Frames)
+	ctx := fs.ctx
+	fs.lock.Unlock()
+	go ns.consumeFrames(ctx, fs.Frames)
 	return ns, nil
 }
 
 func (ns *NoiseSocket) consumeFrames(ctx context.Context, frames <-chan []byte) {
+	defer close(ns.consumerDone)
 	if ctx == nil {
 		// ctx being nil implies the connection already closed somehow
 		return
@@ -77,7 +87,9 @@ func (ns *NoiseSocket) Context() context.Context {
 func (ns *NoiseSocket) Stop(disconnect bool) {
 	if atomic.CompareAndSwapUint32(&ns.destroyed, 0, 1) {
 		close(ns.stopConsumer)
+		ns.fs.lock.Lock()
 		ns.fs.OnDisconnect = nil
+		ns.fs.lock.Unlock()
 		if disconnect {
 			ns.fs.Close(websocket.CloseNormalClosure)
 		}
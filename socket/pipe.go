@@ -0,0 +1,165 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package socket
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"time"
+)
+
+// ErrPipeTransportClosed is returned by PipeTransport methods after Close has been called.
+var ErrPipeTransportClosed = errors.New("pipe transport is closed")
+
+// PipeTransport is an in-memory Transport. Each Dial call creates a new connected pair of
+// TransportConns: one is returned to the dialer and the other is delivered to Accept.
+//
+// This is mostly meant for tests, where the other end is a fake server running in the same process:
+//
+//	transport := socket.NewPipeTransport()
+//	cli.Transport = transport
+//	go func() {
+//		serverConn, err := transport.Accept(ctx)
+//		// read the WAConnHeader and frames from serverConn
+//	}()
+//	err := cli.Connect()
+type PipeTransport struct {
+	conns     chan TransportConn
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+var _ Transport = (*PipeTransport)(nil)
+
+// NewPipeTransport creates a new in-memory transport.
+func NewPipeTransport() *PipeTransport {
+	return &PipeTransport{
+		conns:  make(chan TransportConn),
+		closed: make(chan struct{}),
+	}
+}
+
+// Dial creates a new connection pair and blocks until the server end is accepted with Accept.
+func (pt *PipeTransport) Dial(ctx context.Context) (TransportConn, error) {
+	client, server := NewPipeConn()
+	select {
+	case pt.conns <- server:
+		return client, nil
+	case <-pt.closed:
+		return nil, ErrPipeTransportClosed
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Accept waits for the next Dial call and returns the server end of the connection.
+func (pt *PipeTransport) Accept(ctx context.Context) (TransportConn, error) {
+	select {
+	case conn := <-pt.conns:
+		return conn, nil
+	case <-pt.closed:
+		return nil, ErrPipeTransportClosed
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Close stops accepting new connections. Existing connections are not affected.
+func (pt *PipeTransport) Close() {
+	pt.closeOnce.Do(func() {
+		close(pt.closed)
+	})
+}
+
+type pipeHalf struct {
+	data   chan []byte
+	closed chan struct{}
+	once   sync.Once
+}
+
+func (ph *pipeHalf) close() {
+	ph.once.Do(func() {
+		close(ph.closed)
+	})
+}
+
+type pipeConn struct {
+	read  *pipeHalf
+	write *pipeHalf
+
+	deadlineLock  sync.Mutex
+	writeDeadline time.Time
+}
+
+// NewPipeConn creates a pair of connected in-memory TransportConns.
+// Messages written to one end can be read from the other.
+func NewPipeConn() (TransportConn, TransportConn) {
+	a := &pipeHalf{data: make(chan []byte, 16), closed: make(chan struct{})}
+	b := &pipeHalf{data: make(chan []byte, 16), closed: make(chan struct{})}
+	return &pipeConn{read: a, write: b}, &pipeConn{read: b, write: a}
+}
+
+func (pc *pipeConn) ReadMessage() ([]byte, error) {
+	select {
+	case data := <-pc.read.data:
+		return data, nil
+	case <-pc.read.closed:
+		// Drain anything that was written before the connection was closed
+		select {
+		case data := <-pc.read.data:
+			return data, nil
+		default:
+			return nil, io.EOF
+		}
+	}
+}
+
+func (pc *pipeConn) WriteMessage(data []byte) error {
+	pc.deadlineLock.Lock()
+	deadline := pc.writeDeadline
+	pc.deadlineLock.Unlock()
+	var timeout <-chan time.Time
+	if !deadline.IsZero() {
+		timer := time.NewTimer(time.Until(deadline))
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	// Copy the data so the writer can't modify it after the reader receives it
+	data = append([]byte(nil), data...)
+	select {
+	case <-pc.write.closed:
+		return ErrSocketClosed
+	case <-pc.read.closed:
+		return ErrSocketClosed
+	default:
+	}
+	select {
+	case pc.write.data <- data:
+		return nil
+	case <-pc.write.closed:
+		return ErrSocketClosed
+	case <-pc.read.closed:
+		return ErrSocketClosed
+	case <-timeout:
+		return context.DeadlineExceeded
+	}
+}
+
+func (pc *pipeConn) SetWriteDeadline(t time.Time) error {
+	pc.deadlineLock.Lock()
+	pc.writeDeadline = t
+	pc.deadlineLock.Unlock()
+	return nil
+}
+
+func (pc *pipeConn) Close(_ int) error {
+	pc.read.close()
+	pc.write.close()
+	return nil
+}
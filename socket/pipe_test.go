@@ -0,0 +1,57 @@
+package socket
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	waLog "github.com/go-whatsapp/whatsmeow/util/log"
+)
+
+func TestFrameSocketOverPipe(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	transport := NewPipeTransport()
+	defer transport.Close()
+
+	accepted := make(chan TransportConn, 1)
+	go func() {
+		conn, err := transport.Accept(ctx)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		accepted <- conn
+	}()
+	fs := NewFrameSocket(waLog.Noop, WAConnHeader, transport)
+	if err := fs.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer fs.Close(0)
+	server := <-accepted
+
+	if err := fs.SendFrame([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	data, err := server.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := append(append([]byte{}, WAConnHeader...), 0, 0, 5, 'h', 'e', 'l', 'l', 'o')
+	if !bytes.Equal(data, expected) {
+		t.Fatalf("unexpected frame %x", data)
+	}
+
+	// Split a frame across two messages to make sure partial frames are buffered
+	_ = server.WriteMessage([]byte{0, 0, 5, 'w', 'o'})
+	_ = server.WriteMessage([]byte{'r', 'l', 'd'})
+	select {
+	case frame := <-fs.Frames:
+		if string(frame) != "world" {
+			t.Fatalf("unexpected frame %q", frame)
+		}
+	case <-ctx.Done():
+		t.Fatal("timed out waiting for frame")
+	}
+}
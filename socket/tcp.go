@@ -0,0 +1,159 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package socket
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	waLog "github.com/go-whatsapp/whatsmeow/util/log"
+)
+
+// tcpReadBufferSize is the maximum number of bytes returned by a single ReadMessage call on a TCP connection.
+const tcpReadBufferSize = 32 * 1024
+
+// ContextDialer is the interface for dialing raw network connections. *net.Dialer implements it,
+// and so do the SOCKS5 dialers from golang.org/x/net/proxy.
+type ContextDialer interface {
+	DialContext(ctx context.Context, network, address string) (net.Conn, error)
+}
+
+// TCPTransport is a Transport that connects to the WhatsApp chat server over a raw TCP connection
+// like the official mobile clients, instead of wrapping the frames in websocket messages.
+type TCPTransport struct {
+	// Address is the host:port to dial. Defaults to the TCPAddress constant if empty.
+	Address string
+	// Dialer is used to open the TCP connection. Defaults to a plain net.Dialer if nil.
+	// When used as whatsmeow.Client.Transport, a nil Dialer is replaced with the client's dialer from SetDialer.
+	Dialer ContextDialer
+
+	Log waLog.Logger
+}
+
+var _ Transport = (*TCPTransport)(nil)
+
+// NewTCPTransport creates a TCPTransport that connects to the given address.
+// If the address is empty, the default WhatsApp chat server address is used.
+func NewTCPTransport(log waLog.Logger, address string) *TCPTransport {
+	if len(address) == 0 {
+		address = TCPAddress
+	}
+	return &TCPTransport{
+		Address: address,
+		Log:     log,
+	}
+}
+
+func (tt *TCPTransport) Dial(ctx context.Context) (TransportConn, error) {
+	log := tt.Log
+	if log == nil {
+		log = waLog.Noop
+	}
+	address := tt.Address
+	if len(address) == 0 {
+		address = TCPAddress
+	}
+	var dialer ContextDialer = &net.Dialer{}
+	if tt.Dialer != nil {
+		dialer = tt.Dialer
+	}
+	log.Debugf("Dialing %s", address)
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't dial whatsapp tcp endpoint: %w", err)
+	}
+	return NewTCPConn(conn), nil
+}
+
+type tcpConn struct {
+	conn net.Conn
+	buf  []byte
+}
+
+// NewTCPConn wraps a stream connection as a TransportConn.
+//
+// Reads return whatever is available in the stream, so messages won't align with frame boundaries.
+// That's fine, because FrameSocket buffers partial frames.
+func NewTCPConn(conn net.Conn) TransportConn {
+	return &tcpConn{conn: conn, buf: make([]byte, tcpReadBufferSize)}
+}
+
+func (tc *tcpConn) ReadMessage() ([]byte, error) {
+	n, err := tc.conn.Read(tc.buf)
+	if n > 0 {
+		// Errors will be returned again by the next read, so just return the data for now
+		return append([]byte(nil), tc.buf[:n]...), nil
+	} else if errors.Is(err, net.ErrClosed) {
+		return nil, io.EOF
+	}
+	return nil, err
+}
+
+func (tc *tcpConn) WriteMessage(data []byte) error {
+	_, err := tc.conn.Write(data)
+	return err
+}
+
+func (tc *tcpConn) SetWriteDeadline(t time.Time) error {
+	return tc.conn.SetWriteDeadline(t)
+}
+
+func (tc *tcpConn) Close(_ int) error {
+	return tc.conn.Close()
+}
+
+// TCPListener adapts a net.Listener to accept TransportConns. It's the TCP equivalent of
+// PipeTransport.Accept and is mostly meant for running test servers.
+type TCPListener struct {
+	net.Listener
+}
+
+// deadlineListener is implemented by listeners that support interrupting Accept, like *net.TCPListener.
+type deadlineListener interface {
+	SetDeadline(t time.Time) error
+}
+
+// Accept waits for the next TCP connection. If the context is cancelled, Accept returns ctx.Err() and the
+// listener stays open, like PipeTransport.Accept. Cancellation requires a listener with a SetDeadline method,
+// like *net.TCPListener, and Accept shouldn't be called concurrently, as the deadline is shared.
+func (tl *TCPListener) Accept(ctx context.Context) (TransportConn, error) {
+	dl, ok := tl.Listener.(deadlineListener)
+	if !ok {
+		conn, err := tl.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		return NewTCPConn(conn), nil
+	}
+	stop := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		select {
+		case <-ctx.Done():
+			// A deadline in the past interrupts the pending Accept call
+			_ = dl.SetDeadline(time.Unix(1, 0))
+		case <-stop:
+		}
+	}()
+	conn, err := tl.Listener.Accept()
+	close(stop)
+	<-stopped
+	// Clear the deadline even if the context was cancelled after Accept returned, so the listener can be reused
+	_ = dl.SetDeadline(time.Time{})
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, err
+	}
+	return NewTCPConn(conn), nil
+}
@@ -0,0 +1,126 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package socket
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	waLog "github.com/go-whatsapp/whatsmeow/util/log"
+)
+
+type countingDialer struct {
+	net.Dialer
+	calls int
+}
+
+func (cd *countingDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	cd.calls++
+	return cd.Dialer.DialContext(ctx, network, address)
+}
+
+func TestFrameSocketOverTCP(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener := &TCPListener{Listener: ln}
+	defer listener.Close()
+
+	accepted := make(chan TransportConn, 1)
+	go func() {
+		conn, err := listener.Accept(ctx)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		accepted <- conn
+	}()
+	dialer := &countingDialer{}
+	transport := NewTCPTransport(waLog.Noop, ln.Addr().String())
+	transport.Dialer = dialer
+	fs := NewFrameSocket(waLog.Noop, WAConnHeader, transport)
+	if err = fs.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer fs.Close(0)
+	server := <-accepted
+	if dialer.calls != 1 {
+		t.Fatalf("custom dialer was called %d times", dialer.calls)
+	}
+
+	if err = fs.SendFrame([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	expected := append(append([]byte{}, WAConnHeader...), 0, 0, 5, 'h', 'e', 'l', 'l', 'o')
+	var received []byte
+	for len(received) < len(expected) {
+		data, err := server.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		received = append(received, data...)
+	}
+	if !bytes.Equal(received, expected) {
+		t.Fatalf("unexpected frame %x", received)
+	}
+
+	// TCP doesn't preserve message boundaries, so frames may be split or coalesced arbitrarily
+	_ = server.WriteMessage([]byte{0, 0, 3, 'o', 'n', 'e', 0, 0, 3, 't'})
+	time.Sleep(10 * time.Millisecond)
+	_ = server.WriteMessage([]byte{'w', 'o'})
+	for _, expectedFrame := range []string{"one", "two"} {
+		select {
+		case frame := <-fs.Frames:
+			if string(frame) != expectedFrame {
+				t.Fatalf("expected frame %q, got %q", expectedFrame, frame)
+			}
+		case <-ctx.Done():
+			t.Fatalf("timed out waiting for frame %q", expectedFrame)
+		}
+	}
+
+	_ = server.Close(0)
+	if _, err = server.ReadMessage(); !errors.Is(err, io.EOF) {
+		t.Fatalf("expected EOF after closing connection, got %v", err)
+	}
+}
+
+func TestTCPListenerAcceptCancel(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener := &TCPListener{Listener: ln}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err = listener.Accept(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected context error, got %v", err)
+	}
+
+	// The listener should still accept connections after the cancelled call
+	go func() {
+		if conn, err := net.Dial("tcp", ln.Addr().String()); err == nil {
+			_ = conn.Close()
+		}
+	}()
+	ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn, err := listener.Accept(ctx)
+	if err != nil {
+		t.Fatalf("accept failed after earlier cancellation: %v", err)
+	}
+	_ = conn.Close(0)
+	_ = ln.Close()
+}
@@ -0,0 +1,37 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package socket
+
+import (
+	"context"
+	"time"
+)
+
+// Transport is a way to open connections to the WhatsApp server (or something pretending to be it).
+//
+// FrameSocket uses a Transport to get a raw byte stream, on top of which it implements the
+// 3-byte length-prefixed framing. The default implementation is WebsocketTransport.
+type Transport interface {
+	// Dial opens a new connection. The context only applies to establishing the connection.
+	Dial(ctx context.Context) (TransportConn, error)
+}
+
+// TransportConn is a single connection opened by a Transport.
+type TransportConn interface {
+	// ReadMessage blocks until the next chunk of data is received from the connection.
+	// Chunks don't need to align with frame boundaries, FrameSocket will buffer partial frames.
+	//
+	// When the remote side closes the connection cleanly, ReadMessage must return io.EOF.
+	ReadMessage() ([]byte, error)
+	// WriteMessage writes a chunk of data to the connection. FrameSocket always writes whole frames.
+	WriteMessage(data []byte) error
+	// SetWriteDeadline sets the deadline for future WriteMessage calls. A zero value means no deadline.
+	SetWriteDeadline(t time.Time) error
+	// Close closes the connection. If code is positive, the transport should try to tell the
+	// remote side about the closure first (e.g. with a websocket close message).
+	Close(code int) error
+}
@@ -0,0 +1,123 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package socket
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	waLog "github.com/go-whatsapp/whatsmeow/util/log"
+)
+
+// WebsocketTransport is the default Transport, which connects to the WhatsApp web websocket endpoint.
+type WebsocketTransport struct {
+	// URL is the websocket URL to dial. Defaults to the URL constant if empty.
+	URL string
+	// Origin is the value of the Origin header. Defaults to the Origin constant if empty.
+	Origin string
+	// Proxy is the proxy function passed to the websocket dialer.
+	Proxy Proxy
+	// Dialer is used to open the underlying network connection (to the proxy, if one is set).
+	// Defaults to a plain net.Dialer if nil.
+	Dialer ContextDialer
+
+	Log waLog.Logger
+}
+
+var _ Transport = (*WebsocketTransport)(nil)
+
+// NewWebsocketTransport creates a WebsocketTransport that connects to the default WhatsApp web URL.
+func NewWebsocketTransport(log waLog.Logger, proxy Proxy) *WebsocketTransport {
+	return &WebsocketTransport{
+		URL:    URL,
+		Origin: Origin,
+		Proxy:  proxy,
+		Log:    log,
+	}
+}
+
+func (wt *WebsocketTransport) Dial(ctx context.Context) (TransportConn, error) {
+	log := wt.Log
+	if log == nil {
+		log = waLog.Noop
+	}
+	wsURL := wt.URL
+	if len(wsURL) == 0 {
+		wsURL = URL
+	}
+	origin := wt.Origin
+	if len(origin) == 0 {
+		origin = Origin
+	}
+	dialer := websocket.Dialer{
+		Proxy: wt.Proxy,
+	}
+	if wt.Dialer != nil {
+		dialer.NetDialContext = wt.Dialer.DialContext
+	}
+
+	headers := http.Header{"Origin": []string{origin}}
+	log.Debugf("Dialing %s", wsURL)
+	conn, _, err := dialer.DialContext(ctx, wsURL, headers)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't dial whatsapp web websocket: %w", err)
+	}
+	conn.SetCloseHandler(func(code int, text string) error {
+		log.Debugf("Server closed websocket with status %d/%s", code, text)
+		// from default CloseHandler
+		message := websocket.FormatCloseMessage(code, "")
+		_ = conn.WriteControl(websocket.CloseMessage, message, time.Now().Add(time.Second))
+		return nil
+	})
+	return &websocketConn{conn: conn, log: log}, nil
+}
+
+type websocketConn struct {
+	conn *websocket.Conn
+	log  waLog.Logger
+}
+
+func (wc *websocketConn) ReadMessage() ([]byte, error) {
+	for {
+		msgType, data, err := wc.conn.ReadMessage()
+		var closeErr *websocket.CloseError
+		if errors.As(err, &closeErr) {
+			return nil, io.EOF
+		} else if err != nil {
+			return nil, err
+		} else if msgType != websocket.BinaryMessage {
+			wc.log.Warnf("Got unexpected websocket message type %d", msgType)
+			continue
+		}
+		return data, nil
+	}
+}
+
+func (wc *websocketConn) WriteMessage(data []byte) error {
+	return wc.conn.WriteMessage(websocket.BinaryMessage, data)
+}
+
+func (wc *websocketConn) SetWriteDeadline(t time.Time) error {
+	return wc.conn.SetWriteDeadline(t)
+}
+
+func (wc *websocketConn) Close(code int) error {
+	if code > 0 {
+		message := websocket.FormatCloseMessage(code, "")
+		err := wc.conn.WriteControl(websocket.CloseMessage, message, time.Now().Add(time.Second))
+		if err != nil {
+			wc.log.Warnf("Error sending close message: %v", err)
+		}
+	}
+	return wc.conn.Close()
+}
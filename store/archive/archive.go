@@ -0,0 +1,244 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+// Package archive contains functions for exporting devices into passphrase-encrypted archives
+// and importing them into any store container, e.g. to move a session from SQLite to Postgres without re-pairing.
+package archive
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/go-whatsapp/go-util/random"
+	"golang.org/x/crypto/argon2"
+	"google.golang.org/protobuf/proto"
+
+	waProto "github.com/go-whatsapp/whatsmeow/binary/proto"
+	"github.com/go-whatsapp/whatsmeow/store"
+	"github.com/go-whatsapp/whatsmeow/types"
+	"github.com/go-whatsapp/whatsmeow/util/gcmutil"
+	"github.com/go-whatsapp/whatsmeow/util/keys"
+	waLog "github.com/go-whatsapp/whatsmeow/util/log"
+)
+
+var (
+	ErrInvalidArchive     = errors.New("invalid device archive")
+	ErrWrongPassphrase    = errors.New("wrong passphrase or corrupted archive")
+	ErrDeviceNotPaired    = errors.New("device doesn't have a JID")
+	ErrExportNotSupported = errors.New("device store doesn't support exporting all data")
+	ErrImportNotSupported = errors.New("device container doesn't support importing all data")
+	ErrDeviceExists       = errors.New("device already exists in container")
+)
+
+var archiveMagic = []byte("WMDEVARC")
+
+const archiveVersion = 1
+
+// Parameters for deriving the encryption key from the passphrase with Argon2id.
+// They're stored in the archive header, so they can be changed without breaking old archives.
+const (
+	argonTime    = 3
+	argonMemory  = 64 * 1024
+	argonThreads = 4
+	argonKeySize = 32
+	saltSize     = 16
+	nonceSize    = 12
+
+	// Limits for the parameters in imported archives. The header isn't authenticated until the key has been derived,
+	// so a malicious archive can make every import allocate this much memory (in KiB, i.e. 256 MiB).
+	maxArgonTime   = 64
+	maxArgonMemory = 256 * 1024
+)
+
+// magic + version + argon2 time + memory + threads + salt + nonce
+const headerSize = 8 + 1 + 4 + 4 + 1 + saltSize + nonceSize
+
+type deviceInfo struct {
+	JID             types.JID `json:"jid"`
+	RegistrationID  uint32    `json:"registration_id"`
+	NoiseKey        []byte    `json:"noise_key"`
+	IdentityKey     []byte    `json:"identity_key"`
+	SignedPreKey    []byte    `json:"signed_pre_key"`
+	SignedPreKeyID  uint32    `json:"signed_pre_key_id"`
+	SignedPreKeySig []byte    `json:"signed_pre_key_sig"`
+	AdvSecretKey    []byte    `json:"adv_key"`
+	Account         []byte    `json:"account"`
+	Platform        string    `json:"platform"`
+	BusinessName    string    `json:"business_name"`
+	PushName        string    `json:"push_name"`
+}
+
+type archiveContent struct {
+	Device deviceInfo        `json:"device"`
+	Data   *store.DeviceData `json:"data"`
+}
+
+// Export exports all the data of the given device into an archive encrypted with the given passphrase.
+//
+// The archive contains the private keys of the device, so the passphrase should be strong.
+// All the store implementations in this module support exporting.
+func Export(device *store.Device, passphrase string) ([]byte, error) {
+	if device.JID == nil {
+		return nil, ErrDeviceNotPaired
+	}
+	dataStore, ok := device.Identities.(store.DeviceDataStore)
+	if !ok {
+		return nil, ErrExportNotSupported
+	}
+	data, err := dataStore.ExportDeviceData()
+	if err != nil {
+		return nil, fmt.Errorf("failed to export device data: %w", err)
+	}
+	account, err := proto.Marshal(device.Account)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal account: %w", err)
+	}
+	content := archiveContent{
+		Device: deviceInfo{
+			JID:             *device.JID,
+			RegistrationID:  device.RegistrationID,
+			NoiseKey:        device.NoiseKey.Priv[:],
+			IdentityKey:     device.IdentityKey.Priv[:],
+			SignedPreKey:    device.SignedPreKey.Priv[:],
+			SignedPreKeyID:  device.SignedPreKey.KeyID,
+			SignedPreKeySig: device.SignedPreKey.Signature[:],
+			AdvSecretKey:    device.AdvSecretKey,
+			Account:         account,
+			Platform:        device.Platform,
+			BusinessName:    device.BusinessName,
+			PushName:        device.PushName,
+		},
+		Data: data,
+	}
+	var plaintext bytes.Buffer
+	compressor := gzip.NewWriter(&plaintext)
+	if err = json.NewEncoder(compressor).Encode(&content); err != nil {
+		return nil, fmt.Errorf("failed to encode archive: %w", err)
+	} else if err = compressor.Close(); err != nil {
+		return nil, fmt.Errorf("failed to compress archive: %w", err)
+	}
+
+	header := make([]byte, 0, headerSize)
+	header = append(header, archiveMagic...)
+	header = append(header, archiveVersion)
+	header = binary.BigEndian.AppendUint32(header, argonTime)
+	header = binary.BigEndian.AppendUint32(header, argonMemory)
+	header = append(header, argonThreads)
+	salt := random.Bytes(saltSize)
+	header = append(header, salt...)
+	nonce := random.Bytes(nonceSize)
+	header = append(header, nonce...)
+	key := argon2.IDKey([]byte(passphrase), salt, argonTime, argonMemory, argonThreads, argonKeySize)
+	// The whole header is authenticated, so the KDF parameters can't be tampered with
+	ciphertext, err := gcmutil.Encrypt(key, nonce, plaintext.Bytes(), header)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encrypt archive: %w", err)
+	}
+	return append(header, ciphertext...), nil
+}
+
+func decryptArchive(archive []byte, passphrase string) (*archiveContent, error) {
+	if len(archive) < headerSize || !bytes.HasPrefix(archive, archiveMagic) {
+		return nil, ErrInvalidArchive
+	}
+	header := archive[:headerSize]
+	ptr := header[len(archiveMagic):]
+	if ptr[0] != archiveVersion {
+		return nil, fmt.Errorf("%w: unsupported version %d", ErrInvalidArchive, ptr[0])
+	}
+	time := binary.BigEndian.Uint32(ptr[1:5])
+	memory := binary.BigEndian.Uint32(ptr[5:9])
+	threads := ptr[9]
+	salt := ptr[10 : 10+saltSize]
+	nonce := ptr[10+saltSize : 10+saltSize+nonceSize]
+	if time == 0 || time > maxArgonTime || memory > maxArgonMemory || threads == 0 {
+		return nil, fmt.Errorf("%w: invalid key derivation parameters", ErrInvalidArchive)
+	}
+	key := argon2.IDKey([]byte(passphrase), salt, time, memory, threads, argonKeySize)
+	plaintext, err := gcmutil.Decrypt(key, nonce, archive[headerSize:], header)
+	if err != nil {
+		return nil, ErrWrongPassphrase
+	}
+	decompressor, err := gzip.NewReader(bytes.NewReader(plaintext))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidArchive, err)
+	}
+	var content archiveContent
+	if err = json.NewDecoder(decompressor).Decode(&content); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidArchive, err)
+	} else if content.Data == nil {
+		return nil, fmt.Errorf("%w: archive doesn't contain device data", ErrInvalidArchive)
+	}
+	return &content, nil
+}
+
+// Import decrypts the given archive created with Export and stores the device and all its data in the given container.
+//
+// The container must not already contain the device, otherwise ErrDeviceExists is returned. If storing the device data
+// fails, the device is deleted from the container again. The logger can be nil and will default to a no-op logger.
+// All the store implementations in this module support importing.
+func Import(container store.DeviceContainer, archive []byte, passphrase string, log waLog.Logger) (*store.Device, error) {
+	dataContainer, ok := container.(store.DeviceDataContainer)
+	if !ok {
+		return nil, ErrImportNotSupported
+	}
+	content, err := decryptArchive(archive, passphrase)
+	if err != nil {
+		return nil, err
+	}
+	info := content.Device
+	if len(info.NoiseKey) != 32 || len(info.IdentityKey) != 32 || len(info.SignedPreKey) != 32 || len(info.SignedPreKeySig) != 64 {
+		return nil, fmt.Errorf("%w: invalid key length", ErrInvalidArchive)
+	} else if err = content.Data.Validate(); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidArchive, err)
+	}
+	var account waProto.ADVSignedDeviceIdentity
+	if err = proto.Unmarshal(info.Account, &account); err != nil {
+		return nil, fmt.Errorf("%w: failed to unmarshal account: %v", ErrInvalidArchive, err)
+	}
+	// GetDevice falls back to other devices of the same user, so the JID has to be compared too
+	existing, err := dataContainer.GetDevice(info.JID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check if device exists: %w", err)
+	} else if existing != nil && existing.JID != nil && *existing.JID == info.JID {
+		return nil, ErrDeviceExists
+	}
+	dataStore := dataContainer.GetDeviceDataStore(info.JID)
+	if log == nil {
+		log = waLog.Noop
+	}
+	device := &store.Device{
+		Log:       log,
+		Container: container,
+
+		NoiseKey:       keys.NewKeyPairFromPrivateKey(*(*[32]byte)(info.NoiseKey)),
+		IdentityKey:    keys.NewKeyPairFromPrivateKey(*(*[32]byte)(info.IdentityKey)),
+		SignedPreKey:   &keys.PreKey{KeyID: info.SignedPreKeyID, Signature: (*[64]byte)(info.SignedPreKeySig)},
+		RegistrationID: info.RegistrationID,
+		AdvSecretKey:   info.AdvSecretKey,
+		JID:            &info.JID,
+		Account:        &account,
+		Platform:       info.Platform,
+		BusinessName:   info.BusinessName,
+		PushName:       info.PushName,
+	}
+	device.SignedPreKey.KeyPair = *keys.NewKeyPairFromPrivateKey(*(*[32]byte)(info.SignedPreKey))
+	if err = device.Save(); err != nil {
+		return nil, fmt.Errorf("failed to save device: %w", err)
+	} else if err = dataStore.ImportDeviceData(content.Data); err != nil {
+		// Don't leave a half-imported device behind, it would look like a normal device that's missing sessions
+		if deleteErr := container.DeleteDevice(device); deleteErr != nil {
+			log.Warnf("Failed to delete device %s after failed import: %v", info.JID, deleteErr)
+		}
+		return nil, fmt.Errorf("failed to import device data: %w", err)
+	}
+	return device, nil
+}
@@ -0,0 +1,233 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package archive_test
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+
+	waProto "github.com/go-whatsapp/whatsmeow/binary/proto"
+	"github.com/go-whatsapp/whatsmeow/store"
+	"github.com/go-whatsapp/whatsmeow/store/archive"
+	"github.com/go-whatsapp/whatsmeow/store/kvstore"
+	"github.com/go-whatsapp/whatsmeow/store/memstore"
+	"github.com/go-whatsapp/whatsmeow/store/sqlstore"
+	"github.com/go-whatsapp/whatsmeow/types"
+	waLog "github.com/go-whatsapp/whatsmeow/util/log"
+)
+
+const testPassphrase = "hunter2"
+
+var testJID = types.NewADJID("1111", 0, 1)
+
+// newTestDevice creates a device with something in every part of the device data.
+func newTestDevice(t *testing.T) *store.Device {
+	t.Helper()
+	device := memstore.New(waLog.Noop).NewDevice()
+	jid := testJID
+	device.JID = &jid
+	device.PushName = "Test"
+	device.Account = &waProto.ADVSignedDeviceIdentity{
+		Details:             []byte("details"),
+		AccountSignatureKey: make([]byte, 32),
+		AccountSignature:    make([]byte, 64),
+		DeviceSignature:     make([]byte, 64),
+	}
+	peer := types.NewJID("2222", types.DefaultUserServer)
+	group := types.NewJID("123456789", types.GroupServer)
+	steps := []func() error{
+		device.Save,
+		func() error { _, err := device.PreKeys.GetOrGenPreKeys(2); return err },
+		func() error { return device.PreKeys.MarkPreKeysAsUploaded(1) },
+		func() error { return device.Identities.PutIdentity("2222:0", [32]byte{1, 2, 3}) },
+		func() error { return device.Sessions.PutSession("2222:0", []byte("session")) },
+		func() error { return device.SenderKeys.PutSenderKey(group.String(), "2222:0", []byte("sender key")) },
+		func() error {
+			return device.AppStateKeys.PutAppStateSyncKey([]byte{0, 1}, store.AppStateSyncKey{
+				Data:        []byte("sync key"),
+				Fingerprint: []byte("fingerprint"),
+				Timestamp:   1234,
+			})
+		},
+		func() error { return device.AppState.PutAppStateVersion("regular", 2, [128]byte{1}) },
+		func() error {
+			return device.AppState.PutAppStateMutationMACs("regular", 2, []store.AppStateMutationMAC{{IndexMAC: make([]byte, 32), ValueMAC: make([]byte, 32)}})
+		},
+		func() error { _, _, err := device.Contacts.PutPushName(peer, "Peer"); return err },
+		func() error { _, _, err := device.Contacts.PutBusinessName(peer, "Peer Inc"); return err },
+		func() error { return device.Contacts.PutContactName(peer, "Peer", "Peer Person") },
+		func() error { return device.ChatSettings.PutMutedUntil(group, time.Unix(1700000000, 0)) },
+		func() error { return device.ChatSettings.PutPinned(group, true) },
+		func() error { return device.ChatSettings.PutArchived(peer, true) },
+		func() error { return device.MsgSecrets.PutMessageSecret(group, peer, "ABCD", []byte("secret")) },
+		func() error {
+			return device.PrivacyTokens.PutPrivacyTokens(store.PrivacyToken{User: peer, Token: []byte("token"), Timestamp: time.Unix(1600000000, 0)})
+		},
+	}
+	for i, step := range steps {
+		if err := step(); err != nil {
+			t.Fatalf("failed to set up test device (step #%d): %v", i+1, err)
+		}
+	}
+	return device
+}
+
+func exportDeviceData(t *testing.T, device *store.Device) *store.DeviceData {
+	t.Helper()
+	data, err := device.Identities.(store.DeviceDataStore).ExportDeviceData()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Stores return items in different orders, so sort all lists to make them comparable
+	val := reflect.ValueOf(data).Elem()
+	for i := 0; i < val.NumField(); i++ {
+		field := val.Field(i)
+		if field.Kind() == reflect.Slice {
+			sort.Slice(field.Interface(), func(a, b int) bool {
+				return fmt.Sprint(field.Index(a).Interface()) < fmt.Sprint(field.Index(b).Interface())
+			})
+		}
+	}
+	return data
+}
+
+type importTarget struct {
+	name      string
+	container func(t *testing.T) store.DeviceContainer
+}
+
+func getDevice(t *testing.T, container store.DeviceContainer) *store.Device {
+	t.Helper()
+	device, err := container.(store.DeviceDataContainer).GetDevice(testJID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return device
+}
+
+var importTargets = []importTarget{{
+	name: "memstore",
+	container: func(t *testing.T) store.DeviceContainer {
+		return memstore.New(waLog.Noop)
+	},
+}, {
+	name: "kvstore",
+	container: func(t *testing.T) store.DeviceContainer {
+		container, err := kvstore.New(filepath.Join(t.TempDir(), "whatsmeow.kv"), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() {
+			_ = container.Close()
+		})
+		return container
+	},
+}, {
+	name: "sqlstore",
+	container: func(t *testing.T) store.DeviceContainer {
+		db, err := sql.Open("sqlite3", "file:"+filepath.Join(t.TempDir(), "whatsmeow.db")+"?_foreign_keys=on")
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() {
+			_ = db.Close()
+		})
+		container := sqlstore.NewWithDB(db, "sqlite3", nil)
+		if err = container.Upgrade(); err != nil {
+			t.Fatal(err)
+		}
+		return container
+	},
+}}
+
+func TestExportImport(t *testing.T) {
+	device := newTestDevice(t)
+	expected := exportDeviceData(t, device)
+	val := reflect.ValueOf(expected).Elem()
+	for i := 0; i < val.NumField(); i++ {
+		if val.Field(i).Len() == 0 {
+			t.Fatalf("test device doesn't have any %s", val.Type().Field(i).Name)
+		}
+	}
+	data, err := archive.Export(device, testPassphrase)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, target := range importTargets {
+		t.Run(target.name, func(t *testing.T) {
+			container := target.container(t)
+			if _, err := archive.Import(container, data, "hunter3", nil); !errors.Is(err, archive.ErrWrongPassphrase) {
+				t.Fatalf("expected ErrWrongPassphrase, got %v", err)
+			}
+			modified := append([]byte(nil), data...)
+			modified[len(modified)-1] ^= 1
+			if _, err := archive.Import(container, modified, testPassphrase, nil); !errors.Is(err, archive.ErrWrongPassphrase) {
+				t.Fatalf("expected ErrWrongPassphrase for modified archive, got %v", err)
+			}
+			// The key derivation parameters are checked before deriving the key, as they're not authenticated until then
+			hugeMemory := append([]byte(nil), data...)
+			params := bytes.Index(hugeMemory, []byte{0, 0, 0, 3, 0, 1, 0, 0})
+			binary.BigEndian.PutUint32(hugeMemory[params+4:], 1024*1024)
+			if _, err := archive.Import(container, hugeMemory, testPassphrase, nil); !errors.Is(err, archive.ErrInvalidArchive) {
+				t.Fatalf("expected ErrInvalidArchive for 1 GiB of key derivation memory, got %v", err)
+			}
+			if _, err := archive.Import(container, data, testPassphrase, nil); err != nil {
+				t.Fatal(err)
+			}
+
+			imported := getDevice(t, container)
+			if imported.JID == nil || *imported.JID != testJID {
+				t.Fatal("device wasn't imported")
+			} else if *imported.IdentityKey.Priv != *device.IdentityKey.Priv || *imported.NoiseKey.Priv != *device.NoiseKey.Priv ||
+				*imported.SignedPreKey.Priv != *device.SignedPreKey.Priv || *imported.SignedPreKey.Signature != *device.SignedPreKey.Signature {
+				t.Fatal("device keys weren't imported")
+			} else if imported.PushName != "Test" || string(imported.Account.GetDetails()) != "details" {
+				t.Fatal("device info wasn't imported")
+			} else if importedData := exportDeviceData(t, imported); !reflect.DeepEqual(importedData, expected) {
+				t.Fatalf("imported device data doesn't match:\n%+v\n%+v", importedData, expected)
+			}
+
+			if _, err := archive.Import(container, data, testPassphrase, nil); !errors.Is(err, archive.ErrDeviceExists) {
+				t.Fatalf("expected ErrDeviceExists, got %v", err)
+			}
+		})
+	}
+}
+
+func TestImportFailureDeletesDevice(t *testing.T) {
+	data, err := archive.Export(newTestDevice(t), testPassphrase)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := sql.Open("sqlite3", "file:"+filepath.Join(t.TempDir(), "whatsmeow.db")+"?_foreign_keys=on")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	container := sqlstore.NewWithDB(db, "sqlite3", nil)
+	if err = container.Upgrade(); err != nil {
+		t.Fatal(err)
+	} else if _, err = db.Exec("DROP TABLE whatsmeow_sessions"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = archive.Import(container, data, testPassphrase, nil); err == nil {
+		t.Fatal("import succeeded without sessions table")
+	} else if device := getDevice(t, container); device.JID != nil {
+		t.Fatal("device wasn't deleted after failed import")
+	}
+}
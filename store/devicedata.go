@@ -0,0 +1,138 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package store
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/go-whatsapp/whatsmeow/types"
+)
+
+// ErrInvalidDeviceData is returned by DeviceData.Validate if the data contains keys with invalid lengths.
+var ErrInvalidDeviceData = errors.New("invalid device data")
+
+type PreKeyData struct {
+	ID       uint32 `json:"id"`
+	Key      []byte `json:"key"`
+	Uploaded bool   `json:"uploaded"`
+}
+
+type SenderKeyData struct {
+	Group string `json:"group"`
+	User  string `json:"user"`
+	Key   []byte `json:"key"`
+}
+
+type AppStateSyncKeyData struct {
+	ID          []byte `json:"id"`
+	Data        []byte `json:"data"`
+	Fingerprint []byte `json:"fingerprint"`
+	Timestamp   int64  `json:"timestamp"`
+}
+
+type AppStateVersionData struct {
+	Name    string `json:"name"`
+	Version uint64 `json:"version"`
+	Hash    []byte `json:"hash"`
+}
+
+type AppStateMutationMACData struct {
+	Name     string `json:"name"`
+	Version  uint64 `json:"version"`
+	IndexMAC []byte `json:"index_mac"`
+	ValueMAC []byte `json:"value_mac"`
+}
+
+type ContactData struct {
+	JID          types.JID `json:"jid"`
+	FirstName    string    `json:"first_name,omitempty"`
+	FullName     string    `json:"full_name,omitempty"`
+	PushName     string    `json:"push_name,omitempty"`
+	BusinessName string    `json:"business_name,omitempty"`
+}
+
+type ChatSettingsData struct {
+	Chat       types.JID `json:"chat"`
+	MutedUntil int64     `json:"muted_until,omitempty"`
+	Pinned     bool      `json:"pinned,omitempty"`
+	Archived   bool      `json:"archived,omitempty"`
+}
+
+type MessageSecretData struct {
+	Chat   types.JID       `json:"chat"`
+	Sender types.JID       `json:"sender"`
+	ID     types.MessageID `json:"id"`
+	Secret []byte          `json:"secret"`
+}
+
+type PrivacyTokenData struct {
+	User      types.JID `json:"user"`
+	Token     []byte    `json:"token"`
+	Timestamp int64     `json:"timestamp"`
+}
+
+// DeviceData contains all the data that the stores of a single device have,
+// in a format that is independent of the store implementation.
+type DeviceData struct {
+	Identities           map[string][]byte         `json:"identities"`
+	Sessions             map[string][]byte         `json:"sessions"`
+	PreKeys              []PreKeyData              `json:"pre_keys"`
+	SenderKeys           []SenderKeyData           `json:"sender_keys"`
+	AppStateSyncKeys     []AppStateSyncKeyData     `json:"app_state_sync_keys"`
+	AppStateVersions     []AppStateVersionData     `json:"app_state_versions"`
+	AppStateMutationMACs []AppStateMutationMACData `json:"app_state_mutation_macs"`
+	Contacts             []ContactData             `json:"contacts"`
+	ChatSettings         []ChatSettingsData        `json:"chat_settings"`
+	MessageSecrets       []MessageSecretData       `json:"message_secrets"`
+	PrivacyTokens        []PrivacyTokenData        `json:"privacy_tokens"`
+}
+
+// DeviceDataStore is implemented by stores that can export and import all the data of a device at once.
+// All the stores in this module implement it on the same object as the other store interfaces.
+//
+// It's used for moving devices between containers, see the archive package.
+type DeviceDataStore interface {
+	ExportDeviceData() (*DeviceData, error)
+	// ImportDeviceData stores the given data the same way the individual store methods would,
+	// i.e. existing values are overwritten, except for message secrets, which are never replaced.
+	ImportDeviceData(data *DeviceData) error
+}
+
+// DeviceDataContainer is implemented by device containers that devices can be imported into.
+// All the containers in this module implement it.
+type DeviceDataContainer interface {
+	DeviceContainer
+	GetDevice(jid types.JID) (*Device, error)
+	// GetDeviceDataStore returns the store that the device with the given JID uses once it's saved in the container.
+	GetDeviceDataStore(jid types.JID) DeviceDataStore
+}
+
+// Validate checks that all keys and MACs in the data have the correct lengths.
+func (dd *DeviceData) Validate() error {
+	for address, key := range dd.Identities {
+		if len(key) != 32 {
+			return fmt.Errorf("%w: identity key of %s has invalid length", ErrInvalidDeviceData, address)
+		}
+	}
+	for _, key := range dd.PreKeys {
+		if len(key.Key) != 32 {
+			return fmt.Errorf("%w: prekey %d has invalid length", ErrInvalidDeviceData, key.ID)
+		}
+	}
+	for _, version := range dd.AppStateVersions {
+		if len(version.Hash) != 128 {
+			return fmt.Errorf("%w: app state hash of %s has invalid length", ErrInvalidDeviceData, version.Name)
+		}
+	}
+	for _, mac := range dd.AppStateMutationMACs {
+		if len(mac.IndexMAC) != 32 || len(mac.ValueMAC) != 32 {
+			return fmt.Errorf("%w: app state mutation MAC of %s has invalid length", ErrInvalidDeviceData, mac.Name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,273 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package kvstore
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	mathRand "math/rand"
+
+	"github.com/go-whatsapp/go-util/random"
+	"google.golang.org/protobuf/proto"
+
+	waProto "github.com/go-whatsapp/whatsmeow/binary/proto"
+	"github.com/go-whatsapp/whatsmeow/store"
+	"github.com/go-whatsapp/whatsmeow/types"
+	"github.com/go-whatsapp/whatsmeow/util/keys"
+	waLog "github.com/go-whatsapp/whatsmeow/util/log"
+)
+
+// ErrDeviceIDMustBeSet is the error returned by PutDevice if you try to save a device before knowing its JID.
+var ErrDeviceIDMustBeSet = errors.New("device JID must be known before accessing database")
+
+// ErrInvalidLength is returned when a stored key has an invalid length.
+var ErrInvalidLength = errors.New("database returned byte array with illegal length")
+
+// Container is a wrapper for a key-value database that can contain multiple whatsmeow sessions.
+type Container struct {
+	db  KV
+	log waLog.Logger
+
+	DatabaseErrorHandler func(device *store.Device, action string, attemptIndex int, err error) (retry bool)
+}
+
+var _ store.DeviceContainer = (*Container)(nil)
+
+// New opens the given file with the embedded key-value database and wraps it in a Container.
+//
+// The logger can be nil and will default to a no-op logger.
+func New(path string, log waLog.Logger) (*Container, error) {
+	db, err := OpenFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+	return NewWithDB(db, log), nil
+}
+
+// NewWithDB wraps an existing key-value database in a Container.
+//
+// The logger can be nil and will default to a no-op logger.
+func NewWithDB(db KV, log waLog.Logger) *Container {
+	if log == nil {
+		log = waLog.Noop
+	}
+	return &Container{
+		db:  db,
+		log: log,
+	}
+}
+
+const devicePrefix = "device\x00"
+
+func deviceKey(jid types.JID) []byte {
+	return []byte(devicePrefix + jid.String())
+}
+
+// deviceData is the format that devices are stored in.
+type deviceData struct {
+	JID             types.JID `json:"jid"`
+	RegistrationID  uint32    `json:"registration_id"`
+	NoiseKey        []byte    `json:"noise_key"`
+	IdentityKey     []byte    `json:"identity_key"`
+	SignedPreKey    []byte    `json:"signed_pre_key"`
+	SignedPreKeyID  uint32    `json:"signed_pre_key_id"`
+	SignedPreKeySig []byte    `json:"signed_pre_key_sig"`
+	AdvSecretKey    []byte    `json:"adv_key"`
+	Account         []byte    `json:"account"`
+	Platform        string    `json:"platform"`
+	BusinessName    string    `json:"business_name"`
+	PushName        string    `json:"push_name"`
+}
+
+func (c *Container) initDevice(device *store.Device) {
+	innerStore := NewKVStore(c, *device.JID)
+	device.Identities = innerStore
+	device.Sessions = innerStore
+	device.PreKeys = innerStore
+	device.SenderKeys = innerStore
+	device.AppStateKeys = innerStore
+	device.AppState = innerStore
+	device.Contacts = innerStore
+	device.ChatSettings = innerStore
+	device.MsgSecrets = innerStore
+	device.PrivacyTokens = innerStore
+	device.Container = c
+	device.Initialized = true
+}
+
+func (c *Container) parseDevice(value []byte) (*store.Device, error) {
+	var data deviceData
+	if err := json.Unmarshal(value, &data); err != nil {
+		return nil, fmt.Errorf("failed to parse device: %w", err)
+	} else if len(data.NoiseKey) != 32 || len(data.IdentityKey) != 32 || len(data.SignedPreKey) != 32 || len(data.SignedPreKeySig) != 64 {
+		return nil, ErrInvalidLength
+	}
+	var account waProto.ADVSignedDeviceIdentity
+	if err := proto.Unmarshal(data.Account, &account); err != nil {
+		return nil, fmt.Errorf("failed to parse device account: %w", err)
+	}
+	device := &store.Device{
+		Log:                  c.log,
+		DatabaseErrorHandler: c.DatabaseErrorHandler,
+
+		NoiseKey:       keys.NewKeyPairFromPrivateKey(*(*[32]byte)(data.NoiseKey)),
+		IdentityKey:    keys.NewKeyPairFromPrivateKey(*(*[32]byte)(data.IdentityKey)),
+		SignedPreKey:   &keys.PreKey{KeyID: data.SignedPreKeyID, Signature: (*[64]byte)(data.SignedPreKeySig)},
+		RegistrationID: data.RegistrationID,
+		AdvSecretKey:   data.AdvSecretKey,
+		JID:            &data.JID,
+		Account:        &account,
+		Platform:       data.Platform,
+		BusinessName:   data.BusinessName,
+		PushName:       data.PushName,
+	}
+	device.SignedPreKey.KeyPair = *keys.NewKeyPairFromPrivateKey(*(*[32]byte)(data.SignedPreKey))
+	c.initDevice(device)
+	return device, nil
+}
+
+// GetAllDevices finds all the devices in the database.
+func (c *Container) GetAllDevices() ([]*store.Device, error) {
+	devices := make([]*store.Device, 0)
+	err := c.db.Scan([]byte(devicePrefix), func(key, value []byte) error {
+		device, err := c.parseDevice(value)
+		if err != nil {
+			return err
+		}
+		devices = append(devices, device)
+		return nil
+	})
+	if err != nil {
+		return devices, fmt.Errorf("failed to scan devices: %w", err)
+	}
+	return devices, nil
+}
+
+// GetFirstDevice is a convenience method for getting the first device in the store. If there are
+// no devices, then a new device will be created. You should only use this if you don't want to
+// have multiple sessions simultaneously.
+func (c *Container) GetFirstDevice() (*store.Device, error) {
+	devices, err := c.GetAllDevices()
+	if err != nil {
+		return nil, err
+	}
+	if len(devices) == 0 {
+		return c.NewDevice(), nil
+	} else {
+		return devices[0], nil
+	}
+}
+
+// errStopScan is used to stop a scan early after finding the wanted item.
+var errStopScan = errors.New("stop scan")
+
+// GetDevice finds the device with the given JID in the database. If there's no exact match,
+// a device of the same user is returned instead.
+//
+// If the device doesn't exist, a new device will be created and returned.
+func (c *Container) GetDevice(jid types.JID) (*store.Device, error) {
+	value, err := c.db.Get(deviceKey(jid))
+	if err != nil {
+		return nil, err
+	} else if value != nil {
+		return c.parseDevice(value)
+	}
+	err = c.db.Scan([]byte(devicePrefix+jid.User), func(key, val []byte) error {
+		otherJID, err := types.ParseJID(string(key[len(devicePrefix):]))
+		if err == nil && otherJID.User == jid.User && otherJID.Server == jid.Server {
+			value = val
+			return errStopScan
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, errStopScan) {
+		return nil, err
+	} else if value != nil {
+		return c.parseDevice(value)
+	}
+	return c.NewDevice(), nil
+}
+
+// NewDevice creates a new device in this database.
+//
+// No data is actually stored before Save is called. However, the pairing process will automatically
+// call Save after a successful pairing, so you most likely don't need to call it yourself.
+func (c *Container) NewDevice() *store.Device {
+	device := &store.Device{
+		Log:       c.log,
+		Container: c,
+
+		DatabaseErrorHandler: c.DatabaseErrorHandler,
+
+		NoiseKey:       keys.NewKeyPair(),
+		IdentityKey:    keys.NewKeyPair(),
+		RegistrationID: mathRand.Uint32(),
+		AdvSecretKey:   random.Bytes(32),
+	}
+	device.SignedPreKey = device.IdentityKey.CreateSignedPreKey(1)
+	return device
+}
+
+// Close will close the container's database
+func (c *Container) Close() error {
+	if c != nil && c.db != nil {
+		return c.db.Close()
+	}
+	return nil
+}
+
+// PutDevice stores the given device in this database. This should be called through Device.Save()
+// (which usually doesn't need to be called manually, as the library does that automatically when relevant).
+func (c *Container) PutDevice(device *store.Device) error {
+	if device.JID == nil {
+		return ErrDeviceIDMustBeSet
+	}
+	account, err := proto.Marshal(device.Account)
+	if err != nil {
+		return fmt.Errorf("failed to marshal account: %w", err)
+	}
+	value, err := json.Marshal(&deviceData{
+		JID:             *device.JID,
+		RegistrationID:  device.RegistrationID,
+		NoiseKey:        device.NoiseKey.Priv[:],
+		IdentityKey:     device.IdentityKey.Priv[:],
+		SignedPreKey:    device.SignedPreKey.Priv[:],
+		SignedPreKeyID:  device.SignedPreKey.KeyID,
+		SignedPreKeySig: device.SignedPreKey.Signature[:],
+		AdvSecretKey:    device.AdvSecretKey,
+		Account:         account,
+		Platform:        device.Platform,
+		BusinessName:    device.BusinessName,
+		PushName:        device.PushName,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal device: %w", err)
+	}
+	err = c.db.Put(deviceKey(*device.JID), value)
+	if !device.Initialized {
+		c.initDevice(device)
+	}
+	return err
+}
+
+// DeleteDevice deletes the given device and all of its data from this database. This should be called through Device.Delete()
+func (c *Container) DeleteDevice(store *store.Device) error {
+	if store.JID == nil {
+		return ErrDeviceIDMustBeSet
+	}
+	var batch Batch
+	batch.Delete(deviceKey(*store.JID))
+	err := c.db.Scan(dataPrefix(*store.JID), func(key, _ []byte) error {
+		batch.Delete(key)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return c.db.Write(&batch)
+}
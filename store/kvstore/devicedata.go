@@ -0,0 +1,284 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package kvstore
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+
+	"github.com/go-whatsapp/whatsmeow/store"
+	"github.com/go-whatsapp/whatsmeow/types"
+)
+
+var _ store.DeviceDataStore = (*KVStore)(nil)
+var _ store.DeviceDataContainer = (*Container)(nil)
+
+// GetDeviceDataStore returns the store that the device with the given JID uses.
+func (c *Container) GetDeviceDataStore(jid types.JID) store.DeviceDataStore {
+	return NewKVStore(c, jid)
+}
+
+// scanTable calls the given function with the key parts after the table name for every item in the table.
+func (s *KVStore) scanTable(table string, partCount int, fn func(parts [][]byte, value []byte) error) error {
+	prefix := s.key(table, "")
+	return s.db.Scan(prefix, func(key, value []byte) error {
+		parts := bytes.SplitN(key[len(prefix):], []byte{0}, partCount)
+		if len(parts) != partCount {
+			return fmt.Errorf("invalid key in %s table", table)
+		}
+		return fn(parts, value)
+	})
+}
+
+func (s *KVStore) ExportDeviceData() (*store.DeviceData, error) {
+	data := &store.DeviceData{
+		Identities: make(map[string][]byte),
+		Sessions:   make(map[string][]byte),
+	}
+	err := s.scanTable(tableIdentity, 1, func(parts [][]byte, value []byte) error {
+		data.Identities[string(parts[0])] = value
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to export identities: %w", err)
+	}
+	err = s.scanTable(tableSession, 1, func(parts [][]byte, value []byte) error {
+		data.Sessions[string(parts[0])] = value
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to export sessions: %w", err)
+	}
+	err = s.db.Scan(s.key(tablePreKey, ""), func(key, value []byte) error {
+		preKey, uploaded, err := parsePreKey(key, value)
+		if err != nil {
+			return err
+		}
+		data.PreKeys = append(data.PreKeys, store.PreKeyData{ID: preKey.KeyID, Key: preKey.Priv[:], Uploaded: uploaded})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to export prekeys: %w", err)
+	}
+	err = s.scanTable(tableSenderKey, 2, func(parts [][]byte, value []byte) error {
+		data.SenderKeys = append(data.SenderKeys, store.SenderKeyData{Group: string(parts[0]), User: string(parts[1]), Key: value})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to export sender keys: %w", err)
+	}
+	err = s.scanTable(tableAppStateSyncKey, 1, func(parts [][]byte, value []byte) error {
+		var key appStateSyncKey
+		id, err := hex.DecodeString(string(parts[0]))
+		if err != nil {
+			return err
+		} else if err = json.Unmarshal(value, &key); err != nil {
+			return err
+		}
+		data.AppStateSyncKeys = append(data.AppStateSyncKeys, store.AppStateSyncKeyData{
+			ID:          id,
+			Data:        key.Data,
+			Fingerprint: key.Fingerprint,
+			Timestamp:   key.Timestamp,
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to export app state sync keys: %w", err)
+	}
+	err = s.scanTable(tableAppStateVersion, 1, func(parts [][]byte, value []byte) error {
+		if len(value) != 8+128 {
+			return ErrInvalidLength
+		}
+		data.AppStateVersions = append(data.AppStateVersions, store.AppStateVersionData{
+			Name:    string(parts[0]),
+			Version: binary.BigEndian.Uint64(value[:8]),
+			Hash:    value[8:],
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to export app state versions: %w", err)
+	}
+	err = s.scanTable(tableAppStateMAC, 3, func(parts [][]byte, value []byte) error {
+		indexMAC, err := hex.DecodeString(string(parts[1]))
+		if err != nil {
+			return err
+		} else if len(parts[2]) != 8 {
+			return ErrInvalidLength
+		}
+		data.AppStateMutationMACs = append(data.AppStateMutationMACs, store.AppStateMutationMACData{
+			Name:     string(parts[0]),
+			Version:  binary.BigEndian.Uint64(parts[2]),
+			IndexMAC: indexMAC,
+			ValueMAC: value,
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to export app state mutation MACs: %w", err)
+	}
+	contacts, err := s.GetAllContacts()
+	if err != nil {
+		return nil, fmt.Errorf("failed to export contacts: %w", err)
+	}
+	for jid, info := range contacts {
+		data.Contacts = append(data.Contacts, store.ContactData{
+			JID:          jid,
+			FirstName:    info.FirstName,
+			FullName:     info.FullName,
+			PushName:     info.PushName,
+			BusinessName: info.BusinessName,
+		})
+	}
+	err = s.scanTable(tableChatSettings, 1, func(parts [][]byte, value []byte) error {
+		var settings chatSettings
+		chat, err := types.ParseJID(string(parts[0]))
+		if err != nil {
+			return err
+		} else if err = json.Unmarshal(value, &settings); err != nil {
+			return err
+		}
+		data.ChatSettings = append(data.ChatSettings, store.ChatSettingsData{
+			Chat:       chat,
+			MutedUntil: settings.MutedUntil,
+			Pinned:     settings.Pinned,
+			Archived:   settings.Archived,
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to export chat settings: %w", err)
+	}
+	err = s.scanTable(tableMessageSecret, 3, func(parts [][]byte, value []byte) error {
+		chat, err := types.ParseJID(string(parts[0]))
+		if err != nil {
+			return err
+		}
+		sender, err := types.ParseJID(string(parts[1]))
+		if err != nil {
+			return err
+		}
+		data.MessageSecrets = append(data.MessageSecrets, store.MessageSecretData{
+			Chat:   chat,
+			Sender: sender,
+			ID:     string(parts[2]),
+			Secret: value,
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to export message secrets: %w", err)
+	}
+	err = s.scanTable(tablePrivacyToken, 1, func(parts [][]byte, value []byte) error {
+		var token privacyToken
+		user, err := types.ParseJID(string(parts[0]))
+		if err != nil {
+			return err
+		} else if err = json.Unmarshal(value, &token); err != nil {
+			return err
+		}
+		data.PrivacyTokens = append(data.PrivacyTokens, store.PrivacyTokenData{
+			User:      user,
+			Token:     token.Token,
+			Timestamp: token.Timestamp,
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to export privacy tokens: %w", err)
+	}
+	return data, nil
+}
+
+func (s *KVStore) ImportDeviceData(data *store.DeviceData) error {
+	if err := data.Validate(); err != nil {
+		return err
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	var batch Batch
+	putJSON := func(key []byte, value any) error {
+		encoded, err := json.Marshal(value)
+		if err != nil {
+			return err
+		}
+		batch.Put(key, encoded)
+		return nil
+	}
+	for address, key := range data.Identities {
+		batch.Put(s.key(tableIdentity, address), key)
+	}
+	for address, session := range data.Sessions {
+		batch.Put(s.key(tableSession, address), session)
+	}
+	for _, key := range data.PreKeys {
+		var uploaded byte
+		if key.Uploaded {
+			uploaded = 1
+		}
+		batch.Put(s.preKeyKey(key.ID), append([]byte{uploaded}, key.Key...))
+	}
+	for _, key := range data.SenderKeys {
+		batch.Put(s.key(tableSenderKey, key.Group, key.User), key.Key)
+	}
+	for _, key := range data.AppStateSyncKeys {
+		err := putJSON(s.key(tableAppStateSyncKey, hex.EncodeToString(key.ID)), &appStateSyncKey{
+			Data:        key.Data,
+			Fingerprint: key.Fingerprint,
+			Timestamp:   key.Timestamp,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	for _, version := range data.AppStateVersions {
+		batch.Put(s.key(tableAppStateVersion, version.Name), append(binary.BigEndian.AppendUint64(nil, version.Version), version.Hash...))
+	}
+	for _, mac := range data.AppStateMutationMACs {
+		batch.Put(s.mutationMACKey(mac.Name, mac.IndexMAC, mac.Version), mac.ValueMAC)
+	}
+	for _, entry := range data.Contacts {
+		err := putJSON(s.key(tableContact, entry.JID.String()), &contact{
+			FirstName:    entry.FirstName,
+			FullName:     entry.FullName,
+			PushName:     entry.PushName,
+			BusinessName: entry.BusinessName,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	for _, settings := range data.ChatSettings {
+		err := putJSON(s.key(tableChatSettings, settings.Chat.String()), &chatSettings{
+			MutedUntil: settings.MutedUntil,
+			Pinned:     settings.Pinned,
+			Archived:   settings.Archived,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	for _, secret := range data.MessageSecrets {
+		key := s.messageSecretKey(secret.Chat, secret.Sender, secret.ID)
+		if existing, err := s.db.Get(key); err != nil {
+			return err
+		} else if existing == nil {
+			batch.Put(key, secret.Secret)
+		}
+	}
+	for _, token := range data.PrivacyTokens {
+		err := putJSON(s.key(tablePrivacyToken, token.User.ToNonAD().String()), &privacyToken{Token: token.Token, Timestamp: token.Timestamp})
+		if err != nil {
+			return err
+		}
+	}
+	return s.db.Write(&batch)
+}
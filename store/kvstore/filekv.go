@@ -0,0 +1,455 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package kvstore
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+var (
+	ErrKVClosed      = errors.New("key-value store is closed")
+	ErrInvalidKVFile = errors.New("file is not a whatsmeow key-value store")
+	ErrCorruptKVFile = errors.New("key-value store file is corrupted")
+)
+
+var fileMagic = []byte("WMKV\x00\x00\x00\x01")
+
+const (
+	opPut    byte = 1
+	opDelete byte = 2
+)
+
+// Each record starts with a header containing the payload length, the CRC32-C of the length,
+// and the CRC32-C of the length and payload together. The length has its own checksum so that
+// a corrupted length is noticed before it's used to find the end of the record.
+const recordHeaderSize = 12
+
+// maxRecordSize is the maximum payload size of a single record.
+const maxRecordSize = 1 << 30
+
+// The file is compacted when it contains more overwritten data than live data, but only if there's at least this much.
+const compactMinWasted = 1 << 20
+
+// Compacted files are written in records of roughly this size.
+const compactRecordSize = 256 * 1024
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// FileKV is a pure-Go embedded implementation of KV that stores everything in a single append-only file.
+//
+// All keys and values are kept in memory. Each write is appended to the file as a checksummed record and
+// synced to disk before returning. If the process crashes in the middle of a write, the partial record is
+// discarded when the file is opened. Damaged records anywhere else in the file make OpenFile return
+// ErrCorruptKVFile instead of silently dropping data. Overwritten data is removed by rewriting the file automatically when
+// it makes up more than half of the file.
+type FileKV struct {
+	lock sync.RWMutex
+	path string
+	file *os.File
+
+	data map[string][]byte
+	keys []string
+
+	fileSize int64
+	liveSize int64
+	closed   bool
+}
+
+var _ KV = (*FileKV)(nil)
+
+// OpenFile opens the given key-value store file, creating it if it doesn't exist.
+func OpenFile(path string) (*FileKV, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file: %w", err)
+	}
+	kv := &FileKV{
+		path: path,
+		file: file,
+		data: make(map[string][]byte),
+	}
+	if err = kv.load(); err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+	if kv.shouldCompact() {
+		if err = kv.compact(); err != nil {
+			_ = kv.file.Close()
+			return nil, err
+		}
+	}
+	return kv, nil
+}
+
+func (kv *FileKV) load() error {
+	stat, err := kv.file.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat file: %w", err)
+	} else if stat.Size() == 0 {
+		if _, err = kv.file.Write(fileMagic); err != nil {
+			return fmt.Errorf("failed to write header: %w", err)
+		}
+		kv.fileSize = int64(len(fileMagic))
+		return kv.file.Sync()
+	}
+	reader := bufio.NewReader(kv.file)
+	magic := make([]byte, len(fileMagic))
+	if _, err = io.ReadFull(reader, magic); err != nil || !bytes.Equal(magic, fileMagic) {
+		return ErrInvalidKVFile
+	}
+	size := stat.Size()
+	offset := int64(len(fileMagic))
+	header := make([]byte, recordHeaderSize)
+	for size-offset >= recordHeaderSize {
+		if _, err = io.ReadFull(reader, header); err != nil {
+			return fmt.Errorf("failed to read record header: %w", err)
+		}
+		length := int64(binary.BigEndian.Uint32(header[:4]))
+		if crc32.Checksum(header[:4], crcTable) != binary.BigEndian.Uint32(header[4:8]) {
+			// The file may have been extended with zeros before the last write reached the disk.
+			// Anything else means the length of a record is corrupted, so the rest of the file can't be read.
+			if isZero, err := isZeroTail(reader); err != nil {
+				return fmt.Errorf("failed to read record: %w", err)
+			} else if !isZero {
+				return fmt.Errorf("%w: invalid record header at offset %d", ErrCorruptKVFile, offset)
+			}
+			break
+		} else if length > maxRecordSize {
+			return fmt.Errorf("%w: record at offset %d is too large", ErrCorruptKVFile, offset)
+		}
+		recordEnd := offset + recordHeaderSize + length
+		if recordEnd > size {
+			// The length is intact, but the file ends before the record does, so the last write was interrupted
+			break
+		}
+		payload := make([]byte, length)
+		if _, err = io.ReadFull(reader, payload); err != nil {
+			return fmt.Errorf("failed to read record: %w", err)
+		}
+		var ops []Op
+		if crc32.Update(crc32.Checksum(header[:4], crcTable), crcTable, payload) == binary.BigEndian.Uint32(header[8:]) {
+			ops, err = decodeOps(payload)
+		} else {
+			err = fmt.Errorf("checksum mismatch")
+		}
+		if err != nil && recordEnd == size {
+			// Only the last record can be partially written, anything else means the file is broken
+			break
+		} else if err != nil {
+			return fmt.Errorf("%w: invalid record at offset %d: %v", ErrCorruptKVFile, offset, err)
+		}
+		for _, op := range ops {
+			kv.applyToMap(op)
+		}
+		offset = recordEnd
+	}
+	if offset < size {
+		// The last write was interrupted, drop the partial record
+		if err = kv.file.Truncate(offset); err != nil {
+			return fmt.Errorf("failed to truncate partial record: %w", err)
+		}
+	}
+	if _, err = kv.file.Seek(offset, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to seek to end of file: %w", err)
+	}
+	kv.fileSize = offset
+	kv.keys = make([]string, 0, len(kv.data))
+	for key := range kv.data {
+		kv.keys = append(kv.keys, key)
+	}
+	sort.Strings(kv.keys)
+	return nil
+}
+
+// isZeroTail checks if the rest of the reader only contains zero bytes.
+func isZeroTail(reader io.Reader) (bool, error) {
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := reader.Read(buf)
+		for _, b := range buf[:n] {
+			if b != 0 {
+				return false, nil
+			}
+		}
+		if errors.Is(err, io.EOF) {
+			return true, nil
+		} else if err != nil {
+			return false, err
+		}
+	}
+}
+
+func encodedOpSize(key, value []byte) int64 {
+	return int64(1 + binary.MaxVarintLen32*2 + len(key) + len(value))
+}
+
+func encodeOps(ops []Op) []byte {
+	var payload []byte
+	for _, op := range ops {
+		if op.Delete {
+			payload = append(payload, opDelete)
+			payload = binary.AppendUvarint(payload, uint64(len(op.Key)))
+			payload = append(payload, op.Key...)
+		} else {
+			payload = append(payload, opPut)
+			payload = binary.AppendUvarint(payload, uint64(len(op.Key)))
+			payload = append(payload, op.Key...)
+			payload = binary.AppendUvarint(payload, uint64(len(op.Value)))
+			payload = append(payload, op.Value...)
+		}
+	}
+	return payload
+}
+
+func readLengthPrefixed(payload []byte) ([]byte, []byte, error) {
+	length, n := binary.Uvarint(payload)
+	if n <= 0 || uint64(len(payload)-n) < length {
+		return nil, nil, fmt.Errorf("invalid length")
+	}
+	return payload[n : n+int(length)], payload[n+int(length):], nil
+}
+
+func decodeOps(payload []byte) (ops []Op, err error) {
+	for len(payload) > 0 {
+		var op Op
+		opType := payload[0]
+		op.Key, payload, err = readLengthPrefixed(payload[1:])
+		if err != nil {
+			return nil, err
+		}
+		switch opType {
+		case opPut:
+			op.Value, payload, err = readLengthPrefixed(payload)
+			if err != nil {
+				return nil, err
+			}
+		case opDelete:
+			op.Delete = true
+		default:
+			return nil, fmt.Errorf("unknown operation %d", opType)
+		}
+		ops = append(ops, op)
+	}
+	return ops, nil
+}
+
+func (kv *FileKV) applyToMap(op Op) (isNew, deleted bool) {
+	key := string(op.Key)
+	existing, exists := kv.data[key]
+	if exists {
+		kv.liveSize -= encodedOpSize(op.Key, existing)
+	}
+	if op.Delete {
+		delete(kv.data, key)
+		return false, exists
+	}
+	kv.data[key] = bytes.Clone(op.Value)
+	if kv.data[key] == nil {
+		kv.data[key] = []byte{}
+	}
+	kv.liveSize += encodedOpSize(op.Key, op.Value)
+	return !exists, false
+}
+
+func (kv *FileKV) apply(op Op) {
+	isNew, deleted := kv.applyToMap(op)
+	key := string(op.Key)
+	if isNew {
+		i := sort.SearchStrings(kv.keys, key)
+		kv.keys = append(kv.keys, "")
+		copy(kv.keys[i+1:], kv.keys[i:])
+		kv.keys[i] = key
+	} else if deleted {
+		i := sort.SearchStrings(kv.keys, key)
+		kv.keys = append(kv.keys[:i], kv.keys[i+1:]...)
+	}
+}
+
+func appendRecord(buf, payload []byte) []byte {
+	length := binary.BigEndian.AppendUint32(nil, uint32(len(payload)))
+	lengthCRC := crc32.Checksum(length, crcTable)
+	buf = append(buf, length...)
+	buf = binary.BigEndian.AppendUint32(buf, lengthCRC)
+	buf = binary.BigEndian.AppendUint32(buf, crc32.Update(lengthCRC, crcTable, payload))
+	return append(buf, payload...)
+}
+
+func (kv *FileKV) shouldCompact() bool {
+	wasted := kv.fileSize - int64(len(fileMagic)) - kv.liveSize
+	return wasted > compactMinWasted && wasted > kv.liveSize
+}
+
+// Compact rewrites the file so that it only contains the current values.
+//
+// This is done automatically when needed, so there's usually no reason to call it manually.
+func (kv *FileKV) Compact() error {
+	kv.lock.Lock()
+	defer kv.lock.Unlock()
+	if kv.closed {
+		return ErrKVClosed
+	}
+	return kv.compact()
+}
+
+func (kv *FileKV) compact() error {
+	tempFile, err := os.CreateTemp(filepath.Dir(kv.path), ".tmp-"+filepath.Base(kv.path)+"-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	success := false
+	defer func() {
+		if !success {
+			_ = tempFile.Close()
+			_ = os.Remove(tempFile.Name())
+		}
+	}()
+	writer := bufio.NewWriter(tempFile)
+	size := int64(len(fileMagic))
+	_, _ = writer.Write(fileMagic)
+	var ops []Op
+	var opsSize int64
+	flush := func() {
+		record := appendRecord(nil, encodeOps(ops))
+		_, _ = writer.Write(record)
+		size += int64(len(record))
+		ops, opsSize = ops[:0], 0
+	}
+	for _, key := range kv.keys {
+		value := kv.data[key]
+		ops = append(ops, Op{Key: []byte(key), Value: value})
+		opsSize += encodedOpSize([]byte(key), value)
+		if opsSize >= compactRecordSize {
+			flush()
+		}
+	}
+	if len(ops) > 0 {
+		flush()
+	}
+	if err = writer.Flush(); err != nil {
+		return fmt.Errorf("failed to write compacted file: %w", err)
+	} else if err = tempFile.Sync(); err != nil {
+		return fmt.Errorf("failed to sync compacted file: %w", err)
+	} else if err = os.Rename(tempFile.Name(), kv.path); err != nil {
+		return fmt.Errorf("failed to replace file with compacted file: %w", err)
+	}
+	success = true
+	syncDir(filepath.Dir(kv.path))
+	_ = kv.file.Close()
+	kv.file = tempFile
+	kv.fileSize = size
+	return nil
+}
+
+func syncDir(path string) {
+	dir, err := os.Open(path)
+	if err == nil {
+		_ = dir.Sync()
+		_ = dir.Close()
+	}
+}
+
+// Get returns the value of the given key, or nil if the key doesn't exist.
+func (kv *FileKV) Get(key []byte) ([]byte, error) {
+	kv.lock.RLock()
+	defer kv.lock.RUnlock()
+	if kv.closed {
+		return nil, ErrKVClosed
+	}
+	return kv.data[string(key)], nil
+}
+
+// Put sets the value of the given key.
+func (kv *FileKV) Put(key, value []byte) error {
+	return kv.Write(&Batch{Ops: []Op{{Key: key, Value: value}}})
+}
+
+// Delete removes the given key.
+func (kv *FileKV) Delete(key []byte) error {
+	return kv.Write(&Batch{Ops: []Op{{Key: key, Delete: true}}})
+}
+
+// Scan calls the given function for every key with the given prefix in ascending order.
+//
+// The function is called without holding any locks, so it's safe to modify the store inside it.
+func (kv *FileKV) Scan(prefix []byte, fn func(key, value []byte) error) error {
+	kv.lock.RLock()
+	if kv.closed {
+		kv.lock.RUnlock()
+		return ErrKVClosed
+	}
+	strPrefix := string(prefix)
+	var matches []Op
+	for i := sort.SearchStrings(kv.keys, strPrefix); i < len(kv.keys) && strings.HasPrefix(kv.keys[i], strPrefix); i++ {
+		matches = append(matches, Op{Key: []byte(kv.keys[i]), Value: kv.data[kv.keys[i]]})
+	}
+	kv.lock.RUnlock()
+	for _, match := range matches {
+		if err := fn(match.Key, match.Value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Write appends the batch to the file as a single record and applies it. Batches are limited to 1 GiB.
+func (kv *FileKV) Write(batch *Batch) error {
+	if batch.Len() == 0 {
+		return nil
+	}
+	payload := encodeOps(batch.Ops)
+	if len(payload) > maxRecordSize {
+		return fmt.Errorf("batch is too large (%d bytes, max %d)", len(payload), maxRecordSize)
+	}
+	record := appendRecord(nil, payload)
+	kv.lock.Lock()
+	defer kv.lock.Unlock()
+	if kv.closed {
+		return ErrKVClosed
+	}
+	_, err := kv.file.Write(record)
+	if err == nil {
+		err = kv.file.Sync()
+	}
+	if err != nil {
+		// Try to remove the partial record, so that future writes aren't appended after garbage
+		_ = kv.file.Truncate(kv.fileSize)
+		_, _ = kv.file.Seek(kv.fileSize, io.SeekStart)
+		return fmt.Errorf("failed to write to file: %w", err)
+	}
+	kv.fileSize += int64(len(record))
+	for _, op := range batch.Ops {
+		kv.apply(op)
+	}
+	if kv.shouldCompact() {
+		// The write itself already succeeded, so compaction errors are ignored here.
+		// Compaction will be attempted again on the next write.
+		_ = kv.compact()
+	}
+	return nil
+}
+
+// Close closes the file. The store can't be used after closing.
+func (kv *FileKV) Close() error {
+	kv.lock.Lock()
+	defer kv.lock.Unlock()
+	if kv.closed {
+		return nil
+	}
+	kv.closed = true
+	return kv.file.Close()
+}
@@ -0,0 +1,55 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package kvstore
+
+// KV is a generic ordered key-value database that the store can be built on.
+//
+// Implementations must be safe for concurrent use. Keys are compared as raw bytes.
+type KV interface {
+	// Get returns the value of the given key, or nil if the key doesn't exist.
+	// The returned slice must not be modified.
+	Get(key []byte) ([]byte, error)
+	// Put sets the value of the given key.
+	Put(key, value []byte) error
+	// Delete removes the given key. Deleting a nonexistent key is not an error.
+	Delete(key []byte) error
+	// Scan calls the given function for every key that starts with the given prefix in ascending order.
+	// If the function returns an error, the scan is stopped and the error is returned.
+	// The function may keep references to the key and value slices, but must not modify them.
+	Scan(prefix []byte, fn func(key, value []byte) error) error
+	// Write applies all operations in the batch atomically.
+	Write(batch *Batch) error
+	// Close closes the database.
+	Close() error
+}
+
+// Op is a single operation in a Batch.
+type Op struct {
+	Key    []byte
+	Value  []byte
+	Delete bool
+}
+
+// Batch is a list of operations that are applied atomically with KV.Write.
+type Batch struct {
+	Ops []Op
+}
+
+// Put adds a put operation to the batch.
+func (b *Batch) Put(key, value []byte) {
+	b.Ops = append(b.Ops, Op{Key: key, Value: value})
+}
+
+// Delete adds a delete operation to the batch.
+func (b *Batch) Delete(key []byte) {
+	b.Ops = append(b.Ops, Op{Key: key, Delete: true})
+}
+
+// Len returns the number of operations in the batch.
+func (b *Batch) Len() int {
+	return len(b.Ops)
+}
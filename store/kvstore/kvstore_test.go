@@ -0,0 +1,196 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package kvstore_test
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	waProto "github.com/go-whatsapp/whatsmeow/binary/proto"
+	"github.com/go-whatsapp/whatsmeow/store"
+	"github.com/go-whatsapp/whatsmeow/store/kvstore"
+	"github.com/go-whatsapp/whatsmeow/types"
+)
+
+func scanKeys(t *testing.T, kv kvstore.KV, prefix string) (keys []string) {
+	t.Helper()
+	err := kv.Scan([]byte(prefix), func(key, _ []byte) error {
+		keys = append(keys, string(key))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return
+}
+
+func TestFileKV(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "whatsmeow.kv")
+	kv, err := kvstore.OpenFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = kv.Put([]byte("a:2"), []byte("2"))
+	_ = kv.Put([]byte("a:1"), []byte("1"))
+	_ = kv.Put([]byte("b:1"), []byte("3"))
+	var batch kvstore.Batch
+	batch.Put([]byte("a:3"), []byte("4"))
+	batch.Delete([]byte("b:1"))
+	if err = kv.Write(&batch); err != nil {
+		t.Fatal(err)
+	}
+	_ = kv.Close()
+
+	// A partially written record at the end of the file should be discarded
+	file, _ := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	_, _ = file.Write([]byte{0, 0, 1, 0, 1, 2, 3})
+	_ = file.Close()
+
+	kv, err = kvstore.OpenFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer kv.Close()
+	if keys := scanKeys(t, kv, "a:"); len(keys) != 3 || keys[0] != "a:1" || keys[2] != "a:3" {
+		t.Fatalf("unexpected keys %v", keys)
+	} else if value, _ := kv.Get([]byte("b:1")); value != nil {
+		t.Fatal("deleted key still exists")
+	}
+	if err = kv.Put([]byte("c"), []byte("5")); err != nil {
+		t.Fatal(err)
+	} else if err = kv.Compact(); err != nil {
+		t.Fatal(err)
+	} else if value, _ := kv.Get([]byte("c")); !bytes.Equal(value, []byte("5")) {
+		t.Fatal("value written after truncating partial record is missing")
+	}
+}
+
+func TestKVStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "whatsmeow.kv")
+	container, err := kvstore.New(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	device := container.NewDevice()
+	jid := types.NewADJID("1111", 0, 1)
+	device.JID = &jid
+	device.Account = &waProto.ADVSignedDeviceIdentity{Details: []byte("details")}
+	if err = device.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	preKeys, _ := device.PreKeys.GetOrGenPreKeys(3)
+	_ = device.PreKeys.MarkPreKeysAsUploaded(2)
+	if reused, _ := device.PreKeys.GetOrGenPreKeys(2); reused[0].KeyID != 3 || *reused[0].Priv != *preKeys[2].Priv || reused[1].KeyID != 4 {
+		t.Fatal("unuploaded prekey wasn't reused")
+	} else if count, _ := device.PreKeys.UploadedPreKeyCount(); count != 2 {
+		t.Fatalf("expected 2 uploaded prekeys, got %d", count)
+	}
+
+	_ = device.Sessions.PutSession("2222:0", []byte("s1"))
+	_ = device.Sessions.PutSession("2222:1", []byte("s2"))
+	_ = device.Sessions.PutSession("22223:0", []byte("s3"))
+	_ = device.Sessions.DeleteAllSessions("2222")
+	if has, _ := device.Sessions.HasSession("2222:1"); has {
+		t.Fatal("session wasn't deleted")
+	} else if has, _ = device.Sessions.HasSession("22223:0"); !has {
+		t.Fatal("session of other user was deleted")
+	}
+
+	index := []byte{1, 0, 2}
+	_ = device.AppState.PutAppStateMutationMACs("regular", 1, []store.AppStateMutationMAC{{IndexMAC: index, ValueMAC: []byte("v1")}})
+	_ = device.AppState.PutAppStateMutationMACs("regular", 256, []store.AppStateMutationMAC{{IndexMAC: index, ValueMAC: []byte("v2")}})
+	if value, _ := device.AppState.GetAppStateMutationMAC("regular", index); string(value) != "v2" {
+		t.Fatalf("expected value MAC from latest version, got %q", value)
+	}
+	_ = device.AppState.DeleteAppStateMutationMACs("regular", [][]byte{index})
+	if value, _ := device.AppState.GetAppStateMutationMAC("regular", index); value != nil {
+		t.Fatal("value MAC wasn't deleted")
+	}
+
+	if changed, _, _ := device.Contacts.PutPushName(types.NewJID("2222", types.DefaultUserServer), "Peer"); !changed {
+		t.Fatal("push name wasn't changed")
+	} else if changed, _, _ = device.Contacts.PutPushName(types.NewJID("2222", types.DefaultUserServer), "Peer"); changed {
+		t.Fatal("same push name was reported as changed")
+	}
+	_ = container.Close()
+
+	container, err = kvstore.New(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer container.Close()
+	loaded, err := container.GetDevice(types.NewJID("1111", types.DefaultUserServer))
+	if err != nil {
+		t.Fatal(err)
+	} else if loaded.JID == nil || *loaded.JID != jid || *loaded.IdentityKey.Priv != *device.IdentityKey.Priv {
+		t.Fatal("device wasn't loaded")
+	} else if contacts, _ := loaded.Contacts.GetAllContacts(); contacts[types.NewJID("2222", types.DefaultUserServer)].PushName != "Peer" {
+		t.Fatalf("unexpected contacts %+v", contacts)
+	}
+	if err = loaded.Delete(); err != nil {
+		t.Fatal(err)
+	} else if devices, _ := container.GetAllDevices(); len(devices) != 0 {
+		t.Fatal("device wasn't deleted")
+	} else if session, _ := loaded.Sessions.GetSession("22223:0"); session != nil {
+		t.Fatal("device data wasn't deleted")
+	}
+}
+
+func TestFileKVCorruptLength(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "whatsmeow.kv")
+	kv, err := kvstore.OpenFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"a", "b", "c"} {
+		if err = kv.Put([]byte(key), []byte("1")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_ = kv.Close()
+	original, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The file is an 8-byte magic followed by three 17-byte records (12-byte header, 5-byte payload).
+	// Flip bits in the length of the middle record, both so that it points past the end of the file
+	// and so that it points inside the next record.
+	const secondRecord = 8 + 17
+	for _, flip := range []byte{0x40, 0x02} {
+		corrupted := bytes.Clone(original)
+		corrupted[secondRecord+3] ^= flip
+		if err = os.WriteFile(path, corrupted, 0600); err != nil {
+			t.Fatal(err)
+		}
+		if kv, err = kvstore.OpenFile(path); !errors.Is(err, kvstore.ErrCorruptKVFile) {
+			if kv != nil {
+				_ = kv.Close()
+			}
+			t.Fatalf("expected corrupt file error after flipping %#x, got %v", flip, err)
+		} else if data, _ := os.ReadFile(path); !bytes.Equal(data, corrupted) {
+			t.Fatalf("file was modified after flipping %#x", flip)
+		}
+	}
+
+	// Zeros at the end of the file (e.g. from a crash after the file was extended) are still dropped
+	if err = os.WriteFile(path, append(bytes.Clone(original), make([]byte, 40)...), 0600); err != nil {
+		t.Fatal(err)
+	} else if kv, err = kvstore.OpenFile(path); err != nil {
+		t.Fatal(err)
+	}
+	defer kv.Close()
+	if keys := scanKeys(t, kv, ""); len(keys) != 3 {
+		t.Fatalf("unexpected keys %v", keys)
+	} else if data, _ := os.ReadFile(path); !bytes.Equal(data, original) {
+		t.Fatal("zero tail wasn't truncated")
+	}
+}
@@ -0,0 +1,665 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+// Package kvstore contains an implementation of the interfaces in the store package on top of a generic
+// ordered key-value database, as well as a pure-Go embedded file-backed database to use with it.
+//
+// All data of a device is stored under keys of the form "data\x00<device JID>\x00<table>\x00<parts...>",
+// so the per-user and per-name queries of the store map to prefix scans.
+package kvstore
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/go-whatsapp/whatsmeow/store"
+	"github.com/go-whatsapp/whatsmeow/types"
+	"github.com/go-whatsapp/whatsmeow/util/keys"
+)
+
+// KVStore is a wrapper for a single device in a key-value database that implements the store interfaces.
+type KVStore struct {
+	*Container
+	JID types.JID
+
+	// lock is held during read-modify-write operations, which the key-value database doesn't have transactions for.
+	lock sync.Mutex
+}
+
+// NewKVStore creates a new KVStore with the given database container and user JID.
+// It contains implementations of all the different stores in the store package.
+//
+// In general, you should use Container.NewDevice or Container.GetDevice instead of this.
+func NewKVStore(c *Container, jid types.JID) *KVStore {
+	return &KVStore{
+		Container: c,
+		JID:       jid,
+	}
+}
+
+var _ store.IdentityStore = (*KVStore)(nil)
+var _ store.SessionStore = (*KVStore)(nil)
+var _ store.PreKeyStore = (*KVStore)(nil)
+var _ store.SenderKeyStore = (*KVStore)(nil)
+var _ store.AppStateSyncKeyStore = (*KVStore)(nil)
+var _ store.AppStateStore = (*KVStore)(nil)
+var _ store.ContactStore = (*KVStore)(nil)
+var _ store.ChatSettingsStore = (*KVStore)(nil)
+var _ store.MsgSecretStore = (*KVStore)(nil)
+var _ store.PrivacyTokenStore = (*KVStore)(nil)
+
+const (
+	tableIdentity        = "identity"
+	tableSession         = "session"
+	tablePreKey          = "prekey"
+	tableSenderKey       = "senderkey"
+	tableAppStateSyncKey = "appstatesynckey"
+	tableAppStateVersion = "appstateversion"
+	tableAppStateMAC     = "appstatemac"
+	tableContact         = "contact"
+	tableChatSettings    = "chatsettings"
+	tableMessageSecret   = "msgsecret"
+	tablePrivacyToken    = "privacytoken"
+)
+
+func dataPrefix(jid types.JID) []byte {
+	return []byte("data\x00" + jid.String() + "\x00")
+}
+
+// key builds a key in the given table for this device. If the last part is empty,
+// the key ends with a separator and can be used as a prefix for all keys with the preceding parts.
+func (s *KVStore) key(table string, parts ...string) []byte {
+	key := append(dataPrefix(s.JID), table...)
+	for _, part := range parts {
+		key = append(key, 0)
+		key = append(key, part...)
+	}
+	return key
+}
+
+func (s *KVStore) deletePrefix(prefix []byte) error {
+	var batch Batch
+	err := s.db.Scan(prefix, func(key, _ []byte) error {
+		batch.Delete(key)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return s.db.Write(&batch)
+}
+
+func (s *KVStore) getJSON(key []byte, into any) (bool, error) {
+	value, err := s.db.Get(key)
+	if err != nil || value == nil {
+		return false, err
+	}
+	err = json.Unmarshal(value, into)
+	if err != nil {
+		return false, fmt.Errorf("failed to parse stored value: %w", err)
+	}
+	return true, nil
+}
+
+func (s *KVStore) putJSON(key []byte, value any) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return s.db.Put(key, data)
+}
+
+func (s *KVStore) PutIdentity(address string, key [32]byte) error {
+	return s.db.Put(s.key(tableIdentity, address), key[:])
+}
+
+func (s *KVStore) DeleteAllIdentities(phone string) error {
+	return s.deletePrefix(s.key(tableIdentity, phone+":"))
+}
+
+func (s *KVStore) DeleteIdentity(address string) error {
+	return s.db.Delete(s.key(tableIdentity, address))
+}
+
+func (s *KVStore) IsTrustedIdentity(address string, key [32]byte) (bool, error) {
+	existingIdentity, err := s.db.Get(s.key(tableIdentity, address))
+	if err != nil {
+		return false, err
+	} else if existingIdentity == nil {
+		// Trust if not known, it'll be saved automatically later
+		return true, nil
+	} else if len(existingIdentity) != 32 {
+		return false, ErrInvalidLength
+	}
+	return bytes.Equal(existingIdentity, key[:]), nil
+}
+
+func (s *KVStore) GetSession(address string) ([]byte, error) {
+	return s.db.Get(s.key(tableSession, address))
+}
+
+func (s *KVStore) HasSession(address string) (bool, error) {
+	session, err := s.db.Get(s.key(tableSession, address))
+	return session != nil, err
+}
+
+func (s *KVStore) PutSession(address string, session []byte) error {
+	return s.db.Put(s.key(tableSession, address), session)
+}
+
+func (s *KVStore) DeleteAllSessions(phone string) error {
+	return s.deletePrefix(s.key(tableSession, phone+":"))
+}
+
+func (s *KVStore) DeleteSession(address string) error {
+	return s.db.Delete(s.key(tableSession, address))
+}
+
+// Prekeys are stored with big-endian IDs, so that scans return them in order.
+// The value is a byte indicating whether the key has been uploaded followed by the private key.
+func (s *KVStore) preKeyKey(id uint32) []byte {
+	return s.key(tablePreKey, string(binary.BigEndian.AppendUint32(nil, id)))
+}
+
+func parsePreKey(key, value []byte) (*keys.PreKey, bool, error) {
+	if len(key) < 4 || len(value) != 33 {
+		return nil, false, ErrInvalidLength
+	}
+	return &keys.PreKey{
+		KeyPair: *keys.NewKeyPairFromPrivateKey(*(*[32]byte)(value[1:])),
+		KeyID:   binary.BigEndian.Uint32(key[len(key)-4:]),
+	}, value[0] == 1, nil
+}
+
+func (s *KVStore) genOnePreKey(batch *Batch, id uint32, markUploaded bool) *keys.PreKey {
+	key := keys.NewPreKey(id)
+	var uploaded byte
+	if markUploaded {
+		uploaded = 1
+	}
+	batch.Put(s.preKeyKey(id), append([]byte{uploaded}, key.Priv[:]...))
+	return key
+}
+
+// scanPreKeys calls the given function for every prekey in ascending order of ID.
+func (s *KVStore) scanPreKeys(fn func(key *keys.PreKey, uploaded bool) error) error {
+	return s.db.Scan(s.key(tablePreKey, ""), func(key, value []byte) error {
+		preKey, uploaded, err := parsePreKey(key, value)
+		if err != nil {
+			return err
+		}
+		return fn(preKey, uploaded)
+	})
+}
+
+func (s *KVStore) getNextPreKeyID() (uint32, error) {
+	var lastKeyID uint32
+	err := s.scanPreKeys(func(key *keys.PreKey, _ bool) error {
+		lastKeyID = key.KeyID
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to query next prekey ID: %w", err)
+	}
+	return lastKeyID + 1, nil
+}
+
+func (s *KVStore) GenOnePreKey() (*keys.PreKey, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	nextKeyID, err := s.getNextPreKeyID()
+	if err != nil {
+		return nil, err
+	}
+	var batch Batch
+	key := s.genOnePreKey(&batch, nextKeyID, true)
+	return key, s.db.Write(&batch)
+}
+
+func (s *KVStore) GetOrGenPreKeys(count uint32) ([]*keys.PreKey, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	newKeys := make([]*keys.PreKey, 0, count)
+	var lastKeyID uint32
+	err := s.scanPreKeys(func(key *keys.PreKey, uploaded bool) error {
+		if !uploaded && uint32(len(newKeys)) < count {
+			newKeys = append(newKeys, key)
+		}
+		lastKeyID = key.KeyID
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to query existing prekeys: %w", err)
+	}
+
+	var batch Batch
+	for nextKeyID := lastKeyID + 1; uint32(len(newKeys)) < count; nextKeyID++ {
+		newKeys = append(newKeys, s.genOnePreKey(&batch, nextKeyID, false))
+	}
+	if err = s.db.Write(&batch); err != nil {
+		return nil, fmt.Errorf("failed to store generated prekeys: %w", err)
+	}
+	return newKeys, nil
+}
+
+func (s *KVStore) GetPreKey(id uint32) (*keys.PreKey, error) {
+	key := s.preKeyKey(id)
+	value, err := s.db.Get(key)
+	if err != nil || value == nil {
+		return nil, err
+	}
+	preKey, _, err := parsePreKey(key, value)
+	return preKey, err
+}
+
+func (s *KVStore) RemovePreKey(id uint32) error {
+	return s.db.Delete(s.preKeyKey(id))
+}
+
+func (s *KVStore) MarkPreKeysAsUploaded(upToID uint32) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	var batch Batch
+	err := s.scanPreKeys(func(key *keys.PreKey, uploaded bool) error {
+		if key.KeyID <= upToID && !uploaded {
+			batch.Put(s.preKeyKey(key.KeyID), append([]byte{1}, key.Priv[:]...))
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return s.db.Write(&batch)
+}
+
+func (s *KVStore) UploadedPreKeyCount() (count int, err error) {
+	err = s.scanPreKeys(func(_ *keys.PreKey, uploaded bool) error {
+		if uploaded {
+			count++
+		}
+		return nil
+	})
+	return
+}
+
+func (s *KVStore) PutSenderKey(group, user string, session []byte) error {
+	return s.db.Put(s.key(tableSenderKey, group, user), session)
+}
+
+func (s *KVStore) GetSenderKey(group, user string) ([]byte, error) {
+	return s.db.Get(s.key(tableSenderKey, group, user))
+}
+
+type appStateSyncKey struct {
+	Data        []byte `json:"data"`
+	Fingerprint []byte `json:"fingerprint"`
+	Timestamp   int64  `json:"timestamp"`
+}
+
+func (s *KVStore) PutAppStateSyncKey(id []byte, key store.AppStateSyncKey) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	dbKey := s.key(tableAppStateSyncKey, hex.EncodeToString(id))
+	var existing appStateSyncKey
+	if found, err := s.getJSON(dbKey, &existing); err != nil {
+		return err
+	} else if found && key.Timestamp <= existing.Timestamp {
+		// Existing keys are only replaced with newer ones
+		return nil
+	}
+	return s.putJSON(dbKey, &appStateSyncKey{
+		Data:        key.Data,
+		Fingerprint: key.Fingerprint,
+		Timestamp:   key.Timestamp,
+	})
+}
+
+func (s *KVStore) GetAppStateSyncKey(id []byte) (*store.AppStateSyncKey, error) {
+	var key appStateSyncKey
+	if found, err := s.getJSON(s.key(tableAppStateSyncKey, hex.EncodeToString(id)), &key); err != nil || !found {
+		return nil, err
+	}
+	return &store.AppStateSyncKey{
+		Data:        key.Data,
+		Fingerprint: key.Fingerprint,
+		Timestamp:   key.Timestamp,
+	}, nil
+}
+
+func (s *KVStore) GetLatestAppStateSyncKeyID() ([]byte, error) {
+	prefix := s.key(tableAppStateSyncKey, "")
+	var latestID []byte
+	var latestTimestamp int64
+	err := s.db.Scan(prefix, func(dbKey, value []byte) error {
+		var key appStateSyncKey
+		if err := json.Unmarshal(value, &key); err != nil {
+			return fmt.Errorf("failed to parse app state sync key: %w", err)
+		} else if latestID == nil || key.Timestamp > latestTimestamp {
+			latestID, latestTimestamp = dbKey[len(prefix):], key.Timestamp
+		}
+		return nil
+	})
+	if err != nil || latestID == nil {
+		return nil, err
+	}
+	return hex.DecodeString(string(latestID))
+}
+
+// App state versions are stored as the big-endian version followed by the 128-byte hash.
+func (s *KVStore) PutAppStateVersion(name string, version uint64, hash [128]byte) error {
+	return s.db.Put(s.key(tableAppStateVersion, name), append(binary.BigEndian.AppendUint64(nil, version), hash[:]...))
+}
+
+func (s *KVStore) GetAppStateVersion(name string) (version uint64, hash [128]byte, err error) {
+	var value []byte
+	value, err = s.db.Get(s.key(tableAppStateVersion, name))
+	if err != nil || value == nil {
+		// If there's no version, it'll be 0 and hash will be an empty array, which is the correct initial state
+		return
+	} else if len(value) != 8+128 {
+		err = ErrInvalidLength
+		return
+	}
+	version = binary.BigEndian.Uint64(value[:8])
+	hash = *(*[128]byte)(value[8:])
+	return
+}
+
+func (s *KVStore) DeleteAppStateVersion(name string) error {
+	var batch Batch
+	batch.Delete(s.key(tableAppStateVersion, name))
+	// Mutation MACs belong to the version, so they're deleted too
+	err := s.db.Scan(s.key(tableAppStateMAC, name, ""), func(key, _ []byte) error {
+		batch.Delete(key)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return s.db.Write(&batch)
+}
+
+// Mutation MACs are stored under the app state name, hex-encoded index MAC and big-endian version,
+// so that the last item in a scan of an index MAC is the latest version.
+func (s *KVStore) mutationMACKey(name string, indexMAC []byte, version uint64) []byte {
+	return s.key(tableAppStateMAC, name, hex.EncodeToString(indexMAC), string(binary.BigEndian.AppendUint64(nil, version)))
+}
+
+func (s *KVStore) PutAppStateMutationMACs(name string, version uint64, mutations []store.AppStateMutationMAC) error {
+	var batch Batch
+	for _, mutation := range mutations {
+		batch.Put(s.mutationMACKey(name, mutation.IndexMAC, version), mutation.ValueMAC)
+	}
+	return s.db.Write(&batch)
+}
+
+func (s *KVStore) DeleteAppStateMutationMACs(name string, indexMACs [][]byte) error {
+	var batch Batch
+	for _, indexMAC := range indexMACs {
+		err := s.db.Scan(s.key(tableAppStateMAC, name, hex.EncodeToString(indexMAC), ""), func(key, _ []byte) error {
+			batch.Delete(key)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return s.db.Write(&batch)
+}
+
+func (s *KVStore) GetAppStateMutationMAC(name string, indexMAC []byte) (valueMAC []byte, err error) {
+	err = s.db.Scan(s.key(tableAppStateMAC, name, hex.EncodeToString(indexMAC), ""), func(_, value []byte) error {
+		valueMAC = value
+		return nil
+	})
+	return
+}
+
+type contact struct {
+	FirstName    string `json:"first_name,omitempty"`
+	FullName     string `json:"full_name,omitempty"`
+	PushName     string `json:"push_name,omitempty"`
+	BusinessName string `json:"business_name,omitempty"`
+}
+
+func (c *contact) toInfo() types.ContactInfo {
+	return types.ContactInfo{
+		Found:        true,
+		FirstName:    c.FirstName,
+		FullName:     c.FullName,
+		PushName:     c.PushName,
+		BusinessName: c.BusinessName,
+	}
+}
+
+// updateContact applies the given function to the stored contact info and saves the result if the function returns true.
+func (s *KVStore) updateContact(user types.JID, fn func(existing *contact, found bool) bool) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	key := s.key(tableContact, user.String())
+	var existing contact
+	found, err := s.getJSON(key, &existing)
+	if err != nil {
+		return err
+	} else if !fn(&existing, found) {
+		return nil
+	}
+	return s.putJSON(key, &existing)
+}
+
+func (s *KVStore) PutPushName(user types.JID, pushName string) (changed bool, previousName string, err error) {
+	err = s.updateContact(user, func(existing *contact, _ bool) bool {
+		if existing.PushName == pushName {
+			return false
+		}
+		changed, previousName = true, existing.PushName
+		existing.PushName = pushName
+		return true
+	})
+	if err != nil {
+		return false, "", err
+	}
+	return
+}
+
+func (s *KVStore) PutBusinessName(user types.JID, businessName string) (changed bool, previousName string, err error) {
+	err = s.updateContact(user, func(existing *contact, _ bool) bool {
+		if existing.BusinessName == businessName {
+			return false
+		}
+		changed, previousName = true, existing.BusinessName
+		existing.BusinessName = businessName
+		return true
+	})
+	if err != nil {
+		return false, "", err
+	}
+	return
+}
+
+func (s *KVStore) PutContactName(user types.JID, firstName, fullName string) error {
+	return s.updateContact(user, func(existing *contact, _ bool) bool {
+		if existing.FirstName == firstName && existing.FullName == fullName {
+			return false
+		}
+		existing.FirstName = firstName
+		existing.FullName = fullName
+		return true
+	})
+}
+
+func (s *KVStore) PutAllContactNames(contacts []store.ContactEntry) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	var batch Batch
+	for _, entry := range contacts {
+		if entry.JID.IsEmpty() {
+			s.log.Warnf("Empty contact info in mass insert: %+v", entry)
+			continue
+		}
+		key := s.key(tableContact, entry.JID.String())
+		var existing contact
+		if _, err := s.getJSON(key, &existing); err != nil {
+			return err
+		}
+		existing.FirstName = entry.FirstName
+		existing.FullName = entry.FullName
+		value, err := json.Marshal(&existing)
+		if err != nil {
+			return err
+		}
+		batch.Put(key, value)
+	}
+	return s.db.Write(&batch)
+}
+
+func (s *KVStore) GetContact(user types.JID) (types.ContactInfo, error) {
+	var existing contact
+	found, err := s.getJSON(s.key(tableContact, user.String()), &existing)
+	if err != nil || !found {
+		return types.ContactInfo{}, err
+	}
+	return existing.toInfo(), nil
+}
+
+func (s *KVStore) GetAllContacts() (map[types.JID]types.ContactInfo, error) {
+	prefix := s.key(tableContact, "")
+	output := make(map[types.JID]types.ContactInfo)
+	err := s.db.Scan(prefix, func(key, value []byte) error {
+		jid, err := types.ParseJID(string(key[len(prefix):]))
+		if err != nil {
+			return fmt.Errorf("failed to parse contact JID: %w", err)
+		}
+		var existing contact
+		if err = json.Unmarshal(value, &existing); err != nil {
+			return fmt.Errorf("failed to parse contact info: %w", err)
+		}
+		output[jid] = existing.toInfo()
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return output, nil
+}
+
+type chatSettings struct {
+	MutedUntil int64 `json:"muted_until,omitempty"`
+	Pinned     bool  `json:"pinned,omitempty"`
+	Archived   bool  `json:"archived,omitempty"`
+}
+
+func (s *KVStore) updateChatSettings(chat types.JID, fn func(settings *chatSettings)) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	key := s.key(tableChatSettings, chat.String())
+	var settings chatSettings
+	if _, err := s.getJSON(key, &settings); err != nil {
+		return err
+	}
+	fn(&settings)
+	return s.putJSON(key, &settings)
+}
+
+func (s *KVStore) PutMutedUntil(chat types.JID, mutedUntil time.Time) error {
+	var val int64
+	if !mutedUntil.IsZero() {
+		val = mutedUntil.Unix()
+	}
+	return s.updateChatSettings(chat, func(settings *chatSettings) {
+		settings.MutedUntil = val
+	})
+}
+
+func (s *KVStore) PutPinned(chat types.JID, pinned bool) error {
+	return s.updateChatSettings(chat, func(settings *chatSettings) {
+		settings.Pinned = pinned
+	})
+}
+
+func (s *KVStore) PutArchived(chat types.JID, archived bool) error {
+	return s.updateChatSettings(chat, func(settings *chatSettings) {
+		settings.Archived = archived
+	})
+}
+
+func (s *KVStore) GetChatSettings(chat types.JID) (settings types.LocalChatSettings, err error) {
+	var stored chatSettings
+	settings.Found, err = s.getJSON(s.key(tableChatSettings, chat.String()), &stored)
+	if err != nil || !settings.Found {
+		return
+	}
+	settings.Pinned = stored.Pinned
+	settings.Archived = stored.Archived
+	if stored.MutedUntil != 0 {
+		settings.MutedUntil = time.Unix(stored.MutedUntil, 0)
+	}
+	return
+}
+
+func (s *KVStore) messageSecretKey(chat, sender types.JID, id types.MessageID) []byte {
+	return s.key(tableMessageSecret, chat.ToNonAD().String(), sender.ToNonAD().String(), id)
+}
+
+func (s *KVStore) PutMessageSecrets(inserts []store.MessageSecretInsert) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	var batch Batch
+	for _, insert := range inserts {
+		key := s.messageSecretKey(insert.Chat, insert.Sender, insert.ID)
+		// Like the SQL store, existing secrets are never overwritten
+		if existing, err := s.db.Get(key); err != nil {
+			return err
+		} else if existing == nil {
+			batch.Put(key, insert.Secret)
+		}
+	}
+	return s.db.Write(&batch)
+}
+
+func (s *KVStore) PutMessageSecret(chat, sender types.JID, id types.MessageID, secret []byte) error {
+	return s.PutMessageSecrets([]store.MessageSecretInsert{{Chat: chat, Sender: sender, ID: id, Secret: secret}})
+}
+
+func (s *KVStore) GetMessageSecret(chat, sender types.JID, id types.MessageID) ([]byte, error) {
+	return s.db.Get(s.messageSecretKey(chat, sender, id))
+}
+
+type privacyToken struct {
+	Token     []byte `json:"token"`
+	Timestamp int64  `json:"timestamp"`
+}
+
+func (s *KVStore) PutPrivacyTokens(tokens ...store.PrivacyToken) error {
+	var batch Batch
+	for _, token := range tokens {
+		value, err := json.Marshal(&privacyToken{Token: token.Token, Timestamp: token.Timestamp.Unix()})
+		if err != nil {
+			return err
+		}
+		batch.Put(s.key(tablePrivacyToken, token.User.ToNonAD().String()), value)
+	}
+	return s.db.Write(&batch)
+}
+
+func (s *KVStore) GetPrivacyToken(user types.JID) (*store.PrivacyToken, error) {
+	user = user.ToNonAD()
+	var token privacyToken
+	if found, err := s.getJSON(s.key(tablePrivacyToken, user.String()), &token); err != nil || !found {
+		return nil, err
+	}
+	return &store.PrivacyToken{
+		User:      user,
+		Token:     token.Token,
+		Timestamp: time.Unix(token.Timestamp, 0),
+	}, nil
+}
@@ -0,0 +1,327 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package memstore
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	mathRand "math/rand"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/go-whatsapp/go-util/random"
+	"google.golang.org/protobuf/proto"
+
+	waProto "github.com/go-whatsapp/whatsmeow/binary/proto"
+	"github.com/go-whatsapp/whatsmeow/store"
+	"github.com/go-whatsapp/whatsmeow/types"
+	"github.com/go-whatsapp/whatsmeow/util/keys"
+	waLog "github.com/go-whatsapp/whatsmeow/util/log"
+)
+
+// ErrDeviceIDMustBeSet is the error returned by PutDevice if you try to save a device before knowing its JID.
+var ErrDeviceIDMustBeSet = errors.New("device JID must be known before saving device")
+
+// ErrNoSnapshotPath is returned by Container.Snapshot if the container wasn't created with NewWithFile.
+var ErrNoSnapshotPath = errors.New("container doesn't have a snapshot file")
+
+// Container is an in-memory store that can contain multiple whatsmeow sessions.
+//
+// Everything is lost when the process exits, unless the container is created with NewWithFile,
+// in which case the data can be written into a file with Snapshot and is loaded back on the next start.
+type Container struct {
+	lock    sync.RWMutex
+	devices map[types.JID]*store.Device
+	stores  map[types.JID]*MemStore
+	path    string
+	log     waLog.Logger
+}
+
+var _ store.DeviceContainer = (*Container)(nil)
+
+// New creates a new empty in-memory container.
+//
+// The logger can be nil and will default to a no-op logger.
+func New(log waLog.Logger) *Container {
+	if log == nil {
+		log = waLog.Noop
+	}
+	return &Container{
+		devices: make(map[types.JID]*store.Device),
+		stores:  make(map[types.JID]*MemStore),
+		log:     log,
+	}
+}
+
+// NewWithFile creates an in-memory container that is snapshotted into the given file.
+//
+// If the file exists, the previous snapshot is loaded from it. The data is written back into the file
+// when Snapshot or Close is called.
+func NewWithFile(path string, log waLog.Logger) (*Container, error) {
+	c := New(log)
+	c.path = path
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return c, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to open snapshot: %w", err)
+	}
+	defer file.Close()
+	if err = c.ReadSnapshot(file); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// GetAllDevices returns all the devices in the container.
+func (c *Container) GetAllDevices() ([]*store.Device, error) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	devices := make([]*store.Device, 0, len(c.devices))
+	for _, device := range c.devices {
+		devices = append(devices, device)
+	}
+	return devices, nil
+}
+
+// GetFirstDevice is a convenience method for getting the first device in the store. If there are
+// no devices, then a new device will be created. You should only use this if you don't want to
+// have multiple sessions simultaneously.
+func (c *Container) GetFirstDevice() (*store.Device, error) {
+	devices, err := c.GetAllDevices()
+	if err != nil {
+		return nil, err
+	}
+	if len(devices) == 0 {
+		return c.NewDevice(), nil
+	} else {
+		return devices[0], nil
+	}
+}
+
+// GetDevice finds the device with the given JID in the container. If there's no exact match,
+// a device of the same user is returned instead.
+//
+// If the device doesn't exist, a new device will be created and returned.
+func (c *Container) GetDevice(jid types.JID) (*store.Device, error) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	if device, ok := c.devices[jid]; ok {
+		return device, nil
+	}
+	for deviceJID, device := range c.devices {
+		if deviceJID.User == jid.User && deviceJID.Server == jid.Server {
+			return device, nil
+		}
+	}
+	return c.NewDevice(), nil
+}
+
+// NewDevice creates a new device in this container.
+//
+// No data is actually stored before Save is called. However, the pairing process will automatically
+// call Save after a successful pairing, so you most likely don't need to call it yourself.
+func (c *Container) NewDevice() *store.Device {
+	device := &store.Device{
+		Log:       c.log,
+		Container: c,
+
+		NoiseKey:       keys.NewKeyPair(),
+		IdentityKey:    keys.NewKeyPair(),
+		RegistrationID: mathRand.Uint32(),
+		AdvSecretKey:   random.Bytes(32),
+	}
+	device.SignedPreKey = device.IdentityKey.CreateSignedPreKey(1)
+	return device
+}
+
+func (c *Container) initDevice(device *store.Device, ms *MemStore) {
+	device.Identities = ms
+	device.Sessions = ms
+	device.PreKeys = ms
+	device.SenderKeys = ms
+	device.AppStateKeys = ms
+	device.AppState = ms
+	device.Contacts = ms
+	device.ChatSettings = ms
+	device.MsgSecrets = ms
+	device.PrivacyTokens = ms
+	device.Container = c
+	device.Initialized = true
+}
+
+// PutDevice stores the given device in this container. This should be called through Device.Save()
+// (which usually doesn't need to be called manually, as the library does that automatically when relevant).
+func (c *Container) PutDevice(device *store.Device) error {
+	if device.JID == nil {
+		return ErrDeviceIDMustBeSet
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.devices[*device.JID] = device
+	if !device.Initialized {
+		ms, ok := c.stores[*device.JID]
+		if !ok {
+			ms = NewMemStore(c, *device.JID)
+			c.stores[*device.JID] = ms
+		}
+		c.initDevice(device, ms)
+	}
+	return nil
+}
+
+// DeleteDevice deletes the given device and all of its data from this container. This should be called through Device.Delete()
+func (c *Container) DeleteDevice(device *store.Device) error {
+	if device.JID == nil {
+		return ErrDeviceIDMustBeSet
+	}
+	c.lock.Lock()
+	delete(c.devices, *device.JID)
+	delete(c.stores, *device.JID)
+	c.lock.Unlock()
+	return nil
+}
+
+// Close writes a final snapshot if the container was created with NewWithFile.
+func (c *Container) Close() error {
+	if c != nil && c.path != "" {
+		return c.Snapshot()
+	}
+	return nil
+}
+
+type deviceSnapshot struct {
+	JID             types.JID `json:"jid"`
+	RegistrationID  uint32    `json:"registration_id"`
+	NoiseKey        []byte    `json:"noise_key"`
+	IdentityKey     []byte    `json:"identity_key"`
+	SignedPreKey    []byte    `json:"signed_pre_key"`
+	SignedPreKeyID  uint32    `json:"signed_pre_key_id"`
+	SignedPreKeySig []byte    `json:"signed_pre_key_sig"`
+	AdvSecretKey    []byte    `json:"adv_key"`
+	Account         []byte    `json:"account"`
+	Platform        string    `json:"platform"`
+	BusinessName    string    `json:"business_name"`
+	PushName        string    `json:"push_name"`
+
+	Data json.RawMessage `json:"data,omitempty"`
+}
+
+type snapshot struct {
+	Devices []*deviceSnapshot `json:"devices"`
+}
+
+// WriteSnapshot writes all the devices and their data into the given writer as JSON.
+// The snapshot contains private keys, so it must be stored securely.
+func (c *Container) WriteSnapshot(w io.Writer) error {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	var snap snapshot
+	for jid, device := range c.devices {
+		account, err := proto.Marshal(device.Account)
+		if err != nil {
+			return fmt.Errorf("failed to marshal account of %s: %w", jid, err)
+		}
+		devSnap := &deviceSnapshot{
+			JID:             jid,
+			RegistrationID:  device.RegistrationID,
+			NoiseKey:        device.NoiseKey.Priv[:],
+			IdentityKey:     device.IdentityKey.Priv[:],
+			SignedPreKey:    device.SignedPreKey.Priv[:],
+			SignedPreKeyID:  device.SignedPreKey.KeyID,
+			SignedPreKeySig: device.SignedPreKey.Signature[:],
+			AdvSecretKey:    device.AdvSecretKey,
+			Account:         account,
+			Platform:        device.Platform,
+			BusinessName:    device.BusinessName,
+			PushName:        device.PushName,
+		}
+		if ms, ok := c.stores[jid]; ok {
+			ms.lock.Lock()
+			devSnap.Data, err = json.Marshal(&ms.data)
+			ms.lock.Unlock()
+			if err != nil {
+				return fmt.Errorf("failed to marshal data of %s: %w", jid, err)
+			}
+		}
+		snap.Devices = append(snap.Devices, devSnap)
+	}
+	return json.NewEncoder(w).Encode(&snap)
+}
+
+// ReadSnapshot loads devices and their data from a snapshot created with WriteSnapshot.
+// Existing devices with the same JIDs are replaced.
+func (c *Container) ReadSnapshot(r io.Reader) error {
+	var snap snapshot
+	if err := json.NewDecoder(r).Decode(&snap); err != nil {
+		return fmt.Errorf("failed to parse snapshot: %w", err)
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for _, devSnap := range snap.Devices {
+		if len(devSnap.NoiseKey) != 32 || len(devSnap.IdentityKey) != 32 || len(devSnap.SignedPreKey) != 32 || len(devSnap.SignedPreKeySig) != 64 {
+			return fmt.Errorf("invalid key length in snapshot of %s", devSnap.JID)
+		}
+		var account waProto.ADVSignedDeviceIdentity
+		if err := proto.Unmarshal(devSnap.Account, &account); err != nil {
+			return fmt.Errorf("failed to unmarshal account of %s: %w", devSnap.JID, err)
+		}
+		jid := devSnap.JID
+		device := &store.Device{
+			Log:            c.log,
+			NoiseKey:       keys.NewKeyPairFromPrivateKey(*(*[32]byte)(devSnap.NoiseKey)),
+			IdentityKey:    keys.NewKeyPairFromPrivateKey(*(*[32]byte)(devSnap.IdentityKey)),
+			SignedPreKey:   &keys.PreKey{KeyID: devSnap.SignedPreKeyID, Signature: (*[64]byte)(devSnap.SignedPreKeySig)},
+			RegistrationID: devSnap.RegistrationID,
+			AdvSecretKey:   devSnap.AdvSecretKey,
+			JID:            &jid,
+			Account:        &account,
+			Platform:       devSnap.Platform,
+			BusinessName:   devSnap.BusinessName,
+			PushName:       devSnap.PushName,
+		}
+		device.SignedPreKey.KeyPair = *keys.NewKeyPairFromPrivateKey(*(*[32]byte)(devSnap.SignedPreKey))
+		ms := NewMemStore(c, jid)
+		if len(devSnap.Data) > 0 {
+			if err := json.Unmarshal(devSnap.Data, &ms.data); err != nil {
+				return fmt.Errorf("failed to unmarshal data of %s: %w", jid, err)
+			}
+			ms.data.init()
+		}
+		c.initDevice(device, ms)
+		c.devices[jid] = device
+		c.stores[jid] = ms
+	}
+	return nil
+}
+
+// Snapshot writes all data into the file that was passed to NewWithFile.
+// The file is replaced atomically, so a crash while writing won't corrupt the previous snapshot.
+func (c *Container) Snapshot() error {
+	if c.path == "" {
+		return ErrNoSnapshotPath
+	}
+	tempFile, err := os.CreateTemp(filepath.Dir(c.path), ".tmp-"+filepath.Base(c.path)+"-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	defer func() {
+		_ = tempFile.Close()
+		_ = os.Remove(tempFile.Name())
+	}()
+	if err = c.WriteSnapshot(tempFile); err != nil {
+		return err
+	} else if err = tempFile.Close(); err != nil {
+		return fmt.Errorf("failed to write snapshot: %w", err)
+	} else if err = os.Rename(tempFile.Name(), c.path); err != nil {
+		return fmt.Errorf("failed to replace snapshot: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,209 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package memstore
+
+import (
+	"encoding/hex"
+	"sort"
+	"strings"
+
+	"github.com/go-whatsapp/whatsmeow/store"
+	"github.com/go-whatsapp/whatsmeow/types"
+)
+
+var _ store.DeviceDataStore = (*MemStore)(nil)
+var _ store.DeviceDataContainer = (*Container)(nil)
+
+// GetDeviceDataStore returns the store that the device with the given JID uses.
+// The store is created if it doesn't exist yet, and it's used by the device once it's saved.
+func (c *Container) GetDeviceDataStore(jid types.JID) store.DeviceDataStore {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	ms, ok := c.stores[jid]
+	if !ok {
+		ms = NewMemStore(c, jid)
+		c.stores[jid] = ms
+	}
+	return ms
+}
+
+func (ms *MemStore) ExportDeviceData() (*store.DeviceData, error) {
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+	data := &store.DeviceData{
+		Identities: make(map[string][]byte, len(ms.data.Identities)),
+		Sessions:   make(map[string][]byte, len(ms.data.Sessions)),
+	}
+	for address, key := range ms.data.Identities {
+		data.Identities[address] = key
+	}
+	for address, session := range ms.data.Sessions {
+		data.Sessions[address] = session
+	}
+	for id, key := range ms.data.PreKeys {
+		data.PreKeys = append(data.PreKeys, store.PreKeyData{ID: id, Key: key.Key, Uploaded: key.Uploaded})
+	}
+	sort.Slice(data.PreKeys, func(i, j int) bool {
+		return data.PreKeys[i].ID < data.PreKeys[j].ID
+	})
+	for group, groupKeys := range ms.data.SenderKeys {
+		for user, key := range groupKeys {
+			data.SenderKeys = append(data.SenderKeys, store.SenderKeyData{Group: group, User: user, Key: key})
+		}
+	}
+	for hexID, key := range ms.data.AppStateSyncKeys {
+		id, err := hex.DecodeString(hexID)
+		if err != nil {
+			return nil, err
+		}
+		data.AppStateSyncKeys = append(data.AppStateSyncKeys, store.AppStateSyncKeyData{
+			ID:          id,
+			Data:        key.Data,
+			Fingerprint: key.Fingerprint,
+			Timestamp:   key.Timestamp,
+		})
+	}
+	for name, version := range ms.data.AppStateVersions {
+		data.AppStateVersions = append(data.AppStateVersions, store.AppStateVersionData{
+			Name:    name,
+			Version: version.Version,
+			Hash:    version.Hash,
+		})
+	}
+	for name, macs := range ms.data.AppStateMACs {
+		for hexIndexMAC, versions := range macs {
+			indexMAC, err := hex.DecodeString(hexIndexMAC)
+			if err != nil {
+				return nil, err
+			}
+			for version, valueMAC := range versions {
+				data.AppStateMutationMACs = append(data.AppStateMutationMACs, store.AppStateMutationMACData{
+					Name:     name,
+					Version:  version,
+					IndexMAC: indexMAC,
+					ValueMAC: valueMAC,
+				})
+			}
+		}
+	}
+	for jid, existing := range ms.data.Contacts {
+		data.Contacts = append(data.Contacts, store.ContactData{
+			JID:          jid,
+			FirstName:    existing.FirstName,
+			FullName:     existing.FullName,
+			PushName:     existing.PushName,
+			BusinessName: existing.BusinessName,
+		})
+	}
+	for chat, settings := range ms.data.ChatSettings {
+		data.ChatSettings = append(data.ChatSettings, store.ChatSettingsData{
+			Chat:       chat,
+			MutedUntil: settings.MutedUntil,
+			Pinned:     settings.Pinned,
+			Archived:   settings.Archived,
+		})
+	}
+	for key, secret := range ms.data.MessageSecrets {
+		parts := strings.SplitN(key, "|", 3)
+		if len(parts) != 3 {
+			continue
+		}
+		chat, err := types.ParseJID(parts[0])
+		if err != nil {
+			return nil, err
+		}
+		sender, err := types.ParseJID(parts[1])
+		if err != nil {
+			return nil, err
+		}
+		data.MessageSecrets = append(data.MessageSecrets, store.MessageSecretData{
+			Chat:   chat,
+			Sender: sender,
+			ID:     parts[2],
+			Secret: secret,
+		})
+	}
+	for user, token := range ms.data.PrivacyTokens {
+		data.PrivacyTokens = append(data.PrivacyTokens, store.PrivacyTokenData{
+			User:      user,
+			Token:     token.Token,
+			Timestamp: token.Timestamp,
+		})
+	}
+	return data, nil
+}
+
+func (ms *MemStore) ImportDeviceData(data *store.DeviceData) error {
+	if err := data.Validate(); err != nil {
+		return err
+	}
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+	for address, key := range data.Identities {
+		ms.data.Identities[address] = key
+	}
+	for address, session := range data.Sessions {
+		ms.data.Sessions[address] = session
+	}
+	for _, key := range data.PreKeys {
+		ms.data.PreKeys[key.ID] = &preKey{Key: key.Key, Uploaded: key.Uploaded}
+	}
+	for _, key := range data.SenderKeys {
+		groupKeys, ok := ms.data.SenderKeys[key.Group]
+		if !ok {
+			groupKeys = make(map[string][]byte)
+			ms.data.SenderKeys[key.Group] = groupKeys
+		}
+		groupKeys[key.User] = key.Key
+	}
+	for _, key := range data.AppStateSyncKeys {
+		ms.data.AppStateSyncKeys[hex.EncodeToString(key.ID)] = &syncKey{
+			Data:        key.Data,
+			Fingerprint: key.Fingerprint,
+			Timestamp:   key.Timestamp,
+		}
+	}
+	for _, version := range data.AppStateVersions {
+		ms.data.AppStateVersions[version.Name] = &appStateVersion{Version: version.Version, Hash: version.Hash}
+	}
+	for _, mac := range data.AppStateMutationMACs {
+		macs, ok := ms.data.AppStateMACs[mac.Name]
+		if !ok {
+			macs = make(map[string]map[uint64][]byte)
+			ms.data.AppStateMACs[mac.Name] = macs
+		}
+		indexMAC := hex.EncodeToString(mac.IndexMAC)
+		versions, ok := macs[indexMAC]
+		if !ok {
+			versions = make(map[uint64][]byte)
+			macs[indexMAC] = versions
+		}
+		versions[mac.Version] = mac.ValueMAC
+	}
+	for _, entry := range data.Contacts {
+		ms.data.Contacts[entry.JID] = &contact{
+			FirstName:    entry.FirstName,
+			FullName:     entry.FullName,
+			PushName:     entry.PushName,
+			BusinessName: entry.BusinessName,
+		}
+	}
+	for _, settings := range data.ChatSettings {
+		ms.data.ChatSettings[settings.Chat] = &chatSettings{
+			MutedUntil: settings.MutedUntil,
+			Pinned:     settings.Pinned,
+			Archived:   settings.Archived,
+		}
+	}
+	for _, secret := range data.MessageSecrets {
+		ms.putMessageSecret(secret.Chat, secret.Sender, secret.ID, secret.Secret)
+	}
+	for _, token := range data.PrivacyTokens {
+		ms.data.PrivacyTokens[token.User.ToNonAD()] = &privacyToken{Token: token.Token, Timestamp: token.Timestamp}
+	}
+	return nil
+}
@@ -0,0 +1,133 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package memstore_test
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+
+	waProto "github.com/go-whatsapp/whatsmeow/binary/proto"
+	"github.com/go-whatsapp/whatsmeow/store"
+	"github.com/go-whatsapp/whatsmeow/store/memstore"
+	"github.com/go-whatsapp/whatsmeow/types"
+)
+
+func newDevice(t *testing.T, c *memstore.Container) *store.Device {
+	device := c.NewDevice()
+	jid := types.NewADJID("1111", 0, 1)
+	device.JID = &jid
+	device.Account = &waProto.ADVSignedDeviceIdentity{Details: []byte("details")}
+	if err := device.Save(); err != nil {
+		t.Fatal(err)
+	}
+	return device
+}
+
+func TestPreKeys(t *testing.T) {
+	device := newDevice(t, memstore.New(nil))
+	preKeys, err := device.PreKeys.GetOrGenPreKeys(5)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(preKeys) != 5 || preKeys[0].KeyID != 1 || preKeys[4].KeyID != 5 {
+		t.Fatalf("unexpected prekeys %+v", preKeys)
+	}
+	if err = device.PreKeys.MarkPreKeysAsUploaded(3); err != nil {
+		t.Fatal(err)
+	} else if count, _ := device.PreKeys.UploadedPreKeyCount(); count != 3 {
+		t.Fatalf("expected 3 uploaded prekeys, got %d", count)
+	}
+	reused, _ := device.PreKeys.GetOrGenPreKeys(3)
+	if reused[0].KeyID != 4 || reused[1].KeyID != 5 || reused[2].KeyID != 6 {
+		t.Fatalf("expected unuploaded keys to be reused, got IDs %d, %d, %d", reused[0].KeyID, reused[1].KeyID, reused[2].KeyID)
+	} else if *reused[0].Priv != *preKeys[3].Priv {
+		t.Fatal("reused prekey doesn't match")
+	}
+	if key, _ := device.PreKeys.GenOnePreKey(); key.KeyID != 7 {
+		t.Fatalf("expected prekey 7, got %d", key.KeyID)
+	} else if count, _ := device.PreKeys.UploadedPreKeyCount(); count != 4 {
+		t.Fatalf("expected 4 uploaded prekeys, got %d", count)
+	}
+	if stored, _ := device.PreKeys.GetPreKey(2); stored == nil || *stored.Priv != *preKeys[1].Priv {
+		t.Fatal("stored prekey doesn't match")
+	}
+	_ = device.PreKeys.RemovePreKey(2)
+	if stored, _ := device.PreKeys.GetPreKey(2); stored != nil {
+		t.Fatal("prekey wasn't removed")
+	}
+}
+
+func TestAppStateMACs(t *testing.T) {
+	device := newDevice(t, memstore.New(nil))
+	index1, index2 := []byte{1}, []byte{2}
+	_ = device.AppState.PutAppStateMutationMACs("regular", 1, []store.AppStateMutationMAC{
+		{IndexMAC: index1, ValueMAC: []byte("v1")},
+		{IndexMAC: index2, ValueMAC: []byte("v1")},
+	})
+	_ = device.AppState.PutAppStateMutationMACs("regular", 2, []store.AppStateMutationMAC{
+		{IndexMAC: index1, ValueMAC: []byte("v2")},
+	})
+	if value, _ := device.AppState.GetAppStateMutationMAC("regular", index1); string(value) != "v2" {
+		t.Fatalf("expected value MAC from latest version, got %q", value)
+	}
+	_ = device.AppState.DeleteAppStateMutationMACs("regular", [][]byte{index1})
+	if value, _ := device.AppState.GetAppStateMutationMAC("regular", index1); value != nil {
+		t.Fatalf("expected deleted value MAC to be gone from all versions, got %q", value)
+	} else if value, _ = device.AppState.GetAppStateMutationMAC("regular", index2); string(value) != "v1" {
+		t.Fatalf("unrelated value MAC was deleted")
+	}
+	_ = device.AppState.PutAppStateVersion("regular", 2, [128]byte{1})
+	_ = device.AppState.DeleteAppStateVersion("regular")
+	if version, hash, _ := device.AppState.GetAppStateVersion("regular"); version != 0 || hash != [128]byte{} {
+		t.Fatal("app state version wasn't deleted")
+	} else if value, _ := device.AppState.GetAppStateMutationMAC("regular", index2); value != nil {
+		t.Fatal("deleting app state version didn't delete mutation MACs")
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "whatsmeow.json")
+	c, err := memstore.NewWithFile(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	device := newDevice(t, c)
+	preKeys, _ := device.PreKeys.GetOrGenPreKeys(2)
+	_ = device.PreKeys.MarkPreKeysAsUploaded(1)
+	_ = device.Sessions.PutSession("2222:0", []byte("session"))
+	_ = device.AppStateKeys.PutAppStateSyncKey([]byte{0xab}, store.AppStateSyncKey{Data: []byte("key"), Timestamp: 5})
+	_, _, _ = device.Contacts.PutPushName(types.NewJID("2222", types.DefaultUserServer), "Peer")
+	if err = c.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err = memstore.NewWithFile(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := c.GetDevice(*device.JID)
+	if err != nil {
+		t.Fatal(err)
+	} else if loaded.JID == nil || *loaded.JID != *device.JID || loaded == device {
+		t.Fatal("device wasn't loaded from snapshot")
+	} else if *loaded.IdentityKey.Priv != *device.IdentityKey.Priv || *loaded.SignedPreKey.Signature != *device.SignedPreKey.Signature {
+		t.Fatal("keys don't match after loading snapshot")
+	} else if !bytes.Equal(loaded.Account.GetDetails(), []byte("details")) {
+		t.Fatal("account doesn't match after loading snapshot")
+	}
+	if count, _ := loaded.PreKeys.UploadedPreKeyCount(); count != 1 {
+		t.Fatalf("expected 1 uploaded prekey, got %d", count)
+	} else if key, _ := loaded.PreKeys.GetPreKey(2); key == nil || *key.Priv != *preKeys[1].Priv {
+		t.Fatal("prekey doesn't match after loading snapshot")
+	} else if session, _ := loaded.Sessions.GetSession("2222:0"); string(session) != "session" {
+		t.Fatal("session doesn't match after loading snapshot")
+	} else if keyID, _ := loaded.AppStateKeys.GetLatestAppStateSyncKeyID(); !bytes.Equal(keyID, []byte{0xab}) {
+		t.Fatalf("unexpected latest app state key ID %x", keyID)
+	} else if contact, _ := loaded.Contacts.GetContact(types.NewJID("2222", types.DefaultUserServer)); !contact.Found || contact.PushName != "Peer" {
+		t.Fatalf("unexpected contact info %+v", contact)
+	}
+}
@@ -0,0 +1,653 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+// Package memstore contains an in-memory implementation of the interfaces in the store package.
+package memstore
+
+import (
+	"bytes"
+	"encoding/hex"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-whatsapp/whatsmeow/store"
+	"github.com/go-whatsapp/whatsmeow/types"
+	"github.com/go-whatsapp/whatsmeow/util/keys"
+)
+
+type preKey struct {
+	Key      []byte `json:"key"`
+	Uploaded bool   `json:"uploaded"`
+}
+
+type syncKey struct {
+	Data        []byte `json:"data"`
+	Fingerprint []byte `json:"fingerprint"`
+	Timestamp   int64  `json:"timestamp"`
+}
+
+type appStateVersion struct {
+	Version uint64 `json:"version"`
+	Hash    []byte `json:"hash"`
+}
+
+type contact struct {
+	FirstName    string `json:"first_name,omitempty"`
+	FullName     string `json:"full_name,omitempty"`
+	PushName     string `json:"push_name,omitempty"`
+	BusinessName string `json:"business_name,omitempty"`
+}
+
+type chatSettings struct {
+	MutedUntil int64 `json:"muted_until,omitempty"`
+	Pinned     bool  `json:"pinned,omitempty"`
+	Archived   bool  `json:"archived,omitempty"`
+}
+
+type privacyToken struct {
+	Token     []byte `json:"token"`
+	Timestamp int64  `json:"timestamp"`
+}
+
+// storeData contains all the data of a single device. It's serialized as-is into snapshots.
+type storeData struct {
+	Identities map[string][]byte            `json:"identities"`
+	Sessions   map[string][]byte            `json:"sessions"`
+	PreKeys    map[uint32]*preKey           `json:"pre_keys"`
+	SenderKeys map[string]map[string][]byte `json:"sender_keys"`
+	// Sync keys are keyed by the hex-encoded key ID.
+	AppStateSyncKeys map[string]*syncKey         `json:"app_state_sync_keys"`
+	AppStateVersions map[string]*appStateVersion `json:"app_state_versions"`
+	// Mutation MACs are keyed by app state name, hex-encoded index MAC and version.
+	AppStateMACs   map[string]map[string]map[uint64][]byte `json:"app_state_macs"`
+	Contacts       map[types.JID]*contact                  `json:"contacts"`
+	ChatSettings   map[types.JID]*chatSettings             `json:"chat_settings"`
+	MessageSecrets map[string][]byte                       `json:"message_secrets"`
+	PrivacyTokens  map[types.JID]*privacyToken             `json:"privacy_tokens"`
+}
+
+func (data *storeData) init() {
+	if data.Identities == nil {
+		data.Identities = make(map[string][]byte)
+	}
+	if data.Sessions == nil {
+		data.Sessions = make(map[string][]byte)
+	}
+	if data.PreKeys == nil {
+		data.PreKeys = make(map[uint32]*preKey)
+	}
+	if data.SenderKeys == nil {
+		data.SenderKeys = make(map[string]map[string][]byte)
+	}
+	if data.AppStateSyncKeys == nil {
+		data.AppStateSyncKeys = make(map[string]*syncKey)
+	}
+	if data.AppStateVersions == nil {
+		data.AppStateVersions = make(map[string]*appStateVersion)
+	}
+	if data.AppStateMACs == nil {
+		data.AppStateMACs = make(map[string]map[string]map[uint64][]byte)
+	}
+	if data.Contacts == nil {
+		data.Contacts = make(map[types.JID]*contact)
+	}
+	if data.ChatSettings == nil {
+		data.ChatSettings = make(map[types.JID]*chatSettings)
+	}
+	if data.MessageSecrets == nil {
+		data.MessageSecrets = make(map[string][]byte)
+	}
+	if data.PrivacyTokens == nil {
+		data.PrivacyTokens = make(map[types.JID]*privacyToken)
+	}
+}
+
+// MemStore is an in-memory implementation of the store interfaces for a single device.
+type MemStore struct {
+	*Container
+	JID types.JID
+
+	lock sync.Mutex
+	data storeData
+}
+
+// NewMemStore creates a new in-memory store for the given device JID.
+//
+// In general, you should use Container.GetDevice or Container.NewDevice instead of this.
+func NewMemStore(c *Container, jid types.JID) *MemStore {
+	ms := &MemStore{Container: c, JID: jid}
+	ms.data.init()
+	return ms
+}
+
+var _ store.IdentityStore = (*MemStore)(nil)
+var _ store.SessionStore = (*MemStore)(nil)
+var _ store.PreKeyStore = (*MemStore)(nil)
+var _ store.SenderKeyStore = (*MemStore)(nil)
+var _ store.AppStateSyncKeyStore = (*MemStore)(nil)
+var _ store.AppStateStore = (*MemStore)(nil)
+var _ store.ContactStore = (*MemStore)(nil)
+var _ store.ChatSettingsStore = (*MemStore)(nil)
+var _ store.MsgSecretStore = (*MemStore)(nil)
+var _ store.PrivacyTokenStore = (*MemStore)(nil)
+
+func deleteWithPrefix(m map[string][]byte, prefix string) {
+	for key := range m {
+		if strings.HasPrefix(key, prefix) {
+			delete(m, key)
+		}
+	}
+}
+
+func (ms *MemStore) PutIdentity(address string, key [32]byte) error {
+	ms.lock.Lock()
+	ms.data.Identities[address] = key[:]
+	ms.lock.Unlock()
+	return nil
+}
+
+func (ms *MemStore) DeleteAllIdentities(phone string) error {
+	ms.lock.Lock()
+	deleteWithPrefix(ms.data.Identities, phone+":")
+	ms.lock.Unlock()
+	return nil
+}
+
+func (ms *MemStore) DeleteIdentity(address string) error {
+	ms.lock.Lock()
+	delete(ms.data.Identities, address)
+	ms.lock.Unlock()
+	return nil
+}
+
+func (ms *MemStore) IsTrustedIdentity(address string, key [32]byte) (bool, error) {
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+	existingIdentity, ok := ms.data.Identities[address]
+	if !ok {
+		// Trust if not known, it'll be saved automatically later
+		return true, nil
+	}
+	return bytes.Equal(existingIdentity, key[:]), nil
+}
+
+func (ms *MemStore) GetSession(address string) ([]byte, error) {
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+	return ms.data.Sessions[address], nil
+}
+
+func (ms *MemStore) HasSession(address string) (bool, error) {
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+	_, ok := ms.data.Sessions[address]
+	return ok, nil
+}
+
+func (ms *MemStore) PutSession(address string, session []byte) error {
+	ms.lock.Lock()
+	ms.data.Sessions[address] = session
+	ms.lock.Unlock()
+	return nil
+}
+
+func (ms *MemStore) DeleteAllSessions(phone string) error {
+	ms.lock.Lock()
+	deleteWithPrefix(ms.data.Sessions, phone+":")
+	ms.lock.Unlock()
+	return nil
+}
+
+func (ms *MemStore) DeleteSession(address string) error {
+	ms.lock.Lock()
+	delete(ms.data.Sessions, address)
+	ms.lock.Unlock()
+	return nil
+}
+
+func (ms *MemStore) genOnePreKey(id uint32, markUploaded bool) *keys.PreKey {
+	key := keys.NewPreKey(id)
+	ms.data.PreKeys[id] = &preKey{Key: key.Priv[:], Uploaded: markUploaded}
+	return key
+}
+
+func (ms *MemStore) getNextPreKeyID() uint32 {
+	var lastKeyID uint32
+	for id := range ms.data.PreKeys {
+		if id > lastKeyID {
+			lastKeyID = id
+		}
+	}
+	return lastKeyID + 1
+}
+
+func toPreKey(id uint32, key *preKey) *keys.PreKey {
+	return &keys.PreKey{
+		KeyPair: *keys.NewKeyPairFromPrivateKey(*(*[32]byte)(key.Key)),
+		KeyID:   id,
+	}
+}
+
+func (ms *MemStore) GenOnePreKey() (*keys.PreKey, error) {
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+	return ms.genOnePreKey(ms.getNextPreKeyID(), true), nil
+}
+
+func (ms *MemStore) GetOrGenPreKeys(count uint32) ([]*keys.PreKey, error) {
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+
+	unuploaded := make([]uint32, 0, count)
+	for id, key := range ms.data.PreKeys {
+		if !key.Uploaded {
+			unuploaded = append(unuploaded, id)
+		}
+	}
+	sort.Slice(unuploaded, func(i, j int) bool {
+		return unuploaded[i] < unuploaded[j]
+	})
+	if uint32(len(unuploaded)) > count {
+		unuploaded = unuploaded[:count]
+	}
+	newKeys := make([]*keys.PreKey, count)
+	for i, id := range unuploaded {
+		newKeys[i] = toPreKey(id, ms.data.PreKeys[id])
+	}
+	nextKeyID := ms.getNextPreKeyID()
+	for i := uint32(len(unuploaded)); i < count; i++ {
+		newKeys[i] = ms.genOnePreKey(nextKeyID, false)
+		nextKeyID++
+	}
+	return newKeys, nil
+}
+
+func (ms *MemStore) GetPreKey(id uint32) (*keys.PreKey, error) {
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+	key, ok := ms.data.PreKeys[id]
+	if !ok {
+		return nil, nil
+	}
+	return toPreKey(id, key), nil
+}
+
+func (ms *MemStore) RemovePreKey(id uint32) error {
+	ms.lock.Lock()
+	delete(ms.data.PreKeys, id)
+	ms.lock.Unlock()
+	return nil
+}
+
+func (ms *MemStore) MarkPreKeysAsUploaded(upToID uint32) error {
+	ms.lock.Lock()
+	for id, key := range ms.data.PreKeys {
+		if id <= upToID {
+			key.Uploaded = true
+		}
+	}
+	ms.lock.Unlock()
+	return nil
+}
+
+func (ms *MemStore) UploadedPreKeyCount() (count int, err error) {
+	ms.lock.Lock()
+	for _, key := range ms.data.PreKeys {
+		if key.Uploaded {
+			count++
+		}
+	}
+	ms.lock.Unlock()
+	return
+}
+
+func (ms *MemStore) PutSenderKey(group, user string, session []byte) error {
+	ms.lock.Lock()
+	groupKeys, ok := ms.data.SenderKeys[group]
+	if !ok {
+		groupKeys = make(map[string][]byte)
+		ms.data.SenderKeys[group] = groupKeys
+	}
+	groupKeys[user] = session
+	ms.lock.Unlock()
+	return nil
+}
+
+func (ms *MemStore) GetSenderKey(group, user string) ([]byte, error) {
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+	return ms.data.SenderKeys[group][user], nil
+}
+
+func (ms *MemStore) PutAppStateSyncKey(id []byte, key store.AppStateSyncKey) error {
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+	hexID := hex.EncodeToString(id)
+	// Existing keys are only replaced with newer ones
+	if existing, ok := ms.data.AppStateSyncKeys[hexID]; ok && key.Timestamp <= existing.Timestamp {
+		return nil
+	}
+	ms.data.AppStateSyncKeys[hexID] = &syncKey{
+		Data:        key.Data,
+		Fingerprint: key.Fingerprint,
+		Timestamp:   key.Timestamp,
+	}
+	return nil
+}
+
+func (ms *MemStore) GetAppStateSyncKey(id []byte) (*store.AppStateSyncKey, error) {
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+	key, ok := ms.data.AppStateSyncKeys[hex.EncodeToString(id)]
+	if !ok {
+		return nil, nil
+	}
+	return &store.AppStateSyncKey{
+		Data:        key.Data,
+		Fingerprint: key.Fingerprint,
+		Timestamp:   key.Timestamp,
+	}, nil
+}
+
+func (ms *MemStore) GetLatestAppStateSyncKeyID() ([]byte, error) {
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+	var latestID string
+	var latest *syncKey
+	for id, key := range ms.data.AppStateSyncKeys {
+		if latest == nil || key.Timestamp > latest.Timestamp {
+			latestID, latest = id, key
+		}
+	}
+	if latest == nil {
+		return nil, nil
+	}
+	return hex.DecodeString(latestID)
+}
+
+func (ms *MemStore) PutAppStateVersion(name string, version uint64, hash [128]byte) error {
+	ms.lock.Lock()
+	ms.data.AppStateVersions[name] = &appStateVersion{Version: version, Hash: hash[:]}
+	ms.lock.Unlock()
+	return nil
+}
+
+func (ms *MemStore) GetAppStateVersion(name string) (version uint64, hash [128]byte, err error) {
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+	// If there's no version, it'll be 0 and hash will be an empty array, which is the correct initial state
+	if existing, ok := ms.data.AppStateVersions[name]; ok {
+		version = existing.Version
+		copy(hash[:], existing.Hash)
+	}
+	return
+}
+
+func (ms *MemStore) DeleteAppStateVersion(name string) error {
+	ms.lock.Lock()
+	delete(ms.data.AppStateVersions, name)
+	// Mutation MACs belong to the version, so they're deleted too
+	delete(ms.data.AppStateMACs, name)
+	ms.lock.Unlock()
+	return nil
+}
+
+func (ms *MemStore) PutAppStateMutationMACs(name string, version uint64, mutations []store.AppStateMutationMAC) error {
+	if len(mutations) == 0 {
+		return nil
+	}
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+	macs, ok := ms.data.AppStateMACs[name]
+	if !ok {
+		macs = make(map[string]map[uint64][]byte)
+		ms.data.AppStateMACs[name] = macs
+	}
+	for _, mutation := range mutations {
+		indexMAC := hex.EncodeToString(mutation.IndexMAC)
+		versions, ok := macs[indexMAC]
+		if !ok {
+			versions = make(map[uint64][]byte)
+			macs[indexMAC] = versions
+		}
+		versions[version] = mutation.ValueMAC
+	}
+	return nil
+}
+
+func (ms *MemStore) DeleteAppStateMutationMACs(name string, indexMACs [][]byte) error {
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+	macs, ok := ms.data.AppStateMACs[name]
+	if !ok {
+		return nil
+	}
+	for _, indexMAC := range indexMACs {
+		delete(macs, hex.EncodeToString(indexMAC))
+	}
+	return nil
+}
+
+func (ms *MemStore) GetAppStateMutationMAC(name string, indexMAC []byte) (valueMAC []byte, err error) {
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+	var latestVersion uint64
+	for version, value := range ms.data.AppStateMACs[name][hex.EncodeToString(indexMAC)] {
+		if valueMAC == nil || version > latestVersion {
+			latestVersion, valueMAC = version, value
+		}
+	}
+	return
+}
+
+func (ms *MemStore) getContact(user types.JID) *contact {
+	existing, ok := ms.data.Contacts[user]
+	if !ok {
+		existing = &contact{}
+		ms.data.Contacts[user] = existing
+	}
+	return existing
+}
+
+func (ms *MemStore) PutPushName(user types.JID, pushName string) (bool, string, error) {
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+	existing, ok := ms.data.Contacts[user]
+	if ok && existing.PushName == pushName {
+		return false, "", nil
+	} else if !ok && pushName == "" {
+		return false, "", nil
+	}
+	existing = ms.getContact(user)
+	previousName := existing.PushName
+	existing.PushName = pushName
+	return true, previousName, nil
+}
+
+func (ms *MemStore) PutBusinessName(user types.JID, businessName string) (bool, string, error) {
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+	existing, ok := ms.data.Contacts[user]
+	if ok && existing.BusinessName == businessName {
+		return false, "", nil
+	} else if !ok && businessName == "" {
+		return false, "", nil
+	}
+	existing = ms.getContact(user)
+	previousName := existing.BusinessName
+	existing.BusinessName = businessName
+	return true, previousName, nil
+}
+
+func (ms *MemStore) PutContactName(user types.JID, firstName, fullName string) error {
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+	if _, ok := ms.data.Contacts[user]; !ok && firstName == "" && fullName == "" {
+		return nil
+	}
+	existing := ms.getContact(user)
+	existing.FirstName = firstName
+	existing.FullName = fullName
+	return nil
+}
+
+func (ms *MemStore) PutAllContactNames(contacts []store.ContactEntry) error {
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+	for _, entry := range contacts {
+		if entry.JID.IsEmpty() {
+			ms.log.Warnf("Empty contact info in mass insert: %+v", entry)
+			continue
+		}
+		existing := ms.getContact(entry.JID)
+		existing.FirstName = entry.FirstName
+		existing.FullName = entry.FullName
+	}
+	return nil
+}
+
+func (c *contact) toInfo() types.ContactInfo {
+	return types.ContactInfo{
+		Found:        true,
+		FirstName:    c.FirstName,
+		FullName:     c.FullName,
+		PushName:     c.PushName,
+		BusinessName: c.BusinessName,
+	}
+}
+
+func (ms *MemStore) GetContact(user types.JID) (types.ContactInfo, error) {
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+	existing, ok := ms.data.Contacts[user]
+	if !ok {
+		return types.ContactInfo{}, nil
+	}
+	return existing.toInfo(), nil
+}
+
+func (ms *MemStore) GetAllContacts() (map[types.JID]types.ContactInfo, error) {
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+	output := make(map[types.JID]types.ContactInfo, len(ms.data.Contacts))
+	for jid, existing := range ms.data.Contacts {
+		output[jid] = existing.toInfo()
+	}
+	return output, nil
+}
+
+func (ms *MemStore) getChatSettings(chat types.JID) *chatSettings {
+	existing, ok := ms.data.ChatSettings[chat]
+	if !ok {
+		existing = &chatSettings{}
+		ms.data.ChatSettings[chat] = existing
+	}
+	return existing
+}
+
+func (ms *MemStore) PutMutedUntil(chat types.JID, mutedUntil time.Time) error {
+	var val int64
+	if !mutedUntil.IsZero() {
+		val = mutedUntil.Unix()
+	}
+	ms.lock.Lock()
+	ms.getChatSettings(chat).MutedUntil = val
+	ms.lock.Unlock()
+	return nil
+}
+
+func (ms *MemStore) PutPinned(chat types.JID, pinned bool) error {
+	ms.lock.Lock()
+	ms.getChatSettings(chat).Pinned = pinned
+	ms.lock.Unlock()
+	return nil
+}
+
+func (ms *MemStore) PutArchived(chat types.JID, archived bool) error {
+	ms.lock.Lock()
+	ms.getChatSettings(chat).Archived = archived
+	ms.lock.Unlock()
+	return nil
+}
+
+func (ms *MemStore) GetChatSettings(chat types.JID) (settings types.LocalChatSettings, err error) {
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+	existing, ok := ms.data.ChatSettings[chat]
+	if !ok {
+		return
+	}
+	settings.Found = true
+	settings.Pinned = existing.Pinned
+	settings.Archived = existing.Archived
+	if existing.MutedUntil != 0 {
+		settings.MutedUntil = time.Unix(existing.MutedUntil, 0)
+	}
+	return
+}
+
+func messageSecretKey(chat, sender types.JID, id types.MessageID) string {
+	return chat.ToNonAD().String() + "|" + sender.ToNonAD().String() + "|" + id
+}
+
+func (ms *MemStore) putMessageSecret(chat, sender types.JID, id types.MessageID, secret []byte) {
+	key := messageSecretKey(chat, sender, id)
+	// Like the SQL store, existing secrets are never overwritten
+	if _, exists := ms.data.MessageSecrets[key]; !exists {
+		ms.data.MessageSecrets[key] = secret
+	}
+}
+
+func (ms *MemStore) PutMessageSecrets(inserts []store.MessageSecretInsert) error {
+	ms.lock.Lock()
+	for _, insert := range inserts {
+		ms.putMessageSecret(insert.Chat, insert.Sender, insert.ID, insert.Secret)
+	}
+	ms.lock.Unlock()
+	return nil
+}
+
+func (ms *MemStore) PutMessageSecret(chat, sender types.JID, id types.MessageID, secret []byte) error {
+	ms.lock.Lock()
+	ms.putMessageSecret(chat, sender, id, secret)
+	ms.lock.Unlock()
+	return nil
+}
+
+func (ms *MemStore) GetMessageSecret(chat, sender types.JID, id types.MessageID) ([]byte, error) {
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+	return ms.data.MessageSecrets[messageSecretKey(chat, sender, id)], nil
+}
+
+func (ms *MemStore) PutPrivacyTokens(tokens ...store.PrivacyToken) error {
+	ms.lock.Lock()
+	for _, token := range tokens {
+		ms.data.PrivacyTokens[token.User.ToNonAD()] = &privacyToken{
+			Token:     token.Token,
+			Timestamp: token.Timestamp.Unix(),
+		}
+	}
+	ms.lock.Unlock()
+	return nil
+}
+
+func (ms *MemStore) GetPrivacyToken(user types.JID) (*store.PrivacyToken, error) {
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+	user = user.ToNonAD()
+	token, ok := ms.data.PrivacyTokens[user]
+	if !ok {
+		return nil, nil
+	}
+	return &store.PrivacyToken{
+		User:      user,
+		Token:     token.Token,
+		Timestamp: time.Unix(token.Timestamp, 0),
+	}, nil
+}
@@ -23,26 +23,34 @@ import (
 
 // Container is a wrapper for a SQL database that can contain multiple whatsmeow sessions.
 type Container struct {
-	db      *sql.DB
+	db      *dbConn
 	dialect string
 	log     waLog.Logger
 
 	DatabaseErrorHandler func(device *store.Device, action string, attemptIndex int, err error) (retry bool)
+
+	// Encryption enables encrypting private keys, Signal sessions, sender keys and app state sync keys in the database.
+	// Values that were stored before setting this are still readable, but they're only encrypted after calling Reencrypt.
+	Encryption EncryptionKeyProvider
 }
 
 var _ store.DeviceContainer = (*Container)(nil)
 
 // New connects to the given SQL database and wraps it in a Container.
 //
-// Only SQLite and Postgres are currently fully supported.
+// SQLite (sqlite3), Postgres (postgres or pgx), MySQL 8.0.19+ (mysql) and MariaDB 10.3+ (mariadb) are supported.
 //
 // The logger can be nil and will default to a no-op logger.
 //
 // When using SQLite, it's strongly recommended to enable foreign keys by adding `?_foreign_keys=true`:
 //
 //	container, err := sqlstore.New("sqlite3", "file:yoursqlitefile.db?_foreign_keys=on", nil)
+//
+// When using MySQL or MariaDB, the address is a github.com/go-sql-driver/mysql DSN:
+//
+//	container, err := sqlstore.New("mysql", "user:password@tcp(localhost:3306)/whatsmeow", nil)
 func New(dialect, address string, log waLog.Logger) (*Container, error) {
-	db, err := sql.Open(dialect, address)
+	db, err := sql.Open(driverName(dialect), address)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open database: %w", err)
 	}
@@ -56,7 +64,8 @@ func New(dialect, address string, log waLog.Logger) (*Container, error) {
 
 // NewWithDB wraps an existing SQL connection in a Container.
 //
-// Only SQLite and Postgres are currently fully supported.
+// SQLite (sqlite3), Postgres (postgres or pgx), MySQL 8.0.19+ (mysql) and MariaDB 10.3+ (mariadb) are supported.
+// The MySQL and MariaDB dialects both use the mysql driver, but they need slightly different queries.
 //
 // The logger can be nil and will default to a no-op logger.
 //
@@ -77,7 +86,7 @@ func NewWithDB(db *sql.DB, dialect string, log waLog.Logger) *Container {
 		log = waLog.Noop
 	}
 	return &Container{
-		db:      db,
+		db:      &dbConn{DB: db, dialect: dialect},
 		dialect: dialect,
 		log:     log,
 	}
@@ -113,6 +122,8 @@ func (c *Container) scanDevice(row scannable) (*store.Device, error) {
 		&device.Platform, &device.BusinessName, &device.PushName)
 	if err != nil {
 		return nil, fmt.Errorf("failed to scan session: %w", err)
+	} else if err = c.decryptDeviceKeys(device.JID.String(), &noisePriv, &identityPriv, &preKeyPriv, &device.AdvSecretKey); err != nil {
+		return nil, err
 	} else if len(noisePriv) != 32 || len(identityPriv) != 32 || len(preKeyPriv) != 32 || len(preKeySig) != 64 {
 		return nil, ErrInvalidLength
 	}
@@ -235,7 +246,7 @@ var ErrDeviceIDMustBeSet = errors.New("device JID must be known before accessing
 
 // Close will close the container's database
 func (c *Container) Close() error {
-	if c != nil && c.db != nil {
+	if c != nil && c.db != nil && c.db.DB != nil {
 		return c.db.Close()
 	}
 	return nil
@@ -247,10 +258,14 @@ func (c *Container) PutDevice(device *store.Device) error {
 	if device.JID == nil {
 		return ErrDeviceIDMustBeSet
 	}
-	_, err := c.db.Exec(insertDeviceQuery,
-		device.JID.String(), device.RegistrationID, device.NoiseKey.Priv[:], device.IdentityKey.Priv[:],
-		device.SignedPreKey.Priv[:], device.SignedPreKey.KeyID, device.SignedPreKey.Signature[:],
-		device.AdvSecretKey, device.Account.Details, device.Account.AccountSignature, device.Account.AccountSignatureKey, device.Account.DeviceSignature,
+	noisePriv, identityPriv, preKeyPriv, advKey, err := c.encryptDeviceKeys(device)
+	if err != nil {
+		return err
+	}
+	_, err = c.db.Exec(insertDeviceQuery,
+		device.JID.String(), device.RegistrationID, noisePriv, identityPriv,
+		preKeyPriv, device.SignedPreKey.KeyID, device.SignedPreKey.Signature[:],
+		advKey, device.Account.Details, device.Account.AccountSignature, device.Account.AccountSignatureKey, device.Account.DeviceSignature,
 		device.Platform, device.BusinessName, device.PushName)
 
 	if !device.Initialized {
@@ -270,6 +285,31 @@ func (c *Container) PutDevice(device *store.Device) error {
 	return err
 }
 
+func (c *Container) encryptDeviceKeys(device *store.Device) (noisePriv, identityPriv, preKeyPriv, advKey []byte, err error) {
+	jid := device.JID.String()
+	if noisePriv, err = c.encrypt(columnNoiseKey, device.NoiseKey.Priv[:], jid); err != nil {
+		return
+	} else if identityPriv, err = c.encrypt(columnIdentityKey, device.IdentityKey.Priv[:], jid); err != nil {
+		return
+	} else if preKeyPriv, err = c.encrypt(columnSignedPreKey, device.SignedPreKey.Priv[:], jid); err != nil {
+		return
+	}
+	advKey, err = c.encrypt(columnAdvKey, device.AdvSecretKey, jid)
+	return
+}
+
+func (c *Container) decryptDeviceKeys(jid string, noisePriv, identityPriv, preKeyPriv, advKey *[]byte) (err error) {
+	if *noisePriv, err = c.decrypt(columnNoiseKey, *noisePriv, jid); err != nil {
+		return
+	} else if *identityPriv, err = c.decrypt(columnIdentityKey, *identityPriv, jid); err != nil {
+		return
+	} else if *preKeyPriv, err = c.decrypt(columnSignedPreKey, *preKeyPriv, jid); err != nil {
+		return
+	}
+	*advKey, err = c.decrypt(columnAdvKey, *advKey, jid)
+	return
+}
+
 // DeleteDevice deletes the given device from this database. This should be called through Device.Delete()
 func (c *Container) DeleteDevice(store *store.Device) error {
 	if store.JID == nil {
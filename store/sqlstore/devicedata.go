@@ -0,0 +1,317 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package sqlstore
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+
+	"github.com/go-whatsapp/whatsmeow/store"
+	"github.com/go-whatsapp/whatsmeow/types"
+)
+
+var _ store.DeviceDataStore = (*SQLStore)(nil)
+var _ store.DeviceDataContainer = (*Container)(nil)
+
+// GetDeviceDataStore returns the store that the device with the given JID uses.
+func (c *Container) GetDeviceDataStore(jid types.JID) store.DeviceDataStore {
+	return NewSQLStore(c, jid)
+}
+
+const (
+	exportIdentitiesQuery      = `SELECT their_id, identity FROM whatsmeow_identity_keys WHERE our_jid=$1`
+	exportSessionsQuery        = `SELECT their_id, session FROM whatsmeow_sessions WHERE our_jid=$1`
+	exportPreKeysQuery         = `SELECT key_id, key, uploaded FROM whatsmeow_pre_keys WHERE jid=$1 ORDER BY key_id`
+	exportSenderKeysQuery      = `SELECT chat_id, sender_id, sender_key FROM whatsmeow_sender_keys WHERE our_jid=$1`
+	exportAppStateSyncKeyQuery = `SELECT key_id, key_data, fingerprint, timestamp FROM whatsmeow_app_state_sync_keys WHERE jid=$1`
+	exportAppStateVersionQuery = `SELECT name, version, hash FROM whatsmeow_app_state_version WHERE jid=$1`
+	exportMutationMACsQuery    = `SELECT name, version, index_mac, value_mac FROM whatsmeow_app_state_mutation_macs WHERE jid=$1`
+	exportChatSettingsQuery    = `SELECT chat_jid, muted_until, pinned, archived FROM whatsmeow_chat_settings WHERE our_jid=$1`
+	exportMessageSecretsQuery  = `SELECT chat_jid, sender_jid, message_id, key FROM whatsmeow_message_secrets WHERE our_jid=$1`
+	exportPrivacyTokensQuery   = `SELECT their_jid, token, timestamp FROM whatsmeow_privacy_tokens WHERE our_jid=$1`
+
+	importPreKeyQuery = `
+		INSERT INTO whatsmeow_pre_keys (jid, key_id, key, uploaded) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (jid, key_id) DO UPDATE SET key=excluded.key, uploaded=excluded.uploaded
+	`
+	importContactQuery = `
+		INSERT INTO whatsmeow_contacts (our_jid, their_jid, first_name, full_name, push_name, business_name)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (our_jid, their_jid) DO UPDATE
+			SET first_name=excluded.first_name, full_name=excluded.full_name,
+			    push_name=excluded.push_name, business_name=excluded.business_name
+	`
+	importChatSettingsQuery = `
+		INSERT INTO whatsmeow_chat_settings (our_jid, chat_jid, muted_until, pinned, archived) VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (our_jid, chat_jid) DO UPDATE
+			SET muted_until=excluded.muted_until, pinned=excluded.pinned, archived=excluded.archived
+	`
+)
+
+// exportRows runs the given query for this device and calls the given function for each row.
+func (s *SQLStore) exportRows(query string, fn func(rows *sql.Rows) error) error {
+	rows, err := s.db.Query(query, s.JID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		if err = fn(rows); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+func (s *SQLStore) ExportDeviceData() (*store.DeviceData, error) {
+	data := &store.DeviceData{
+		Identities: make(map[string][]byte),
+		Sessions:   make(map[string][]byte),
+	}
+	err := s.exportRows(exportIdentitiesQuery, func(rows *sql.Rows) error {
+		var address string
+		var key []byte
+		err := rows.Scan(&address, &key)
+		data.Identities[address] = key
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to export identities: %w", err)
+	}
+	err = s.exportRows(exportSessionsQuery, func(rows *sql.Rows) error {
+		var address string
+		var session []byte
+		err := rows.Scan(&address, &session)
+		if err != nil {
+			return err
+		}
+		data.Sessions[address], err = s.decrypt(columnSession, session, s.JID, address)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to export sessions: %w", err)
+	}
+	err = s.exportRows(exportPreKeysQuery, func(rows *sql.Rows) error {
+		var key store.PreKeyData
+		err := rows.Scan(&key.ID, &key.Key, &key.Uploaded)
+		if err != nil {
+			return err
+		} else if key.Key, err = s.decrypt(columnPreKey, key.Key, s.JID, strconv.FormatUint(uint64(key.ID), 10)); err != nil {
+			return err
+		}
+		data.PreKeys = append(data.PreKeys, key)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to export prekeys: %w", err)
+	}
+	err = s.exportRows(exportSenderKeysQuery, func(rows *sql.Rows) error {
+		var key store.SenderKeyData
+		err := rows.Scan(&key.Group, &key.User, &key.Key)
+		if err != nil {
+			return err
+		} else if key.Key, err = s.decrypt(columnSenderKey, key.Key, s.JID, key.Group, key.User); err != nil {
+			return err
+		}
+		data.SenderKeys = append(data.SenderKeys, key)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to export sender keys: %w", err)
+	}
+	err = s.exportRows(exportAppStateSyncKeyQuery, func(rows *sql.Rows) error {
+		var key store.AppStateSyncKeyData
+		err := rows.Scan(&key.ID, &key.Data, &key.Fingerprint, &key.Timestamp)
+		if err != nil {
+			return err
+		} else if key.Data, err = s.decrypt(columnAppStateSyncKey, key.Data, s.JID, string(key.ID)); err != nil {
+			return err
+		}
+		data.AppStateSyncKeys = append(data.AppStateSyncKeys, key)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to export app state sync keys: %w", err)
+	}
+	err = s.exportRows(exportAppStateVersionQuery, func(rows *sql.Rows) error {
+		var version store.AppStateVersionData
+		err := rows.Scan(&version.Name, &version.Version, &version.Hash)
+		data.AppStateVersions = append(data.AppStateVersions, version)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to export app state versions: %w", err)
+	}
+	err = s.exportRows(exportMutationMACsQuery, func(rows *sql.Rows) error {
+		var mac store.AppStateMutationMACData
+		err := rows.Scan(&mac.Name, &mac.Version, &mac.IndexMAC, &mac.ValueMAC)
+		data.AppStateMutationMACs = append(data.AppStateMutationMACs, mac)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to export app state mutation MACs: %w", err)
+	}
+	contacts, err := s.GetAllContacts()
+	if err != nil {
+		return nil, fmt.Errorf("failed to export contacts: %w", err)
+	}
+	for jid, info := range contacts {
+		data.Contacts = append(data.Contacts, store.ContactData{
+			JID:          jid,
+			FirstName:    info.FirstName,
+			FullName:     info.FullName,
+			PushName:     info.PushName,
+			BusinessName: info.BusinessName,
+		})
+	}
+	err = s.exportRows(exportChatSettingsQuery, func(rows *sql.Rows) error {
+		var settings store.ChatSettingsData
+		err := rows.Scan(&settings.Chat, &settings.MutedUntil, &settings.Pinned, &settings.Archived)
+		data.ChatSettings = append(data.ChatSettings, settings)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to export chat settings: %w", err)
+	}
+	err = s.exportRows(exportMessageSecretsQuery, func(rows *sql.Rows) error {
+		var secret store.MessageSecretData
+		err := rows.Scan(&secret.Chat, &secret.Sender, &secret.ID, &secret.Secret)
+		data.MessageSecrets = append(data.MessageSecrets, secret)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to export message secrets: %w", err)
+	}
+	err = s.exportRows(exportPrivacyTokensQuery, func(rows *sql.Rows) error {
+		var token store.PrivacyTokenData
+		err := rows.Scan(&token.User, &token.Token, &token.Timestamp)
+		data.PrivacyTokens = append(data.PrivacyTokens, token)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to export privacy tokens: %w", err)
+	}
+	return data, nil
+}
+
+func (s *SQLStore) ImportDeviceData(data *store.DeviceData) error {
+	if err := data.Validate(); err != nil {
+		return err
+	}
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to start transaction: %w", err)
+	}
+	err = s.importDeviceData(tx, data)
+	if err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	// The contact cache may contain outdated info now
+	s.contactCache.Clear()
+	return nil
+}
+
+func (s *SQLStore) importDeviceData(tx *dbTx, data *store.DeviceData) error {
+	for address, key := range data.Identities {
+		if _, err := tx.Exec(putIdentityQuery, s.JID, address, key); err != nil {
+			return fmt.Errorf("failed to import identity: %w", err)
+		}
+	}
+	for address, session := range data.Sessions {
+		encrypted, err := s.encrypt(columnSession, session, s.JID, address)
+		if err != nil {
+			return err
+		} else if _, err = tx.Exec(putSessionQuery, s.JID, address, encrypted); err != nil {
+			return fmt.Errorf("failed to import session: %w", err)
+		}
+	}
+	for _, key := range data.PreKeys {
+		encrypted, err := s.encrypt(columnPreKey, key.Key, s.JID, strconv.FormatUint(uint64(key.ID), 10))
+		if err != nil {
+			return err
+		} else if _, err = tx.Exec(importPreKeyQuery, s.JID, key.ID, encrypted, key.Uploaded); err != nil {
+			return fmt.Errorf("failed to import prekey: %w", err)
+		}
+	}
+	for _, key := range data.SenderKeys {
+		encrypted, err := s.encrypt(columnSenderKey, key.Key, s.JID, key.Group, key.User)
+		if err != nil {
+			return err
+		} else if _, err = tx.Exec(putSenderKeyQuery, s.JID, key.Group, key.User, encrypted); err != nil {
+			return fmt.Errorf("failed to import sender key: %w", err)
+		}
+	}
+	syncKeyQuery := putAppStateSyncKeyQuery
+	if isMySQL(s.dialect) {
+		syncKeyQuery = putAppStateSyncKeyQueryMySQL
+	}
+	for _, key := range data.AppStateSyncKeys {
+		encrypted, err := s.encrypt(columnAppStateSyncKey, key.Data, s.JID, string(key.ID))
+		if err != nil {
+			return err
+		} else if _, err = tx.Exec(syncKeyQuery, s.JID, key.ID, encrypted, key.Timestamp, key.Fingerprint); err != nil {
+			return fmt.Errorf("failed to import app state sync key: %w", err)
+		}
+	}
+	// Versions must be inserted before mutation MACs because of the foreign key
+	for _, version := range data.AppStateVersions {
+		if _, err := tx.Exec(putAppStateVersionQuery, s.JID, version.Name, version.Version, version.Hash); err != nil {
+			return fmt.Errorf("failed to import app state version: %w", err)
+		}
+	}
+	type macGroup struct {
+		name    string
+		version uint64
+	}
+	macGroups := make(map[macGroup][]store.AppStateMutationMAC)
+	for _, mac := range data.AppStateMutationMACs {
+		group := macGroup{mac.Name, mac.Version}
+		macGroups[group] = append(macGroups[group], store.AppStateMutationMAC{IndexMAC: mac.IndexMAC, ValueMAC: mac.ValueMAC})
+	}
+	for group, mutations := range macGroups {
+		for i := 0; i < len(mutations); i += mutationBatchSize {
+			end := i + mutationBatchSize
+			if end > len(mutations) {
+				end = len(mutations)
+			}
+			if err := s.putAppStateMutationMACs(tx, group.name, group.version, mutations[i:end]); err != nil {
+				return fmt.Errorf("failed to import app state mutation MACs: %w", err)
+			}
+		}
+	}
+	for _, entry := range data.Contacts {
+		_, err := tx.Exec(importContactQuery, s.JID, entry.JID, entry.FirstName, entry.FullName, entry.PushName, entry.BusinessName)
+		if err != nil {
+			return fmt.Errorf("failed to import contact: %w", err)
+		}
+	}
+	for _, settings := range data.ChatSettings {
+		_, err := tx.Exec(importChatSettingsQuery, s.JID, settings.Chat, settings.MutedUntil, settings.Pinned, settings.Archived)
+		if err != nil {
+			return fmt.Errorf("failed to import chat settings: %w", err)
+		}
+	}
+	for _, secret := range data.MessageSecrets {
+		_, err := tx.Exec(putMsgSecret, s.JID, secret.Chat.ToNonAD(), secret.Sender.ToNonAD(), secret.ID, secret.Secret)
+		if err != nil {
+			return fmt.Errorf("failed to import message secret: %w", err)
+		}
+	}
+	for _, token := range data.PrivacyTokens {
+		_, err := tx.Exec(putPrivacyTokens, s.JID, token.User.ToNonAD().String(), token.Token, token.Timestamp)
+		if err != nil {
+			return fmt.Errorf("failed to import privacy token: %w", err)
+		}
+	}
+	return nil
+}
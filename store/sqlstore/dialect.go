@@ -0,0 +1,211 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package sqlstore
+
+import (
+	"database/sql"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/puzpuzpuz/xsync/v3"
+)
+
+// isMySQL returns true if the dialect is MySQL or MariaDB (which use the same driver).
+func isMySQL(dialect string) bool {
+	return dialect == "mysql" || dialect == "mariadb"
+}
+
+// driverName returns the name of the database/sql driver used for the given dialect.
+func driverName(dialect string) string {
+	if dialect == "mariadb" {
+		return "mysql"
+	}
+	return dialect
+}
+
+var (
+	onConflictNothing   = regexp.MustCompile(`(?is)ON CONFLICT\s*\(\s*(\w+)[^)]*\)\s*DO\s+NOTHING`)
+	onConflictUpdate    = regexp.MustCompile(`(?is)ON CONFLICT\s*\([^)]*\)\s*DO\s+UPDATE\s+SET\s`)
+	onDuplicateKeyRegex = regexp.MustCompile(`(?i)\bON DUPLICATE KEY UPDATE\b`)
+	excludedColumnRegex = regexp.MustCompile(`(?i)\bexcluded\.(\w+)`)
+)
+
+// mysqlRowAlias is the alias of the inserted row in upserts, see https://dev.mysql.com/doc/refman/8.0/en/insert-on-duplicate.html
+const mysqlRowAlias = "new"
+
+// mysqlQuery is a query converted to MySQL syntax by convertMySQL.
+type mysqlQuery struct {
+	query string
+	// argOrder contains the index of the original argument for each placeholder in the converted query.
+	argOrder []int
+}
+
+// reorderArgs returns the arguments in the order of the placeholders in the converted query.
+// If the original query had invalid placeholders, the arguments are returned as-is and the driver will return an error.
+func (mq *mysqlQuery) reorderArgs(args []interface{}) []interface{} {
+	newArgs := make([]interface{}, len(mq.argOrder))
+	for i, index := range mq.argOrder {
+		if index < 0 || index >= len(args) {
+			return args
+		}
+		newArgs[i] = args[index]
+	}
+	return newArgs
+}
+
+// convertMySQL converts a query written for Postgres and SQLite into MySQL syntax.
+//
+// Upserts are converted to ON DUPLICATE KEY UPDATE. On MySQL, excluded.column is replaced with a row alias,
+// while MariaDB doesn't support row aliases, so the VALUES() function is used instead. Upserts with a WHERE
+// clause can't be converted automatically, so they need a separate MySQL-specific query, which can still
+// refer to excluded.column.
+//
+// Numbered placeholders are replaced with question marks, and the order of arguments is stored in the returned
+// struct, as the same placeholder may be used multiple times. The `key` column is quoted, as it's a reserved word
+// in MySQL. String literals are left as-is.
+func convertMySQL(query string, mariaDB bool) *mysqlQuery {
+	query = onConflictNothing.ReplaceAllString(query, "ON DUPLICATE KEY UPDATE ${1}=${1}")
+	query = onConflictUpdate.ReplaceAllString(query, "ON DUPLICATE KEY UPDATE ")
+	if mariaDB {
+		query = excludedColumnRegex.ReplaceAllString(query, "VALUES(${1})")
+	} else if excludedColumnRegex.MatchString(query) {
+		query = excludedColumnRegex.ReplaceAllString(query, mysqlRowAlias+".${1}")
+		query = onDuplicateKeyRegex.ReplaceAllString(query, "AS "+mysqlRowAlias+" ${0}")
+	}
+
+	converted := &mysqlQuery{}
+	var output strings.Builder
+	output.Grow(len(query) + 8)
+	for i := 0; i < len(query); {
+		switch char := query[i]; {
+		case char == '\'':
+			end := strings.IndexByte(query[i+1:], '\'')
+			if end < 0 {
+				end = len(query)
+			} else {
+				end += i + 2
+			}
+			output.WriteString(query[i:end])
+			i = end
+		case char == '$' && i+1 < len(query) && isDigit(query[i+1]):
+			end := i + 1
+			for end < len(query) && isDigit(query[end]) {
+				end++
+			}
+			index, _ := strconv.Atoi(query[i+1 : end])
+			converted.argOrder = append(converted.argOrder, index-1)
+			output.WriteByte('?')
+			i = end
+		case isIdentifierChar(char):
+			end := i + 1
+			for end < len(query) && isIdentifierChar(query[end]) {
+				end++
+			}
+			if word := query[i:end]; word == "key" {
+				output.WriteString("`key`")
+			} else {
+				output.WriteString(word)
+			}
+			i = end
+		default:
+			output.WriteByte(char)
+			i++
+		}
+	}
+	converted.query = output.String()
+	return converted
+}
+
+func isDigit(char byte) bool {
+	return char >= '0' && char <= '9'
+}
+
+func isIdentifierChar(char byte) bool {
+	return isDigit(char) || char == '_' || (char >= 'a' && char <= 'z') || (char >= 'A' && char <= 'Z')
+}
+
+// mysqlQueryCache contains queries that have already been converted to MySQL syntax, so that each query
+// is only converted once. The converted queries only depend on the original query, so they're shared
+// between all containers.
+type mysqlQueryCache struct {
+	mariaDB bool
+	queries *xsync.MapOf[string, *mysqlQuery]
+}
+
+var (
+	mysqlQueries   = &mysqlQueryCache{queries: xsync.NewMapOf[string, *mysqlQuery]()}
+	mariaDBQueries = &mysqlQueryCache{queries: xsync.NewMapOf[string, *mysqlQuery](), mariaDB: true}
+)
+
+func (cache *mysqlQueryCache) rewrite(query string, args []interface{}) (string, []interface{}) {
+	converted, _ := cache.queries.LoadOrCompute(query, func() *mysqlQuery {
+		return convertMySQL(query, cache.mariaDB)
+	})
+	return converted.query, converted.reorderArgs(args)
+}
+
+// dbConn is a wrapper for sql.DB that converts queries to the syntax of the dialect before executing them.
+type dbConn struct {
+	*sql.DB
+	dialect string
+}
+
+func (db *dbConn) rewrite(query string, args []interface{}) (string, []interface{}) {
+	switch db.dialect {
+	case "mysql":
+		return mysqlQueries.rewrite(query, args)
+	case "mariadb":
+		return mariaDBQueries.rewrite(query, args)
+	default:
+		return query, args
+	}
+}
+
+func (db *dbConn) Exec(query string, args ...interface{}) (sql.Result, error) {
+	query, args = db.rewrite(query, args)
+	return db.DB.Exec(query, args...)
+}
+
+func (db *dbConn) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	query, args = db.rewrite(query, args)
+	return db.DB.Query(query, args...)
+}
+
+func (db *dbConn) QueryRow(query string, args ...interface{}) *sql.Row {
+	query, args = db.rewrite(query, args)
+	return db.DB.QueryRow(query, args...)
+}
+
+func (db *dbConn) Begin() (*dbTx, error) {
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	return &dbTx{Tx: tx, db: db}, nil
+}
+
+// dbTx is a wrapper for sql.Tx that converts queries to the syntax of the dialect like dbConn.
+type dbTx struct {
+	*sql.Tx
+	db *dbConn
+}
+
+func (tx *dbTx) Exec(query string, args ...interface{}) (sql.Result, error) {
+	query, args = tx.db.rewrite(query, args)
+	return tx.Tx.Exec(query, args...)
+}
+
+func (tx *dbTx) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	query, args = tx.db.rewrite(query, args)
+	return tx.Tx.Query(query, args...)
+}
+
+func (tx *dbTx) QueryRow(query string, args ...interface{}) *sql.Row {
+	query, args = tx.db.rewrite(query, args)
+	return tx.Tx.QueryRow(query, args...)
+}
@@ -0,0 +1,67 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package sqlstore
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/puzpuzpuz/xsync/v3"
+)
+
+func TestConvertMySQL(t *testing.T) {
+	converted := convertMySQL(putMsgSecret, false)
+	if !strings.Contains(converted.query, "ON DUPLICATE KEY UPDATE our_jid=our_jid") || strings.Contains(converted.query, "ON CONFLICT") {
+		t.Fatalf("DO NOTHING wasn't converted: %s", converted.query)
+	} else if !strings.Contains(converted.query, "message_id, `key`)") || !strings.Contains(converted.query, "VALUES (?, ?, ?, ?, ?)") {
+		t.Fatalf("key column or placeholders weren't converted: %s", converted.query)
+	} else if strings.Contains(converted.query, "AS new") {
+		t.Fatalf("row alias was added to upsert without excluded references: %s", converted.query)
+	} else if !reflect.DeepEqual(converted.argOrder, []int{0, 1, 2, 3, 4}) {
+		t.Fatalf("unexpected argument order %v", converted.argOrder)
+	}
+
+	converted = convertMySQL(putPrivacyTokens, false)
+	if !strings.Contains(converted.query, "AS new ON DUPLICATE KEY UPDATE token=new.token, timestamp=new.timestamp") {
+		t.Fatalf("DO UPDATE wasn't converted: %s", converted.query)
+	}
+	converted = convertMySQL(putPrivacyTokens, true)
+	if !strings.Contains(converted.query, "ON DUPLICATE KEY UPDATE token=VALUES(token), timestamp=VALUES(timestamp)") || strings.Contains(converted.query, "AS new") {
+		t.Fatalf("DO UPDATE wasn't converted for MariaDB: %s", converted.query)
+	}
+	converted = convertMySQL(putAppStateSyncKeyQueryMySQL, false)
+	if !strings.Contains(converted.query, "AS new ON DUPLICATE KEY UPDATE") || !strings.Contains(converted.query, "key_data=IF(new.timestamp > timestamp, new.key_data, key_data)") {
+		t.Fatalf("MySQL-specific upsert wasn't converted: %s", converted.query)
+	}
+
+	// Reused placeholders must duplicate the arguments, and string literals must not be changed
+	converted = convertMySQL("INSERT INTO t (key, value) VALUES ($1, $3), ($1, 'key $2'), ($1, $2)", false)
+	if converted.query != "INSERT INTO t (`key`, value) VALUES (?, ?), (?, 'key $2'), (?, ?)" {
+		t.Fatalf("unexpected query %q", converted.query)
+	} else if args := converted.reorderArgs([]interface{}{1, 2, 3}); !reflect.DeepEqual(args, []interface{}{1, 3, 1, 1, 2}) {
+		t.Fatalf("unexpected args %v", args)
+	} else if args = converted.reorderArgs([]interface{}{1, 2}); !reflect.DeepEqual(args, []interface{}{1, 2}) {
+		t.Fatalf("args weren't returned as-is with missing arguments: %v", args)
+	}
+}
+
+func TestMySQLQueryCache(t *testing.T) {
+	cache := &mysqlQueryCache{queries: xsync.NewMapOf[string, *mysqlQuery]()}
+	query, args := cache.rewrite(getSessionQuery, []interface{}{"a", "b"})
+	if query != "SELECT session FROM whatsmeow_sessions WHERE our_jid=? AND their_id=?" || !reflect.DeepEqual(args, []interface{}{"a", "b"}) {
+		t.Fatalf("unexpected rewrite %q %v", query, args)
+	}
+	cached, ok := cache.queries.Load(getSessionQuery)
+	if !ok || cached.query != query {
+		t.Fatal("converted query wasn't cached")
+	}
+	cached.query = "cached"
+	if query, _ = cache.rewrite(getSessionQuery, nil); query != "cached" {
+		t.Fatal("query was converted again")
+	}
+}
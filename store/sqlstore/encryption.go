@@ -0,0 +1,286 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package sqlstore
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/go-whatsapp/go-util/random"
+
+	"github.com/go-whatsapp/whatsmeow/util/gcmutil"
+)
+
+// EncryptionKeyProvider supplies the keys used to encrypt sensitive columns in the database.
+//
+// Keys must be 16, 24 or 32 bytes long (for AES-128, AES-192 or AES-256 respectively). Key IDs are stored
+// next to the encrypted data, so they must not be secret, and they must be at most 255 bytes long.
+type EncryptionKeyProvider interface {
+	// CurrentKey returns the key that new data should be encrypted with.
+	CurrentKey() (id string, key []byte, err error)
+	// GetKey returns the key with the given ID for decrypting existing data.
+	GetKey(id string) ([]byte, error)
+}
+
+var (
+	ErrEncryptionNotConfigured = errors.New("database contains encrypted data, but no encryption key provider is set")
+	ErrInvalidEncryptedData    = errors.New("invalid encrypted data in database")
+)
+
+// encryptedMagic is the prefix of encrypted values. Plaintext values never start with it: the private keys
+// are exactly 32 bytes (shorter than any encrypted value), and serialized protobufs can't start with a null byte.
+var encryptedMagic = []byte{0, 'W', 'M', 1}
+
+const encryptionNonceSize = 12
+
+// Identifiers of encrypted columns. They're used as the additional data when encrypting together with
+// the primary key of the row (see encryptionAAD), so that encrypted values can't be moved to a different
+// column or row.
+const (
+	columnNoiseKey        = "whatsmeow_device.noise_key"
+	columnIdentityKey     = "whatsmeow_device.identity_key"
+	columnSignedPreKey    = "whatsmeow_device.signed_pre_key"
+	columnAdvKey          = "whatsmeow_device.adv_key"
+	columnPreKey          = "whatsmeow_pre_keys.key"
+	columnSession         = "whatsmeow_sessions.session"
+	columnSenderKey       = "whatsmeow_sender_keys.sender_key"
+	columnAppStateSyncKey = "whatsmeow_app_state_sync_keys.key_data"
+)
+
+// keyColumn is a primary key column of a table with encrypted columns.
+// The column values are scanned into the pointer returned by newTarget and passed back as-is when updating rows.
+type keyColumn struct {
+	name      string
+	newTarget func() interface{}
+}
+
+func textKey(name string) keyColumn {
+	return keyColumn{name, func() interface{} { return new(string) }}
+}
+
+func intKey(name string) keyColumn {
+	return keyColumn{name, func() interface{} { return new(int64) }}
+}
+
+func bytesKey(name string) keyColumn {
+	return keyColumn{name, func() interface{} { return new([]byte) }}
+}
+
+// rowID converts a scanned primary key column value into the form used in encryptionAAD.
+func (kc keyColumn) rowID(target interface{}) string {
+	switch value := target.(type) {
+	case *string:
+		return *value
+	case *int64:
+		return strconv.FormatInt(*value, 10)
+	case *[]byte:
+		return string(*value)
+	default:
+		panic(fmt.Errorf("unsupported key column target %T", target))
+	}
+}
+
+type encryptedColumn struct {
+	column     string
+	primaryKey []keyColumn
+}
+
+var encryptedColumns = []encryptedColumn{
+	{columnNoiseKey, []keyColumn{textKey("jid")}},
+	{columnIdentityKey, []keyColumn{textKey("jid")}},
+	{columnSignedPreKey, []keyColumn{textKey("jid")}},
+	{columnAdvKey, []keyColumn{textKey("jid")}},
+	{columnPreKey, []keyColumn{textKey("jid"), intKey("key_id")}},
+	{columnSession, []keyColumn{textKey("our_jid"), textKey("their_id")}},
+	{columnSenderKey, []keyColumn{textKey("our_jid"), textKey("chat_id"), textKey("sender_id")}},
+	{columnAppStateSyncKey, []keyColumn{textKey("jid"), bytesKey("key_id")}},
+}
+
+func isEncrypted(data []byte) bool {
+	return len(data) > 32 && bytes.HasPrefix(data, encryptedMagic)
+}
+
+// parseEncrypted splits an encrypted value into the key ID, nonce and ciphertext.
+func parseEncrypted(data []byte) (keyID string, nonce, ciphertext []byte, err error) {
+	data = data[len(encryptedMagic):]
+	if len(data) < 1 || len(data) < 1+int(data[0])+encryptionNonceSize {
+		err = ErrInvalidEncryptedData
+		return
+	}
+	keyIDLength := int(data[0])
+	keyID = string(data[1 : 1+keyIDLength])
+	data = data[1+keyIDLength:]
+	return keyID, data[:encryptionNonceSize], data[encryptionNonceSize:], nil
+}
+
+// encryptionAAD returns the additional data for encrypting a value in the given column and row.
+// The row ID is the primary key of the row in the order listed in encryptedColumns, with integers
+// in decimal form. Each part is length-prefixed, so the boundaries between them can't be shifted.
+func encryptionAAD(column string, rowID []string) []byte {
+	aad := append([]byte(column), 0)
+	for _, part := range rowID {
+		aad = binary.AppendUvarint(aad, uint64(len(part)))
+		aad = append(aad, part...)
+	}
+	return aad
+}
+
+// encrypt encrypts a value for the given column and row with the current key of the encryption key provider.
+// If encryption is not enabled, the value is returned as-is.
+func (c *Container) encrypt(column string, plaintext []byte, rowID ...string) ([]byte, error) {
+	if c.Encryption == nil || plaintext == nil {
+		return plaintext, nil
+	}
+	keyID, key, err := c.Encryption.CurrentKey()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get current encryption key: %w", err)
+	} else if len(keyID) > 255 {
+		return nil, fmt.Errorf("encryption key ID is too long")
+	}
+	nonce := random.Bytes(encryptionNonceSize)
+	ciphertext, err := gcmutil.Encrypt(key, nonce, plaintext, encryptionAAD(column, rowID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to encrypt %s: %w", column, err)
+	}
+	output := make([]byte, 0, len(encryptedMagic)+1+len(keyID)+len(nonce)+len(ciphertext))
+	output = append(output, encryptedMagic...)
+	output = append(output, byte(len(keyID)))
+	output = append(output, keyID...)
+	output = append(output, nonce...)
+	output = append(output, ciphertext...)
+	return output, nil
+}
+
+// decrypt decrypts a value from the given column and row. Plaintext values are returned as-is,
+// so that enabling encryption doesn't break existing databases.
+func (c *Container) decrypt(column string, data []byte, rowID ...string) ([]byte, error) {
+	if !isEncrypted(data) {
+		return data, nil
+	} else if c.Encryption == nil {
+		return nil, ErrEncryptionNotConfigured
+	}
+	keyID, nonce, ciphertext, err := parseEncrypted(data)
+	if err != nil {
+		return nil, err
+	}
+	key, err := c.Encryption.GetKey(keyID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get encryption key %q: %w", keyID, err)
+	}
+	plaintext, err := gcmutil.Decrypt(key, nonce, ciphertext, encryptionAAD(column, rowID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt %s: %w", column, err)
+	} else if plaintext == nil {
+		plaintext = []byte{}
+	}
+	return plaintext, nil
+}
+
+// needsReencryption returns true if the given value isn't encrypted with the current key.
+func needsReencryption(data []byte, currentKeyID string) bool {
+	if data == nil {
+		return false
+	} else if !isEncrypted(data) {
+		return true
+	}
+	keyID, _, _, err := parseEncrypted(data)
+	return err != nil || keyID != currentKeyID
+}
+
+// Reencrypt encrypts all sensitive values in the database with the current key of the encryption key provider.
+//
+// This should be called after enabling encryption on an existing database to encrypt old plaintext values,
+// and after rotating keys, so that the old keys can be removed from the provider once this returns.
+// Values are decrypted using whichever key they were encrypted with, so the old keys must still be available.
+//
+// Each column is re-encrypted in a separate transaction. The returned count is the number of values that were changed.
+func (c *Container) Reencrypt() (count int, err error) {
+	if c.Encryption == nil {
+		return 0, fmt.Errorf("encryption key provider is not set")
+	}
+	currentKeyID, _, err := c.Encryption.CurrentKey()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get current encryption key: %w", err)
+	}
+	for _, col := range encryptedColumns {
+		var columnCount int
+		columnCount, err = c.reencryptColumn(col, currentKeyID)
+		count += columnCount
+		if err != nil {
+			return count, fmt.Errorf("failed to re-encrypt %s: %w", col.column, err)
+		}
+	}
+	return count, nil
+}
+
+type reencryptRow struct {
+	primaryKey []interface{}
+	value      []byte
+}
+
+func (c *Container) reencryptColumn(col encryptedColumn, currentKeyID string) (int, error) {
+	table, column, _ := strings.Cut(col.column, ".")
+	keyNames := make([]string, len(col.primaryKey))
+	conditions := make([]string, len(col.primaryKey))
+	for i, key := range col.primaryKey {
+		keyNames[i] = key.name
+		conditions[i] = fmt.Sprintf("%s=$%d", key.name, i+2)
+	}
+	selectQuery := fmt.Sprintf("SELECT %s, %s FROM %s", strings.Join(keyNames, ", "), column, table)
+	updateQuery := fmt.Sprintf("UPDATE %s SET %s=$1 WHERE %s", table, column, strings.Join(conditions, " AND "))
+
+	tx, err := c.db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+	res, err := tx.Query(selectQuery)
+	if err != nil {
+		return 0, fmt.Errorf("failed to query rows: %w", err)
+	}
+	// Collect all rows before updating, as some drivers don't support running queries while iterating over a result
+	var rows []reencryptRow
+	for res.Next() {
+		row := reencryptRow{primaryKey: make([]interface{}, len(col.primaryKey))}
+		for i, key := range col.primaryKey {
+			row.primaryKey[i] = key.newTarget()
+		}
+		if err = res.Scan(append(row.primaryKey, &row.value)...); err != nil {
+			_ = res.Close()
+			return 0, fmt.Errorf("failed to scan row: %w", err)
+		} else if needsReencryption(row.value, currentKeyID) {
+			rows = append(rows, row)
+		}
+	}
+	if err = res.Err(); err != nil {
+		return 0, fmt.Errorf("failed to iterate rows: %w", err)
+	}
+	for _, row := range rows {
+		rowID := make([]string, len(col.primaryKey))
+		for i, key := range col.primaryKey {
+			rowID[i] = key.rowID(row.primaryKey[i])
+		}
+		var plaintext, encrypted []byte
+		if plaintext, err = c.decrypt(col.column, row.value, rowID...); err != nil {
+			return 0, err
+		} else if encrypted, err = c.encrypt(col.column, plaintext, rowID...); err != nil {
+			return 0, err
+		} else if _, err = tx.Exec(updateQuery, append([]interface{}{encrypted}, row.primaryKey...)...); err != nil {
+			return 0, fmt.Errorf("failed to update row: %w", err)
+		}
+	}
+	if err = tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return len(rows), nil
+}
@@ -0,0 +1,252 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package sqlstore
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/go-whatsapp/go-util/random"
+
+	waProto "github.com/go-whatsapp/whatsmeow/binary/proto"
+	"github.com/go-whatsapp/whatsmeow/store"
+	"github.com/go-whatsapp/whatsmeow/types"
+	"github.com/go-whatsapp/whatsmeow/util/keys"
+)
+
+type testKeyProvider struct {
+	current string
+	keys    map[string][]byte
+}
+
+func (tkp *testKeyProvider) CurrentKey() (string, []byte, error) {
+	return tkp.current, tkp.keys[tkp.current], nil
+}
+
+func (tkp *testKeyProvider) GetKey(id string) ([]byte, error) {
+	key, ok := tkp.keys[id]
+	if !ok {
+		return nil, fmt.Errorf("unknown key")
+	}
+	return key, nil
+}
+
+func TestEncryption(t *testing.T) {
+	c := NewWithDB(nil, "sqlite3", nil)
+	plaintext := random.Bytes(32)
+	if data, _ := c.encrypt(columnNoiseKey, plaintext, "1@s.whatsapp.net"); !bytes.Equal(data, plaintext) {
+		t.Fatal("data was encrypted without a key provider")
+	}
+
+	provider := &testKeyProvider{current: "old", keys: map[string][]byte{"old": random.Bytes(32)}}
+	c.Encryption = provider
+	if data, _ := c.decrypt(columnNoiseKey, plaintext, "1@s.whatsapp.net"); !bytes.Equal(data, plaintext) {
+		t.Fatal("unencrypted data wasn't returned as-is")
+	}
+	encrypted, err := c.encrypt(columnNoiseKey, plaintext, "1@s.whatsapp.net")
+	if err != nil {
+		t.Fatal(err)
+	} else if !isEncrypted(encrypted) || bytes.Contains(encrypted, plaintext) {
+		t.Fatal("data wasn't encrypted")
+	} else if decrypted, _ := c.decrypt(columnNoiseKey, encrypted, "1@s.whatsapp.net"); !bytes.Equal(decrypted, plaintext) {
+		t.Fatal("decrypted data doesn't match")
+	} else if _, err = c.decrypt(columnIdentityKey, encrypted, "1@s.whatsapp.net"); err == nil {
+		t.Fatal("data encrypted for one column was decrypted as another column")
+	} else if _, err = c.decrypt(columnNoiseKey, encrypted, "2@s.whatsapp.net"); err == nil {
+		t.Fatal("data encrypted for one row was decrypted as another row")
+	}
+	senderKey, err := c.encrypt(columnSenderKey, plaintext, "1@s.whatsapp.net", "a", "bc")
+	if err != nil {
+		t.Fatal(err)
+	} else if _, err = c.decrypt(columnSenderKey, senderKey, "1@s.whatsapp.net", "ab", "c"); err == nil {
+		t.Fatal("data was decrypted with shifted row ID parts")
+	}
+
+	provider.keys["new"] = random.Bytes(32)
+	provider.current = "new"
+	if !needsReencryption(encrypted, "new") || !needsReencryption(plaintext, "new") {
+		t.Fatal("data encrypted with old key isn't marked for re-encryption")
+	} else if decrypted, _ := c.decrypt(columnNoiseKey, encrypted, "1@s.whatsapp.net"); !bytes.Equal(decrypted, plaintext) {
+		t.Fatal("data encrypted with old key couldn't be decrypted after rotation")
+	} else if reencrypted, _ := c.encrypt(columnNoiseKey, plaintext, "1@s.whatsapp.net"); needsReencryption(reencrypted, "new") {
+		t.Fatal("data encrypted with new key is marked for re-encryption")
+	}
+
+	c.Encryption = nil
+	if _, err = c.decrypt(columnNoiseKey, encrypted, "1@s.whatsapp.net"); !errors.Is(err, ErrEncryptionNotConfigured) {
+		t.Fatalf("expected ErrEncryptionNotConfigured, got %v", err)
+	}
+}
+
+type testDeviceData struct {
+	device    *store.Device
+	preKey    *keys.PreKey
+	session   []byte
+	senderKey []byte
+	syncKeyID []byte
+	syncKey   store.AppStateSyncKey
+}
+
+func putTestDevice(t *testing.T, c *Container, user string) *testDeviceData {
+	t.Helper()
+	data := &testDeviceData{
+		device:    c.NewDevice(),
+		session:   random.Bytes(200),
+		senderKey: random.Bytes(150),
+		syncKeyID: random.Bytes(6),
+		syncKey:   store.AppStateSyncKey{Data: random.Bytes(32), Fingerprint: random.Bytes(16), Timestamp: 1234},
+	}
+	jid := types.NewADJID(user, 0, 1)
+	data.device.JID = &jid
+	data.device.Account = &waProto.ADVSignedDeviceIdentity{
+		Details:             random.Bytes(40),
+		AccountSignature:    random.Bytes(64),
+		AccountSignatureKey: random.Bytes(32),
+		DeviceSignature:     random.Bytes(64),
+	}
+	var err error
+	if err = data.device.Save(); err != nil {
+		t.Fatal(err)
+	} else if data.preKey, err = data.device.PreKeys.GenOnePreKey(); err != nil {
+		t.Fatal(err)
+	} else if err = data.device.Sessions.PutSession("2.0:1", data.session); err != nil {
+		t.Fatal(err)
+	} else if err = data.device.SenderKeys.PutSenderKey("group", "2.0:1", data.senderKey); err != nil {
+		t.Fatal(err)
+	} else if err = data.device.AppStateKeys.PutAppStateSyncKey(data.syncKeyID, data.syncKey); err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func (data *testDeviceData) check(t *testing.T, c *Container) {
+	t.Helper()
+	device, err := c.GetDevice(*data.device.JID)
+	if err != nil {
+		t.Fatal(err)
+	} else if device.JID == nil {
+		t.Fatal("device not found")
+	} else if *device.NoiseKey.Priv != *data.device.NoiseKey.Priv ||
+		*device.IdentityKey.Priv != *data.device.IdentityKey.Priv ||
+		*device.SignedPreKey.Priv != *data.device.SignedPreKey.Priv ||
+		!bytes.Equal(device.AdvSecretKey, data.device.AdvSecretKey) ||
+		!bytes.Equal(device.Account.Details, data.device.Account.Details) {
+		t.Fatal("device keys don't match")
+	}
+	if preKey, err := device.PreKeys.GetPreKey(data.preKey.KeyID); err != nil {
+		t.Fatal(err)
+	} else if preKey == nil || *preKey.Priv != *data.preKey.Priv {
+		t.Fatal("prekey doesn't match")
+	}
+	if session, err := device.Sessions.GetSession("2.0:1"); err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(session, data.session) {
+		t.Fatal("session doesn't match")
+	}
+	if senderKey, err := device.SenderKeys.GetSenderKey("group", "2.0:1"); err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(senderKey, data.senderKey) {
+		t.Fatal("sender key doesn't match")
+	}
+	if syncKey, err := device.AppStateKeys.GetAppStateSyncKey(data.syncKeyID); err != nil {
+		t.Fatal(err)
+	} else if syncKey == nil || !bytes.Equal(syncKey.Data, data.syncKey.Data) || !bytes.Equal(syncKey.Fingerprint, data.syncKey.Fingerprint) {
+		t.Fatal("app state sync key doesn't match")
+	}
+}
+
+// storedEncryptionKeyIDs returns the encryption key ID of every stored value in the encrypted columns,
+// or an empty string for plaintext values.
+func storedEncryptionKeyIDs(t *testing.T, c *Container) (keyIDs []string) {
+	t.Helper()
+	for _, col := range encryptedColumns {
+		table, column, _ := strings.Cut(col.column, ".")
+		rows, err := c.db.Query(fmt.Sprintf("SELECT %s FROM %s", column, table))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for rows.Next() {
+			var value []byte
+			if err = rows.Scan(&value); err != nil {
+				t.Fatal(err)
+			} else if !isEncrypted(value) {
+				keyIDs = append(keyIDs, "")
+			} else if keyID, _, _, err := parseEncrypted(value); err != nil {
+				t.Fatal(err)
+			} else {
+				keyIDs = append(keyIDs, keyID)
+			}
+		}
+		if err = rows.Err(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return
+}
+
+func assertStoredKeyIDs(t *testing.T, c *Container, expected string, count int) {
+	t.Helper()
+	keyIDs := storedEncryptionKeyIDs(t, c)
+	if len(keyIDs) != count {
+		t.Fatalf("expected %d stored values, found %d", count, len(keyIDs))
+	}
+	for _, keyID := range keyIDs {
+		if keyID != expected {
+			t.Fatalf("expected all values to be encrypted with %q, found %q", expected, keyID)
+		}
+	}
+}
+
+func TestEncryptedSQLStore(t *testing.T) {
+	c := newTestSQLite(t)
+	if err := c.Upgrade(); err != nil {
+		t.Fatal(err)
+	}
+	// 4 device keys, one prekey, session, sender key and app state sync key per device
+	const valuesPerDevice = 8
+
+	plain := putTestDevice(t, c, "1")
+	assertStoredKeyIDs(t, c, "", valuesPerDevice)
+
+	provider := &testKeyProvider{current: "old", keys: map[string][]byte{"old": random.Bytes(32)}}
+	c.Encryption = provider
+	plain.check(t, c)
+	if count, err := c.Reencrypt(); err != nil {
+		t.Fatal(err)
+	} else if count != valuesPerDevice {
+		t.Fatalf("expected %d values to be encrypted, got %d", valuesPerDevice, count)
+	}
+	assertStoredKeyIDs(t, c, "old", valuesPerDevice)
+	plain.check(t, c)
+
+	encrypted := putTestDevice(t, c, "2")
+	assertStoredKeyIDs(t, c, "old", 2*valuesPerDevice)
+	encrypted.check(t, c)
+
+	provider.keys["new"] = random.Bytes(32)
+	provider.current = "new"
+	if count, err := c.Reencrypt(); err != nil {
+		t.Fatal(err)
+	} else if count != 2*valuesPerDevice {
+		t.Fatalf("expected %d values to be re-encrypted, got %d", 2*valuesPerDevice, count)
+	}
+	assertStoredKeyIDs(t, c, "new", 2*valuesPerDevice)
+	delete(provider.keys, "old")
+	plain.check(t, c)
+	encrypted.check(t, c)
+	if count, err := c.Reencrypt(); err != nil || count != 0 {
+		t.Fatalf("expected nothing to be re-encrypted, got %d (%v)", count, err)
+	}
+
+	c.Encryption = nil
+	if _, err := c.GetDevice(*plain.device.JID); !errors.Is(err, ErrEncryptionNotConfigured) {
+		t.Fatalf("expected ErrEncryptionNotConfigured, got %v", err)
+	}
+}
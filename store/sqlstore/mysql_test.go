@@ -0,0 +1,127 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package sqlstore
+
+import (
+	"bytes"
+	"os"
+	"testing"
+	"time"
+
+	_ "github.com/go-sql-driver/mysql"
+	"github.com/go-whatsapp/go-util/random"
+
+	"github.com/go-whatsapp/whatsmeow/store"
+	"github.com/go-whatsapp/whatsmeow/types"
+)
+
+// TestMySQL runs the store against a real MySQL or MariaDB database. It's skipped unless WHATSMEOW_TEST_MYSQL_DSN
+// is set to the DSN of an empty database, e.g. `user:password@tcp(localhost:3306)/whatsmeow_test`.
+// WHATSMEOW_TEST_MYSQL_DIALECT can be set to mariadb to test MariaDB.
+func TestMySQL(t *testing.T) {
+	dsn := os.Getenv("WHATSMEOW_TEST_MYSQL_DSN")
+	if dsn == "" {
+		t.Skip("WHATSMEOW_TEST_MYSQL_DSN not set")
+	}
+	dialect := os.Getenv("WHATSMEOW_TEST_MYSQL_DIALECT")
+	if dialect == "" {
+		dialect = "mysql"
+	}
+	c, err := New(dialect, dsn, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = c.Close()
+	})
+	if version, err := c.getVersion(); err != nil || version != len(Upgrades) {
+		t.Fatalf("unexpected version %d after upgrade (%v)", version, err)
+	}
+
+	data := putTestDevice(t, c, "1")
+	t.Cleanup(func() {
+		_ = data.device.Delete()
+	})
+	data.check(t, c)
+	device := data.device
+	peer := types.NewJID("2", types.DefaultUserServer)
+
+	// Upserts must update existing rows
+	data.session = random.Bytes(100)
+	if err = device.Sessions.PutSession("2.0:1", data.session); err != nil {
+		t.Fatal(err)
+	} else if err = device.Identities.PutIdentity("2.0:1", [32]byte{1}); err != nil {
+		t.Fatal(err)
+	} else if err = device.Identities.PutIdentity("2.0:1", [32]byte{2}); err != nil {
+		t.Fatal(err)
+	} else if trusted, err := device.Identities.IsTrustedIdentity("2.0:1", [32]byte{2}); err != nil || !trusted {
+		t.Fatalf("identity wasn't updated (%v)", err)
+	}
+	data.check(t, c)
+
+	// App state sync keys have a MySQL-specific upsert, which must only replace keys with newer timestamps
+	oldKey := store.AppStateSyncKey{Data: random.Bytes(32), Fingerprint: random.Bytes(16), Timestamp: data.syncKey.Timestamp - 1}
+	if err = device.AppStateKeys.PutAppStateSyncKey(data.syncKeyID, oldKey); err != nil {
+		t.Fatal(err)
+	}
+	data.check(t, c)
+	data.syncKey = store.AppStateSyncKey{Data: random.Bytes(32), Fingerprint: random.Bytes(16), Timestamp: data.syncKey.Timestamp + 1}
+	if err = device.AppStateKeys.PutAppStateSyncKey(data.syncKeyID, data.syncKey); err != nil {
+		t.Fatal(err)
+	}
+	data.check(t, c)
+
+	if _, _, err = device.Contacts.PutPushName(peer, "Peer"); err != nil {
+		t.Fatal(err)
+	} else if err = device.Contacts.PutContactName(peer, "Peer", "Peer Person"); err != nil {
+		t.Fatal(err)
+	} else if contact, err := device.Contacts.GetContact(peer); err != nil {
+		t.Fatal(err)
+	} else if contact.PushName != "Peer" || contact.FullName != "Peer Person" {
+		t.Fatalf("unexpected contact info %+v", contact)
+	}
+
+	mutedUntil := time.Unix(1700000000, 0)
+	if err = device.ChatSettings.PutMutedUntil(peer, mutedUntil); err != nil {
+		t.Fatal(err)
+	} else if err = device.ChatSettings.PutPinned(peer, true); err != nil {
+		t.Fatal(err)
+	} else if settings, err := device.ChatSettings.GetChatSettings(peer); err != nil {
+		t.Fatal(err)
+	} else if !settings.MutedUntil.Equal(mutedUntil) || !settings.Pinned {
+		t.Fatalf("unexpected chat settings %+v", settings)
+	}
+
+	// Message secrets are never replaced
+	if err = device.MsgSecrets.PutMessageSecret(peer, peer, "ABCD", []byte("secret")); err != nil {
+		t.Fatal(err)
+	} else if err = device.MsgSecrets.PutMessageSecret(peer, peer, "ABCD", []byte("other")); err != nil {
+		t.Fatal(err)
+	} else if secret, err := device.MsgSecrets.GetMessageSecret(peer, peer, "ABCD"); err != nil || !bytes.Equal(secret, []byte("secret")) {
+		t.Fatalf("unexpected message secret %q (%v)", secret, err)
+	}
+
+	token := store.PrivacyToken{User: peer, Token: []byte("token"), Timestamp: time.Unix(1600000000, 0)}
+	if err = device.PrivacyTokens.PutPrivacyTokens(token); err != nil {
+		t.Fatal(err)
+	} else if stored, err := device.PrivacyTokens.GetPrivacyToken(peer); err != nil || stored == nil || !bytes.Equal(stored.Token, token.Token) {
+		t.Fatalf("privacy token wasn't stored (%v)", err)
+	}
+
+	exported, err := NewSQLStore(c, *device.JID).ExportDeviceData()
+	if err != nil {
+		t.Fatal(err)
+	} else if err = NewSQLStore(c, *device.JID).ImportDeviceData(exported); err != nil {
+		t.Fatal(err)
+	}
+
+	c.Encryption = &testKeyProvider{current: "key", keys: map[string][]byte{"key": random.Bytes(32)}}
+	if _, err = c.Reencrypt(); err != nil {
+		t.Fatal(err)
+	}
+	data.check(t, c)
+}
@@ -12,6 +12,7 @@ import (
 	"database/sql/driver"
 	"errors"
 	"fmt"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -24,7 +25,8 @@ import (
 )
 
 // ErrInvalidLength is returned by some database getters if the database returned a byte array with an unexpected length.
-// This should be impossible, as the database schema contains CHECK()s for all the relevant columns.
+// This should be impossible, as the database schema contains CHECK()s for all the relevant unencrypted columns,
+// and encrypted columns are authenticated.
 var ErrInvalidLength = errors.New("database returned byte array with illegal length")
 
 // PostgresArrayWrapper is a function to wrap array values before passing them to the sql package.
@@ -120,6 +122,8 @@ func (s *SQLStore) GetSession(address string) (session []byte, err error) {
 	err = s.db.QueryRow(getSessionQuery, s.JID, address).Scan(&session)
 	if errors.Is(err, sql.ErrNoRows) {
 		err = nil
+	} else if err == nil {
+		session, err = s.decrypt(columnSession, session, s.JID, address)
 	}
 	return
 }
@@ -133,7 +137,11 @@ func (s *SQLStore) HasSession(address string) (has bool, err error) {
 }
 
 func (s *SQLStore) PutSession(address string, session []byte) error {
-	_, err := s.db.Exec(putSessionQuery, s.JID, address, session)
+	session, err := s.encrypt(columnSession, session, s.JID, address)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.Exec(putSessionQuery, s.JID, address, session)
 	return err
 }
 
@@ -159,7 +167,11 @@ const (
 
 func (s *SQLStore) genOnePreKey(id uint32, markUploaded bool) (*keys.PreKey, error) {
 	key := keys.NewPreKey(id)
-	_, err := s.db.Exec(insertPreKeyQuery, s.JID, key.KeyID, key.Priv[:], markUploaded)
+	priv, err := s.encrypt(columnPreKey, key.Priv[:], s.JID, strconv.FormatUint(uint64(id), 10))
+	if err != nil {
+		return nil, err
+	}
+	_, err = s.db.Exec(insertPreKeyQuery, s.JID, key.KeyID, priv, markUploaded)
 	return key, err
 }
 
@@ -194,7 +206,7 @@ func (s *SQLStore) GetOrGenPreKeys(count uint32) ([]*keys.PreKey, error) {
 	var existingCount uint32
 	for res.Next() {
 		var key *keys.PreKey
-		key, err = scanPreKey(res)
+		key, err = s.scanPreKey(res)
 		if err != nil {
 			return nil, err
 		} else if key != nil {
@@ -221,7 +233,7 @@ func (s *SQLStore) GetOrGenPreKeys(count uint32) ([]*keys.PreKey, error) {
 	return newKeys, nil
 }
 
-func scanPreKey(row scannable) (*keys.PreKey, error) {
+func (s *SQLStore) scanPreKey(row scannable) (*keys.PreKey, error) {
 	var priv []byte
 	var id uint32
 	err := row.Scan(&id, &priv)
@@ -229,6 +241,8 @@ func scanPreKey(row scannable) (*keys.PreKey, error) {
 		return nil, nil
 	} else if err != nil {
 		return nil, err
+	} else if priv, err = s.decrypt(columnPreKey, priv, s.JID, strconv.FormatUint(uint64(id), 10)); err != nil {
+		return nil, err
 	} else if len(priv) != 32 {
 		return nil, ErrInvalidLength
 	}
@@ -239,7 +253,7 @@ func scanPreKey(row scannable) (*keys.PreKey, error) {
 }
 
 func (s *SQLStore) GetPreKey(id uint32) (*keys.PreKey, error) {
-	return scanPreKey(s.db.QueryRow(getPreKeyQuery, s.JID, id))
+	return s.scanPreKey(s.db.QueryRow(getPreKeyQuery, s.JID, id))
 }
 
 func (s *SQLStore) RemovePreKey(id uint32) error {
@@ -266,7 +280,11 @@ const (
 )
 
 func (s *SQLStore) PutSenderKey(group, user string, session []byte) error {
-	_, err := s.db.Exec(putSenderKeyQuery, s.JID, group, user, session)
+	session, err := s.encrypt(columnSenderKey, session, s.JID, group, user)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.Exec(putSenderKeyQuery, s.JID, group, user, session)
 	return err
 }
 
@@ -274,6 +292,8 @@ func (s *SQLStore) GetSenderKey(group, user string) (key []byte, err error) {
 	err = s.db.QueryRow(getSenderKeyQuery, s.JID, group, user).Scan(&key)
 	if errors.Is(err, sql.ErrNoRows) {
 		err = nil
+	} else if err == nil {
+		key, err = s.decrypt(columnSenderKey, key, s.JID, group, user)
 	}
 	return
 }
@@ -285,12 +305,30 @@ const (
 			SET key_data=excluded.key_data, timestamp=excluded.timestamp, fingerprint=excluded.fingerprint
 			WHERE excluded.timestamp > whatsmeow_app_state_sync_keys.timestamp
 	`
+	// MySQL doesn't support conditional upserts, so the condition is in each assignment instead.
+	// The timestamp must be updated last, as MySQL applies the assignments in order.
+	// The excluded references are converted like in other upserts, see convertMySQL.
+	putAppStateSyncKeyQueryMySQL = `
+		INSERT INTO whatsmeow_app_state_sync_keys (jid, key_id, key_data, timestamp, fingerprint) VALUES ($1, $2, $3, $4, $5)
+		ON DUPLICATE KEY UPDATE
+			key_data=IF(excluded.timestamp > timestamp, excluded.key_data, key_data),
+			fingerprint=IF(excluded.timestamp > timestamp, excluded.fingerprint, fingerprint),
+			timestamp=GREATEST(excluded.timestamp, timestamp)
+	`
 	getAppStateSyncKeyQuery         = `SELECT key_data, timestamp, fingerprint FROM whatsmeow_app_state_sync_keys WHERE jid=$1 AND key_id=$2`
 	getLatestAppStateSyncKeyIDQuery = `SELECT key_id FROM whatsmeow_app_state_sync_keys WHERE jid=$1 ORDER BY timestamp DESC LIMIT 1`
 )
 
 func (s *SQLStore) PutAppStateSyncKey(id []byte, key store.AppStateSyncKey) error {
-	_, err := s.db.Exec(putAppStateSyncKeyQuery, s.JID, id, key.Data, key.Timestamp, key.Fingerprint)
+	keyData, err := s.encrypt(columnAppStateSyncKey, key.Data, s.JID, string(id))
+	if err != nil {
+		return err
+	}
+	query := putAppStateSyncKeyQuery
+	if isMySQL(s.dialect) {
+		query = putAppStateSyncKeyQueryMySQL
+	}
+	_, err = s.db.Exec(query, s.JID, id, keyData, key.Timestamp, key.Fingerprint)
 	return err
 }
 
@@ -299,8 +337,12 @@ func (s *SQLStore) GetAppStateSyncKey(id []byte) (*store.AppStateSyncKey, error)
 	err := s.db.QueryRow(getAppStateSyncKeyQuery, s.JID, id).Scan(&key.Data, &key.Timestamp, &key.Fingerprint)
 	if errors.Is(err, sql.ErrNoRows) {
 		return nil, nil
+	} else if err != nil {
+		return nil, err
+	} else if key.Data, err = s.decrypt(columnAppStateSyncKey, key.Data, s.JID, string(id)); err != nil {
+		return nil, err
 	}
-	return &key, err
+	return &key, nil
 }
 
 func (s *SQLStore) GetLatestAppStateSyncKeyID() ([]byte, error) {
@@ -7,7 +7,9 @@
 package sqlstore
 
 import (
+	"context"
 	"database/sql"
+	"database/sql/driver"
 	"fmt"
 )
 
@@ -17,7 +19,7 @@ type upgradeFunc func(*sql.Tx, *Container) error
 //
 // This may be of use if you want to manage the database fully manually, but in most cases you
 // should just call Container.Upgrade to let the library handle everything.
-var Upgrades = [...]upgradeFunc{upgradeV1, upgradeV2, upgradeV3, upgradeV4, upgradeV5}
+var Upgrades = [...]upgradeFunc{upgradeV1, upgradeV2, upgradeV3, upgradeV4, upgradeV5, upgradeV6}
 
 func (c *Container) getVersion() (int, error) {
 	_, err := c.db.Exec("CREATE TABLE IF NOT EXISTS whatsmeow_version (version INTEGER)")
@@ -33,7 +35,7 @@ func (c *Container) getVersion() (int, error) {
 	return version, nil
 }
 
-func (c *Container) setVersion(tx *sql.Tx, version int) error {
+func (c *Container) setVersion(tx *dbTx, version int) error {
 	_, err := tx.Exec("DELETE FROM whatsmeow_version")
 	if err != nil {
 		return err
@@ -60,33 +62,89 @@ func (c *Container) Upgrade() error {
 	}
 
 	for ; version < len(Upgrades); version++ {
-		var tx *sql.Tx
-		tx, err = c.db.Begin()
-		if err != nil {
+		if err = c.runUpgrade(version); err != nil {
 			return err
 		}
+	}
 
-		migrateFunc := Upgrades[version]
-		c.log.Infof("Upgrading database to v%d", version+1)
-		err = migrateFunc(tx, c)
-		if err != nil {
-			_ = tx.Rollback()
-			return err
-		}
+	return nil
+}
 
-		if err = c.setVersion(tx, version+1); err != nil {
-			return err
-		}
+func (c *Container) runUpgrade(version int) error {
+	tx, done, err := c.beginUpgrade(version + 1)
+	if err != nil {
+		return err
+	}
+	defer done()
 
-		if err = tx.Commit(); err != nil {
-			return err
-		}
+	migrateFunc := Upgrades[version]
+	c.log.Infof("Upgrading database to v%d", version+1)
+	err = migrateFunc(tx.Tx, c)
+	if err != nil {
+		_ = tx.Rollback()
+		return err
 	}
 
-	return nil
+	if err = c.setVersion(tx, version+1); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// sqliteTableRebuildVersions contains the versions whose SQLite upgrades rebuild tables that other tables reference.
+// Those must run with foreign keys disabled, as dropping the old table would otherwise delete all the rows
+// referencing it, see https://www.sqlite.org/lang_altertable.html#otheralter
+var sqliteTableRebuildVersions = map[int]bool{6: true}
+
+// beginUpgrade starts the transaction for upgrading to the given version. The returned function must be called
+// after the transaction is committed or rolled back.
+//
+// Foreign keys can't be disabled inside a transaction, so for SQLite table rebuilds, a single connection is reserved
+// for disabling foreign keys, running the transaction and restoring the previous setting.
+func (c *Container) beginUpgrade(version int) (*dbTx, func(), error) {
+	if c.dialect == "postgres" || c.dialect == "pgx" || isMySQL(c.dialect) || !sqliteTableRebuildVersions[version] {
+		tx, err := c.db.Begin()
+		return tx, func() {}, err
+	}
+	ctx := context.Background()
+	conn, err := c.db.DB.Conn(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	var foreignKeysEnabled bool
+	if err = conn.QueryRowContext(ctx, "PRAGMA foreign_keys").Scan(&foreignKeysEnabled); err != nil {
+		_ = conn.Close()
+		return nil, nil, fmt.Errorf("failed to check if foreign keys are enabled: %w", err)
+	} else if _, err = conn.ExecContext(ctx, "PRAGMA foreign_keys=OFF"); err != nil {
+		_ = conn.Close()
+		return nil, nil, fmt.Errorf("failed to disable foreign keys: %w", err)
+	}
+	done := func() {
+		if foreignKeysEnabled {
+			if _, err := conn.ExecContext(ctx, "PRAGMA foreign_keys=ON"); err != nil {
+				c.log.Warnf("Failed to re-enable foreign keys after upgrade: %v", err)
+				// Make sure the connection isn't reused with foreign keys disabled
+				_ = conn.Raw(func(interface{}) error {
+					return driver.ErrBadConn
+				})
+			}
+		}
+		_ = conn.Close()
+	}
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		done()
+		return nil, nil, err
+	}
+	return &dbTx{Tx: tx, db: c.db}, done, nil
 }
 
-func upgradeV1(tx *sql.Tx, _ *Container) error {
+func upgradeV1(tx *sql.Tx, container *Container) error {
+	if isMySQL(container.dialect) {
+		return upgradeV1MySQL(tx)
+	}
 	_, err := tx.Exec(`CREATE TABLE whatsmeow_device (
 		jid TEXT PRIMARY KEY,
 
@@ -246,6 +304,9 @@ UPDATE whatsmeow_device SET adv_account_sig_key=(
 `
 
 func upgradeV2(tx *sql.Tx, container *Container) error {
+	if isMySQL(container.dialect) {
+		return nil
+	}
 	_, err := tx.Exec("ALTER TABLE whatsmeow_device ADD COLUMN adv_account_sig_key bytea CHECK ( length(adv_account_sig_key) = 32 )")
 	if err != nil {
 		return err
@@ -259,6 +320,9 @@ func upgradeV2(tx *sql.Tx, container *Container) error {
 }
 
 func upgradeV3(tx *sql.Tx, container *Container) error {
+	if isMySQL(container.dialect) {
+		return nil
+	}
 	_, err := tx.Exec(`CREATE TABLE whatsmeow_message_secrets (
 		our_jid    TEXT,
 		chat_jid   TEXT,
@@ -273,6 +337,9 @@ func upgradeV3(tx *sql.Tx, container *Container) error {
 }
 
 func upgradeV4(tx *sql.Tx, container *Container) error {
+	if isMySQL(container.dialect) {
+		return nil
+	}
 	_, err := tx.Exec(`CREATE TABLE whatsmeow_privacy_tokens (
 		our_jid   TEXT,
 		their_jid TEXT,
@@ -284,6 +351,225 @@ func upgradeV4(tx *sql.Tx, container *Container) error {
 }
 
 func upgradeV5(tx *sql.Tx, container *Container) error {
+	if isMySQL(container.dialect) {
+		return nil
+	}
 	_, err := tx.Exec("UPDATE whatsmeow_device SET jid=REPLACE(jid, '.0', '')")
 	return err
 }
+
+// The length checks of columns that may contain encrypted values are dropped in v6.
+// SQLite doesn't support dropping constraints, so the tables are rebuilt without them
+// using the procedure from https://www.sqlite.org/lang_altertable.html#otheralter
+var rebuildEncryptedTablesSQLite = [...]string{`CREATE TABLE whatsmeow_device_new (
+		jid TEXT PRIMARY KEY,
+
+		registration_id BIGINT NOT NULL CHECK ( registration_id >= 0 AND registration_id < 4294967296 ),
+
+		noise_key    bytea NOT NULL,
+		identity_key bytea NOT NULL,
+
+		signed_pre_key     bytea   NOT NULL,
+		signed_pre_key_id  INTEGER NOT NULL CHECK ( signed_pre_key_id >= 0 AND signed_pre_key_id < 16777216 ),
+		signed_pre_key_sig bytea   NOT NULL CHECK ( length(signed_pre_key_sig) = 64 ),
+
+		adv_key         bytea NOT NULL,
+		adv_details     bytea NOT NULL,
+		adv_account_sig bytea NOT NULL CHECK ( length(adv_account_sig) = 64 ),
+		adv_device_sig  bytea NOT NULL CHECK ( length(adv_device_sig) = 64 ),
+
+		platform      TEXT NOT NULL DEFAULT '',
+		business_name TEXT NOT NULL DEFAULT '',
+		push_name     TEXT NOT NULL DEFAULT '',
+
+		adv_account_sig_key bytea CHECK ( length(adv_account_sig_key) = 32 )
+	)`, `INSERT INTO whatsmeow_device_new (
+		jid, registration_id, noise_key, identity_key, signed_pre_key, signed_pre_key_id, signed_pre_key_sig,
+		adv_key, adv_details, adv_account_sig, adv_device_sig, platform, business_name, push_name, adv_account_sig_key
+	)
+	SELECT jid, registration_id, noise_key, identity_key, signed_pre_key, signed_pre_key_id, signed_pre_key_sig,
+	       adv_key, adv_details, adv_account_sig, adv_device_sig, platform, business_name, push_name, adv_account_sig_key
+	FROM whatsmeow_device`,
+	`DROP TABLE whatsmeow_device`,
+	`ALTER TABLE whatsmeow_device_new RENAME TO whatsmeow_device`,
+	`CREATE TABLE whatsmeow_pre_keys_new (
+		jid      TEXT,
+		key_id   INTEGER          CHECK ( key_id >= 0 AND key_id < 16777216 ),
+		key      bytea   NOT NULL,
+		uploaded BOOLEAN NOT NULL,
+
+		PRIMARY KEY (jid, key_id),
+		FOREIGN KEY (jid) REFERENCES whatsmeow_device(jid) ON DELETE CASCADE ON UPDATE CASCADE
+	)`,
+	`INSERT INTO whatsmeow_pre_keys_new (jid, key_id, key, uploaded) SELECT jid, key_id, key, uploaded FROM whatsmeow_pre_keys`,
+	`DROP TABLE whatsmeow_pre_keys`,
+	`ALTER TABLE whatsmeow_pre_keys_new RENAME TO whatsmeow_pre_keys`,
+}
+
+func upgradeV6(tx *sql.Tx, container *Container) error {
+	if container.dialect == "postgres" || container.dialect == "pgx" {
+		_, err := tx.Exec(`
+			ALTER TABLE whatsmeow_device
+				DROP CONSTRAINT IF EXISTS whatsmeow_device_noise_key_check,
+				DROP CONSTRAINT IF EXISTS whatsmeow_device_identity_key_check,
+				DROP CONSTRAINT IF EXISTS whatsmeow_device_signed_pre_key_check;
+			ALTER TABLE whatsmeow_pre_keys DROP CONSTRAINT IF EXISTS whatsmeow_pre_keys_key_check;
+		`)
+		return err
+	} else if isMySQL(container.dialect) {
+		return nil
+	}
+	var foreignKeysEnabled bool
+	err := tx.QueryRow("PRAGMA foreign_keys").Scan(&foreignKeysEnabled)
+	if err != nil {
+		return fmt.Errorf("failed to check if foreign keys are enabled: %w", err)
+	} else if foreignKeysEnabled {
+		// Container.Upgrade handles this automatically, but the upgrade functions can also be called manually
+		return fmt.Errorf("foreign keys must be disabled when upgrading to v6, as the upgrade rebuilds tables")
+	}
+	for _, query := range rebuildEncryptedTablesSQLite {
+		if _, err = tx.Exec(query); err != nil {
+			return fmt.Errorf("failed to rebuild tables: %w", err)
+		}
+	}
+	rows, err := tx.Query("PRAGMA foreign_key_check")
+	if err != nil {
+		return fmt.Errorf("failed to check foreign keys: %w", err)
+	}
+	hasViolations := rows.Next()
+	_ = rows.Close()
+	if hasViolations {
+		return fmt.Errorf("foreign key check failed after rebuilding tables")
+	}
+	return rows.Err()
+}
+
+// mysqlIDType is the column type used for JIDs and other identifiers in MySQL.
+// TEXT columns can't be used in primary keys, and the default collations are case-insensitive,
+// so identifiers are stored as ASCII with binary collation (which also keeps the primary keys within the length limit).
+const mysqlIDType = "VARCHAR(255) CHARACTER SET ascii COLLATE ascii_bin"
+
+// MySQL support was added after v6, so the first upgrade creates the whole v6 schema directly
+// and the other upgrades up to v6 are no-ops for MySQL. The key columns are named with %[2]s,
+// as key is a reserved word in MySQL and must be quoted with backticks, which can't be used in raw strings.
+var mysqlSchemaV6 = [...]string{`CREATE TABLE whatsmeow_device (
+		jid %[1]s PRIMARY KEY,
+
+		registration_id BIGINT NOT NULL CHECK ( registration_id >= 0 AND registration_id < 4294967296 ),
+
+		noise_key    BLOB NOT NULL,
+		identity_key BLOB NOT NULL,
+
+		signed_pre_key     BLOB          NOT NULL,
+		signed_pre_key_id  INTEGER       NOT NULL CHECK ( signed_pre_key_id >= 0 AND signed_pre_key_id < 16777216 ),
+		signed_pre_key_sig VARBINARY(64) NOT NULL CHECK ( length(signed_pre_key_sig) = 64 ),
+
+		adv_key             BLOB          NOT NULL,
+		adv_details         BLOB          NOT NULL,
+		adv_account_sig     VARBINARY(64) NOT NULL CHECK ( length(adv_account_sig) = 64 ),
+		adv_account_sig_key VARBINARY(32) NOT NULL CHECK ( length(adv_account_sig_key) = 32 ),
+		adv_device_sig      VARBINARY(64) NOT NULL CHECK ( length(adv_device_sig) = 64 ),
+
+		platform      TEXT NOT NULL,
+		business_name TEXT NOT NULL,
+		push_name     TEXT NOT NULL
+	)`, `CREATE TABLE whatsmeow_identity_keys (
+		our_jid  %[1]s,
+		their_id %[1]s,
+		identity VARBINARY(32) NOT NULL CHECK ( length(identity) = 32 ),
+
+		PRIMARY KEY (our_jid, their_id),
+		FOREIGN KEY (our_jid) REFERENCES whatsmeow_device(jid) ON DELETE CASCADE ON UPDATE CASCADE
+	)`, `CREATE TABLE whatsmeow_pre_keys (
+		jid      %[1]s,
+		key_id   INTEGER          CHECK ( key_id >= 0 AND key_id < 16777216 ),
+		%[2]s    BLOB    NOT NULL,
+		uploaded BOOLEAN NOT NULL,
+
+		PRIMARY KEY (jid, key_id),
+		FOREIGN KEY (jid) REFERENCES whatsmeow_device(jid) ON DELETE CASCADE ON UPDATE CASCADE
+	)`, `CREATE TABLE whatsmeow_sessions (
+		our_jid  %[1]s,
+		their_id %[1]s,
+		session  MEDIUMBLOB,
+
+		PRIMARY KEY (our_jid, their_id),
+		FOREIGN KEY (our_jid) REFERENCES whatsmeow_device(jid) ON DELETE CASCADE ON UPDATE CASCADE
+	)`, `CREATE TABLE whatsmeow_sender_keys (
+		our_jid    %[1]s,
+		chat_id    %[1]s,
+		sender_id  %[1]s,
+		sender_key MEDIUMBLOB NOT NULL,
+
+		PRIMARY KEY (our_jid, chat_id, sender_id),
+		FOREIGN KEY (our_jid) REFERENCES whatsmeow_device(jid) ON DELETE CASCADE ON UPDATE CASCADE
+	)`, `CREATE TABLE whatsmeow_app_state_sync_keys (
+		jid         %[1]s,
+		key_id      VARBINARY(255),
+		key_data    BLOB   NOT NULL,
+		timestamp   BIGINT NOT NULL,
+		fingerprint BLOB   NOT NULL,
+
+		PRIMARY KEY (jid, key_id),
+		FOREIGN KEY (jid) REFERENCES whatsmeow_device(jid) ON DELETE CASCADE ON UPDATE CASCADE
+	)`, `CREATE TABLE whatsmeow_app_state_version (
+		jid     %[1]s,
+		name    %[1]s,
+		version BIGINT         NOT NULL,
+		hash    VARBINARY(128) NOT NULL CHECK ( length(hash) = 128 ),
+
+		PRIMARY KEY (jid, name),
+		FOREIGN KEY (jid) REFERENCES whatsmeow_device(jid) ON DELETE CASCADE ON UPDATE CASCADE
+	)`, `CREATE TABLE whatsmeow_app_state_mutation_macs (
+		jid       %[1]s,
+		name      %[1]s,
+		version   BIGINT,
+		index_mac VARBINARY(32)          CHECK ( length(index_mac) = 32 ),
+		value_mac VARBINARY(32) NOT NULL CHECK ( length(value_mac) = 32 ),
+
+		PRIMARY KEY (jid, name, version, index_mac),
+		FOREIGN KEY (jid, name) REFERENCES whatsmeow_app_state_version(jid, name) ON DELETE CASCADE ON UPDATE CASCADE
+	)`, `CREATE TABLE whatsmeow_contacts (
+		our_jid       %[1]s,
+		their_jid     %[1]s,
+		first_name    TEXT,
+		full_name     TEXT,
+		push_name     TEXT,
+		business_name TEXT,
+
+		PRIMARY KEY (our_jid, their_jid),
+		FOREIGN KEY (our_jid) REFERENCES whatsmeow_device(jid) ON DELETE CASCADE ON UPDATE CASCADE
+	)`, `CREATE TABLE whatsmeow_chat_settings (
+		our_jid       %[1]s,
+		chat_jid      %[1]s,
+		muted_until   BIGINT  NOT NULL DEFAULT 0,
+		pinned        BOOLEAN NOT NULL DEFAULT false,
+		archived      BOOLEAN NOT NULL DEFAULT false,
+
+		PRIMARY KEY (our_jid, chat_jid),
+		FOREIGN KEY (our_jid) REFERENCES whatsmeow_device(jid) ON DELETE CASCADE ON UPDATE CASCADE
+	)`, `CREATE TABLE whatsmeow_message_secrets (
+		our_jid    %[1]s,
+		chat_jid   %[1]s,
+		sender_jid %[1]s,
+		message_id %[1]s,
+		%[2]s      BLOB NOT NULL,
+
+		PRIMARY KEY (our_jid, chat_jid, sender_jid, message_id),
+		FOREIGN KEY (our_jid) REFERENCES whatsmeow_device(jid) ON DELETE CASCADE ON UPDATE CASCADE
+	)`, `CREATE TABLE whatsmeow_privacy_tokens (
+		our_jid   %[1]s,
+		their_jid %[1]s,
+		token     BLOB   NOT NULL,
+		timestamp BIGINT NOT NULL,
+		PRIMARY KEY (our_jid, their_jid)
+	)`}
+
+func upgradeV1MySQL(tx *sql.Tx) error {
+	for _, query := range mysqlSchemaV6 {
+		if _, err := tx.Exec(fmt.Sprintf(query, mysqlIDType, "`key`")); err != nil {
+			return err
+		}
+	}
+	return nil
+}
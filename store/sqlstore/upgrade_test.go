@@ -0,0 +1,108 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package sqlstore
+
+import (
+	"bytes"
+	"database/sql"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-whatsapp/go-util/random"
+	_ "github.com/mattn/go-sqlite3"
+
+	"github.com/go-whatsapp/whatsmeow/types"
+)
+
+// newTestSQLite opens a new SQLite database with foreign keys enabled, like the New documentation recommends.
+func newTestSQLite(t *testing.T) *Container {
+	t.Helper()
+	db, err := sql.Open("sqlite3", "file:"+filepath.Join(t.TempDir(), "whatsmeow.db")+"?_foreign_keys=on")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+	return NewWithDB(db, "sqlite3", nil)
+}
+
+const insertV5DeviceQuery = `
+	INSERT INTO whatsmeow_device (
+		jid, registration_id, noise_key, identity_key, signed_pre_key, signed_pre_key_id, signed_pre_key_sig,
+		adv_key, adv_details, adv_account_sig, adv_device_sig, adv_account_sig_key
+	) VALUES ($1, 1, $2, $2, $2, 1, $3, $2, $2, $3, $3, $2)
+`
+
+func TestUpgradeV6SQLite(t *testing.T) {
+	c := newTestSQLite(t)
+	if _, err := c.getVersion(); err != nil {
+		t.Fatal(err)
+	}
+	for version := 0; version < 5; version++ {
+		if err := c.runUpgrade(version); err != nil {
+			t.Fatalf("failed to upgrade to v%d: %v", version+1, err)
+		}
+	}
+	const jid = "1@s.whatsapp.net"
+	key32, key64 := random.Bytes(32), random.Bytes(64)
+	queries := []struct {
+		query string
+		args  []interface{}
+	}{
+		{insertV5DeviceQuery, []interface{}{jid, key32, key64}},
+		{insertPreKeyQuery, []interface{}{jid, 1, key32, true}},
+		{putSessionQuery, []interface{}{jid, "2@s.whatsapp.net", []byte("session")}},
+		{putIdentityQuery, []interface{}{jid, "2@s.whatsapp.net", key32}},
+	}
+	for _, q := range queries {
+		if _, err := c.db.Exec(q.query, q.args...); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The v5 schema still has the length checks
+	if _, err := c.db.Exec(insertPreKeyQuery, jid, 2, random.Bytes(60), false); err == nil {
+		t.Fatal("v5 schema accepted prekey with invalid length")
+	}
+
+	if err := c.Upgrade(); err != nil {
+		t.Fatal(err)
+	} else if version, err := c.getVersion(); err != nil || version != 6 {
+		t.Fatalf("unexpected version %d after upgrade (%v)", version, err)
+	}
+
+	// Rebuilding the device table must not cascade to the tables referencing it
+	var noiseKey []byte
+	var sessionCount, identityCount int
+	if err := c.db.QueryRow("SELECT noise_key FROM whatsmeow_device WHERE jid=$1", jid).Scan(&noiseKey); err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(noiseKey, key32) {
+		t.Fatal("device data changed during upgrade")
+	} else if err = c.db.QueryRow("SELECT COUNT(*) FROM whatsmeow_sessions").Scan(&sessionCount); err != nil || sessionCount != 1 {
+		t.Fatalf("sessions were lost during upgrade: %d (%v)", sessionCount, err)
+	} else if err = c.db.QueryRow("SELECT COUNT(*) FROM whatsmeow_identity_keys").Scan(&identityCount); err != nil || identityCount != 1 {
+		t.Fatalf("identities were lost during upgrade: %d (%v)", identityCount, err)
+	} else if key, err := NewSQLStore(c, types.NewJID("1", types.DefaultUserServer)).GetPreKey(1); err != nil || key == nil || !bytes.Equal(key.Priv[:], key32) {
+		t.Fatalf("prekey was lost during upgrade: %v", err)
+	}
+
+	// The length checks of encrypted columns are gone, but other checks and the foreign keys still work
+	if _, err := c.db.Exec(insertPreKeyQuery, jid, 2, random.Bytes(60), false); err != nil {
+		t.Fatalf("v6 schema rejected encrypted prekey: %v", err)
+	} else if _, err = c.db.Exec("UPDATE whatsmeow_device SET noise_key=$1", random.Bytes(60)); err != nil {
+		t.Fatalf("v6 schema rejected encrypted noise key: %v", err)
+	} else if _, err = c.db.Exec("UPDATE whatsmeow_device SET signed_pre_key_sig=$1", key32); err == nil {
+		t.Fatal("v6 schema accepted signature with invalid length")
+	} else if _, err = c.db.Exec(insertPreKeyQuery, "3@s.whatsapp.net", 1, key32, false); err == nil {
+		t.Fatal("v6 schema accepted prekey for unknown device")
+	}
+	if _, err := c.db.Exec("DELETE FROM whatsmeow_device WHERE jid=$1", jid); err != nil {
+		t.Fatal(err)
+	} else if err = c.db.QueryRow("SELECT COUNT(*) FROM whatsmeow_sessions").Scan(&sessionCount); err != nil || sessionCount != 0 {
+		t.Fatalf("deleting device didn't cascade after upgrade: %d (%v)", sessionCount, err)
+	}
+}
@@ -0,0 +1,28 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package whatsmeow
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/go-whatsapp/go-util/random"
+	_ "github.com/mattn/go-sqlite3"
+
+	"github.com/go-whatsapp/whatsmeow/store"
+	"github.com/go-whatsapp/whatsmeow/store/sqlstore"
+	waLog "github.com/go-whatsapp/whatsmeow/util/log"
+)
+
+// newTestDevice creates an unpaired device backed by its own in-memory SQLite database.
+func newTestDevice(t *testing.T) *store.Device {
+	container, err := sqlstore.New("sqlite3", fmt.Sprintf("file:%x?mode=memory&cache=shared&_foreign_keys=on", random.Bytes(8)), waLog.Noop)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return container.NewDevice()
+}
@@ -0,0 +1,39 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package types
+
+// ConnectionState is the state of the client's connection to the WhatsApp servers.
+type ConnectionState string
+
+const (
+	// ConnectionStateDisconnected means there's no connection and the client isn't trying to connect.
+	ConnectionStateDisconnected ConnectionState = "disconnected"
+	// ConnectionStateConnecting means the client is opening the transport connection.
+	ConnectionStateConnecting ConnectionState = "connecting"
+	// ConnectionStateHandshaking means the transport is open and the noise handshake is in progress.
+	ConnectionStateHandshaking ConnectionState = "handshaking"
+	// ConnectionStateAuthenticating means the handshake is done, and the client is waiting for the
+	// server to accept the login (or for the user to scan a QR code when pairing).
+	ConnectionStateAuthenticating ConnectionState = "authenticating"
+	// ConnectionStateConnected means the client is logged in and ready to use.
+	ConnectionStateConnected ConnectionState = "connected"
+	// ConnectionStateBackingOff means the connection was lost, and the client is waiting before automatically reconnecting.
+	ConnectionStateBackingOff ConnectionState = "backing_off"
+	// ConnectionStateLoggedOut means the device was logged out. The client won't reconnect on its own,
+	// and the device must be paired again.
+	ConnectionStateLoggedOut ConnectionState = "logged_out"
+	// ConnectionStateBanned means the server refused the login with a temporary ban.
+	// The client won't reconnect on its own, unless the client's ReconnectPolicy allows reconnecting
+	// after the ban expires.
+	ConnectionStateBanned ConnectionState = "banned"
+)
+
+// IsTerminal returns true if the client will stay in this state until Connect is called manually
+// (or in the case of temporary bans, until the ban expires if the client's ReconnectPolicy allows reconnecting).
+func (cs ConnectionState) IsTerminal() bool {
+	return cs == ConnectionStateDisconnected || cs == ConnectionStateLoggedOut || cs == ConnectionStateBanned
+}
@@ -55,6 +55,17 @@ type QRScannedWithoutMultidevice struct{}
 // at this point, which is why this event doesn't contain any data.
 type Connected struct{}
 
+// ConnectionState is emitted whenever the connection state of the client changes (see Client.State).
+//
+// Unlike most events, these are guaranteed to be dispatched in the order the transitions happened,
+// but they're dispatched asynchronously, so the state may have already changed again when the event is handled.
+type ConnectionState struct {
+	State    types.ConnectionState
+	Previous types.ConnectionState
+	// Error is the error that caused the transition, if there was one (e.g. a failed connection attempt).
+	Error error
+}
+
 // KeepAliveTimeout is emitted when the keepalive ping request to WhatsApp web servers times out.
 //
 // Currently, there's no automatic handling for these, but it's expected that the TCP connection will
@@ -67,7 +78,10 @@ type KeepAliveTimeout struct {
 
 // KeepAliveRestored is emitted if the keepalive pings start working again after some KeepAliveTimeout events.
 // Note that if the websocket disconnects before the pings start working, this event will not be emitted.
-type KeepAliveRestored struct{}
+type KeepAliveRestored struct {
+	// RTT is the round-trip time of the ping that succeeded.
+	RTT time.Duration
+}
 
 // LoggedOut is emitted when the client has been unpaired from the phone.
 //
@@ -7,6 +7,7 @@
 package whatsmeow
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"net/http"
@@ -34,11 +35,21 @@ const CheckUpdateURL = "https://web.whatsapp.com/check-update"
 // CheckUpdate asks the WhatsApp servers if there is an update available
 // (using the HTTP client and proxy settings of this whatsmeow Client instance).
 func (cli *Client) CheckUpdate() (respData CheckUpdateResponse, err error) {
-	return CheckUpdate(cli.http)
+	return cli.CheckUpdateContext(context.Background())
+}
+
+// CheckUpdateContext is like CheckUpdate, but takes a context for cancellation and deadlines.
+func (cli *Client) CheckUpdateContext(ctx context.Context) (respData CheckUpdateResponse, err error) {
+	return CheckUpdateContext(ctx, cli.http)
 }
 
 // CheckUpdate asks the WhatsApp servers if there is an update available.
 func CheckUpdate(httpClient *http.Client) (respData CheckUpdateResponse, err error) {
+	return CheckUpdateContext(context.Background(), httpClient)
+}
+
+// CheckUpdateContext is like CheckUpdate, but takes a context for cancellation and deadlines.
+func CheckUpdateContext(ctx context.Context, httpClient *http.Client) (respData CheckUpdateResponse, err error) {
 	var reqURL *url.URL
 	reqURL, err = url.Parse(CheckUpdateURL)
 	if err != nil {
@@ -50,7 +61,7 @@ func CheckUpdate(httpClient *http.Client) (respData CheckUpdateResponse, err err
 	q.Set("platform", "web")
 	reqURL.RawQuery = q.Encode()
 	var req *http.Request
-	req, err = http.NewRequest(http.MethodGet, reqURL.String(), nil)
+	req, err = http.NewRequestWithContext(ctx, http.MethodGet, reqURL.String(), nil)
 	if err != nil {
 		err = fmt.Errorf("failed to prepare request: %w", err)
 		return
@@ -9,11 +9,17 @@ package whatsmeow
 import (
 	"bytes"
 	"context"
+	"crypto/aes"
+	"crypto/cipher"
 	"crypto/hmac"
 	"crypto/sha256"
+	"errors"
 	"fmt"
+	"io"
+	"net"
 	"net/http"
 	"net/url"
+	"os"
 
 	"github.com/cristalhq/base64"
 	"github.com/go-whatsapp/go-util/random"
@@ -33,10 +39,33 @@ type UploadResponse struct {
 	FileEncSHA256 []byte `json:"-"`
 	FileSHA256    []byte `json:"-"`
 	FileLength    uint64 `json:"-"`
+
+	// StreamingSidecar contains the chunk HMACs of the encrypted file, which allow receivers to verify parts
+	// of the file before downloading all of it. It's only generated for videos and audio, and should be put
+	// in the StreamingSidecar field of the message.
+	StreamingSidecar []byte `json:"-"`
+}
+
+// UploadExtra contains optional parameters for the Upload methods.
+type UploadExtra struct {
+	// Progress is called as the encrypted file is being uploaded.
+	Progress MediaProgressFunc
+}
+
+func getUploadExtra(extra []UploadExtra) (req UploadExtra, err error) {
+	if len(extra) > 1 {
+		err = errors.New("only one extra parameter may be provided to Upload")
+	} else if len(extra) == 1 {
+		req = extra[0]
+	}
+	return
 }
 
 // Upload uploads the given attachment to WhatsApp servers.
 //
+// In most cases, the media message builders like BuildImageMessage are easier to use,
+// as they also fill the metadata fields and thumbnails in the message.
+//
 // You should copy the fields in the response to the corresponding fields in a protobuf message.
 //
 // For example, to send an image:
@@ -62,11 +91,21 @@ type UploadResponse struct {
 //	// handle error again
 //
 // The same applies to the other message types like DocumentMessage, just replace the struct type and Message field name.
-func (cli *Client) Upload(ctx context.Context, plaintext []byte, appInfo MediaType) (resp UploadResponse, err error) {
+//
+// If Client.MediaCache is set, uploading a file that was already uploaded recently returns the previous response
+// (including the media key) instead of uploading the file again, which makes forwarding the same file to lots
+// of chats much cheaper.
+func (cli *Client) Upload(ctx context.Context, plaintext []byte, appInfo MediaType, extra ...UploadExtra) (resp UploadResponse, err error) {
+	var req UploadExtra
+	if req, err = getUploadExtra(extra); err != nil {
+		return
+	}
+	plaintextSHA256 := sha256.Sum256(plaintext)
+	if cached := cli.getCachedUpload(plaintextSHA256[:], appInfo); cached != nil {
+		return *cached, nil
+	}
 	resp.FileLength = uint64(len(plaintext))
 	resp.MediaKey = random.Bytes(32)
-
-	plaintextSHA256 := sha256.Sum256(plaintext)
 	resp.FileSHA256 = plaintextSHA256[:]
 
 	iv, cipherKey, macKey, _ := getMediaKeys(resp.MediaKey, appInfo)
@@ -85,8 +124,193 @@ func (cli *Client) Upload(ctx context.Context, plaintext []byte, appInfo MediaTy
 
 	dataHash := sha256.Sum256(dataToUpload)
 	resp.FileEncSHA256 = dataHash[:]
+	if sidecar := newUploadSidecar(resp.MediaKey, appInfo); sidecar != nil {
+		_, _ = sidecar.Write(dataToUpload)
+		resp.StreamingSidecar = sidecar.Sum()
+	}
+
+	err = cli.rawUpload(ctx, bytesUploadBody(dataToUpload), int64(len(dataToUpload)), resp.FileEncSHA256, appInfo, false, &resp, req)
+	if err == nil {
+		cli.putCachedUpload(appInfo, &resp)
+	}
+	return
+}
+
+// UploadReader is like Upload, but reads the plaintext from the given reader instead of taking it as a byte slice.
+//
+// The size parameter is the length of the plaintext, which is used to check that the reader returned
+// all the data. It can be -1 if the size is not known in advance.
+//
+// The encrypted file is never fully buffered in memory. If the reader implements io.Seeker, it will be read twice:
+// first to calculate the hashes (which are needed before starting the upload), then again while uploading.
+// Otherwise, the encrypted file is written into a temporary file, which is removed after the upload.
+//
+// If Client.MediaCache is set, previous responses are reused like in Upload. The hash of the file isn't known
+// before reading it, so the reader is still read fully (and written into the temporary file if it isn't seekable)
+// before the cache is checked.
+func (cli *Client) UploadReader(ctx context.Context, plaintext io.Reader, size int64, appInfo MediaType, extra ...UploadExtra) (resp UploadResponse, err error) {
+	var req UploadExtra
+	if req, err = getUploadExtra(extra); err != nil {
+		return
+	}
+	resp.MediaKey = random.Bytes(32)
+	seeker, ok := plaintext.(io.ReadSeeker)
+	if !ok {
+		return cli.uploadReaderWithTempFile(ctx, plaintext, size, appInfo, resp, req)
+	}
+	var start int64
+	start, err = seeker.Seek(0, io.SeekCurrent)
+	if err != nil {
+		err = fmt.Errorf("failed to get current position of reader: %w", err)
+		return
+	}
+	var dst io.Writer = io.Discard
+	sidecar := newUploadSidecar(resp.MediaKey, appInfo)
+	if sidecar != nil {
+		dst = sidecar
+	}
+	resp.FileSHA256, resp.FileEncSHA256, resp.FileLength, err = encryptMediaStream(dst, seeker, resp.MediaKey, appInfo)
+	if err != nil {
+		return
+	} else if err = checkUploadSize(size, resp.FileLength); err != nil {
+		return
+	} else if cached := cli.getCachedUpload(resp.FileSHA256, appInfo); cached != nil {
+		return *cached, nil
+	} else if _, err = seeker.Seek(start, io.SeekStart); err != nil {
+		err = fmt.Errorf("failed to seek back to start of reader: %w", err)
+		return
+	}
+	if sidecar != nil {
+		resp.StreamingSidecar = sidecar.Sum()
+	}
+
+	getBody := func() (io.ReadCloser, error) {
+		if _, err := seeker.Seek(start, io.SeekStart); err != nil {
+			return nil, fmt.Errorf("failed to seek back to start of reader: %w", err)
+		}
+		pipeReader, pipeWriter := io.Pipe()
+		body := &encryptingPipe{PipeReader: pipeReader, done: make(chan struct{})}
+		go func() {
+			defer close(body.done)
+			_, fileEncSHA256, _, err := encryptMediaStream(pipeWriter, seeker, resp.MediaKey, appInfo)
+			if err == nil && !bytes.Equal(fileEncSHA256, resp.FileEncSHA256) {
+				err = fmt.Errorf("data changed between reading it the first and second time")
+			}
+			_ = pipeWriter.CloseWithError(err)
+		}()
+		return body, nil
+	}
+	err = cli.rawUpload(ctx, getBody, encryptedMediaSize(resp.FileLength), resp.FileEncSHA256, appInfo, false, &resp, req)
+	if err == nil {
+		cli.putCachedUpload(appInfo, &resp)
+	}
+	return
+}
+
+// encryptingPipe is the reading end of a pipe that's being written to by encryptMediaStream in another goroutine.
+// Closing it waits for the goroutine to stop, so that the source reader can safely be reused.
+type encryptingPipe struct {
+	*io.PipeReader
+	done chan struct{}
+}
 
-	err = cli.rawUpload(ctx, dataToUpload, resp.FileEncSHA256, appInfo, false, &resp)
+func (ep *encryptingPipe) Close() error {
+	err := ep.PipeReader.Close()
+	<-ep.done
+	return err
+}
+
+func (cli *Client) uploadReaderWithTempFile(ctx context.Context, plaintext io.Reader, size int64, appInfo MediaType, resp UploadResponse, extra UploadExtra) (UploadResponse, error) {
+	tempFile, err := os.CreateTemp("", "whatsmeow-upload-*")
+	if err != nil {
+		return resp, fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	defer func() {
+		_ = tempFile.Close()
+		_ = os.Remove(tempFile.Name())
+	}()
+	var dst io.Writer = tempFile
+	sidecar := newUploadSidecar(resp.MediaKey, appInfo)
+	if sidecar != nil {
+		dst = io.MultiWriter(tempFile, sidecar)
+	}
+	resp.FileSHA256, resp.FileEncSHA256, resp.FileLength, err = encryptMediaStream(dst, plaintext, resp.MediaKey, appInfo)
+	if err != nil {
+		return resp, err
+	} else if err = checkUploadSize(size, resp.FileLength); err != nil {
+		return resp, err
+	} else if cached := cli.getCachedUpload(resp.FileSHA256, appInfo); cached != nil {
+		return *cached, nil
+	}
+	if sidecar != nil {
+		resp.StreamingSidecar = sidecar.Sum()
+	}
+	encryptedSize := encryptedMediaSize(resp.FileLength)
+	getBody := func() (io.ReadCloser, error) {
+		return io.NopCloser(io.NewSectionReader(tempFile, 0, encryptedSize)), nil
+	}
+	err = cli.rawUpload(ctx, getBody, encryptedSize, resp.FileEncSHA256, appInfo, false, &resp, extra)
+	if err == nil {
+		cli.putCachedUpload(appInfo, &resp)
+	}
+	return resp, err
+}
+
+func checkUploadSize(expected int64, actual uint64) error {
+	if expected >= 0 && uint64(expected) != actual {
+		return fmt.Errorf("%w: expected %d bytes, but reader returned %d", ErrFileLengthMismatch, expected, actual)
+	}
+	return nil
+}
+
+// encryptedMediaSize returns the size of an encrypted media file, i.e. the padded ciphertext and the truncated HMAC.
+func encryptedMediaSize(plaintextLength uint64) int64 {
+	return int64(plaintextLength/aes.BlockSize+1)*aes.BlockSize + mediaHMACLength
+}
+
+// encryptMediaStream encrypts the data from src in chunks and writes the ciphertext followed by
+// the truncated HMAC into dst. It returns the hashes of the plaintext and the encrypted file.
+func encryptMediaStream(dst io.Writer, src io.Reader, mediaKey []byte, appInfo MediaType) (fileSHA256, fileEncSHA256 []byte, fileLength uint64, err error) {
+	iv, cipherKey, macKey, _ := getMediaKeys(mediaKey, appInfo)
+	block, err := aes.NewCipher(cipherKey)
+	if err != nil {
+		err = fmt.Errorf("failed to encrypt file: %w", err)
+		return
+	}
+	cbc := cipher.NewCBCEncrypter(block, iv)
+	plaintextHash := sha256.New()
+	encHash := sha256.New()
+	mac := hmac.New(sha256.New, macKey)
+	mac.Write(iv)
+	out := io.MultiWriter(dst, encHash)
+
+	buf := make([]byte, 32*1024, 32*1024+aes.BlockSize)
+	for {
+		n, readErr := io.ReadFull(src, buf)
+		chunk := buf[:n]
+		plaintextHash.Write(chunk)
+		fileLength += uint64(n)
+		isLast := readErr == io.EOF || readErr == io.ErrUnexpectedEOF
+		if readErr != nil && !isLast {
+			err = fmt.Errorf("failed to read file: %w", readErr)
+			return
+		} else if isLast {
+			padding := aes.BlockSize - n%aes.BlockSize
+			chunk = append(chunk, bytes.Repeat([]byte{byte(padding)}, padding)...)
+		}
+		cbc.CryptBlocks(chunk, chunk)
+		mac.Write(chunk)
+		if _, err = out.Write(chunk); err != nil {
+			return
+		} else if isLast {
+			break
+		}
+	}
+	if _, err = out.Write(mac.Sum(nil)[:mediaHMACLength]); err != nil {
+		return
+	}
+	fileSHA256 = plaintextHash.Sum(nil)
+	fileEncSHA256 = encHash.Sum(nil)
 	return
 }
 
@@ -121,56 +345,121 @@ func (cli *Client) Upload(ctx context.Context, plaintext []byte, appInfo MediaTy
 //	})
 //	// handle error again
 
-func (cli *Client) UploadNewsletter(ctx context.Context, data []byte, appInfo MediaType) (resp UploadResponse, err error) {
+func (cli *Client) UploadNewsletter(ctx context.Context, data []byte, appInfo MediaType, extra ...UploadExtra) (resp UploadResponse, err error) {
+	var req UploadExtra
+	if req, err = getUploadExtra(extra); err != nil {
+		return
+	}
 	resp.FileLength = uint64(len(data))
 	hash := sha256.Sum256(data)
 	resp.FileSHA256 = hash[:]
-	err = cli.rawUpload(ctx, data, resp.FileSHA256, appInfo, true, &resp)
+	err = cli.rawUpload(ctx, bytesUploadBody(data), int64(len(data)), resp.FileSHA256, appInfo, true, &resp, req)
 	return
 }
 
-func (cli *Client) rawUpload(ctx context.Context, dataToUpload, fileHash []byte, appInfo MediaType, newsletter bool, resp *UploadResponse) error {
-	mediaConn, err := cli.refreshMediaConn(false)
+// uploadBodyFunc returns a new reader for the data to upload, so that the upload can be retried with another host.
+type uploadBodyFunc func() (io.ReadCloser, error)
+
+func bytesUploadBody(data []byte) uploadBodyFunc {
+	return func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(data)), nil
+	}
+}
+
+// shouldRetryMediaUpload returns true if the upload should be retried with the next media host, i.e. if it failed
+// due to a network error or a server error. Uploads are addressed by the hash of the encrypted file, so sending
+// the same file to another host is safe even if the first host already stored it.
+func shouldRetryMediaUpload(err error) bool {
+	var netErr net.Error
+	var httpErr UploadHTTPError
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	} else if errors.As(err, &httpErr) {
+		return httpErr.StatusCode >= 500 || httpErr.StatusCode == http.StatusTooManyRequests
+	}
+	return errors.As(err, &netErr) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+func isMediaAuthError(err error) bool {
+	var httpErr UploadHTTPError
+	return errors.As(err, &httpErr) && (httpErr.StatusCode == http.StatusUnauthorized || httpErr.StatusCode == http.StatusForbidden)
+}
+
+func (cli *Client) rawUpload(ctx context.Context, getBody uploadBodyFunc, bodySize int64, fileHash []byte, appInfo MediaType, newsletter bool, resp *UploadResponse, extra UploadExtra) error {
+	mediaConn, err := cli.refreshMediaConn(ctx, false)
 	if err != nil {
 		return fmt.Errorf("failed to refresh media connections: %w", err)
 	}
 
 	token := base64.URLEncoding.EncodeToString(fileHash)
-	q := url.Values{
-		"auth":  []string{mediaConn.Auth},
-		"token": []string{token},
-	}
 	mmsType := mediaTypeToMMSType[appInfo]
 	uploadPrefix := "mms"
 	if newsletter {
 		mmsType = fmt.Sprintf("newsletter-%s", mmsType)
 		uploadPrefix = "newsletter"
 	}
-	uploadURL := url.URL{
-		Scheme:   "https",
-		Host:     mediaConn.Hosts[0].Hostname,
-		Path:     fmt.Sprintf("/%s/%s/%s", uploadPrefix, mmsType, token),
-		RawQuery: q.Encode(),
+	refreshedAuth := false
+	for i := 0; i < len(mediaConn.Hosts); i++ {
+		host := mediaConn.Hosts[i].Hostname
+		uploadURL := url.URL{
+			Scheme: "https",
+			Host:   host,
+			Path:   fmt.Sprintf("/%s/%s/%s", uploadPrefix, mmsType, token),
+			RawQuery: url.Values{
+				"auth":  []string{mediaConn.Auth},
+				"token": []string{token},
+			}.Encode(),
+		}
+		err = cli.uploadToHost(ctx, uploadURL.String(), getBody, bodySize, resp, extra.Progress)
+		if err == nil {
+			return nil
+		} else if ctx.Err() != nil {
+			return err
+		} else if isMediaAuthError(err) && !refreshedAuth {
+			cli.Log.Debugf("Got auth error while uploading media to %s (%v), refreshing media connection", host, err)
+			refreshedAuth = true
+			mediaConn, err = cli.refreshMediaConn(ctx, true)
+			if err != nil {
+				return fmt.Errorf("failed to refresh media connections: %w", err)
+			}
+			i = -1
+			continue
+		} else if !shouldRetryMediaUpload(err) {
+			return err
+		} else if i < len(mediaConn.Hosts)-1 {
+			cli.Log.Warnf("Failed to upload media to %s: %v, trying with next host...", host, err)
+		}
+	}
+	if err == nil {
+		return fmt.Errorf("failed to upload media: no media hosts available")
 	}
+	return fmt.Errorf("failed to upload media to last host: %w", err)
+}
 
-	req, err := http.NewRequestWithContext(ctx, http.MethodPost, uploadURL.String(), bytes.NewReader(dataToUpload))
+func (cli *Client) uploadToHost(ctx context.Context, uploadURL string, getBody uploadBodyFunc, bodySize int64, resp *UploadResponse, progress MediaProgressFunc) error {
+	body, err := getBody()
+	if err != nil {
+		return fmt.Errorf("failed to prepare upload body: %w", err)
+	}
+	defer body.Close()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, uploadURL, withProgress(body, bodySize, progress))
 	if err != nil {
 		return fmt.Errorf("failed to prepare request: %w", err)
 	}
+	req.ContentLength = bodySize
 
 	req.Header.Set("Origin", socket.Origin)
 	req.Header.Set("Referer", socket.Origin+"/")
 
 	httpResp, err := cli.http.Do(req)
 	if err != nil {
-		err = fmt.Errorf("failed to execute request: %w", err)
-	} else if httpResp.StatusCode != http.StatusOK {
-		err = fmt.Errorf("upload failed with status code %d", httpResp.StatusCode)
-	} else if err = json.NewDecoder(httpResp.Body).Decode(&resp); err != nil {
-		err = fmt.Errorf("failed to parse upload response: %w", err)
+		return fmt.Errorf("failed to execute request: %w", err)
 	}
-	if httpResp != nil {
-		_ = httpResp.Body.Close()
+	defer httpResp.Body.Close()
+	if httpResp.StatusCode != http.StatusOK {
+		return UploadHTTPError{Response: httpResp}
+	} else if err = json.NewDecoder(httpResp.Body).Decode(&resp); err != nil {
+		return fmt.Errorf("failed to parse upload response: %w", err)
 	}
-	return err
+	return nil
 }
@@ -0,0 +1,97 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package whatsmeow
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"syscall"
+	"testing"
+
+	"github.com/go-whatsapp/go-util/random"
+)
+
+func TestShouldRetryMediaUpload(t *testing.T) {
+	wrapRequestErr := func(err error) error {
+		return fmt.Errorf("failed to execute request: %w", &url.Error{Op: "Post", URL: "https://mmg.whatsapp.net/", Err: err})
+	}
+	tests := []struct {
+		name  string
+		err   error
+		retry bool
+	}{
+		{"dial refused", wrapRequestErr(&net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED}), true},
+		{"dns failure", wrapRequestErr(&net.DNSError{Err: "no such host", Name: "mmg.whatsapp.net"}), true},
+		{"connection reset while sending", wrapRequestErr(&net.OpError{Op: "write", Net: "tcp", Err: syscall.ECONNRESET}), true},
+		{"tls handshake failure", wrapRequestErr(errors.New("remote error: tls: handshake failure")), true},
+		{"response body read timeout", fmt.Errorf("failed to parse upload response: %w", &net.OpError{Op: "read", Net: "tcp", Err: syscall.ETIMEDOUT}), true},
+		{"truncated response body", fmt.Errorf("failed to parse upload response: %w", io.ErrUnexpectedEOF), true},
+		{"context canceled", wrapRequestErr(context.Canceled), false},
+		{"context deadline", wrapRequestErr(context.DeadlineExceeded), false},
+		{"service unavailable", UploadHTTPError{Response: &http.Response{StatusCode: http.StatusServiceUnavailable}}, true},
+		{"bad gateway", UploadHTTPError{Response: &http.Response{StatusCode: http.StatusBadGateway}}, true},
+		{"internal server error", UploadHTTPError{Response: &http.Response{StatusCode: http.StatusInternalServerError}}, true},
+		{"too many requests", UploadHTTPError{Response: &http.Response{StatusCode: http.StatusTooManyRequests}}, true},
+		{"bad request", UploadHTTPError{Response: &http.Response{StatusCode: http.StatusBadRequest}}, false},
+		{"unauthorized", UploadHTTPError{Response: &http.Response{StatusCode: http.StatusUnauthorized}}, false},
+		{"other error", errors.New("failed to prepare upload body"), false},
+	}
+	for _, test := range tests {
+		if retry := shouldRetryMediaUpload(test.err); retry != test.retry {
+			t.Errorf("%s: expected retry=%t, got %t", test.name, test.retry, retry)
+		}
+	}
+}
+
+type failingReader struct {
+	data []byte
+	err  error
+}
+
+func (fr *failingReader) Read(p []byte) (int, error) {
+	if len(fr.data) == 0 {
+		return 0, fr.err
+	}
+	n := copy(p, fr.data)
+	fr.data = fr.data[n:]
+	return n, nil
+}
+
+func TestEncryptMediaStream(t *testing.T) {
+	for _, size := range []int{0, 1, 16, 32*1024 - 1, 32 * 1024, 32*1024 + 1, 100 * 1024} {
+		// The streamed output must be identical to encrypting the whole file at once
+		expected := encryptTestMedia(t, random.Bytes(size))
+		var buf bytes.Buffer
+		fileSHA256, fileEncSHA256, fileLength, err := encryptMediaStream(&buf, bytes.NewReader(expected.plaintext), expected.mediaKey, MediaDocument)
+		if err != nil {
+			t.Fatalf("size %d: %v", size, err)
+		} else if !bytes.Equal(buf.Bytes(), expected.encrypted) {
+			t.Fatalf("size %d: encrypted data doesn't match", size)
+		} else if !bytes.Equal(fileSHA256, expected.fileSha256) || !bytes.Equal(fileEncSHA256, expected.fileEncSha256) {
+			t.Fatalf("size %d: hashes don't match", size)
+		} else if fileLength != uint64(size) || encryptedMediaSize(fileLength) != int64(buf.Len()) {
+			t.Fatalf("size %d: unexpected length %d (encrypted %d)", size, fileLength, buf.Len())
+		}
+	}
+
+	testErr := errors.New("test")
+	_, _, _, err := encryptMediaStream(io.Discard, &failingReader{data: random.Bytes(100), err: testErr}, random.Bytes(32), MediaDocument)
+	if !errors.Is(err, testErr) {
+		t.Fatalf("expected read error, got %v", err)
+	}
+	if err = checkUploadSize(100, 99); !errors.Is(err, ErrFileLengthMismatch) {
+		t.Fatalf("expected ErrFileLengthMismatch, got %v", err)
+	} else if err = checkUploadSize(-1, 99); err != nil {
+		t.Fatalf("unknown size wasn't accepted: %v", err)
+	}
+}
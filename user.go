@@ -32,10 +32,16 @@ const NewsletterLinkPrefix = "https://whatsapp.com/channel/"
 // The links look like https://wa.me/message/<code> or https://api.whatsapp.com/message/<code>. You can either provide
 // the full link, or just the <code> part.
 func (cli *Client) ResolveBusinessMessageLink(code string) (*types.BusinessMessageLinkTarget, error) {
+	return cli.ResolveBusinessMessageLinkContext(context.Background(), code)
+}
+
+// ResolveBusinessMessageLinkContext is like ResolveBusinessMessageLink, but takes a context for cancellation and deadlines.
+func (cli *Client) ResolveBusinessMessageLinkContext(ctx context.Context, code string) (*types.BusinessMessageLinkTarget, error) {
 	code = strings.TrimPrefix(code, BusinessMessageLinkPrefix)
 	code = strings.TrimPrefix(code, BusinessMessageLinkDirectPrefix)
 
 	resp, err := cli.sendIQ(infoQuery{
+		Context:   ctx,
 		Namespace: "w:qr",
 		Type:      iqGet,
 		// WhatsApp android doesn't seem to have a "to" field for this one at all, not sure why but it works
@@ -79,10 +85,16 @@ func (cli *Client) ResolveBusinessMessageLink(code string) (*types.BusinessMessa
 // The links look like https://wa.me/qr/<code> or https://api.whatsapp.com/qr/<code>. You can either provide
 // the full link, or just the <code> part.
 func (cli *Client) ResolveContactQRLink(code string) (*types.ContactQRLinkTarget, error) {
+	return cli.ResolveContactQRLinkContext(context.Background(), code)
+}
+
+// ResolveContactQRLinkContext is like ResolveContactQRLink, but takes a context for cancellation and deadlines.
+func (cli *Client) ResolveContactQRLinkContext(ctx context.Context, code string) (*types.ContactQRLinkTarget, error) {
 	code = strings.TrimPrefix(code, ContactQRLinkPrefix)
 	code = strings.TrimPrefix(code, ContactQRLinkDirectPrefix)
 
 	resp, err := cli.sendIQ(infoQuery{
+		Context:   ctx,
 		Namespace: "w:qr",
 		Type:      iqGet,
 		Content: []waBinary.Node{{
@@ -114,11 +126,17 @@ func (cli *Client) ResolveContactQRLink(code string) (*types.ContactQRLinkTarget
 //
 // If the revoke parameter is set to true, it will ask the server to revoke the previous link and generate a new one.
 func (cli *Client) GetContactQRLink(revoke bool) (string, error) {
+	return cli.GetContactQRLinkContext(context.Background(), revoke)
+}
+
+// GetContactQRLinkContext is like GetContactQRLink, but takes a context for cancellation and deadlines.
+func (cli *Client) GetContactQRLinkContext(ctx context.Context, revoke bool) (string, error) {
 	action := "get"
 	if revoke {
 		action = "revoke"
 	}
 	resp, err := cli.sendIQ(infoQuery{
+		Context:   ctx,
 		Namespace: "w:qr",
 		Type:      iqSet,
 		Content: []waBinary.Node{{
@@ -145,7 +163,13 @@ func (cli *Client) GetContactQRLink(revoke bool) (string, error) {
 // This is different from the ephemeral status broadcast messages. Use SendMessage to types.StatusBroadcastJID to send
 // such messages.
 func (cli *Client) SetStatusMessage(msg string) error {
+	return cli.SetStatusMessageContext(context.Background(), msg)
+}
+
+// SetStatusMessageContext is like SetStatusMessage, but takes a context for cancellation and deadlines.
+func (cli *Client) SetStatusMessageContext(ctx context.Context, msg string) error {
 	_, err := cli.sendIQ(infoQuery{
+		Context:   ctx,
 		Namespace: "status",
 		Type:      iqSet,
 		To:        types.ServerJID,
@@ -160,11 +184,16 @@ func (cli *Client) SetStatusMessage(msg string) error {
 // IsOnWhatsApp checks if the given phone numbers are registered on WhatsApp.
 // The phone numbers should be in international format, including the `+` prefix.
 func (cli *Client) IsOnWhatsApp(phones []string) ([]types.IsOnWhatsAppResponse, error) {
+	return cli.IsOnWhatsAppContext(context.Background(), phones)
+}
+
+// IsOnWhatsAppContext is like IsOnWhatsApp, but takes a context for cancellation and deadlines.
+func (cli *Client) IsOnWhatsAppContext(ctx context.Context, phones []string) ([]types.IsOnWhatsAppResponse, error) {
 	jids := make([]types.JID, len(phones))
 	for i := range jids {
 		jids[i] = types.NewJID(phones[i], types.LegacyUserServer)
 	}
-	list, err := cli.usync(context.TODO(), jids, "query", "interactive", []waBinary.Node{
+	list, err := cli.usync(ctx, jids, "query", "interactive", []waBinary.Node{
 		{Tag: "business", Content: []waBinary.Node{{Tag: "verified_name"}}},
 		{Tag: "contact"},
 	})
@@ -195,7 +224,12 @@ func (cli *Client) IsOnWhatsApp(phones []string) ([]types.IsOnWhatsAppResponse,
 
 // GetUserInfo gets basic user info (avatar, status, verified business name, device list).
 func (cli *Client) GetUserInfo(jids []types.JID) (map[types.JID]types.UserInfo, error) {
-	list, err := cli.usync(context.TODO(), jids, "full", "background", []waBinary.Node{
+	return cli.GetUserInfoContext(context.Background(), jids)
+}
+
+// GetUserInfoContext is like GetUserInfo, but takes a context for cancellation and deadlines.
+func (cli *Client) GetUserInfoContext(ctx context.Context, jids []types.JID) (map[types.JID]types.UserInfo, error) {
+	list, err := cli.usync(ctx, jids, "full", "background", []waBinary.Node{
 		{Tag: "business", Content: []waBinary.Node{{Tag: "verified_name"}}},
 		{Tag: "status"},
 		{Tag: "picture"},
@@ -281,6 +315,11 @@ type GetProfilePictureParams struct {
 //
 // To get a community photo, you should pass `IsCommunity: true`, as otherwise you may get a 401 error.
 func (cli *Client) GetProfilePictureInfo(jid types.JID, params *GetProfilePictureParams) (*types.ProfilePictureInfo, error) {
+	return cli.GetProfilePictureInfoContext(context.Background(), jid, params)
+}
+
+// GetProfilePictureInfoContext is like GetProfilePictureInfo, but takes a context for cancellation and deadlines.
+func (cli *Client) GetProfilePictureInfoContext(ctx context.Context, jid types.JID, params *GetProfilePictureParams) (*types.ProfilePictureInfo, error) {
 	attrs := waBinary.Attrs{
 		"query": "url",
 	}
@@ -321,6 +360,7 @@ func (cli *Client) GetProfilePictureInfo(jid types.JID, params *GetProfilePictur
 		}}
 	}
 	resp, err := cli.sendIQ(infoQuery{
+		Context:   ctx,
 		Namespace: namespace,
 		Type:      iqGet,
 		To:        to,
@@ -535,7 +575,13 @@ func (cli *Client) parseBlocklist(node *waBinary.Node) *types.Blocklist {
 
 // GetBlocklist gets the list of users that this user has blocked.
 func (cli *Client) GetBlocklist() (*types.Blocklist, error) {
+	return cli.GetBlocklistContext(context.Background())
+}
+
+// GetBlocklistContext is like GetBlocklist, but takes a context for cancellation and deadlines.
+func (cli *Client) GetBlocklistContext(ctx context.Context) (*types.Blocklist, error) {
 	resp, err := cli.sendIQ(infoQuery{
+		Context:   ctx,
 		Namespace: "blocklist",
 		Type:      iqGet,
 		To:        types.ServerJID,
@@ -552,7 +598,13 @@ func (cli *Client) GetBlocklist() (*types.Blocklist, error) {
 
 // UpdateBlocklist updates the user's block list and returns the updated list.
 func (cli *Client) UpdateBlocklist(jid types.JID, action events.BlocklistChangeAction) (*types.Blocklist, error) {
+	return cli.UpdateBlocklistContext(context.Background(), jid, action)
+}
+
+// UpdateBlocklistContext is like UpdateBlocklist, but takes a context for cancellation and deadlines.
+func (cli *Client) UpdateBlocklistContext(ctx context.Context, jid types.JID, action events.BlocklistChangeAction) (*types.Blocklist, error) {
 	resp, err := cli.sendIQ(infoQuery{
+		Context:   ctx,
 		Namespace: "blocklist",
 		Type:      iqSet,
 		To:        types.ServerJID,
@@ -0,0 +1,95 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package mediautil
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"
+	"image/jpeg"
+	_ "image/png"
+
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+)
+
+// MaxImagePixels is the maximum number of pixels (width * height) that Thumbnail and ConvertToSticker
+// will decode. Decoded images take at least 4 bytes per pixel, so this stops small files with huge dimensions
+// from using gigabytes of memory.
+var MaxImagePixels int64 = 50 * 1000 * 1000
+
+// ErrImageTooLarge is returned by Thumbnail and ConvertToSticker if the image has more than MaxImagePixels pixels.
+var ErrImageTooLarge = errors.New("image dimensions are too large")
+
+// decodeImage decodes the given image after checking that its dimensions are within MaxImagePixels.
+func decodeImage(data []byte) (image.Image, error) {
+	width, height, err := ImageSize(data)
+	if err != nil {
+		return nil, err
+	} else if int64(width)*int64(height) > MaxImagePixels {
+		return nil, fmt.Errorf("%w: %dx%d", ErrImageTooLarge, width, height)
+	}
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode image: %w", err)
+	}
+	return src, nil
+}
+
+// ImageSize returns the dimensions of a JPEG, PNG, GIF or WebP image without decoding the whole image.
+func ImageSize(data []byte) (width, height int, err error) {
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to decode image config: %w", err)
+	}
+	return cfg.Width, cfg.Height, nil
+}
+
+// Thumbnail decodes the given image and returns a JPEG thumbnail that fits in a maxSize x maxSize box,
+// along with the dimensions of the original image. Transparent areas are filled with white.
+// Images smaller than the box are not scaled up, and images with more than MaxImagePixels pixels are rejected.
+func Thumbnail(data []byte, maxSize int) (thumbnail []byte, width, height int, err error) {
+	src, err := decodeImage(data)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	bounds := src.Bounds()
+	width, height = bounds.Dx(), bounds.Dy()
+	if width == 0 || height == 0 {
+		return nil, width, height, fmt.Errorf("image is empty")
+	}
+	thumbWidth, thumbHeight := width, height
+	if thumbWidth > maxSize || thumbHeight > maxSize {
+		if width >= height {
+			thumbWidth, thumbHeight = maxSize, height*maxSize/width
+		} else {
+			thumbWidth, thumbHeight = width*maxSize/height, maxSize
+		}
+		if thumbWidth < 1 {
+			thumbWidth = 1
+		}
+		if thumbHeight < 1 {
+			thumbHeight = 1
+		}
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, thumbWidth, thumbHeight))
+	draw.Draw(dst, dst.Bounds(), image.White, image.Point{}, draw.Src)
+	draw.ApproxBiLinear.Scale(dst, dst.Bounds(), src, bounds, draw.Over, nil)
+	var buf bytes.Buffer
+	if err = jpeg.Encode(&buf, dst, &jpeg.Options{Quality: 75}); err != nil {
+		return nil, width, height, fmt.Errorf("failed to encode thumbnail: %w", err)
+	}
+	return buf.Bytes(), width, height, nil
+}
+
+// IsAnimatedWebP checks if the given data is an animated WebP image, i.e. if it has the animation flag
+// set in the extended (VP8X) header.
+func IsAnimatedWebP(data []byte) bool {
+	return len(data) >= 21 && string(data[0:4]) == "RIFF" && string(data[8:16]) == "WEBPVP8X" && data[20]&vp8xFlagAnimation != 0
+}
@@ -0,0 +1,244 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package mediautil
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/png"
+	"testing"
+	"time"
+
+	"golang.org/x/image/webp"
+)
+
+func makeMP4Box(boxType string, content ...[]byte) []byte {
+	data := bytes.Join(content, nil)
+	box := binary.BigEndian.AppendUint32(nil, uint32(8+len(data)))
+	return append(append(box, boxType...), data...)
+}
+
+func TestParseMP4(t *testing.T) {
+	mvhd := make([]byte, 100)
+	binary.BigEndian.PutUint32(mvhd[12:16], 1000)
+	binary.BigEndian.PutUint32(mvhd[16:20], 12500)
+	tkhd := make([]byte, 84)
+	binary.BigEndian.PutUint32(tkhd[76:80], 640<<16)
+	binary.BigEndian.PutUint32(tkhd[80:84], 360<<16)
+	hdlr := func(handler string) []byte {
+		return makeMP4Box("mdia", makeMP4Box("hdlr", make([]byte, 8), []byte(handler), make([]byte, 13)))
+	}
+	data := bytes.Join([][]byte{
+		makeMP4Box("ftyp", []byte("isom"), make([]byte, 4)),
+		makeMP4Box("moov",
+			makeMP4Box("mvhd", mvhd),
+			makeMP4Box("trak", makeMP4Box("tkhd", make([]byte, 84)), hdlr("soun")),
+			makeMP4Box("trak", makeMP4Box("tkhd", tkhd), hdlr("vide")),
+		),
+	}, nil)
+	info, err := ParseMP4(data)
+	if err != nil {
+		t.Fatal(err)
+	} else if info.Duration != 12500*time.Millisecond || info.Width != 640 || info.Height != 360 || !info.HasVideo || !info.HasAudio {
+		t.Fatalf("unexpected info %+v", info)
+	} else if mimeType := DetectMIMEType(data, ""); mimeType != "video/mp4" {
+		t.Fatalf("unexpected MIME type %s", mimeType)
+	}
+
+	// The ReaderAt version should skip over the media data without reading it
+	data = bytes.Join([][]byte{data[:16], makeMP4Box("mdat", make([]byte, 100000)), data[16:]}, nil)
+	if readerInfo, err := ParseMP4ReaderAt(bytes.NewReader(data), int64(len(data))); err != nil || *readerInfo != *info {
+		t.Fatalf("unexpected info from reader %+v (%v)", readerInfo, err)
+	}
+	if _, err = ParseMP4ReaderAt(bytes.NewReader(data[:100016]), 100016); !errors.Is(err, ErrNoMoovBox) {
+		t.Fatalf("expected no moov box error, got %v", err)
+	}
+}
+
+func TestPDFPageCount(t *testing.T) {
+	pdf := []byte("%PDF-1.4\n1 0 obj << /Type /Pages /Kids [3 0 R] /Count 1 >> endobj\n2 0 obj << /Count 12 /Type /Pages /Kids [1 0 R] >> endobj\n%%EOF")
+	if count, err := PDFPageCount(pdf); err != nil || count != 12 {
+		t.Fatalf("expected 12 pages, got %d/%v", count, err)
+	}
+
+	var compressed bytes.Buffer
+	writer := zlib.NewWriter(&compressed)
+	_, _ = writer.Write([]byte("<< /Type /Pages /Kids [3 0 R 4 0 R] /Count 2 >>"))
+	_ = writer.Close()
+	pdf = append([]byte("%PDF-1.5\n5 0 obj << /Type /ObjStm /Filter /FlateDecode >> stream\n"), compressed.Bytes()...)
+	pdf = append(pdf, "\nendstream endobj\n%%EOF"...)
+	if count, err := PDFPageCount(pdf); err != nil || count != 2 {
+		t.Fatalf("expected 2 pages in object stream, got %d/%v", count, err)
+	}
+
+	// Only the start and the end of large files are searched
+	defer func(size int64) { PDFScanSize = size }(PDFScanSize)
+	PDFScanSize = 128
+	pdf = append(append([]byte("%PDF-1.4\n1 0 obj << /Type /Pages /Count 4 >> endobj\n"), make([]byte, 1000)...), "\n%%EOF"...)
+	if count, err := PDFPageCountReaderAt(bytes.NewReader(pdf), int64(len(pdf))); err != nil || count != 4 {
+		t.Fatalf("expected 4 pages from reader, got %d/%v", count, err)
+	}
+	pdf = append(append([]byte("%PDF-1.4\n"), make([]byte, 1000)...), "1 0 obj << /Type /Pages /Count 4 >> endobj\n"...)
+	pdf = append(pdf, make([]byte, 1000)...)
+	if _, err := PDFPageCountReaderAt(bytes.NewReader(pdf), int64(len(pdf))); !errors.Is(err, ErrPageCountNotFound) {
+		t.Fatalf("expected page count not found error, got %v", err)
+	}
+}
+
+func TestThumbnail(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 400, 100))
+	img.Set(10, 10, color.NRGBA{R: 255, A: 255})
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	thumbnail, width, height, err := Thumbnail(buf.Bytes(), 72)
+	if err != nil {
+		t.Fatal(err)
+	} else if width != 400 || height != 100 {
+		t.Fatalf("unexpected original size %dx%d", width, height)
+	} else if thumbWidth, thumbHeight, err := ImageSize(thumbnail); err != nil || thumbWidth != 72 || thumbHeight != 18 {
+		t.Fatalf("unexpected thumbnail size %dx%d (%v)", thumbWidth, thumbHeight, err)
+	} else if mimeType := DetectMIMEType(thumbnail, ""); mimeType != "image/jpeg" {
+		t.Fatalf("unexpected thumbnail MIME type %s", mimeType)
+	}
+}
+
+func TestThumbnailRejectsHugeImage(t *testing.T) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, 1, 1))); err != nil {
+		t.Fatal(err)
+	}
+	// Patch the dimensions in the IHDR chunk to claim a 100000x100000 image and fix the chunk checksum
+	data := buf.Bytes()
+	binary.BigEndian.PutUint32(data[16:20], 100000)
+	binary.BigEndian.PutUint32(data[20:24], 100000)
+	binary.BigEndian.PutUint32(data[29:33], crc32.ChecksumIEEE(data[12:29]))
+	if width, height, err := ImageSize(data); err != nil || width != 100000 || height != 100000 {
+		t.Fatalf("failed to patch PNG dimensions: %dx%d (%v)", width, height, err)
+	} else if _, _, _, err = Thumbnail(data, 72); !errors.Is(err, ErrImageTooLarge) {
+		t.Fatalf("expected image too large error from Thumbnail, got %v", err)
+	} else if _, err = ConvertToSticker(data); !errors.Is(err, ErrImageTooLarge) {
+		t.Fatalf("expected image too large error from ConvertToSticker, got %v", err)
+	}
+}
+
+func makeOggPage(granule int64, packet []byte) []byte {
+	page := []byte("OggS\x00\x00")
+	page = binary.LittleEndian.AppendUint64(page, uint64(granule))
+	page = append(page, 1, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0)
+	var segments []byte
+	for size := len(packet); ; size -= 255 {
+		if size < 255 {
+			segments = append(segments, byte(size))
+			break
+		}
+		segments = append(segments, 255)
+	}
+	page = append(page, byte(len(segments)))
+	page = append(page, segments...)
+	return append(page, packet...)
+}
+
+func TestParseOggOpus(t *testing.T) {
+	head := []byte("OpusHead\x01\x01")
+	head = binary.LittleEndian.AppendUint16(head, 312)
+	head = binary.LittleEndian.AppendUint32(head, 16000)
+	head = append(head, 0, 0, 0)
+	ogg := append(makeOggPage(0, head), makeOggPage(0, []byte("OpusTags"))...)
+	// 5 seconds of 20ms CELT frames, getting louder at the end
+	var granule int64
+	for i := 0; i < 250; i++ {
+		granule += 960
+		size := 10
+		if i >= 200 {
+			size = 300
+		}
+		ogg = append(ogg, makeOggPage(granule, append([]byte{31 << 3}, make([]byte, size)...))...)
+	}
+	if mimeType := DetectMIMEType(ogg, ""); mimeType != "audio/ogg; codecs=opus" {
+		t.Fatalf("unexpected MIME type %s", mimeType)
+	}
+	info, err := ParseOggOpus(ogg)
+	if err != nil {
+		t.Fatal(err)
+	} else if info.Duration != 5*time.Second-6500*time.Microsecond {
+		t.Fatalf("unexpected duration %s", info.Duration)
+	} else if info.Channels != 1 || info.InputSampleRate != 16000 {
+		t.Fatalf("unexpected info %+v", info)
+	} else if len(info.Waveform) != WaveformLength || info.Waveform[0] > 10 || info.Waveform[WaveformLength-1] != 100 {
+		t.Fatalf("unexpected waveform %v", info.Waveform)
+	}
+	if bars := DecodeWaveform(info.Waveform, 16); len(bars) != 16 || bars[15] != 1 || bars[0] > 0.1 {
+		t.Fatalf("unexpected decoded waveform %v", bars)
+	}
+
+	if _, err = ParseOggOpus(makeOggPage(0, []byte("\x01vorbis"))); err == nil {
+		t.Fatal("expected error for non-opus stream")
+	}
+}
+
+func TestEncodeWebP(t *testing.T) {
+	noise := image.NewNRGBA(image.Rect(0, 0, 70, 33))
+	for i := range noise.Pix {
+		noise.Pix[i] = byte(i * 7919 % 251)
+	}
+	solid := image.NewNRGBA(image.Rect(0, 0, 5, 5))
+	for i := range solid.Pix {
+		solid.Pix[i] = 0xff
+	}
+	for _, img := range []*image.NRGBA{noise, solid} {
+		data, err := EncodeWebP(img)
+		if err != nil {
+			t.Fatal(err)
+		}
+		decoded, err := webp.Decode(bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		bounds := img.Bounds()
+		for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+			for x := bounds.Min.X; x < bounds.Max.X; x++ {
+				if expected, actual := img.NRGBAAt(x, y), color.NRGBAModel.Convert(decoded.At(x, y)); expected != actual {
+					t.Fatalf("pixel %d,%d doesn't match: expected %v, got %v", x, y, expected, actual)
+				}
+			}
+		}
+	}
+}
+
+func TestStickerMetadata(t *testing.T) {
+	sticker, err := EncodeWebP(image.NewNRGBA(image.Rect(0, 0, 16, 16)))
+	if err != nil {
+		t.Fatal(err)
+	} else if _, err = GetStickerMetadata(sticker); err != ErrNoStickerMetadata {
+		t.Fatalf("expected no metadata error, got %v", err)
+	}
+	meta := &StickerPackMetadata{PackID: "1", PackName: "Test pack", Publisher: "Tester", Emojis: []string{"👍"}}
+	exif, err := meta.EXIF()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Setting metadata twice should replace the old EXIF chunk
+	for i := 0; i < 2; i++ {
+		if sticker, err = SetWebPEXIF(sticker, exif); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if parsed, err := GetStickerMetadata(sticker); err != nil || parsed.PackName != meta.PackName || parsed.Emojis[0] != "👍" {
+		t.Fatalf("unexpected metadata %+v (%v)", parsed, err)
+	} else if width, height, err := ImageSize(sticker); err != nil || width != 16 || height != 16 {
+		t.Fatalf("unexpected size %dx%d (%v)", width, height, err)
+	} else if IsAnimatedWebP(sticker) {
+		t.Fatal("static sticker detected as animated")
+	}
+}
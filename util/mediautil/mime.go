@@ -0,0 +1,73 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+// Package mediautil contains helpers for extracting metadata from media files, like MIME types,
+// image dimensions, thumbnails and durations. Everything is implemented in pure Go.
+package mediautil
+
+import (
+	"bytes"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strings"
+)
+
+// DetectMIMEType guesses the MIME type of a file based on its contents. The file name is optional:
+// if the contents only match a generic type like application/octet-stream or application/zip,
+// the file extension is used instead.
+func DetectMIMEType(data []byte, fileName string) string {
+	if mimeType := detectMP4Type(data); len(mimeType) > 0 {
+		return mimeType
+	}
+	mimeType := http.DetectContentType(data)
+	switch {
+	case mimeType == "application/ogg":
+		if isOggOpus(data) {
+			return "audio/ogg; codecs=opus"
+		}
+		return "audio/ogg"
+	case isGenericMIMEType(mimeType) && len(fileName) > 0:
+		if byExt := mime.TypeByExtension(strings.ToLower(filepath.Ext(fileName))); len(byExt) > 0 {
+			return byExt
+		}
+	}
+	return mimeType
+}
+
+func isGenericMIMEType(mimeType string) bool {
+	return mimeType == "application/octet-stream" || mimeType == "application/zip" || strings.HasPrefix(mimeType, "text/plain")
+}
+
+// detectMP4Type checks the major brand in the ftyp box of ISO base media files.
+// http.DetectContentType only recognizes a few brands, and always says they're video.
+func detectMP4Type(data []byte) string {
+	if len(data) < 12 || string(data[4:8]) != "ftyp" {
+		return ""
+	}
+	switch string(data[8:12]) {
+	case "M4A ", "M4B ":
+		return "audio/mp4"
+	case "qt  ":
+		return "video/quicktime"
+	case "3gp4", "3gp5", "3gp6":
+		return "video/3gpp"
+	case "avif":
+		return "image/avif"
+	case "heic", "heix", "mif1":
+		return "image/heic"
+	default:
+		return "video/mp4"
+	}
+}
+
+func isOggOpus(data []byte) bool {
+	// The OpusHead packet is always at the start of the first page, right after the 27-byte header and segment table
+	if len(data) > 128 {
+		data = data[:128]
+	}
+	return bytes.Contains(data, []byte("OpusHead"))
+}
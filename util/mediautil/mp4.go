@@ -0,0 +1,193 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package mediautil
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// MP4Info contains metadata parsed from the moov box of an MP4 file.
+type MP4Info struct {
+	Duration time.Duration
+	// Width and Height are the dimensions of the first video track, or zero if there's no video.
+	Width  int
+	Height int
+
+	HasVideo bool
+	HasAudio bool
+}
+
+// Errors returned by ParseMP4 and ParseMP4ReaderAt
+var (
+	ErrNoMoovBox       = errors.New("moov box not found in MP4 file")
+	ErrMoovBoxTooLarge = errors.New("moov box in MP4 file is too large")
+)
+
+// MaxMP4MoovSize is the maximum size of the moov box that ParseMP4ReaderAt will read into memory.
+var MaxMP4MoovSize int64 = 16 * 1024 * 1024
+
+type mp4Box struct {
+	boxType string
+	data    []byte
+}
+
+// readMP4Boxes splits the given data into boxes. Truncated boxes at the end are ignored.
+func readMP4Boxes(data []byte) (boxes []mp4Box) {
+	for len(data) >= 8 {
+		size := uint64(binary.BigEndian.Uint32(data[0:4]))
+		boxType := string(data[4:8])
+		headerSize := uint64(8)
+		if size == 1 {
+			if len(data) < 16 {
+				return
+			}
+			size = binary.BigEndian.Uint64(data[8:16])
+			headerSize = 16
+		} else if size == 0 {
+			size = uint64(len(data))
+		}
+		if size < headerSize || size > uint64(len(data)) {
+			return
+		}
+		boxes = append(boxes, mp4Box{boxType: boxType, data: data[headerSize:size]})
+		data = data[size:]
+	}
+	return
+}
+
+func findMP4Box(boxes []mp4Box, boxType string) []byte {
+	for _, box := range boxes {
+		if box.boxType == boxType {
+			return box.data
+		}
+	}
+	return nil
+}
+
+// ParseMP4 reads the duration and video dimensions of an MP4 (or M4A/MOV) file.
+func ParseMP4(data []byte) (*MP4Info, error) {
+	moov := findMP4Box(readMP4Boxes(data), "moov")
+	if moov == nil {
+		return nil, ErrNoMoovBox
+	}
+	return parseMP4Moov(moov)
+}
+
+// ParseMP4ReaderAt is like ParseMP4, but only reads the top-level box headers and the moov box from the given reader
+// instead of requiring the whole file in memory. The size is the total size of the file.
+func ParseMP4ReaderAt(r io.ReaderAt, size int64) (*MP4Info, error) {
+	var header [16]byte
+	for offset := int64(0); size-offset >= 8; {
+		if _, err := r.ReadAt(header[:8], offset); err != nil {
+			return nil, fmt.Errorf("failed to read box header: %w", err)
+		}
+		boxSize := int64(binary.BigEndian.Uint32(header[0:4]))
+		headerSize := int64(8)
+		if boxSize == 1 {
+			if size-offset < 16 {
+				break
+			} else if _, err := r.ReadAt(header[8:16], offset+8); err != nil {
+				return nil, fmt.Errorf("failed to read box header: %w", err)
+			}
+			largeSize := binary.BigEndian.Uint64(header[8:16])
+			if largeSize > uint64(size-offset) {
+				break
+			}
+			boxSize = int64(largeSize)
+			headerSize = 16
+		} else if boxSize == 0 {
+			boxSize = size - offset
+		}
+		if boxSize < headerSize || boxSize > size-offset {
+			break
+		} else if string(header[4:8]) == "moov" {
+			if boxSize-headerSize > MaxMP4MoovSize {
+				return nil, fmt.Errorf("%w (%d bytes)", ErrMoovBoxTooLarge, boxSize-headerSize)
+			}
+			moov := make([]byte, boxSize-headerSize)
+			if _, err := r.ReadAt(moov, offset+headerSize); err != nil {
+				return nil, fmt.Errorf("failed to read moov box: %w", err)
+			}
+			return parseMP4Moov(moov)
+		}
+		offset += boxSize
+	}
+	return nil, ErrNoMoovBox
+}
+
+func parseMP4Moov(moov []byte) (*MP4Info, error) {
+	moovBoxes := readMP4Boxes(moov)
+	var info MP4Info
+	if mvhd := findMP4Box(moovBoxes, "mvhd"); mvhd == nil {
+		return nil, fmt.Errorf("mvhd box not found in MP4 file")
+	} else if duration, err := parseMVHD(mvhd); err != nil {
+		return nil, err
+	} else {
+		info.Duration = duration
+	}
+	for _, box := range moovBoxes {
+		if box.boxType != "trak" {
+			continue
+		}
+		trakBoxes := readMP4Boxes(box.data)
+		switch getMP4HandlerType(trakBoxes) {
+		case "vide":
+			if !info.HasVideo {
+				info.HasVideo = true
+				info.Width, info.Height = parseTKHDSize(findMP4Box(trakBoxes, "tkhd"))
+			}
+		case "soun":
+			info.HasAudio = true
+		}
+	}
+	return &info, nil
+}
+
+func parseMVHD(mvhd []byte) (time.Duration, error) {
+	var timescale uint32
+	var duration uint64
+	if len(mvhd) >= 32 && mvhd[0] == 1 {
+		timescale = binary.BigEndian.Uint32(mvhd[20:24])
+		duration = binary.BigEndian.Uint64(mvhd[24:32])
+	} else if len(mvhd) >= 20 && mvhd[0] == 0 {
+		timescale = binary.BigEndian.Uint32(mvhd[12:16])
+		duration = uint64(binary.BigEndian.Uint32(mvhd[16:20]))
+	} else {
+		return 0, fmt.Errorf("invalid mvhd box")
+	}
+	if timescale == 0 {
+		return 0, fmt.Errorf("invalid timescale in mvhd box")
+	}
+	return time.Duration(float64(duration) / float64(timescale) * float64(time.Second)), nil
+}
+
+func getMP4HandlerType(trakBoxes []mp4Box) string {
+	hdlr := findMP4Box(readMP4Boxes(findMP4Box(trakBoxes, "mdia")), "hdlr")
+	if len(hdlr) < 12 {
+		return ""
+	}
+	return string(hdlr[8:12])
+}
+
+func parseTKHDSize(tkhd []byte) (width, height int) {
+	// The width and height are 16.16 fixed-point numbers at the end of the box,
+	// the offset depends on whether the timestamps are 32 or 64 bits.
+	offset := 76
+	if len(tkhd) > 0 && tkhd[0] == 1 {
+		offset = 88
+	}
+	if len(tkhd) < offset+8 {
+		return 0, 0
+	}
+	width = int(binary.BigEndian.Uint32(tkhd[offset:offset+4]) >> 16)
+	height = int(binary.BigEndian.Uint32(tkhd[offset+4:offset+8]) >> 16)
+	return
+}
@@ -0,0 +1,250 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package mediautil
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// WaveformLength is the number of samples in the waveform of voice messages.
+const WaveformLength = 64
+
+// Errors returned by ParseOggOpus
+var (
+	ErrInvalidOgg = errors.New("invalid ogg container")
+	ErrNotOpus    = errors.New("ogg stream doesn't contain opus audio")
+)
+
+// OggOpusInfo contains metadata parsed from an Ogg Opus file.
+type OggOpusInfo struct {
+	Duration time.Duration
+	Channels int
+	// InputSampleRate is the sample rate of the original audio before encoding.
+	// Opus always decodes at 48 kHz regardless of this value.
+	InputSampleRate uint32
+	// Waveform contains WaveformLength values between 0 and 100, which is the format
+	// used in the Waveform field of voice messages.
+	Waveform []byte
+}
+
+const (
+	oggPageHeaderSize   = 27
+	oggContinuedPacket  = 0x01
+	opusSampleRate      = 48000
+	opusHeadMinimumSize = 19
+)
+
+type oggPacket struct {
+	data []byte
+	// granule is the granule position of the page where the packet ended.
+	granule int64
+}
+
+// readOggPackets reads all packets of the first logical stream in an Ogg file.
+func readOggPackets(data []byte) ([]oggPacket, error) {
+	var packets []oggPacket
+	var current []byte
+	var serial uint32
+	first := true
+	for len(data) > 0 {
+		if len(data) < oggPageHeaderSize || string(data[0:4]) != "OggS" {
+			return nil, fmt.Errorf("%w: missing page header", ErrInvalidOgg)
+		} else if data[4] != 0 {
+			return nil, fmt.Errorf("%w: unsupported version %d", ErrInvalidOgg, data[4])
+		}
+		headerType := data[5]
+		granule := int64(binary.LittleEndian.Uint64(data[6:14]))
+		pageSerial := binary.LittleEndian.Uint32(data[14:18])
+		segmentCount := int(data[26])
+		if len(data) < oggPageHeaderSize+segmentCount {
+			return nil, fmt.Errorf("%w: truncated segment table", ErrInvalidOgg)
+		}
+		segments := data[oggPageHeaderSize : oggPageHeaderSize+segmentCount]
+		body := data[oggPageHeaderSize+segmentCount:]
+		var bodySize int
+		for _, size := range segments {
+			bodySize += int(size)
+		}
+		if len(body) < bodySize {
+			return nil, fmt.Errorf("%w: truncated page", ErrInvalidOgg)
+		}
+		data = body[bodySize:]
+		if first {
+			serial = pageSerial
+			first = false
+		} else if pageSerial != serial {
+			// Ignore other multiplexed streams
+			continue
+		}
+		if headerType&oggContinuedPacket == 0 {
+			current = nil
+		}
+		for _, size := range segments {
+			current = append(current, body[:size]...)
+			body = body[size:]
+			if size < 255 {
+				packets = append(packets, oggPacket{data: current, granule: granule})
+				current = nil
+			}
+		}
+	}
+	return packets, nil
+}
+
+// getOpusPacketSamples returns the number of 48 kHz samples in an Opus packet based on its TOC byte.
+func getOpusPacketSamples(packet []byte) int {
+	if len(packet) == 0 {
+		return 0
+	}
+	config := int(packet[0] >> 3)
+	var frameSize int // in units of 2.5ms (120 samples)
+	switch {
+	case config < 12:
+		// SILK-only: 10, 20, 40 or 60 ms
+		frameSize = []int{4, 8, 16, 24}[config%4]
+	case config < 16:
+		// Hybrid: 10 or 20 ms
+		frameSize = []int{4, 8}[config%2]
+	default:
+		// CELT-only: 2.5, 5, 10 or 20 ms
+		frameSize = []int{1, 2, 4, 8}[config%4]
+	}
+	var frameCount int
+	switch packet[0] & 0x03 {
+	case 0:
+		frameCount = 1
+	case 1, 2:
+		frameCount = 2
+	case 3:
+		if len(packet) < 2 {
+			return 0
+		}
+		frameCount = int(packet[1] & 0x3F)
+	}
+	return frameSize * 120 * frameCount
+}
+
+// ParseOggOpus reads the duration and generates a waveform for an Ogg Opus file.
+//
+// Decoding Opus audio isn't possible in pure Go, so the waveform is estimated from the sizes of the packets:
+// Opus is usually encoded with a variable bitrate, so louder parts of the audio take more space than silence.
+func ParseOggOpus(data []byte) (*OggOpusInfo, error) {
+	packets, err := readOggPackets(data)
+	if err != nil {
+		return nil, err
+	} else if len(packets) < 2 {
+		return nil, fmt.Errorf("%w: missing header packets", ErrInvalidOgg)
+	}
+	head := packets[0].data
+	if len(head) < opusHeadMinimumSize || !bytes.HasPrefix(head, []byte("OpusHead")) {
+		return nil, ErrNotOpus
+	} else if version := head[8]; version>>4 != 0 {
+		return nil, fmt.Errorf("%w: unsupported OpusHead version %d", ErrNotOpus, version)
+	} else if !bytes.HasPrefix(packets[1].data, []byte("OpusTags")) {
+		return nil, fmt.Errorf("%w: missing OpusTags packet", ErrNotOpus)
+	}
+	info := &OggOpusInfo{
+		Channels:        int(head[9]),
+		InputSampleRate: binary.LittleEndian.Uint32(head[12:16]),
+	}
+	preSkip := int64(binary.LittleEndian.Uint16(head[10:12]))
+	if info.Channels == 0 {
+		return nil, fmt.Errorf("%w: invalid channel count", ErrNotOpus)
+	}
+
+	audioPackets := packets[2:]
+	packetSamples := make([]int, len(audioPackets))
+	var totalSamples int64
+	for i, packet := range audioPackets {
+		packetSamples[i] = getOpusPacketSamples(packet.data)
+		totalSamples += int64(packetSamples[i])
+	}
+	// The granule position of the last page is the exact length of the stream, but fall back to counting samples
+	// in packets if it's missing.
+	lengthSamples := totalSamples
+	if len(audioPackets) > 0 {
+		if lastGranule := audioPackets[len(audioPackets)-1].granule; lastGranule > 0 {
+			lengthSamples = lastGranule
+		}
+	}
+	lengthSamples -= preSkip
+	if lengthSamples < 0 {
+		lengthSamples = 0
+	}
+	info.Duration = time.Duration(lengthSamples) * time.Second / opusSampleRate
+	info.Waveform = makeWaveform(audioPackets, packetSamples, totalSamples)
+	return info, nil
+}
+
+func makeWaveform(packets []oggPacket, packetSamples []int, totalSamples int64) []byte {
+	waveform := make([]byte, WaveformLength)
+	if totalSamples == 0 {
+		return waveform
+	}
+	var sums [WaveformLength]float64
+	var counts [WaveformLength]int
+	var position int64
+	for i, packet := range packets {
+		bucket := int(position * WaveformLength / totalSamples)
+		if bucket >= WaveformLength {
+			bucket = WaveformLength - 1
+		}
+		sums[bucket] += float64(len(packet.data))
+		counts[bucket]++
+		position += int64(packetSamples[i])
+	}
+	var averages [WaveformLength]float64
+	var maxAverage float64
+	for i := range averages {
+		if counts[i] > 0 {
+			averages[i] = sums[i] / float64(counts[i])
+			if averages[i] > maxAverage {
+				maxAverage = averages[i]
+			}
+		}
+	}
+	if maxAverage == 0 {
+		return waveform
+	}
+	for i, avg := range averages {
+		waveform[i] = byte(avg / maxAverage * 100)
+	}
+	return waveform
+}
+
+// DecodeWaveform converts the Waveform field of a voice message into values between 0 and 1 for rendering.
+// If bars is positive and different from the length of the waveform, the waveform is resampled to that many bars.
+func DecodeWaveform(waveform []byte, bars int) []float64 {
+	if bars <= 0 {
+		bars = len(waveform)
+	}
+	output := make([]float64, bars)
+	if len(waveform) == 0 {
+		return output
+	}
+	for i := range output {
+		// Average all the input values that fall into this bar, or pick the closest one when upsampling
+		start := i * len(waveform) / bars
+		end := (i + 1) * len(waveform) / bars
+		if end <= start {
+			end = start + 1
+		}
+		var sum float64
+		for _, val := range waveform[start:end] {
+			if val > 100 {
+				val = 100
+			}
+			sum += float64(val) / 100
+		}
+		output[i] = sum / float64(end-start)
+	}
+	return output
+}
@@ -0,0 +1,104 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package mediautil
+
+import (
+	"bytes"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+)
+
+// ErrPageCountNotFound is returned by PDFPageCount if the file doesn't seem to contain a page tree.
+var ErrPageCountNotFound = errors.New("page count not found in PDF")
+
+var (
+	pdfPagesCountRegex = regexp.MustCompile(`/Type\s*/Pages\b[^>]*?/Count\s+(\d+)|/Count\s+(\d+)[^>]*?/Type\s*/Pages\b`)
+	pdfStreamRegex     = regexp.MustCompile(`stream\r?\n`)
+)
+
+// maxPDFStreamSize is the maximum size of a decompressed object stream that PDFPageCount will look into.
+const maxPDFStreamSize = 16 * 1024 * 1024
+
+// PDFScanSize is how much of the start and the end of large files PDFPageCountReaderAt searches for the page count.
+var PDFScanSize int64 = 8 * 1024 * 1024
+
+// PDFPageCount finds the number of pages in a PDF file.
+//
+// This doesn't fully parse the file: it looks for the /Count entry of the page tree root,
+// both in the file itself and in compressed object streams.
+func PDFPageCount(data []byte) (int, error) {
+	if count := findPDFPageCount(data); count > 0 {
+		return count, nil
+	}
+	// PDF 1.5+ files may store the page tree inside compressed object streams
+	var maxCount int
+	for _, loc := range pdfStreamRegex.FindAllIndex(data, -1) {
+		start := loc[1]
+		end := bytes.Index(data[start:], []byte("endstream"))
+		if end < 0 {
+			break
+		}
+		reader, err := zlib.NewReader(bytes.NewReader(data[start : start+end]))
+		if err != nil {
+			continue
+		}
+		decompressed, _ := io.ReadAll(io.LimitReader(reader, maxPDFStreamSize))
+		_ = reader.Close()
+		if count := findPDFPageCount(decompressed); count > maxCount {
+			maxCount = count
+		}
+	}
+	if maxCount == 0 {
+		return 0, ErrPageCountNotFound
+	}
+	return maxCount, nil
+}
+
+// PDFPageCountReaderAt is like PDFPageCount, but reads the file from the given reader. The size is the total size
+// of the file. Files larger than twice PDFScanSize aren't read fully: only the start and the end of the file are
+// searched, which is where the page tree root usually is.
+func PDFPageCountReaderAt(r io.ReaderAt, size int64) (int, error) {
+	if size <= 2*PDFScanSize {
+		data := make([]byte, size)
+		if _, err := r.ReadAt(data, 0); err != nil && !errors.Is(err, io.EOF) {
+			return 0, fmt.Errorf("failed to read file: %w", err)
+		}
+		return PDFPageCount(data)
+	}
+	data := make([]byte, PDFScanSize)
+	var maxCount int
+	for _, offset := range []int64{0, size - PDFScanSize} {
+		if _, err := r.ReadAt(data, offset); err != nil && !errors.Is(err, io.EOF) {
+			return 0, fmt.Errorf("failed to read file: %w", err)
+		} else if count, err := PDFPageCount(data); err == nil && count > maxCount {
+			maxCount = count
+		}
+	}
+	if maxCount == 0 {
+		return 0, ErrPageCountNotFound
+	}
+	return maxCount, nil
+}
+
+// findPDFPageCount returns the largest /Count of all /Pages dictionaries, which is the count of the root node.
+func findPDFPageCount(data []byte) (maxCount int) {
+	for _, match := range pdfPagesCountRegex.FindAllSubmatch(data, -1) {
+		countStr := match[1]
+		if countStr == nil {
+			countStr = match[2]
+		}
+		count, err := strconv.Atoi(string(countStr))
+		if err == nil && count > maxCount {
+			maxCount = count
+		}
+	}
+	return
+}
@@ -0,0 +1,245 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package mediautil
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+
+	"golang.org/x/image/draw"
+)
+
+// StickerSize is the width and height of WhatsApp stickers.
+const StickerSize = 512
+
+// StickerPackMetadata is the sticker pack info that WhatsApp stores in the EXIF data of sticker files.
+type StickerPackMetadata struct {
+	PackID    string   `json:"sticker-pack-id"`
+	PackName  string   `json:"sticker-pack-name"`
+	Publisher string   `json:"sticker-pack-publisher"`
+	Emojis    []string `json:"emojis,omitempty"`
+}
+
+// stickerEXIFTag is the custom EXIF tag that WhatsApp uses for the sticker pack JSON.
+const stickerEXIFTag = 0x5741
+
+// Errors returned by the WebP sticker functions
+var (
+	ErrInvalidWebP            = errors.New("invalid WebP file")
+	ErrNoStickerMetadata      = errors.New("no sticker pack metadata found")
+	ErrInvalidAnimatedSticker = errors.New("animated stickers must already be 512x512 WebP files")
+)
+
+// EXIF returns the metadata as an EXIF blob that can be embedded into a WebP file with SetWebPEXIF.
+func (meta *StickerPackMetadata) EXIF() ([]byte, error) {
+	data, err := json.Marshal(meta)
+	if err != nil {
+		return nil, err
+	}
+	// Little-endian TIFF header, followed by an IFD with one entry that points right after itself.
+	exif := []byte{'I', 'I', 0x2a, 0x00, 0x08, 0x00, 0x00, 0x00, 0x01, 0x00}
+	exif = binary.LittleEndian.AppendUint16(exif, stickerEXIFTag)
+	exif = binary.LittleEndian.AppendUint16(exif, 7) // type UNDEFINED
+	exif = binary.LittleEndian.AppendUint32(exif, uint32(len(data)))
+	exif = binary.LittleEndian.AppendUint32(exif, uint32(len(exif)+4))
+	return append(exif, data...), nil
+}
+
+// ParseStickerEXIF parses sticker pack metadata from an EXIF blob.
+func ParseStickerEXIF(exif []byte) (*StickerPackMetadata, error) {
+	if len(exif) < 10 {
+		return nil, ErrNoStickerMetadata
+	}
+	var order binary.ByteOrder
+	switch string(exif[0:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return nil, fmt.Errorf("%w: invalid TIFF header", ErrNoStickerMetadata)
+	}
+	ifdOffset := int(order.Uint32(exif[4:8]))
+	if ifdOffset+2 > len(exif) {
+		return nil, fmt.Errorf("%w: invalid IFD offset", ErrNoStickerMetadata)
+	}
+	entryCount := int(order.Uint16(exif[ifdOffset : ifdOffset+2]))
+	for i := 0; i < entryCount; i++ {
+		entry := exif[ifdOffset+2+i*12:]
+		if len(entry) < 12 {
+			break
+		} else if order.Uint16(entry[0:2]) != stickerEXIFTag {
+			continue
+		}
+		length := int(order.Uint32(entry[4:8]))
+		offset := int(order.Uint32(entry[8:12]))
+		if offset+length > len(exif) || length < 0 {
+			return nil, fmt.Errorf("%w: metadata out of bounds", ErrNoStickerMetadata)
+		}
+		var meta StickerPackMetadata
+		if err := json.Unmarshal(exif[offset:offset+length], &meta); err != nil {
+			return nil, fmt.Errorf("failed to parse sticker pack metadata: %w", err)
+		}
+		return &meta, nil
+	}
+	return nil, ErrNoStickerMetadata
+}
+
+type webpChunk struct {
+	fourCC string
+	data   []byte
+}
+
+func parseWebPChunks(data []byte) ([]webpChunk, error) {
+	if len(data) < 12 || string(data[0:4]) != "RIFF" || string(data[8:12]) != "WEBP" {
+		return nil, fmt.Errorf("%w: missing RIFF header", ErrInvalidWebP)
+	}
+	riffSize := int(binary.LittleEndian.Uint32(data[4:8]))
+	if riffSize+8 < len(data) {
+		// Ignore trailing garbage after the RIFF container
+		data = data[:riffSize+8]
+	}
+	data = data[12:]
+	var chunks []webpChunk
+	for len(data) >= 8 {
+		size := int(binary.LittleEndian.Uint32(data[4:8]))
+		if size < 0 || size > len(data)-8 {
+			return nil, fmt.Errorf("%w: truncated %s chunk", ErrInvalidWebP, data[0:4])
+		}
+		chunks = append(chunks, webpChunk{fourCC: string(data[0:4]), data: data[8 : 8+size]})
+		data = data[8+size:]
+		if size%2 == 1 && len(data) > 0 {
+			data = data[1:]
+		}
+	}
+	if len(chunks) == 0 {
+		return nil, fmt.Errorf("%w: no chunks", ErrInvalidWebP)
+	}
+	return chunks, nil
+}
+
+// WebPEXIF returns the EXIF chunk of a WebP file, or nil if it doesn't have one.
+func WebPEXIF(data []byte) ([]byte, error) {
+	chunks, err := parseWebPChunks(data)
+	if err != nil {
+		return nil, err
+	}
+	for _, chunk := range chunks {
+		if chunk.fourCC == "EXIF" {
+			return chunk.data, nil
+		}
+	}
+	return nil, nil
+}
+
+// GetStickerMetadata reads the sticker pack metadata embedded in a WebP sticker.
+func GetStickerMetadata(data []byte) (*StickerPackMetadata, error) {
+	exif, err := WebPEXIF(data)
+	if err != nil {
+		return nil, err
+	} else if exif == nil {
+		return nil, ErrNoStickerMetadata
+	}
+	return ParseStickerEXIF(exif)
+}
+
+const (
+	vp8xFlagAnimation = 0x02
+	vp8xFlagEXIF      = 0x08
+	vp8xFlagAlpha     = 0x10
+)
+
+// SetWebPEXIF embeds the given EXIF data into a WebP file, replacing any existing EXIF data.
+// Simple WebP files are converted to the extended format, as that's required for metadata.
+func SetWebPEXIF(data, exif []byte) ([]byte, error) {
+	chunks, err := parseWebPChunks(data)
+	if err != nil {
+		return nil, err
+	}
+	var vp8x []byte
+	if chunks[0].fourCC == "VP8X" {
+		if len(chunks[0].data) < 10 {
+			return nil, fmt.Errorf("%w: VP8X chunk too short", ErrInvalidWebP)
+		}
+		vp8x = bytes.Clone(chunks[0].data)
+		chunks = chunks[1:]
+	} else {
+		width, height, err := ImageSize(data)
+		if err != nil {
+			return nil, err
+		}
+		vp8x = make([]byte, 10)
+		putUint24(vp8x[4:7], uint32(width-1))
+		putUint24(vp8x[7:10], uint32(height-1))
+		// Lossless images have a hint bit for alpha right after the dimensions
+		if chunks[0].fourCC == "VP8L" && len(chunks[0].data) >= 5 && chunks[0].data[4]&0x10 != 0 {
+			vp8x[0] |= vp8xFlagAlpha
+		}
+	}
+	vp8x[0] |= vp8xFlagEXIF
+	output := [][]byte{makeWebPChunk("VP8X", vp8x)}
+	for _, chunk := range chunks {
+		if chunk.fourCC != "EXIF" {
+			output = append(output, makeWebPChunk(chunk.fourCC, chunk.data))
+		}
+	}
+	output = append(output, makeWebPChunk("EXIF", exif))
+	return makeRIFF(output...), nil
+}
+
+func putUint24(dst []byte, val uint32) {
+	dst[0] = byte(val)
+	dst[1] = byte(val >> 8)
+	dst[2] = byte(val >> 16)
+}
+
+// ConvertToSticker converts a JPEG, PNG, GIF or static WebP image into a 512x512 WebP image suitable for stickers.
+// The image is scaled to fit and centered on a transparent background.
+//
+// Files that are already 512x512 WebP images are returned as-is. Animated WebP files can't be converted,
+// so ErrInvalidAnimatedSticker is returned if they're not the right size. Like Thumbnail, images with more than
+// MaxImagePixels pixels are rejected.
+func ConvertToSticker(data []byte) ([]byte, error) {
+	if DetectMIMEType(data, "") == "image/webp" {
+		width, height, err := ImageSize(data)
+		if err != nil {
+			return nil, err
+		} else if width == StickerSize && height == StickerSize {
+			return data, nil
+		} else if IsAnimatedWebP(data) {
+			return nil, ErrInvalidAnimatedSticker
+		}
+	}
+	src, err := decodeImage(data)
+	if err != nil {
+		return nil, err
+	}
+	bounds := src.Bounds()
+	if bounds.Dx() == 0 || bounds.Dy() == 0 {
+		return nil, fmt.Errorf("image is empty")
+	}
+	targetWidth, targetHeight := StickerSize, StickerSize
+	if bounds.Dx() > bounds.Dy() {
+		targetHeight = bounds.Dy() * StickerSize / bounds.Dx()
+	} else if bounds.Dy() > bounds.Dx() {
+		targetWidth = bounds.Dx() * StickerSize / bounds.Dy()
+	}
+	if targetWidth < 1 {
+		targetWidth = 1
+	}
+	if targetHeight < 1 {
+		targetHeight = 1
+	}
+	offset := image.Pt((StickerSize-targetWidth)/2, (StickerSize-targetHeight)/2)
+	dst := image.NewNRGBA(image.Rect(0, 0, StickerSize, StickerSize))
+	draw.CatmullRom.Scale(dst, image.Rectangle{Min: offset, Max: offset.Add(image.Pt(targetWidth, targetHeight))}, src, bounds, draw.Src, nil)
+	return EncodeWebP(dst)
+}
@@ -0,0 +1,402 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package mediautil
+
+import (
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	"sort"
+)
+
+// EncodeWebP encodes the given image as a lossless WebP file.
+//
+// The encoder is simple: it only uses the subtract green and predictor transforms and Huffman-coded literals
+// without backward references, so files are larger than what libwebp would produce, but it's pure Go.
+func EncodeWebP(img image.Image) ([]byte, error) {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width < 1 || height < 1 || width > 1<<14 || height > 1<<14 {
+		return nil, fmt.Errorf("invalid image size %dx%d for WebP", width, height)
+	}
+	pixels := make([]uint32, 0, width*height)
+	hasAlpha := false
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+			if c.A != 0xff {
+				hasAlpha = true
+			}
+			pixels = append(pixels, uint32(c.A)<<24|uint32(c.R)<<16|uint32(c.G)<<8|uint32(c.B))
+		}
+	}
+
+	var bw vp8lBitWriter
+	bw.write(0x2f, 8)
+	bw.write(uint32(width-1), 14)
+	bw.write(uint32(height-1), 14)
+	if hasAlpha {
+		bw.write(1, 1)
+	} else {
+		bw.write(0, 1)
+	}
+	bw.write(0, 3) // version
+
+	// Subtract green transform
+	bw.write(1, 1)
+	bw.write(vp8lTransformSubtractGreen, 2)
+	subtractGreen(pixels)
+
+	// Predictor transform with one mode for all blocks
+	bw.write(1, 1)
+	bw.write(vp8lTransformPredictor, 2)
+	bw.write(vp8lPredictorBits-2, 3)
+	blocks := vp8lTiles(width) * vp8lTiles(height)
+	modes := make([]uint32, blocks)
+	for i := range modes {
+		modes[i] = vp8lPredictorMode << 8
+	}
+	bw.writeImageData(modes, false)
+	pixels = predictResiduals(pixels, width, height)
+
+	bw.write(0, 1) // no more transforms
+	bw.writeImageData(pixels, true)
+
+	vp8l := bw.bytes()
+	return makeRIFF(makeWebPChunk("VP8L", vp8l)), nil
+}
+
+const (
+	vp8lTransformPredictor     = 0
+	vp8lTransformSubtractGreen = 2
+	// vp8lPredictorBits is the log2 of the predictor block size (512x512, the largest allowed).
+	vp8lPredictorBits = 9
+	// vp8lPredictorMode is ClampAddSubtractFull(L, T, TL), which works well for most images.
+	vp8lPredictorMode = 12
+
+	vp8lGreenAlphabetSize    = 256 + 24
+	vp8lDistanceAlphabetSize = 40
+	vp8lMaxCodeLength        = 15
+	vp8lMaxCodeLengthLength  = 7
+)
+
+var vp8lCodeLengthCodeOrder = [19]int{17, 18, 0, 1, 2, 3, 4, 5, 16, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15}
+
+func vp8lTiles(size int) int {
+	return (size + 1<<vp8lPredictorBits - 1) >> vp8lPredictorBits
+}
+
+func subtractGreen(pixels []uint32) {
+	for i, argb := range pixels {
+		green := (argb >> 8) & 0xff
+		red := ((argb >> 16) - green) & 0xff
+		blue := (argb - green) & 0xff
+		pixels[i] = argb&0xff00ff00 | red<<16 | blue
+	}
+}
+
+// subPixels subtracts each channel of b from a, wrapping around on overflow.
+func subPixels(a, b uint32) uint32 {
+	alphaGreen := 0x00ff00ff + (a & 0xff00ff00) - (b & 0xff00ff00)
+	redBlue := 0xff00ff00 + (a & 0x00ff00ff) - (b & 0x00ff00ff)
+	return alphaGreen&0xff00ff00 | redBlue&0x00ff00ff
+}
+
+func clampAddSubtractFull(left, top, topLeft uint32) (out uint32) {
+	for shift := 0; shift < 32; shift += 8 {
+		val := int(left>>shift&0xff) + int(top>>shift&0xff) - int(topLeft>>shift&0xff)
+		if val < 0 {
+			val = 0
+		} else if val > 0xff {
+			val = 0xff
+		}
+		out |= uint32(val) << shift
+	}
+	return
+}
+
+// predictResiduals replaces the pixels with the differences to the prediction, like the decoder expects
+// for the predictor transform.
+func predictResiduals(pixels []uint32, width, height int) []uint32 {
+	residuals := make([]uint32, len(pixels))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			i := y*width + x
+			var prediction uint32
+			switch {
+			case x == 0 && y == 0:
+				prediction = 0xff000000
+			case y == 0:
+				prediction = pixels[i-1]
+			case x == 0:
+				prediction = pixels[i-width]
+			default:
+				prediction = clampAddSubtractFull(pixels[i-1], pixels[i-width], pixels[i-width-1])
+			}
+			residuals[i] = subPixels(pixels[i], prediction)
+		}
+	}
+	return residuals
+}
+
+type vp8lBitWriter struct {
+	buf  []byte
+	acc  uint64
+	bits uint
+}
+
+func (bw *vp8lBitWriter) write(value uint32, bits uint) {
+	bw.acc |= uint64(value) << bw.bits
+	bw.bits += bits
+	for bw.bits >= 8 {
+		bw.buf = append(bw.buf, byte(bw.acc))
+		bw.acc >>= 8
+		bw.bits -= 8
+	}
+}
+
+func (bw *vp8lBitWriter) bytes() []byte {
+	if bw.bits > 0 {
+		bw.buf = append(bw.buf, byte(bw.acc))
+		bw.acc, bw.bits = 0, 0
+	}
+	return bw.buf
+}
+
+type huffmanCode struct {
+	lengths []uint8
+	// codes contains the bit-reversed canonical codes, as the bit writer is least significant bit first.
+	codes []uint32
+}
+
+func (bw *vp8lBitWriter) writeSymbol(code *huffmanCode, symbol int) {
+	bw.write(code.codes[symbol], uint(code.lengths[symbol]))
+}
+
+// writeImageData writes an entropy-coded image using only literal pixels, no backward references or color cache.
+func (bw *vp8lBitWriter) writeImageData(pixels []uint32, topLevel bool) {
+	bw.write(0, 1) // no color cache
+	if topLevel {
+		bw.write(0, 1) // no meta prefix codes
+	}
+	green := make([]uint32, vp8lGreenAlphabetSize)
+	red := make([]uint32, 256)
+	blue := make([]uint32, 256)
+	alpha := make([]uint32, 256)
+	for _, argb := range pixels {
+		alpha[argb>>24]++
+		red[argb>>16&0xff]++
+		green[argb>>8&0xff]++
+		blue[argb&0xff]++
+	}
+	greenCode := bw.writeHuffmanCode(green)
+	redCode := bw.writeHuffmanCode(red)
+	blueCode := bw.writeHuffmanCode(blue)
+	alphaCode := bw.writeHuffmanCode(alpha)
+	bw.writeHuffmanCode(make([]uint32, vp8lDistanceAlphabetSize))
+	for _, argb := range pixels {
+		bw.writeSymbol(greenCode, int(argb>>8&0xff))
+		bw.writeSymbol(redCode, int(argb>>16&0xff))
+		bw.writeSymbol(blueCode, int(argb&0xff))
+		bw.writeSymbol(alphaCode, int(argb>>24))
+	}
+}
+
+// writeHuffmanCode builds a Huffman code for the given symbol counts, writes it and returns it.
+func (bw *vp8lBitWriter) writeHuffmanCode(counts []uint32) *huffmanCode {
+	code := &huffmanCode{lengths: make([]uint8, len(counts)), codes: make([]uint32, len(counts))}
+	usedSymbols := 0
+	lastSymbol := 0
+	for symbol, count := range counts {
+		if count > 0 {
+			usedSymbols++
+			lastSymbol = symbol
+		}
+	}
+	if usedSymbols <= 1 {
+		// Simple code with one symbol, which takes zero bits to write
+		bw.write(1, 1)
+		bw.write(0, 1)
+		if lastSymbol < 2 {
+			bw.write(0, 1)
+			bw.write(uint32(lastSymbol), 1)
+		} else {
+			bw.write(1, 1)
+			bw.write(uint32(lastSymbol), 8)
+		}
+		return code
+	}
+	code.lengths = buildHuffmanLengths(counts, vp8lMaxCodeLength)
+	code.codes = canonicalHuffmanCodes(code.lengths)
+
+	lengthCounts := make([]uint32, len(vp8lCodeLengthCodeOrder))
+	for _, length := range code.lengths {
+		lengthCounts[length]++
+	}
+	lengthCode := &huffmanCode{lengths: make([]uint8, len(lengthCounts)), codes: make([]uint32, len(lengthCounts))}
+	var usedLengths int
+	for symbol, count := range lengthCounts {
+		if count > 0 {
+			usedLengths++
+			lastSymbol = symbol
+		}
+	}
+	var lengthCodeLengths []uint8
+	if usedLengths == 1 {
+		// A code with a single symbol takes zero bits, but it's still sent with a non-zero length.
+		lengthCodeLengths = make([]uint8, len(lengthCounts))
+		lengthCodeLengths[lastSymbol] = 1
+	} else {
+		lengthCode.lengths = buildHuffmanLengths(lengthCounts, vp8lMaxCodeLengthLength)
+		lengthCode.codes = canonicalHuffmanCodes(lengthCode.lengths)
+		lengthCodeLengths = lengthCode.lengths
+	}
+
+	numCodes := 4
+	for i, symbol := range vp8lCodeLengthCodeOrder {
+		if lengthCodeLengths[symbol] > 0 && i+1 > numCodes {
+			numCodes = i + 1
+		}
+	}
+	bw.write(0, 1) // normal code
+	bw.write(uint32(numCodes-4), 4)
+	for _, symbol := range vp8lCodeLengthCodeOrder[:numCodes] {
+		bw.write(uint32(lengthCodeLengths[symbol]), 3)
+	}
+	bw.write(0, 1) // max_symbol is the alphabet size
+	for _, length := range code.lengths {
+		bw.writeSymbol(lengthCode, int(length))
+	}
+	return code
+}
+
+type huffmanNode struct {
+	weight uint64
+	parent int
+}
+
+// buildHuffmanLengths computes the code lengths of a Huffman code for the given symbol counts.
+// If the lengths don't fit in maxLength bits, the counts of rare symbols are increased until they do.
+func buildHuffmanLengths(counts []uint32, maxLength uint8) []uint8 {
+	for minCount := uint64(1); ; minCount *= 2 {
+		lengths, longest := buildHuffmanLengthsWithMinCount(counts, minCount)
+		if longest <= maxLength {
+			return lengths
+		}
+	}
+}
+
+func buildHuffmanLengthsWithMinCount(counts []uint32, minCount uint64) (lengths []uint8, longest uint8) {
+	var symbols []int
+	for symbol, count := range counts {
+		if count > 0 {
+			symbols = append(symbols, symbol)
+		}
+	}
+	weight := func(symbol int) uint64 {
+		if count := uint64(counts[symbol]); count > minCount {
+			return count
+		}
+		return minCount
+	}
+	sort.SliceStable(symbols, func(i, j int) bool {
+		return weight(symbols[i]) < weight(symbols[j])
+	})
+	leaves := len(symbols)
+	nodes := make([]huffmanNode, leaves, 2*leaves-1)
+	for i, symbol := range symbols {
+		nodes[i] = huffmanNode{weight: weight(symbol), parent: -1}
+	}
+	// Leaves are sorted and merged nodes are created in increasing order of weight,
+	// so the two smallest nodes are always at the front of one of the two queues.
+	nextLeaf, nextMerged := 0, leaves
+	pop := func() int {
+		if nextLeaf < leaves && (nextMerged >= len(nodes) || nodes[nextLeaf].weight <= nodes[nextMerged].weight) {
+			nextLeaf++
+			return nextLeaf - 1
+		}
+		nextMerged++
+		return nextMerged - 1
+	}
+	for i := 0; i < leaves-1; i++ {
+		a, b := pop(), pop()
+		nodes = append(nodes, huffmanNode{weight: nodes[a].weight + nodes[b].weight, parent: -1})
+		nodes[a].parent = len(nodes) - 1
+		nodes[b].parent = len(nodes) - 1
+	}
+	depths := make([]uint8, len(nodes))
+	for i := len(nodes) - 2; i >= 0; i-- {
+		depths[i] = depths[nodes[i].parent] + 1
+	}
+	lengths = make([]uint8, len(counts))
+	for i, symbol := range symbols {
+		lengths[symbol] = depths[i]
+		if depths[i] > longest {
+			longest = depths[i]
+		}
+	}
+	return
+}
+
+// canonicalHuffmanCodes assigns canonical codes to the given code lengths like DEFLATE,
+// and returns them bit-reversed for writing.
+func canonicalHuffmanCodes(lengths []uint8) []uint32 {
+	var lengthCounts [vp8lMaxCodeLength + 1]uint32
+	for _, length := range lengths {
+		lengthCounts[length]++
+	}
+	lengthCounts[0] = 0
+	var nextCode [vp8lMaxCodeLength + 1]uint32
+	var code uint32
+	for length := 1; length <= vp8lMaxCodeLength; length++ {
+		code = (code + lengthCounts[length-1]) << 1
+		nextCode[length] = code
+	}
+	codes := make([]uint32, len(lengths))
+	for symbol, length := range lengths {
+		if length == 0 {
+			continue
+		}
+		code := nextCode[length]
+		nextCode[length]++
+		var reversed uint32
+		for i := uint8(0); i < length; i++ {
+			reversed = reversed<<1 | (code>>i)&1
+		}
+		codes[symbol] = reversed
+	}
+	return codes
+}
+
+// makeWebPChunk returns a RIFF chunk with the given FourCC, padded to an even size.
+func makeWebPChunk(fourCC string, data []byte) []byte {
+	chunk := make([]byte, 8, 8+len(data)+1)
+	copy(chunk, fourCC)
+	binary.LittleEndian.PutUint32(chunk[4:8], uint32(len(data)))
+	chunk = append(chunk, data...)
+	if len(data)%2 == 1 {
+		chunk = append(chunk, 0)
+	}
+	return chunk
+}
+
+// makeRIFF wraps the given chunks in a RIFF WEBP container.
+func makeRIFF(chunks ...[]byte) []byte {
+	size := 4
+	for _, chunk := range chunks {
+		size += len(chunk)
+	}
+	out := make([]byte, 12, 8+size)
+	copy(out, "RIFF")
+	binary.LittleEndian.PutUint32(out[4:8], uint32(size))
+	copy(out[8:12], "WEBP")
+	for _, chunk := range chunks {
+		out = append(out, chunk...)
+	}
+	return out
+}